@@ -3,30 +3,74 @@ package cmd
 import "github.com/spf13/viper"
 
 type Config struct {
-	Width       int
-	Height      int
-	Workers     int
-	Samples     int
-	Aperture    float64
-	FocalLength float64
-	DeviceIndex int
-	ListDevices bool
-	ListScenes  bool
-	Scene       string
+	Width             int
+	Height            int
+	Workers           int
+	Samples           int
+	Seed              uint32
+	Sampler           string
+	Filter            string
+	FilterRadius      float64
+	MaxSamples        int
+	AdaptiveThreshold float64
+	AOVs              []string
+	MaxDepth          int
+	RRDepth           int
+	Aperture          float64
+	FStop             float64
+	FocusDistance     float64
+	Blades            int
+	BladeRotation     float64
+	Autofocus         []int
+	Projection        string
+	OrthoScale        float64
+	Stereo            string
+	IPD               float64
+	Convergence       float64
+	ShutterOpen       float64
+	ShutterClose      float64
+	Frames            string
+	FPS               float64
+	DeviceIndex       int
+	ListDevices       bool
+	ListScenes        bool
+	Scene             string
 }
 
 var Cfg *Config
 
 func FromConfig() {
 	Cfg = &Config{
-		Width:       viper.GetInt("width"),
-		Height:      viper.GetInt("height"),
-		Samples:     viper.GetInt("samples"),
-		Aperture:    viper.GetFloat64("aperture"),
-		FocalLength: viper.GetFloat64("focal-length"),
-		DeviceIndex: viper.GetInt("device-index"),
-		ListDevices: viper.GetBool("list-devices"),
-		ListScenes:  viper.GetBool("list-scenes"),
-		Scene:       viper.GetString("scene"),
+		Width:             viper.GetInt("width"),
+		Height:            viper.GetInt("height"),
+		Samples:           viper.GetInt("samples"),
+		Seed:              viper.GetUint32("seed"),
+		Sampler:           viper.GetString("sampler"),
+		Filter:            viper.GetString("filter"),
+		FilterRadius:      viper.GetFloat64("filter-radius"),
+		MaxSamples:        viper.GetInt("max-samples"),
+		AdaptiveThreshold: viper.GetFloat64("adaptive-threshold"),
+		AOVs:              viper.GetStringSlice("aovs"),
+		MaxDepth:          viper.GetInt("max-depth"),
+		RRDepth:           viper.GetInt("rr-depth"),
+		Aperture:          viper.GetFloat64("aperture"),
+		FStop:             viper.GetFloat64("f-stop"),
+		FocusDistance:     viper.GetFloat64("focus-distance"),
+		Blades:            viper.GetInt("blades"),
+		BladeRotation:     viper.GetFloat64("blade-rotation"),
+		Autofocus:         viper.GetIntSlice("autofocus"),
+		Projection:        viper.GetString("projection"),
+		OrthoScale:        viper.GetFloat64("ortho-scale"),
+		Stereo:            viper.GetString("stereo"),
+		IPD:               viper.GetFloat64("ipd"),
+		Convergence:       viper.GetFloat64("convergence"),
+		ShutterOpen:       viper.GetFloat64("shutter-open"),
+		ShutterClose:      viper.GetFloat64("shutter-close"),
+		Frames:            viper.GetString("frames"),
+		FPS:               viper.GetFloat64("fps"),
+		DeviceIndex:       viper.GetInt("device-index"),
+		ListDevices:       viper.GetBool("list-devices"),
+		ListScenes:        viper.GetBool("list-scenes"),
+		Scene:             viper.GetString("scene"),
 	}
 }
@@ -3,9 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"math/rand"
 	"os"
-	"time"
 
 	"github.com/eriklupander/pathtracer-ocl/cmd"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/scenes"
@@ -15,10 +13,6 @@ import (
 	"github.com/spf13/viper"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 type scene struct {
 	name string
 	fn   func() *scenes.Scene
@@ -39,6 +33,14 @@ var sc = []scene{
 	{"transparency_quad_lights", scenes.TransparencyQuadLightsScene()},
 	{"transparency_f_light", scenes.TransparencyFLightScene()},
 	{"transparent_teapot", scenes.TransparentTeapotScene()},
+	{"material-ball", scenes.MaterialBallScene()},
+	{"prism", scenes.PrismScene()},
+	{"patterns", scenes.PatternsScene()},
+	{"primitives", scenes.PrimitivesScene()},
+	{"csg", scenes.CSGScene()},
+	{"forest", scenes.ForestScene()},
+	{"animated", scenes.AnimatedScene()},
+	{"wheel", scenes.WheelScene()},
 	{"default", scenes.OCLScene()},
 }
 
@@ -47,15 +49,44 @@ func main() {
 	var configFlags = pflag.NewFlagSet("config", pflag.ExitOnError)
 	configFlags.Int("width", 640, "Image width")
 	configFlags.Int("height", 480, "Image height")
-	configFlags.Int("samples", 1, "Number of samples per pixel")
-	configFlags.Float64("aperture", 0.0, "Aperture. If 0, no DoF will be used")
-	configFlags.Float64("focal-length", 0.0, "Focal length.")
+	configFlags.Int("samples", 1, "Number of samples per pixel, or of the first pass of adaptive sampling")
+	configFlags.Float64("adaptive-threshold", 0.0, "Sample adaptively: keep doubling the samples of pixels whose relative error is above this, e.g. 0.05. Default: 0, every pixel takes --samples")
+	configFlags.Int("max-samples", 1024, "Maximum number of samples per pixel of adaptive sampling")
+	configFlags.String("sampler", "sobol", "Sampler of the random numbers of paths: independent, sobol or blue-noise")
+	configFlags.String("filter", "box", "Reconstruction filter of the pixels: box, tent, gaussian, mitchell or blackman-harris")
+	configFlags.Float64("filter-radius", 0.0, "Radius of the filter in pixels, at most 3. Default: 0.5 for box, 1 for tent, 1.5 for gaussian and 2 for mitchell and blackman-harris")
+	configFlags.StringSlice("aovs", nil, "AOVs to write next to the image, e.g. albedo,normal,depth. Any of albedo, normal, depth, position, object-id, material-id, direct and indirect")
+	configFlags.Uint32("seed", 0, "Seed of the random numbers. The same seed, scene and device always render the same image")
+	configFlags.Int("max-depth", 10, "Maximum number of bounces per path")
+	configFlags.Int("rr-depth", 4, "Number of bounces before russian roulette may terminate a path")
+	configFlags.Float64("aperture", 0.0, "Radius of the lens. If 0, no DoF will be used")
+	configFlags.Float64("f-stop", 0.0, "Sets the aperture from an f-number instead, with world units in metres")
+	configFlags.Float64("focus-distance", 0.0, "Distance to the plane in focus. Default: the look-at point of the scene")
+	configFlags.Int("blades", 0, "Number of aperture blades for polygonal bokeh. Less than 3 gives round bokeh")
+	configFlags.Float64("blade-rotation", 0.0, "Rotation of the aperture blades in degrees")
+	configFlags.IntSlice("autofocus", nil, "Focus on whatever is at pixel x,y of the image")
+	configFlags.String("projection", "", "Camera projection: perspective, orthographic, fisheye or equirectangular. Default: that of the scene")
+	configFlags.Float64("ortho-scale", 0.0, "Scale of the image plane of orthographic cameras. Default: the distance to the look-at point")
+	configFlags.String("stereo", "", "Render a stereo pair for VR, side-by-side or over-under, into an image twice the width or height")
+	configFlags.Float64("ipd", 0.0, "Interpupillary distance of stereo pairs in world units. Default: 0.064")
+	configFlags.Float64("convergence", 0.0, "Distance at which the eyes of stereo pairs converge. Default: 0, parallel eyes")
+	configFlags.Float64("shutter-open", 0.0, "Time the shutter opens for motion blur, in seconds relative to the time of the image. Default: that of the scene")
+	configFlags.Float64("shutter-close", 0.0, "Time the shutter closes for motion blur, in seconds relative to the time of the image. Default: that of the scene")
+	configFlags.String("frames", "1-120", "Frames to render with render-sequence, first-last")
+	configFlags.Float64("fps", 24, "Frames per second of render-sequence")
 	configFlags.String("scene", "gopher", "scene from /scenes")
 	configFlags.Int("device-index", 0, "Use device with index (use --list-devices to list available devices)")
 	configFlags.Bool("list-devices", false, "List available devices")
 	configFlags.Bool("list-scenes", false, "List available scenes")
 
-	if err := configFlags.Parse(os.Args[1:]); err != nil {
+	// pt render-sequence renders the frames of an animated scene, plain pt a single image
+	args := os.Args[1:]
+	sequence := len(args) > 0 && args[0] == "render-sequence"
+	if sequence {
+		args = args[1:]
+	}
+
+	if err := configFlags.Parse(args); err != nil {
 		panic(err.Error())
 	}
 	if err := viper.BindPFlags(configFlags); err != nil {
@@ -86,6 +117,10 @@ func main() {
 		scene = scenes.OCLScene()
 	}
 
+	if sequence {
+		tracer.RenderSequence(scene)
+		return
+	}
 	tracer.Render(scene)
 }
 
@@ -0,0 +1,195 @@
+package adaptive
+
+import (
+	"math"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+)
+
+// minLuminance is about one step of an 8 bit image. The error of darker pixels is measured relative to it instead of
+// their own luminance, so that they don't take the maximum samples for noise no one can see.
+const minLuminance = 1.0 / 255
+
+// Stats tracks the mean color and the variance of the luminance of each pixel of an image over the passes of adaptive
+// sampling. The first pass takes the initial samples in every pixel, and each following pass doubles the samples of
+// the pixels whose relative error is still above the threshold, until they reach the maximum samples. Doubling keeps
+// the samples of a pixel at the initial samples times a power of two, where Sobol points are the most evenly spread,
+// and all pixels still being sampled at the same number of samples, so that a pass continues their samples from the
+// same index.
+type Stats struct {
+	Width, Height int
+
+	initialSamples int
+	maxSamples     int
+	threshold      float64
+
+	// taken is the number of samples of the pixels still being sampled, and pass those the current pass adds to them
+	taken, pass  int
+	active       []bool
+	samples      []int
+	sum          []geom.Tuple4
+	sumOfSquares []float64
+}
+
+// NewStats returns the stats of a width x height image, of which every pixel takes initialSamples samples and
+// pixels above the relative error threshold up to maxSamples samples. A threshold of 0 samples every pixel
+// initialSamples times.
+func NewStats(width, height, initialSamples, maxSamples int, threshold float64) *Stats {
+	if initialSamples < 1 {
+		initialSamples = 1
+	}
+	if threshold <= 0 || maxSamples < initialSamples {
+		maxSamples = initialSamples
+	}
+	n := width * height
+	active := make([]bool, n)
+	for i := range active {
+		active[i] = true
+	}
+	return &Stats{
+		Width:          width,
+		Height:         height,
+		initialSamples: initialSamples,
+		maxSamples:     maxSamples,
+		threshold:      threshold,
+		active:         active,
+		samples:        make([]int, n),
+		sum:            make([]geom.Tuple4, n),
+		sumOfSquares:   make([]float64, n),
+	}
+}
+
+// NextPass returns the samples each pixel takes in the next pass and the index of their first sample, or nil once all
+// pixels have converged or reached the maximum samples.
+func (s *Stats) NextPass() ([]uint32, int) {
+	if s.taken == 0 {
+		s.pass = s.initialSamples
+	} else {
+		for i := range s.active {
+			s.active[i] = s.active[i] && !s.Converged(i)
+		}
+		s.pass = s.taken
+		if s.taken+s.pass > s.maxSamples {
+			s.pass = s.maxSamples - s.taken
+		}
+	}
+	if s.pass <= 0 || s.Active() == 0 {
+		return nil, 0
+	}
+	samples := make([]uint32, len(s.active))
+	for i, active := range s.active {
+		if active {
+			samples[i] = uint32(s.pass)
+		}
+	}
+	return samples, s.taken
+}
+
+// Add adds the result of a pass to the stats: the RGBA values of the pixels, where the RGB values are the mean color
+// of the samples of the pass and alpha the mean of their squared luminance.
+func (s *Stats) Add(samples []uint32, result []float64) {
+	for i, n := range samples {
+		if n == 0 {
+			continue
+		}
+		weight := float64(n)
+		s.sum[i] = geom.Add(s.sum[i], geom.NewVector(result[i*4]*weight, result[i*4+1]*weight, result[i*4+2]*weight))
+		s.sumOfSquares[i] += result[i*4+3] * weight
+		s.samples[i] += int(n)
+	}
+	s.taken += s.pass
+}
+
+// Active returns the number of pixels still being sampled.
+func (s *Stats) Active() int {
+	active := 0
+	for _, a := range s.active {
+		if a {
+			active++
+		}
+	}
+	return active
+}
+
+// Color returns the mean color of the samples of pixel i.
+func (s *Stats) Color(i int) geom.Tuple4 {
+	if s.samples[i] == 0 {
+		return geom.NewColor(0, 0, 0)
+	}
+	n := float64(s.samples[i])
+	return geom.NewColor(s.sum[i][0]/n, s.sum[i][1]/n, s.sum[i][2]/n)
+}
+
+// Samples returns the number of samples pixel i took.
+func (s *Stats) Samples(i int) int {
+	return s.samples[i]
+}
+
+// MeanSamples returns the mean number of samples of the pixels.
+func (s *Stats) MeanSamples() float64 {
+	sum := 0
+	for _, n := range s.samples {
+		sum += n
+	}
+	return float64(sum) / float64(len(s.samples))
+}
+
+// RelativeError returns the standard error of the mean luminance of pixel i relative to the mean luminance. It is
+// infinite until the pixel has taken two samples.
+func (s *Stats) RelativeError(i int) float64 {
+	n := float64(s.samples[i])
+	if n < 2 {
+		return math.Inf(1)
+	}
+	mean := luminance(s.sum[i]) / n
+	variance := math.Max(s.sumOfSquares[i]/n-mean*mean, 0) * n / (n - 1)
+	return math.Sqrt(variance/n) / math.Max(mean, minLuminance)
+}
+
+// Converged tells if the relative error of pixel i is within the threshold.
+func (s *Stats) Converged(i int) bool {
+	return s.RelativeError(i) <= s.threshold
+}
+
+// Heatmap returns an image of the samples of each pixel, from blue for the initial samples over cyan, green and yellow
+// to red for the maximum samples, on a log scale.
+func (s *Stats) Heatmap() *canvas.Canvas {
+	heatmap := canvas.NewCanvas(s.Width, s.Height)
+	span := math.Log2(float64(s.maxSamples) / float64(s.initialSamples))
+	for i, n := range s.samples {
+		if n == 0 {
+			continue
+		}
+		t := 1.0
+		if span > 0 {
+			t = math.Log2(float64(n)/float64(s.initialSamples)) / span
+		}
+		heatmap.WritePixelToIndex(i, heatColor(t))
+	}
+	return heatmap
+}
+
+var heatColors = []geom.Tuple4{
+	geom.NewColor(0, 0, 1),
+	geom.NewColor(0, 1, 1),
+	geom.NewColor(0, 1, 0),
+	geom.NewColor(1, 1, 0),
+	geom.NewColor(1, 0, 0),
+}
+
+// heatColor returns the color of t in [0, 1] on the scale of heatColors.
+func heatColor(t float64) geom.Tuple4 {
+	t = math.Max(0, math.Min(t, 1)) * float64(len(heatColors)-1)
+	i := int(t)
+	if i == len(heatColors)-1 {
+		return heatColors[i]
+	}
+	f := t - float64(i)
+	return geom.Add(geom.MultiplyByScalar(heatColors[i], 1-f), geom.MultiplyByScalar(heatColors[i+1], f))
+}
+
+// luminance returns the Rec. 709 luminance of the color, the same as the kernel.
+func luminance(c geom.Tuple4) float64 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
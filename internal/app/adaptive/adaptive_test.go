@@ -0,0 +1,100 @@
+package adaptive
+
+import (
+	"math"
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+// trace fakes the kernel for two pixels: the first is a flat gray of 0.5, while the samples of the second alternate
+// between black and white.
+func trace(samples []uint32, firstSample int) []float64 {
+	result := make([]float64, len(samples)*4)
+	result[0], result[1], result[2], result[3] = 0.5, 0.5, 0.5, 0.25
+	white := 0
+	for n := firstSample; n < firstSample+int(samples[1]); n++ {
+		white += n % 2
+	}
+	mean := float64(white) / float64(samples[1])
+	result[4], result[5], result[6], result[7] = mean, mean, mean, mean
+	return result
+}
+
+func TestStats_SamplesNoisyPixelsUntilMaxSamples(t *testing.T) {
+	stats := NewStats(2, 1, 4, 32, 0.05)
+
+	var passes [][]uint32
+	var firstSamples []int
+	for {
+		samples, firstSample := stats.NextPass()
+		if samples == nil {
+			break
+		}
+		passes = append(passes, samples)
+		firstSamples = append(firstSamples, firstSample)
+		stats.Add(samples, trace(samples, firstSample))
+	}
+
+	// the flat pixel converges after the first pass, while the samples of the noisy pixel double up to the maximum
+	assert.Equal(t, [][]uint32{{4, 4}, {0, 4}, {0, 8}, {0, 16}}, passes)
+	assert.Equal(t, []int{0, 4, 8, 16}, firstSamples)
+	assert.Equal(t, 4, stats.Samples(0))
+	assert.Equal(t, 32, stats.Samples(1))
+	assert.Equal(t, 18.0, stats.MeanSamples())
+	assert.Equal(t, geom.NewColor(0.5, 0.5, 0.5), stats.Color(0))
+	assert.Equal(t, geom.NewColor(0.5, 0.5, 0.5), stats.Color(1))
+}
+
+func TestStats_WithoutThresholdTakesOnePass(t *testing.T) {
+	stats := NewStats(2, 1, 8, 1024, 0)
+	samples, firstSample := stats.NextPass()
+	assert.Equal(t, []uint32{8, 8}, samples)
+	assert.Equal(t, 0, firstSample)
+	stats.Add(samples, trace(samples, firstSample))
+
+	samples, _ = stats.NextPass()
+	assert.Nil(t, samples)
+}
+
+func TestStats_RelativeError(t *testing.T) {
+	stats := NewStats(1, 1, 1, 16, 0.05)
+	samples, _ := stats.NextPass()
+	stats.Add(samples, []float64{1, 1, 1, 1})
+	assert.True(t, math.IsInf(stats.RelativeError(0), 1), "a single sample tells nothing about the variance")
+
+	// 4 samples of luminance 0 and 2: a mean of 1 with a sample variance of 4/3
+	stats = NewStats(1, 1, 4, 16, 0.05)
+	samples, _ = stats.NextPass()
+	stats.Add(samples, []float64{1, 1, 1, 2})
+	assert.InDelta(t, math.Sqrt(4.0/3/4), stats.RelativeError(0), 1e-9)
+	assert.False(t, stats.Converged(0))
+
+	// dark pixels are measured against minLuminance
+	stats = NewStats(1, 1, 4, 16, 0.05)
+	samples, _ = stats.NextPass()
+	stats.Add(samples, []float64{0.0005, 0.0005, 0.0005, 0.000001})
+	assert.InDelta(t, math.Sqrt((0.000001-0.0005*0.0005)*4/3/4)*255, stats.RelativeError(0), 1e-9)
+}
+
+func TestStats_Heatmap(t *testing.T) {
+	stats := NewStats(2, 1, 4, 64, 0.05)
+	for {
+		samples, firstSample := stats.NextPass()
+		if samples == nil {
+			break
+		}
+		stats.Add(samples, trace(samples, firstSample))
+	}
+	heatmap := stats.Heatmap()
+	assert.Equal(t, geom.NewColor(0, 0, 1), heatmap.ColorAt(0, 0))
+	assert.Equal(t, geom.NewColor(1, 0, 0), heatmap.ColorAt(1, 0))
+}
+
+func Test_heatColor(t *testing.T) {
+	assert.Equal(t, geom.NewColor(0, 0, 1), heatColor(-1))
+	assert.Equal(t, geom.NewColor(0, 1, 0), heatColor(0.5))
+	assert.Equal(t, geom.NewColor(0.5, 1, 0), heatColor(0.625))
+	assert.Equal(t, geom.NewColor(1, 0, 0), heatColor(2))
+}
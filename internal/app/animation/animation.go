@@ -0,0 +1,92 @@
+package animation
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+)
+
+// Animation holds the keyframed camera and objects of a scene. Tracks without keyframes leave what they would
+// animate as the scene set it up.
+type Animation struct {
+	Camera  CameraAnimation
+	Objects []*ObjectAnimation
+}
+
+// CameraAnimation moves the camera and its look-at point and changes the aperture radius over time. Moving the camera
+// keeps it focused on the look-at point, like NewCamera does.
+type CameraAnimation struct {
+	From     Track[geom.Tuple4]
+	LookAt   Track[geom.Tuple4]
+	Aperture Track[float64]
+}
+
+// ObjectAnimation animates the transform of a shape on top of the transform the scene gave it: Rotation turns the
+// shape around its own x, y and z axes, in radians and in that order, and Scale scales it along them, while
+// Translation moves the shape in world space.
+type ObjectAnimation struct {
+	Shape       shapes.Shape
+	Translation Track[geom.Tuple4]
+	Rotation    Track[geom.Tuple4]
+	Scale       Track[geom.Tuple4]
+
+	// base is the transform of the shape before the first frame, since SetTransform only multiplies onto the
+	// current transform.
+	base    geom.Mat4x4
+	hasBase bool
+}
+
+// Apply sets the camera and the objects up for the time, in seconds. Objects may be animated again for other times,
+// while the camera should be a fresh copy of the camera of the scene for each time.
+func (a *Animation) Apply(cam *camera.Camera, time float64) {
+	if a.Camera.From.Animated() || a.Camera.LookAt.Animated() {
+		from, lookAt := cam.From, cam.LookAt
+		if a.Camera.From.Animated() {
+			from = a.Camera.From.At(time)
+		}
+		if a.Camera.LookAt.Animated() {
+			lookAt = a.Camera.LookAt.At(time)
+		}
+		cam.SetView(from, lookAt)
+	}
+	if a.Camera.Aperture.Animated() {
+		cam.Aperture = a.Camera.Aperture.At(time)
+	}
+	a.ApplyObjects(time)
+}
+
+// ApplyObjects sets just the objects up for the time, in seconds, leaving the camera be.
+func (a *Animation) ApplyObjects(time float64) {
+	for _, object := range a.Objects {
+		object.apply(time)
+	}
+}
+
+// Transform returns the transform of the shape at the time, given the transform the scene gave it.
+func (a *ObjectAnimation) Transform(base geom.Mat4x4, time float64) geom.Mat4x4 {
+	transform := base
+	if a.Rotation.Animated() {
+		r := a.Rotation.At(time)
+		transform = geom.Multiply(transform, geom.RotateZ(r[2]))
+		transform = geom.Multiply(transform, geom.RotateY(r[1]))
+		transform = geom.Multiply(transform, geom.RotateX(r[0]))
+	}
+	if a.Scale.Animated() {
+		s := a.Scale.At(time)
+		transform = geom.Multiply(transform, geom.Scale(s[0], s[1], s[2]))
+	}
+	if a.Translation.Animated() {
+		t := a.Translation.At(time)
+		transform = geom.Multiply(geom.Translate(t[0], t[1], t[2]), transform)
+	}
+	return transform
+}
+
+func (a *ObjectAnimation) apply(time float64) {
+	if !a.hasBase {
+		a.base = a.Shape.GetTransform()
+		a.hasBase = true
+	}
+	// undo the current transform on the way to the one of this time
+	a.Shape.SetTransform(geom.Multiply(a.Shape.GetInverse(), a.Transform(a.base, time)))
+}
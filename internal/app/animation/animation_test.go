@@ -0,0 +1,55 @@
+package animation
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestApplyCamera(t *testing.T) {
+	cam := camera.NewCamera(100, 50, math.Pi/3, geom.NewPoint(0, 0, -5), geom.NewPoint(0, 0, 0))
+	a := Animation{Camera: CameraAnimation{
+		From:     NewTrack(Linear, Key(0.0, geom.NewPoint(0, 0, -5)), Key(1.0, geom.NewPoint(0, 0, -3))),
+		Aperture: NewTrack(Linear, Key(0.0, 0.0), Key(1.0, 0.2)),
+	}}
+
+	frame := cam
+	a.Apply(&frame, 0.5)
+	assert.Equal(t, geom.NewPoint(0, 0, -4), frame.From)
+	assert.Equal(t, geom.NewPoint(0, 0, 0), frame.LookAt)
+	assert.InDelta(t, 4.0, frame.FocusDistance, geom.Epsilon)
+	assert.InDelta(t, 0.1, frame.Aperture, geom.Epsilon)
+
+	// the camera of the scene is left as is
+	assert.Equal(t, geom.NewPoint(0, 0, -5), cam.From)
+}
+
+func TestApplyObject(t *testing.T) {
+	s := shapes.NewSphere()
+	s.SetTransform(geom.Translate(1, 0, 0))
+	s.SetTransform(geom.Scale(2, 2, 2))
+	a := Animation{Objects: []*ObjectAnimation{{
+		Shape:       s,
+		Translation: NewTrack(Linear, Key(0.0, geom.NewVector(0, 0, 0)), Key(1.0, geom.NewVector(0, 4, 0))),
+		Rotation:    NewTrack(Linear, Key(0.0, geom.NewVector(0, 0, 0)), Key(1.0, geom.NewVector(0, math.Pi, 0))),
+	}}}
+
+	// the translation is in world units, while the rotation turns the sphere around its own centre
+	a.Apply(&camera.Camera{}, 0.5)
+	expected := geom.Multiply(geom.Multiply(geom.Translate(1, 2, 0), geom.Scale(2, 2, 2)), geom.RotateY(math.Pi/2))
+	assertMatrixInDelta(t, expected, s.GetTransform())
+	assertMatrixInDelta(t, geom.Inverse(expected), s.GetInverse())
+
+	// going back in time restores the transform of the scene
+	a.ApplyObjects(0)
+	assertMatrixInDelta(t, geom.Multiply(geom.Translate(1, 0, 0), geom.Scale(2, 2, 2)), s.GetTransform())
+}
+
+func assertMatrixInDelta(t *testing.T, expected, actual geom.Mat4x4) {
+	for i := range expected {
+		assert.InDelta(t, expected[i], actual[i], geom.Epsilon)
+	}
+}
@@ -0,0 +1,25 @@
+package animation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseFrames parses a range of frames such as "1-120", or a single frame such as "42", into the first and the last
+// frame to render.
+func ParseFrames(frames string) (int, int, error) {
+	firstText, lastText, isRange := strings.Cut(frames, "-")
+	first, err := strconv.Atoi(strings.TrimSpace(firstText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid frames %q, expected first-last such as 1-120", frames)
+	}
+	if !isRange {
+		return first, first, nil
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(lastText))
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid frames %q, expected first-last such as 1-120", frames)
+	}
+	return first, last, nil
+}
@@ -0,0 +1,23 @@
+package animation
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseFrames(t *testing.T) {
+	first, last, err := ParseFrames("1-120")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 120, last)
+
+	first, last, err = ParseFrames("42")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, first)
+	assert.Equal(t, 42, last)
+
+	for _, invalid := range []string{"", "a-b", "10-1", "1-", "-5"} {
+		_, _, err = ParseFrames(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,112 @@
+package animation
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"sort"
+)
+
+// Value is what tracks animate, numbers such as the aperture or tuples such as positions.
+type Value interface {
+	float64 | geom.Tuple4
+}
+
+// Interpolation decides how a track moves between its keyframes.
+type Interpolation int
+
+const (
+	// Linear moves at constant speed from one keyframe to the next, changing direction abruptly at the keyframes.
+	Linear Interpolation = iota
+	// Bezier follows a smooth curve through the keyframes, made of cubic Bezier segments whose control points are
+	// given by the neighbouring keyframes, like a Catmull-Rom spline.
+	Bezier
+)
+
+// Keyframe is the value of a track at a time, in seconds.
+type Keyframe[T Value] struct {
+	Time  float64
+	Value T
+}
+
+// Key returns a keyframe with the value at the time, in seconds.
+func Key[T Value](time float64, value T) Keyframe[T] {
+	return Keyframe[T]{Time: time, Value: value}
+}
+
+// Track is the animation of a single value by keyframes. Before the first and after the last keyframe the value is
+// that of the keyframe.
+type Track[T Value] struct {
+	Keys          []Keyframe[T]
+	Interpolation Interpolation
+}
+
+// NewTrack returns a track through the keyframes, which may be passed in any order.
+func NewTrack[T Value](interpolation Interpolation, keys ...Keyframe[T]) Track[T] {
+	sorted := append([]Keyframe[T]{}, keys...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+	return Track[T]{Keys: sorted, Interpolation: interpolation}
+}
+
+// Animated tells if the track has any keyframes.
+func (t Track[T]) Animated() bool {
+	return len(t.Keys) > 0
+}
+
+// At returns the value of the track at the time, in seconds, or the zero value if it has no keyframes.
+func (t Track[T]) At(time float64) T {
+	n := len(t.Keys)
+	if n == 0 {
+		var zero T
+		return zero
+	}
+	if time <= t.Keys[0].Time {
+		return t.Keys[0].Value
+	}
+	if time >= t.Keys[n-1].Time {
+		return t.Keys[n-1].Value
+	}
+
+	// the segment from key i to i+1 contains the time
+	i := sort.Search(n, func(i int) bool { return t.Keys[i].Time > time }) - 1
+	s := (time - t.Keys[i].Time) / (t.Keys[i+1].Time - t.Keys[i].Time)
+	p1, p2 := t.Keys[i].Value, t.Keys[i+1].Value
+	if t.Interpolation == Linear {
+		return weightedSum([]float64{1 - s, s}, []T{p1, p2})
+	}
+
+	// the first and last segments use their end keyframes as missing neighbours
+	p0, p3 := p1, p2
+	if i > 0 {
+		p0 = t.Keys[i-1].Value
+	}
+	if i+2 < n {
+		p3 = t.Keys[i+2].Value
+	}
+
+	// the Bezier segment from p1 to p2 with the control points p1 + (p2-p0)/6 and p2 - (p3-p1)/6, expanded into
+	// weights of the four keyframes
+	b0 := (1 - s) * (1 - s) * (1 - s)
+	b1 := 3 * (1 - s) * (1 - s) * s
+	b2 := 3 * (1 - s) * s * s
+	b3 := s * s * s
+	return weightedSum([]float64{-b1 / 6, b0 + b1 + b2/6, b1/6 + b2 + b3, -b2 / 6}, []T{p0, p1, p2, p3})
+}
+
+// weightedSum returns the sum of the values multiplied by their weights. The weights add up to 1, so points stay
+// points.
+func weightedSum[T Value](weights []float64, values []T) T {
+	var sum T
+	switch s := any(&sum).(type) {
+	case *float64:
+		for i, v := range values {
+			*s += weights[i] * any(v).(float64)
+		}
+	case *geom.Tuple4:
+		for i, v := range values {
+			t := any(v).(geom.Tuple4)
+			for j := range s {
+				s[j] += weights[i] * t[j]
+			}
+		}
+	}
+	return sum
+}
@@ -0,0 +1,46 @@
+package animation
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTrackLinear(t *testing.T) {
+	track := NewTrack(Linear, Key(2.0, 10.0), Key(0.0, 0.0), Key(3.0, 4.0))
+
+	assert.Equal(t, 0.0, track.Keys[0].Time)
+	assert.InDelta(t, 5.0, track.At(1), geom.Epsilon)
+	assert.InDelta(t, 7.0, track.At(2.5), geom.Epsilon)
+
+	// held before the first and after the last keyframe
+	assert.Equal(t, 0.0, track.At(-1))
+	assert.Equal(t, 4.0, track.At(10))
+}
+
+func TestTrackBezierPassesThroughKeyframes(t *testing.T) {
+	track := NewTrack(Bezier, Key(0.0, 0.0), Key(1.0, 1.0), Key(2.0, 0.0), Key(3.0, 2.0))
+	for _, key := range track.Keys {
+		assert.InDelta(t, key.Value, track.At(key.Time), geom.Epsilon)
+	}
+
+	// the curve overshoots the linear path when it turns around at the peak
+	assert.Greater(t, track.At(0.9), 0.9)
+	assert.Greater(t, track.At(1.1), 0.9)
+}
+
+func TestTrackBezierStraightLine(t *testing.T) {
+	// keyframes evenly spaced on a line give constant speed between the inner keyframes, just like linear
+	// interpolation, while the curve eases in and out of the first and last keyframe
+	track := NewTrack(Bezier, Key(0.0, geom.NewPoint(0, 0, 0)), Key(1.0, geom.NewPoint(1, 2, 0)), Key(2.0, geom.NewPoint(2, 4, 0)), Key(3.0, geom.NewPoint(3, 6, 0)))
+	p := track.At(1.5)
+	for i, expected := range geom.NewPoint(1.5, 3, 0) {
+		assert.InDelta(t, expected, p[i], geom.Epsilon)
+	}
+}
+
+func TestTrackWithoutKeys(t *testing.T) {
+	var track Track[geom.Tuple4]
+	assert.False(t, track.Animated())
+	assert.Equal(t, geom.Tuple4{}, track.At(1))
+}
@@ -2,20 +2,62 @@ package camera
 
 import (
 	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
 	"math"
 )
 
+// SensorWidth is the width in metres of the sensor of a full frame camera. Together with the field of view it gives
+// the focal length of the lens, see FocalLength.
+const SensorWidth = 0.036
+
+// Camera is a thin lens camera. With an Aperture of 0 it is a pinhole camera with everything in focus, otherwise
+// only the plane at FocusDistance in front of the camera is in focus.
 type Camera struct {
-	Width       int
-	Height      int
-	Fov         float64
-	Transform   geom.Mat4x4
-	Inverse     geom.Mat4x4
-	PixelSize   float64
-	HalfWidth   float64
-	HalfHeight  float64
-	Aperture    float64
-	FocalLength float64
+	Width      int
+	Height     int
+	Fov        float64
+	Transform  geom.Mat4x4
+	Inverse    geom.Mat4x4
+	PixelSize  float64
+	HalfWidth  float64
+	HalfHeight float64
+
+	// Aperture is the radius of the lens in world units, see also SetFStop.
+	Aperture float64
+	// FocusDistance is the distance from the lens to the plane in focus, along the view direction.
+	FocusDistance float64
+	// Blades is the number of aperture blades, which gives polygonal bokeh. Less than 3 gives a circular aperture.
+	Blades int
+	// BladeRotation rotates the polygonal aperture, in radians.
+	BladeRotation float64
+
+	// Projection is the mapping from pixels to rays, Perspective by default. Depth of field only applies to
+	// Perspective.
+	Projection Projection
+	// OrthoScale scales the image plane of Orthographic cameras, which by default covers the same area at the
+	// look-at point as the perspective view does.
+	OrthoScale float64
+
+	// From and LookAt are the position of the camera and the point it looks at, from which the eye cameras of
+	// stereo pairs are derived, see EyeCamera.
+	From   geom.Tuple4
+	LookAt geom.Tuple4
+	// IPD is the interpupillary distance, i.e. the distance between the eyes of stereo pairs in world units.
+	IPD float64
+	// Convergence is the distance along the view direction at which the eyes of stereo pairs converge. 0 gives
+	// parallel eyes, which converge at infinity.
+	Convergence float64
+	// EyeOffset is the signed distance of the eye from the centre of the head, positive for the left eye. It is
+	// only used by Equirectangular eye cameras, whose eye position depends on the direction of the ray, see
+	// EyeCamera.
+	EyeOffset float64
+
+	// ShutterOpen and ShutterClose are the times the shutter opens and closes, in seconds relative to the time of the
+	// image. Objects that the animation of the scene moves in between are motion blurred, see ocl.AddMotion, while the
+	// camera itself is only ever where the animation has it at ShutterOpen. If the shutter doesn't close after it opens,
+	// the image is a still of the scene at ShutterOpen.
+	ShutterOpen  float64
+	ShutterClose float64
 }
 
 func NewCamera(width int, height int, fov float64, from geom.Tuple4, lookAt geom.Tuple4) Camera {
@@ -44,7 +86,118 @@ func NewCamera(width int, height int, fov float64, from geom.Tuple4, lookAt geom
 		HalfWidth:  halfWidth,
 		HalfHeight: halfHeight,
 		Aperture:   0.0, // default, pinhole
+		// focus on what we're looking at, in case an aperture is set
+		FocusDistance: geom.Magnitude(geom.Sub(lookAt, from)),
+		OrthoScale:    geom.Magnitude(geom.Sub(lookAt, from)),
+		From:          from,
+		LookAt:        lookAt,
+		IPD:           0.064, // an average adult, with world units in metres
+	}
+}
+
+// FocalLength returns the focal length of the lens in world units, assumed to be metres, i.e. the distance from the
+// lens to a sensor of SensorWidth that gives the field of view of the camera.
+func (c *Camera) FocalLength() float64 {
+	return SensorWidth * 0.5 / c.HalfWidth
+}
+
+// SetFStop sets the aperture from the f-number of the lens, which is the focal length divided by the diameter of the
+// aperture. Lower f-numbers give shallower depth of field.
+func (c *Camera) SetFStop(fstop float64) {
+	c.Aperture = c.FocalLength() / (2 * fstop)
+}
+
+// SetView moves the camera to from, looking at lookAt, and focuses on lookAt like NewCamera does.
+func (c *Camera) SetView(from, lookAt geom.Tuple4) {
+	c.From, c.LookAt = from, lookAt
+	c.Transform = ViewTransform(from, lookAt, geom.NewVector(0, 1, 0))
+	c.Inverse = geom.Inverse(c.Transform)
+	c.FocusDistance = geom.Magnitude(geom.Sub(lookAt, from))
+	c.OrthoScale = c.FocusDistance
+}
+
+// RayForPixel returns the ray from the centre of the lens through the point x, y of the image, in pixels, or false if
+// the point is outside the image of the projection. For Perspective and Orthographic cameras the direction has a
+// length of 1 along the view direction, so the T of an intersection is its distance from the camera along the view
+// direction, otherwise the direction is normalized. The kernel does the same in rayForPixel.
+func (c *Camera) RayForPixel(x, y float64) (geom.Ray, bool) {
+	origin, direction, ok := c.viewRay(x, y)
+	if !ok {
+		return geom.Ray{}, false
+	}
+	return geom.NewRay(geom.MultiplyByTuple(c.Inverse, origin), geom.MultiplyByTuple(c.Inverse, direction)), true
+}
+
+// Autofocus sets the focus distance to the distance of the closest of the objects at pixel x, y of the image.
+// Returns false and leaves the focus distance as is if nothing is hit at the pixel.
+func (c *Camera) Autofocus(objects []shapes.Shape, x, y int) bool {
+	ray, ok := c.RayForPixel(float64(x)+0.5, float64(y)+0.5)
+	if !ok {
+		return false
+	}
+	hit, ok := shapes.Hit(objects, ray)
+	if ok {
+		c.FocusDistance = hit.T
+	}
+	return ok
+}
+
+// Eye is one of the eyes of a stereo pair.
+type Eye int
+
+const (
+	LeftEye Eye = iota
+	RightEye
+)
+
+// EyeCamera returns the camera of the eye, which is moved half the IPD sideways in camera space. Unless Convergence
+// is 0, the eye is turned towards the point at the convergence distance in front of the camera. Equirectangular
+// cameras instead keep the position and orientation of the camera and get an EyeOffset, so that the eye moves around
+// the centre of the head with the direction of each ray, i.e. omni-directional stereo.
+func (c Camera) EyeCamera(eye Eye) Camera {
+	// camera space x points to the left of the image
+	offset := c.IPD * 0.5
+	if eye == RightEye {
+		offset = -offset
+	}
+	if c.Projection == Equirectangular {
+		c.EyeOffset = offset
+		return c
+	}
+
+	shift := geom.MultiplyByTuple(c.Inverse, geom.NewVector(offset, 0, 0))
+	from := geom.Add(c.From, shift)
+	to := geom.Add(c.LookAt, shift)
+	if c.Convergence > 0 {
+		forward := geom.Normalize(geom.Sub(c.LookAt, c.From))
+		to = geom.Add(c.From, geom.MultiplyByScalar(forward, c.Convergence))
+	}
+	c.From, c.LookAt = from, to
+	c.Transform = ViewTransform(from, to, geom.NewVector(0, 1, 0))
+	c.Inverse = geom.Inverse(c.Transform)
+	return c
+}
+
+// SampleLens maps the uniform random numbers u1 and u2 onto a point of the aperture in camera space, within the unit
+// circle or the polygon of Blades blades, and scales it by the aperture radius. It is the reference implementation
+// of sampleLens in tracer.cl.
+func (c *Camera) SampleLens(u1, u2 float64) (float64, float64) {
+	if c.Blades < 3 {
+		r := math.Sqrt(u1) * c.Aperture
+		theta := 2 * math.Pi * u2
+		return r * math.Cos(theta), r * math.Sin(theta)
 	}
+	// pick one of the triangles between the centre and two adjacent corners, then a uniform point within it
+	blade := math.Floor(u1 * float64(c.Blades))
+	u1 = u1*float64(c.Blades) - blade
+	angle := 2 * math.Pi / float64(c.Blades)
+	a0 := c.BladeRotation + blade*angle
+	a1 := a0 + angle
+	su := math.Sqrt(u1)
+	b0, b1 := su*(1-u2), su*u2
+	x := b0*math.Cos(a0) + b1*math.Cos(a1)
+	y := b0*math.Sin(a0) + b1*math.Sin(a1)
+	return x * c.Aperture, y * c.Aperture
 }
 
 func ViewTransform(from, to, up geom.Tuple4) geom.Mat4x4 {
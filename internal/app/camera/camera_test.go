@@ -0,0 +1,98 @@
+package camera
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestNewCameraFocusesOnLookAt(t *testing.T) {
+	c := NewCamera(200, 100, math.Pi/2, geom.NewPoint(0, 0, -5), geom.NewPoint(0, 0, 3))
+	assert.InEpsilon(t, 8.0, c.FocusDistance, geom.Epsilon)
+	assert.Equal(t, 0.0, c.Aperture)
+}
+
+func TestFocalLengthAndFStop(t *testing.T) {
+	// a 90 degree horizontal field of view on a full frame sensor is an 18mm lens
+	c := NewCamera(200, 100, math.Pi/2, geom.NewPoint(0, 0, 0), geom.NewPoint(0, 0, 1))
+	assert.InEpsilon(t, 0.018, c.FocalLength(), geom.Epsilon)
+
+	c.SetFStop(2)
+	assert.InEpsilon(t, 0.0045, c.Aperture, geom.Epsilon)
+}
+
+func TestSetView(t *testing.T) {
+	c := NewCamera(201, 101, math.Pi/2, geom.NewPoint(0, 0, 0), geom.NewPoint(0, 0, 1))
+	c.SetView(geom.NewPoint(0, 2, -5), geom.NewPoint(0, 2, 0))
+
+	expected := NewCamera(201, 101, math.Pi/2, geom.NewPoint(0, 2, -5), geom.NewPoint(0, 2, 0))
+	assert.Equal(t, expected, c)
+}
+
+func TestRayForPixel(t *testing.T) {
+	c := NewCamera(201, 101, math.Pi/2, geom.NewPoint(0, 2, -5), geom.NewPoint(0, 2, 0))
+
+	// the centre of the image looks straight ahead, with a direction of unit length along the view direction
+	r, ok := c.RayForPixel(100.5, 50.5)
+	assert.True(t, ok)
+	assertTupleInDelta(t, geom.NewPoint(0, 2, -5), r.Origin)
+	assertTupleInDelta(t, geom.NewVector(0, 0, 1), r.Direction)
+
+	// the left edge of a 90 degree field of view is 45 degrees to the left, still 1 along the view direction
+	r, _ = c.RayForPixel(0, 50.5)
+	assertTupleInDelta(t, geom.NewVector(-1, 0, 1), r.Direction)
+}
+
+func TestAutofocus(t *testing.T) {
+	c := NewCamera(100, 100, math.Pi/3, geom.NewPoint(0, 0, -5), geom.NewPoint(0, 0, 0))
+	s := shapes.NewSphere()
+	s.SetTransform(geom.Translate(0, 0, 2))
+
+	assert.True(t, c.Autofocus([]shapes.Shape{s}, 50, 50))
+	assert.InDelta(t, 6.0, c.FocusDistance, 0.01)
+
+	// nothing in the corner, the focus distance is kept
+	assert.False(t, c.Autofocus([]shapes.Shape{s}, 0, 0))
+	assert.InDelta(t, 6.0, c.FocusDistance, 0.01)
+}
+
+func TestSampleLensCircular(t *testing.T) {
+	c := Camera{Aperture: 0.5}
+	x, y := c.SampleLens(1, 0.25)
+	assert.InDelta(t, 0.0, x, geom.Epsilon)
+	assert.InDelta(t, 0.5, y, geom.Epsilon)
+
+	for _, u := range [][2]float64{{0, 0}, {0.3, 0.7}, {0.99, 0.5}} {
+		x, y := c.SampleLens(u[0], u[1])
+		assert.LessOrEqual(t, math.Hypot(x, y), 0.5+geom.Epsilon)
+	}
+}
+
+func TestSampleLensPolygonal(t *testing.T) {
+	c := Camera{Aperture: 2, Blades: 6, BladeRotation: math.Pi / 6}
+
+	// u1 == 0 is the centre, while u1 approaching the end of the first blade and u2 == 0 is its first corner
+	x, y := c.SampleLens(0, 0.5)
+	assert.Equal(t, 0.0, math.Hypot(x, y))
+	x, y = c.SampleLens(0.999999/6, 0)
+	assert.InDelta(t, 2*math.Cos(math.Pi/6), x, geom.Epsilon)
+	assert.InDelta(t, 2*math.Sin(math.Pi/6), y, geom.Epsilon)
+
+	// all samples are within the hexagon, whose edges are at a distance of cos(30°) times the radius from the centre
+	for u1 := 0.0; u1 < 1; u1 += 0.05 {
+		for u2 := 0.0; u2 <= 1; u2 += 0.1 {
+			x, y := c.SampleLens(u1, u2)
+			angle := math.Atan2(y, x) - c.BladeRotation
+			sector := math.Mod(angle+4*math.Pi, math.Pi/3) - math.Pi/6
+			assert.LessOrEqual(t, math.Hypot(x, y)*math.Cos(sector), 2*math.Cos(math.Pi/6)+geom.Epsilon)
+		}
+	}
+}
+
+func assertTupleInDelta(t *testing.T, expected, actual geom.Tuple4) {
+	for i := 0; i < 4; i++ {
+		assert.InDelta(t, expected[i], actual[i], geom.Epsilon)
+	}
+}
@@ -0,0 +1,74 @@
+package camera
+
+import (
+	"fmt"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"math"
+)
+
+// Projection decides how the pixels of the image map to rays leaving the camera. The values are passed as is to the
+// kernel, see rayForPixel in tracer.cl.
+type Projection int
+
+const (
+	// Perspective is the pinhole or thin lens camera with a field of view of Fov.
+	Perspective Projection = iota
+	// Orthographic shoots parallel rays along the view direction from a plane OrthoScale times the size of the
+	// perspective image plane, so there is no perspective distortion.
+	Orthographic
+	// Fisheye is an equidistant 180° fisheye, i.e. the angle to the view direction grows linearly with the distance
+	// from the centre of the image. It fills a circle across the shorter side of the image, leaving the rest black.
+	Fisheye
+	// Equirectangular is a full 360x180° panorama with longitude along x and latitude along y, looking forward in the
+	// centre of the image. Use an image twice as wide as it is high.
+	Equirectangular
+)
+
+var projectionNames = []string{"perspective", "orthographic", "fisheye", "equirectangular"}
+
+func (p Projection) String() string {
+	if p < 0 || int(p) >= len(projectionNames) {
+		return fmt.Sprintf("Projection(%d)", int(p))
+	}
+	return projectionNames[p]
+}
+
+// ParseProjection returns the projection of the passed name, such as "fisheye".
+func ParseProjection(name string) (Projection, error) {
+	for i, n := range projectionNames {
+		if n == name {
+			return Projection(i), nil
+		}
+	}
+	return Perspective, fmt.Errorf("unknown projection %q, expected one of %v", name, projectionNames)
+}
+
+// viewRay returns the origin and direction in camera space of the ray through the point x, y of the image, in pixels,
+// ignoring the lens. Returns false if the point is outside the image of the projection, i.e. the corners of fisheye
+// images. It is the reference implementation of the projections of rayForPixel in tracer.cl.
+func (c *Camera) viewRay(x, y float64) (geom.Tuple4, geom.Tuple4, bool) {
+	switch c.Projection {
+	case Orthographic:
+		origin := geom.NewPoint((c.HalfWidth-x*c.PixelSize)*c.OrthoScale, (c.HalfHeight-y*c.PixelSize)*c.OrthoScale, 0)
+		return origin, geom.NewVector(0, 0, -1), true
+	case Fisheye:
+		radius := math.Min(float64(c.Width), float64(c.Height)) * 0.5
+		nx := (float64(c.Width)*0.5 - x) / radius
+		ny := (float64(c.Height)*0.5 - y) / radius
+		r := math.Sqrt(nx*nx + ny*ny)
+		if r > 1.0 {
+			return geom.Tuple4{}, geom.Tuple4{}, false
+		}
+		theta := r * math.Pi * 0.5
+		phi := math.Atan2(ny, nx)
+		return geom.NewPoint(0, 0, 0), geom.NewVector(math.Sin(theta)*math.Cos(phi), math.Sin(theta)*math.Sin(phi), -math.Cos(theta)), true
+	case Equirectangular:
+		phi := (x/float64(c.Width) - 0.5) * 2 * math.Pi
+		theta := (0.5 - y/float64(c.Height)) * math.Pi
+		// the eye of stereo pairs is on the left (or the right) of the horizontal direction of the ray
+		origin := geom.NewPoint(math.Cos(phi)*c.EyeOffset, 0, -math.Sin(phi)*c.EyeOffset)
+		return origin, geom.NewVector(-math.Sin(phi)*math.Cos(theta), math.Sin(theta), -math.Cos(phi)*math.Cos(theta)), true
+	default:
+		return geom.NewPoint(0, 0, 0), geom.NewVector(c.HalfWidth-x*c.PixelSize, c.HalfHeight-y*c.PixelSize, -1), true
+	}
+}
@@ -0,0 +1,79 @@
+package camera
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestParseProjection(t *testing.T) {
+	for _, p := range []Projection{Perspective, Orthographic, Fisheye, Equirectangular} {
+		parsed, err := ParseProjection(p.String())
+		assert.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+	_, err := ParseProjection("cylindrical")
+	assert.Error(t, err)
+	assert.Equal(t, "Projection(7)", Projection(7).String())
+}
+
+// identityCamera looks along -z from the origin, so camera space is world space.
+func identityCamera(width, height int, projection Projection) Camera {
+	c := NewCamera(width, height, math.Pi/2, geom.NewPoint(0, 0, 0), geom.NewPoint(0, 0, -2))
+	c.Projection = projection
+	return c
+}
+
+func TestOrthographicRays(t *testing.T) {
+	c := identityCamera(200, 100, Orthographic)
+
+	// the image plane covers the same area at the look-at point as the 90° perspective view, i.e. 4 units across
+	r, ok := c.RayForPixel(0, 50)
+	assert.True(t, ok)
+	assertTupleInDelta(t, geom.NewPoint(2, 0, 0), r.Origin)
+	assertTupleInDelta(t, geom.NewVector(0, 0, -1), r.Direction)
+
+	r, _ = c.RayForPixel(200, 0)
+	assertTupleInDelta(t, geom.NewPoint(-2, 1, 0), r.Origin)
+	assertTupleInDelta(t, geom.NewVector(0, 0, -1), r.Direction)
+}
+
+func TestFisheyeRays(t *testing.T) {
+	c := identityCamera(200, 100, Fisheye)
+
+	r, ok := c.RayForPixel(100, 50)
+	assert.True(t, ok)
+	assertTupleInDelta(t, geom.NewVector(0, 0, -1), r.Direction)
+
+	// the circle spans the height of the image, with its edge 90° off the view direction
+	r, ok = c.RayForPixel(100, 0)
+	assert.True(t, ok)
+	assertTupleInDelta(t, geom.NewVector(0, 1, 0), r.Direction)
+	r, ok = c.RayForPixel(75, 50)
+	assert.True(t, ok)
+	assertTupleInDelta(t, geom.NewVector(math.Sqrt(0.5), 0, -math.Sqrt(0.5)), r.Direction)
+
+	// outside the circle
+	_, ok = c.RayForPixel(10, 50)
+	assert.False(t, ok)
+}
+
+func TestEquirectangularRays(t *testing.T) {
+	c := identityCamera(200, 100, Equirectangular)
+
+	r, ok := c.RayForPixel(100, 50)
+	assert.True(t, ok)
+	assertTupleInDelta(t, geom.NewPoint(0, 0, 0), r.Origin)
+	assertTupleInDelta(t, geom.NewVector(0, 0, -1), r.Direction)
+
+	// a quarter of the image to the left is 90° to the left, the edges look backwards
+	r, _ = c.RayForPixel(50, 50)
+	assertTupleInDelta(t, geom.NewVector(1, 0, 0), r.Direction)
+	r, _ = c.RayForPixel(0, 50)
+	assertTupleInDelta(t, geom.NewVector(0, 0, 1), r.Direction)
+
+	// the top row looks straight up
+	r, _ = c.RayForPixel(30, 0)
+	assertTupleInDelta(t, geom.NewVector(0, 1, 0), r.Direction)
+}
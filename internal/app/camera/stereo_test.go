@@ -0,0 +1,58 @@
+package camera
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestEyeCameraParallel(t *testing.T) {
+	c := identityCamera(200, 100, Perspective)
+
+	left := c.EyeCamera(LeftEye)
+	r, _ := left.RayForPixel(100, 50)
+	assertTupleInDelta(t, geom.NewPoint(0.032, 0, 0), r.Origin)
+	assertTupleInDelta(t, geom.NewVector(0, 0, -1), r.Direction)
+
+	right := c.EyeCamera(RightEye)
+	r, _ = right.RayForPixel(100, 50)
+	assertTupleInDelta(t, geom.NewPoint(-0.032, 0, 0), r.Origin)
+	assertTupleInDelta(t, geom.NewVector(0, 0, -1), r.Direction)
+
+	// the camera itself is left as is
+	assertTupleInDelta(t, geom.NewPoint(0, 0, 0), c.From)
+}
+
+func TestEyeCameraConverging(t *testing.T) {
+	c := NewCamera(200, 100, math.Pi/2, geom.NewPoint(0, 0, -5), geom.NewPoint(0, 0, 0))
+	c.IPD = 1
+	c.Convergence = 2
+
+	// the centre rays of both eyes meet 2 units in front of the camera
+	for _, eye := range []Eye{LeftEye, RightEye} {
+		e := c.EyeCamera(eye)
+		r, _ := e.RayForPixel(100, 50)
+		assert.InDelta(t, 0.5, math.Abs(r.Origin[0]), geom.Epsilon)
+		t2 := -r.Origin[0] / r.Direction[0]
+		assertTupleInDelta(t, geom.NewPoint(0, 0, -3), geom.Add(r.Origin, geom.MultiplyByScalar(r.Direction, t2)))
+	}
+}
+
+func TestEyeCameraEquirectangular(t *testing.T) {
+	c := identityCamera(200, 100, Equirectangular)
+	left := c.EyeCamera(LeftEye)
+	assert.Equal(t, c.Inverse, left.Inverse)
+	assert.Equal(t, 0.032, left.EyeOffset)
+
+	// the eye is always on the left of the direction of the ray
+	r, _ := left.RayForPixel(100, 50)
+	assertTupleInDelta(t, geom.NewPoint(0.032, 0, 0), r.Origin)
+	r, _ = left.RayForPixel(150, 50)
+	assertTupleInDelta(t, geom.NewVector(-1, 0, 0), r.Direction)
+	assertTupleInDelta(t, geom.NewPoint(0, 0, -0.032), r.Origin)
+
+	right := c.EyeCamera(RightEye)
+	r, _ = right.RayForPixel(100, 50)
+	assertTupleInDelta(t, geom.NewPoint(-0.032, 0, 0), r.Origin)
+}
@@ -0,0 +1,40 @@
+package canvas
+
+import "github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+
+// Accumulator is a weighted float accumulation buffer for reconstruction filters. Samples are splatted into the
+// pixels around them as a sum of colors weighted by the filter and the sum of those weights, and the color of a pixel
+// is the weighted average of all samples splatted into it.
+type Accumulator struct {
+	W       int
+	H       int
+	sums    []geom.Tuple4
+	weights []float64
+}
+
+func NewAccumulator(w int, h int) *Accumulator {
+	return &Accumulator{W: w, H: h, sums: make([]geom.Tuple4, w*h), weights: make([]float64, w*h)}
+}
+
+// Splat adds the weighted sum of the colors of samples and the sum of their weights to the pixel. Splats outside the
+// image are dropped.
+func (a *Accumulator) Splat(col, row int, weightedSum geom.Tuple4, weight float64) {
+	if row < 0 || col < 0 || row >= a.H || col >= a.W {
+		return
+	}
+	i := row*a.W + col
+	for c := 0; c < 3; c++ {
+		a.sums[i][c] += weightedSum[c]
+	}
+	a.weights[i] += weight
+}
+
+// ColorAt returns the weighted average of the samples splatted into the pixel, or black if their weights don't add up
+// to more than 0, which filters with negative lobes may do for pixels with few samples.
+func (a *Accumulator) ColorAt(col, row int) geom.Tuple4 {
+	i := row*a.W + col
+	if a.weights[i] <= 0 {
+		return geom.NewColor(0, 0, 0)
+	}
+	return geom.NewColor(a.sums[i][0]/a.weights[i], a.sums[i][1]/a.weights[i], a.sums[i][2]/a.weights[i])
+}
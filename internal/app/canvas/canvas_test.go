@@ -25,3 +25,16 @@ func TestCanvas_WritePixel(t *testing.T) {
 	assert.True(t, px.Get(1) == 0.0)
 	assert.True(t, px.Get(2) == 0.0)
 }
+
+func TestAccumulator_Splat(t *testing.T) {
+	film := NewAccumulator(2, 2)
+	film.Splat(0, 0, geom.NewColor(1, 0.5, 0), 2)
+	film.Splat(0, 0, geom.NewColor(-0.25, 0, 0), -0.5)
+	film.Splat(2, 0, geom.NewColor(1, 1, 1), 1)
+	film.Splat(1, -1, geom.NewColor(1, 1, 1), 1)
+	film.Splat(1, 1, geom.NewColor(1, 1, 1), -1)
+
+	assert.Equal(t, geom.NewColor(0.5, 1.0/3, 0), film.ColorAt(0, 0))
+	assert.Equal(t, geom.NewColor(0, 0, 0), film.ColorAt(1, 0), "splats outside the image are dropped")
+	assert.Equal(t, geom.NewColor(0, 0, 0), film.ColorAt(1, 1), "pixels with negative weights are black")
+}
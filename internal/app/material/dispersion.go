@@ -0,0 +1,62 @@
+package material
+
+import (
+	"math"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+)
+
+// This file contains the Go reference implementation of the dispersion model used by tracer.cl. A path that hits a
+// dispersive material picks a single wavelength, carries WavelengthToRGB of that wavelength as its color weight and
+// uses CauchyIOR for every dispersive refraction further down the path.
+
+const (
+	// MinWavelength and MaxWavelength is the range of visible light sampled, in nanometers.
+	MinWavelength = 380.0
+	MaxWavelength = 730.0
+
+	// Fraunhofer d, F and C lines used to define the Abbe number, in nanometers.
+	wavelengthD = 587.6
+	wavelengthF = 486.1
+	wavelengthC = 656.3
+)
+
+// spectral response of each color channel, modelled as a gaussian exp(-((lambda - center) / width)²)
+var responseCenter = [3]float64{610.0, 550.0, 465.0}
+var responseWidth = [3]float64{45.0, 40.0, 35.0}
+
+// responseScale normalizes each channel so that the average response over the sampled range is exactly 1, i.e.
+// white light stays white on average.
+var responseScale = func() [3]float64 {
+	var out [3]float64
+	for i := range out {
+		c, w := responseCenter[i], responseWidth[i]
+		integral := w * math.Sqrt(math.Pi) / 2.0 * (math.Erf((MaxWavelength-c)/w) - math.Erf((MinWavelength-c)/w))
+		out[i] = (MaxWavelength - MinWavelength) / integral
+	}
+	return out
+}()
+
+// CauchyIOR returns the refractive index at the given wavelength (nm) for a material with refractive index nd at the
+// d line and the given Abbe number, using the two term Cauchy equation n = A + B/lambda².
+func CauchyIOR(nd, abbe, wavelength float64) float64 {
+	b := (nd - 1.0) / (abbe * (1.0/(wavelengthF*wavelengthF) - 1.0/(wavelengthC*wavelengthC)))
+	a := nd - b/(wavelengthD*wavelengthD)
+	return a + b/(wavelength*wavelength)
+}
+
+// SampleWavelength maps a uniform random number in [0, 1) to a wavelength in the sampled range.
+func SampleWavelength(u float64) float64 {
+	return MinWavelength + u*(MaxWavelength-MinWavelength)
+}
+
+// WavelengthToRGB returns the color weight of a single wavelength sample.
+func WavelengthToRGB(wavelength float64) geom.Tuple4 {
+	var out geom.Tuple4
+	for i := 0; i < 3; i++ {
+		d := (wavelength - responseCenter[i]) / responseWidth[i]
+		out[i] = responseScale[i] * math.Exp(-d*d)
+	}
+	out[3] = 1.0
+	return out
+}
@@ -0,0 +1,43 @@
+package material
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCauchyIOR(t *testing.T) {
+	// BK7-like glass
+	assert.InDelta(t, 1.5168, CauchyIOR(1.5168, 64.17, wavelengthD), 1e-9)
+
+	// the Abbe number is defined as (nd - 1) / (nF - nC)
+	nF := CauchyIOR(1.5168, 64.17, wavelengthF)
+	nC := CauchyIOR(1.5168, 64.17, wavelengthC)
+	assert.InDelta(t, 64.17, (1.5168-1.0)/(nF-nC), 1e-9)
+
+	// blue light bends more than red
+	assert.True(t, CauchyIOR(1.5168, 64.17, 450) > CauchyIOR(1.5168, 64.17, 650))
+}
+
+func TestWavelengthToRGBAveragesToWhite(t *testing.T) {
+	const steps = 100000
+	var sum [3]float64
+	for i := 0; i < steps; i++ {
+		rgb := WavelengthToRGB(SampleWavelength((float64(i) + 0.5) / steps))
+		for c := 0; c < 3; c++ {
+			sum[c] += rgb[c]
+		}
+	}
+	for c := 0; c < 3; c++ {
+		assert.InDelta(t, 1.0, sum[c]/steps, 1e-6)
+	}
+}
+
+func TestWavelengthToRGB(t *testing.T) {
+	red := WavelengthToRGB(650)
+	assert.True(t, red[0] > red[1] && red[0] > red[2])
+	green := WavelengthToRGB(545)
+	assert.True(t, green[1] > green[0] && green[1] > green[2])
+	blue := WavelengthToRGB(450)
+	assert.True(t, blue[2] > blue[0] && blue[2] > blue[1])
+}
@@ -0,0 +1,248 @@
+package material
+
+import (
+	"math"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+)
+
+// This file contains Go reference implementations of the GGX microfacet functions used by tracer.cl. All directions
+// are given in a local shading frame where the (macro) surface normal is +Z, and both wo (towards the eye) and wi
+// (the sampled direction) point away from the surface. The kernel uses the separable Smith shadowing-masking term
+// G2 = G1(wo) * G1(wi), which makes the sample weight of visible normal sampling collapse into G1(wi).
+
+// RoughnessToAlpha maps a perceptual roughness in [0, 1] to the GGX alpha parameter.
+func RoughnessToAlpha(roughness float64) float64 {
+	return math.Max(roughness*roughness, 0.001)
+}
+
+// GGXDistribution is the GGX (Trowbridge-Reitz) normal distribution function D(m).
+func GGXDistribution(m geom.Tuple4, alpha float64) float64 {
+	if m[2] <= 0.0 {
+		return 0.0
+	}
+	a2 := alpha * alpha
+	d := m[2]*m[2]*(a2-1.0) + 1.0
+	return a2 / (math.Pi * d * d)
+}
+
+// SmithG1 is the Smith masking function for GGX, i.e. the fraction of microfacets visible from direction v.
+func SmithG1(v geom.Tuple4, alpha float64) float64 {
+	cos2 := v[2] * v[2]
+	if cos2 == 0.0 {
+		return 0.0
+	}
+	tan2 := (1.0 - cos2) / cos2
+	return 2.0 / (1.0 + math.Sqrt(1.0+alpha*alpha*tan2))
+}
+
+// SampleGGXVNDF samples a microfacet normal from the distribution of normals visible from wo, using "Sampling the
+// GGX Distribution of Visible Normals" by Eric Heitz (2018). wo is mirrored into the upper hemisphere if needed, and
+// the returned normal is always in the upper hemisphere.
+func SampleGGXVNDF(wo geom.Tuple4, alpha, u1, u2 float64) geom.Tuple4 {
+	// stretch the view vector so we're sampling a hemisphere
+	vh := geom.Normalize(geom.NewVector(alpha*wo[0], alpha*wo[1], wo[2]))
+	if vh[2] < 0.0 {
+		vh = geom.Negate(vh)
+	}
+
+	// build an orthonormal basis around the stretched view vector
+	t1 := geom.NewVector(1, 0, 0)
+	if lensq := vh[0]*vh[0] + vh[1]*vh[1]; lensq > 0.0 {
+		t1 = geom.DivideByScalar(geom.NewVector(-vh[1], vh[0], 0), math.Sqrt(lensq))
+	}
+	t2 := geom.Cross(vh, t1)
+
+	// sample the projected area of the visible hemisphere
+	r := math.Sqrt(u1)
+	phi := 2.0 * math.Pi * u2
+	p1 := r * math.Cos(phi)
+	p2 := r * math.Sin(phi)
+	s := 0.5 * (1.0 + vh[2])
+	p2 = (1.0-s)*math.Sqrt(1.0-p1*p1) + s*p2
+
+	// reproject onto the hemisphere and unstretch
+	p3 := math.Sqrt(math.Max(0.0, 1.0-p1*p1-p2*p2))
+	nh := geom.Add(geom.Add(geom.MultiplyByScalar(t1, p1), geom.MultiplyByScalar(t2, p2)), geom.MultiplyByScalar(vh, p3))
+	return geom.Normalize(geom.NewVector(alpha*nh[0], alpha*nh[1], math.Max(0.0, nh[2])))
+}
+
+// VisibleNormalPdf is the density of SampleGGXVNDF returning the microfacet normal m, given direction w.
+func VisibleNormalPdf(w, m geom.Tuple4, alpha float64) float64 {
+	if w[2] == 0.0 {
+		return 0.0
+	}
+	return SmithG1(w, alpha) / math.Abs(w[2]) * GGXDistribution(m, alpha) * math.Abs(geom.Dot(w, m))
+}
+
+// FresnelDielectric returns the unpolarized Fresnel reflectance for light arriving at cosI to a boundary where eta is
+// the ratio of the refractive index on the far side to the near side. A negative cosI means we are on the inside.
+func FresnelDielectric(cosI, eta float64) float64 {
+	cosI = math.Max(-1.0, math.Min(1.0, cosI))
+	if cosI < 0.0 {
+		eta = 1.0 / eta
+		cosI = -cosI
+	}
+	sin2I := 1.0 - cosI*cosI
+	sin2T := sin2I / (eta * eta)
+	if sin2T >= 1.0 {
+		// total internal reflection
+		return 1.0
+	}
+	cosT := math.Sqrt(math.Max(0.0, 1.0-sin2T))
+	rParl := (eta*cosI - cosT) / (eta*cosI + cosT)
+	rPerp := (cosI - eta*cosT) / (cosI + eta*cosT)
+	return (rParl*rParl + rPerp*rPerp) / 2.0
+}
+
+// FresnelConductor returns the unpolarized Fresnel reflectance of a conductor with the complex index of refraction
+// eta + i*k, for a single wavelength (color channel).
+func FresnelConductor(cosI, eta, k float64) float64 {
+	cosI = math.Max(0.0, math.Min(1.0, cosI))
+	cos2 := cosI * cosI
+	sin2 := 1.0 - cos2
+	eta2 := eta * eta
+	k2 := k * k
+
+	t0 := eta2 - k2 - sin2
+	a2PlusB2 := math.Sqrt(t0*t0 + 4.0*eta2*k2)
+	t1 := a2PlusB2 + cos2
+	a := math.Sqrt(math.Max(0.0, 0.5*(a2PlusB2+t0)))
+	t2 := 2.0 * cosI * a
+	rs := (t1 - t2) / (t1 + t2)
+
+	t3 := cos2*a2PlusB2 + sin2*sin2
+	t4 := t2 * sin2
+	rp := rs * (t3 - t4) / (t3 + t4)
+	return 0.5 * (rp + rs)
+}
+
+// Reflect mirrors the outgoing direction wo around the normal n.
+func Reflect(wo, n geom.Tuple4) geom.Tuple4 {
+	return geom.Sub(geom.MultiplyByScalar(n, 2.0*geom.Dot(wo, n)), wo)
+}
+
+// Refract refracts wo through a surface with normal n, where eta is the ratio of the refractive index below n to the
+// one above it. Returns false on total internal reflection.
+func Refract(wo, n geom.Tuple4, eta float64) (geom.Tuple4, bool) {
+	cosI := geom.Dot(n, wo)
+	if cosI < 0.0 {
+		eta = 1.0 / eta
+		cosI = -cosI
+		n = geom.Negate(n)
+	}
+	sin2T := math.Max(0.0, 1.0-cosI*cosI) / (eta * eta)
+	if sin2T >= 1.0 {
+		return geom.Tuple4{}, false
+	}
+	cosT := math.Sqrt(1.0 - sin2T)
+	return geom.Add(geom.DivideByScalar(geom.Negate(wo), eta), geom.MultiplyByScalar(n, cosI/eta-cosT)), true
+}
+
+// EvalGGXReflection returns the value and the sampling density of a GGX microfacet reflection lobe with a Fresnel
+// term of 1. Multiply f by the (conductor) Fresnel reflectance to get a rough metal.
+func EvalGGXReflection(wo, wi geom.Tuple4, alpha float64) (f float64, pdf float64) {
+	if wo[2] <= 0.0 || wi[2] <= 0.0 {
+		return 0.0, 0.0
+	}
+	m := geom.Add(wo, wi)
+	if geom.Magnitude(m) == 0.0 {
+		return 0.0, 0.0
+	}
+	m = geom.Normalize(m)
+	d := GGXDistribution(m, alpha)
+	f = d * SmithG1(wo, alpha) * SmithG1(wi, alpha) / (4.0 * wo[2] * wi[2])
+	pdf = VisibleNormalPdf(wo, m, alpha) / (4.0 * geom.Dot(wo, m))
+	return f, pdf
+}
+
+// SampleGGXReflection samples an incident direction for a GGX reflection lobe. weight is f*cos/pdf, which for
+// visible normal sampling is G1(wi). Returns false if the sampled direction ends up below the surface.
+func SampleGGXReflection(wo geom.Tuple4, alpha, u1, u2 float64) (wi geom.Tuple4, weight float64, ok bool) {
+	m := SampleGGXVNDF(wo, alpha, u1, u2)
+	wi = Reflect(wo, m)
+	if wi[2] <= 0.0 {
+		return wi, 0.0, false
+	}
+	return wi, SmithG1(wi, alpha), true
+}
+
+// EvalRoughDielectric returns the value and sampling density of a rough dielectric BSDF (GGX reflection plus
+// transmission, see Walter et al. 2007), where eta is the refractive index below the surface relative to above it.
+// Transmission is evaluated without the 1/eta² radiance scaling, matching how the kernel carries throughput.
+func EvalRoughDielectric(wo, wi geom.Tuple4, alpha, eta float64) (f float64, pdf float64) {
+	cosO := wo[2]
+	cosI := wi[2]
+	if cosO == 0.0 || cosI == 0.0 {
+		return 0.0, 0.0
+	}
+	reflect := cosI*cosO > 0.0
+	etap := 1.0
+	if !reflect {
+		etap = eta
+		if cosO < 0.0 {
+			etap = 1.0 / eta
+		}
+	}
+
+	// generalized half vector
+	m := geom.Add(geom.MultiplyByScalar(wi, etap), wo)
+	if geom.Magnitude(m) == 0.0 {
+		return 0.0, 0.0
+	}
+	m = geom.Normalize(m)
+	if m[2] < 0.0 {
+		m = geom.Negate(m)
+	}
+
+	// discard back-facing microfacets
+	if geom.Dot(m, wi)*cosI < 0.0 || geom.Dot(m, wo)*cosO < 0.0 {
+		return 0.0, 0.0
+	}
+
+	fr := FresnelDielectric(geom.Dot(wo, m), eta)
+	d := GGXDistribution(m, alpha)
+	g := SmithG1(wo, alpha) * SmithG1(wi, alpha)
+	if reflect {
+		f = d * g * fr / math.Abs(4.0*cosI*cosO)
+		pdf = VisibleNormalPdf(wo, m, alpha) / (4.0 * math.Abs(geom.Dot(wo, m))) * fr
+		return f, pdf
+	}
+
+	denom := geom.Dot(wi, m) + geom.Dot(wo, m)/etap
+	denom = denom * denom
+	f = (1.0 - fr) * d * g * math.Abs(geom.Dot(wi, m)*geom.Dot(wo, m)/(cosI*cosO*denom))
+	pdf = VisibleNormalPdf(wo, m, alpha) * math.Abs(geom.Dot(wi, m)) / denom * (1.0 - fr)
+	return f, pdf
+}
+
+// SampleRoughDielectric samples an incident direction for the rough dielectric BSDF. u1 selects reflection or
+// transmission based on the Fresnel reflectance of the sampled microfacet, u2 and u3 sample the microfacet itself.
+func SampleRoughDielectric(wo geom.Tuple4, alpha, eta, u1, u2, u3 float64) (wi geom.Tuple4, weight float64, ok bool) {
+	m := SampleGGXVNDF(wo, alpha, u2, u3)
+
+	// m is in the upper hemisphere, so the sign of wo·m tells FresnelDielectric which side we're on
+	fr := FresnelDielectric(geom.Dot(wo, m), eta)
+	if wo[2] < 0.0 {
+		// VNDF sampling is symmetric, put the microfacet on the same side as wo
+		m = geom.Negate(m)
+	}
+	if u1 < fr {
+		wi = Reflect(wo, m)
+		if wi[2]*wo[2] <= 0.0 {
+			return wi, 0.0, false
+		}
+	} else {
+		relEta := eta
+		if wo[2] < 0.0 {
+			// m points into the surface, so Refract needs the ratio flipped
+			relEta = 1.0 / eta
+		}
+		var refracted bool
+		wi, refracted = Refract(wo, m, relEta)
+		if !refracted || wi[2]*wo[2] >= 0.0 {
+			return wi, 0.0, false
+		}
+	}
+	return wi, SmithG1(wi, alpha), true
+}
@@ -0,0 +1,178 @@
+package material
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+const mcSamples = 200000
+
+var alphas = []float64{0.05, 0.2, 0.5, 1.0}
+
+// uniform sphere sampling is too noisy to integrate very sharp lobes, so the cross checks use wider ones
+var wideAlphas = []float64{0.2, 0.5, 1.0}
+
+func directionAt(cosTheta float64) geom.Tuple4 {
+	return geom.NewVector(math.Sqrt(1.0-cosTheta*cosTheta), 0, cosTheta)
+}
+
+// uniformSphere returns a uniformly distributed direction on the unit sphere, with a pdf of 1/4π.
+func uniformSphere(rnd *rand.Rand) geom.Tuple4 {
+	z := 1.0 - 2.0*rnd.Float64()
+	r := math.Sqrt(math.Max(0.0, 1.0-z*z))
+	phi := 2.0 * math.Pi * rnd.Float64()
+	return geom.NewVector(r*math.Cos(phi), r*math.Sin(phi), z)
+}
+
+func TestFresnelConductorWithoutAbsorptionMatchesDielectric(t *testing.T) {
+	for _, cos := range []float64{1.0, 0.8, 0.5, 0.2} {
+		assert.InDelta(t, FresnelDielectric(cos, 1.5), FresnelConductor(cos, 1.5, 0.0), 1e-9)
+	}
+	assert.InDelta(t, 0.04, FresnelConductor(1.0, 1.5, 0.0), 1e-9)
+}
+
+func TestFresnelConductorIsBounded(t *testing.T) {
+	// eta and k of gold for the red, green and blue channels
+	eta := []float64{0.143, 0.374, 1.442}
+	k := []float64{3.983, 2.385, 1.603}
+	for i := range eta {
+		for cos := 0.0; cos <= 1.0; cos += 0.05 {
+			f := FresnelConductor(cos, eta[i], k[i])
+			assert.True(t, f >= 0.0 && f <= 1.0, "F out of bounds: %f", f)
+		}
+		assert.InDelta(t, 1.0, FresnelConductor(0.0, eta[i], k[i]), 1e-9)
+	}
+}
+
+func TestFresnelDielectricTotalInternalReflection(t *testing.T) {
+	assert.Equal(t, 1.0, FresnelDielectric(-0.2, 1.5))
+	assert.True(t, FresnelDielectric(-1.0, 1.5) < 1.0)
+}
+
+func TestSampleGGXVNDFIsInUpperHemisphere(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, alpha := range alphas {
+		for i := 0; i < 1000; i++ {
+			m := SampleGGXVNDF(directionAt(rnd.Float64()), alpha, rnd.Float64(), rnd.Float64())
+			assert.True(t, m[2] >= 0.0)
+			assert.InDelta(t, 1.0, geom.Magnitude(m), 1e-9)
+		}
+	}
+}
+
+func TestGGXReflectionSampleWeightMatchesEval(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, alpha := range alphas {
+		wo := directionAt(0.6)
+		for i := 0; i < 1000; i++ {
+			wi, weight, ok := SampleGGXReflection(wo, alpha, rnd.Float64(), rnd.Float64())
+			if !ok {
+				continue
+			}
+			f, pdf := EvalGGXReflection(wo, wi, alpha)
+			assert.InEpsilon(t, f*wi[2]/pdf, weight, 1e-6)
+		}
+	}
+}
+
+func TestGGXReflectionConservesEnergy(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, alpha := range wideAlphas {
+		for _, cos := range []float64{1.0, 0.7, 0.3, 0.1} {
+			t.Run(fmt.Sprintf("alpha %v cos %v", alpha, cos), func(t *testing.T) {
+				wo := directionAt(cos)
+				sampled := 0.0
+				for i := 0; i < mcSamples; i++ {
+					if _, weight, ok := SampleGGXReflection(wo, alpha, rnd.Float64(), rnd.Float64()); ok {
+						sampled += weight
+					}
+				}
+				sampled /= mcSamples
+				assert.True(t, sampled <= 1.0, "albedo > 1: %f", sampled)
+
+				// independently estimate the albedo using uniform sphere sampling of the eval function
+				estimated := 0.0
+				for i := 0; i < mcSamples; i++ {
+					wi := uniformSphere(rnd)
+					f, _ := EvalGGXReflection(wo, wi, alpha)
+					estimated += f * math.Abs(wi[2]) * 4.0 * math.Pi
+				}
+				estimated /= mcSamples
+				assert.InDelta(t, sampled, estimated, 0.03)
+			})
+		}
+	}
+}
+
+func TestGGXReflectionPdfMatchesSampler(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, alpha := range wideAlphas {
+		wo := directionAt(0.8)
+
+		// the pdf should integrate to the fraction of samples that don't end up below the surface
+		valid := 0.0
+		for i := 0; i < mcSamples; i++ {
+			if _, _, ok := SampleGGXReflection(wo, alpha, rnd.Float64(), rnd.Float64()); ok {
+				valid++
+			}
+		}
+		valid /= mcSamples
+
+		sum := 0.0
+		for i := 0; i < mcSamples; i++ {
+			_, pdf := EvalGGXReflection(wo, uniformSphere(rnd), alpha)
+			sum += pdf * 4.0 * math.Pi
+		}
+		sum /= mcSamples
+		assert.InDelta(t, valid, sum, 0.03)
+	}
+}
+
+func TestRoughDielectricSampleWeightMatchesEval(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, alpha := range alphas {
+		for _, wo := range []geom.Tuple4{directionAt(0.6), geom.Negate(directionAt(0.9))} {
+			for i := 0; i < 1000; i++ {
+				wi, weight, ok := SampleRoughDielectric(wo, alpha, 1.5, rnd.Float64(), rnd.Float64(), rnd.Float64())
+				if !ok {
+					continue
+				}
+				f, pdf := EvalRoughDielectric(wo, wi, alpha, 1.5)
+				assert.InEpsilon(t, f*math.Abs(wi[2])/pdf, weight, 1e-6)
+			}
+		}
+	}
+}
+
+func TestRoughDielectricConservesEnergy(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// the transmission lobe is sharper than the reflection lobe, so skip the narrowest of the wide alphas too
+	for _, alpha := range wideAlphas[1:] {
+		for _, wo := range []geom.Tuple4{directionAt(1.0), directionAt(0.3), geom.Negate(directionAt(0.9)), geom.Negate(directionAt(0.3))} {
+			t.Run(fmt.Sprintf("alpha %v wo %v", alpha, wo), func(t *testing.T) {
+				sampled := 0.0
+				for i := 0; i < mcSamples; i++ {
+					if _, weight, ok := SampleRoughDielectric(wo, alpha, 1.5, rnd.Float64(), rnd.Float64(), rnd.Float64()); ok {
+						sampled += weight
+					}
+				}
+				sampled /= mcSamples
+				assert.True(t, sampled <= 1.0, "albedo > 1: %f", sampled)
+
+				estimated := 0.0
+				for i := 0; i < mcSamples; i++ {
+					wi := uniformSphere(rnd)
+					f, _ := EvalRoughDielectric(wo, wi, alpha, 1.5)
+					estimated += f * math.Abs(wi[2]) * 4.0 * math.Pi
+				}
+				estimated /= mcSamples
+				assert.InDelta(t, sampled, estimated, 0.05)
+			})
+		}
+	}
+}
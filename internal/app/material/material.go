@@ -1,6 +1,8 @@
 package material
 
 import (
+	"math"
+
 	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
 )
 
@@ -17,7 +19,80 @@ type Material struct {
 	TextureIDNM     uint8
 	TextureScaleXNM float64
 	TextureScaleYNM float64
+	StrengthNM      float64 // scales the bumps of the normal map, 0 is flat and 1 is the normal map as authored.
 	IsEnvMap        bool
+	Roughness       float64     // 0 is perfectly smooth, 1 is very rough. Used by metals, reflective and glass materials.
+	Metalness       float64     // probability of a bounce being a conductor (metal) reflection
+	Eta             geom.Tuple4 // per channel real part of the complex index of refraction of a conductor
+	K               geom.Tuple4 // per channel imaginary part (absorption) of a conductor. Zero means Fresnel is ignored.
+
+	// The fields below are the principled (Disney-style) layers on top of the base color. All default to 0, which keeps
+	// the plain diffuse, reflective and refractive behaviour above.
+	Specular           float64 // strength of the dielectric specular layer, 0.5 corresponds to a reflectance of 4%.
+	Clearcoat          float64 // strength of a white, glossy coat on top of everything else.
+	ClearcoatRoughness float64 // roughness of the clearcoat layer.
+	Sheen              float64 // white retro-reflection at grazing angles, useful for cloth.
+	Transmission       float64 // probability of refracting into the material. 0 with a RefractiveIndex != 1 means always.
+
+	// Absorption is the per channel Beer-Lambert absorption coefficient of refractive materials, per scene unit. Light
+	// travelling a distance t inside the material is attenuated by exp(-Absorption * t).
+	Absorption geom.Tuple4
+
+	// AbbeNumber enables chromatic dispersion of refractive materials, where RefractiveIndex is the index at 587.6nm.
+	// Lower numbers disperse more. 0 means no dispersion, which also is much cheaper to render.
+	AbbeNumber float64
+
+	// Procedural patterns, evaluated in the object space of the shape. ColorPattern replaces the color,
+	// RoughnessPattern the roughness and BumpPattern tilts the normal against the gradient of the pattern, scaled by
+	// BumpStrength. Nil means not used.
+	ColorPattern     *Pattern
+	RoughnessPattern *Pattern
+	BumpPattern      *Pattern
+	BumpStrength     float64
+}
+
+// Principled holds the parameters of a principled BSDF as exported by most DCC tools.
+type Principled struct {
+	BaseColor          geom.Tuple4
+	Metallic           float64
+	Roughness          float64
+	Specular           float64
+	Clearcoat          float64
+	ClearcoatRoughness float64
+	Sheen              float64
+	Transmission       float64
+	IOR                float64
+}
+
+// NewPrincipledParams returns the defaults for a principled BSDF, i.e. a white, fairly rough dielectric.
+func NewPrincipledParams() Principled {
+	return Principled{
+		BaseColor:          geom.Tuple4{0.8, 0.8, 0.8},
+		Roughness:          0.5,
+		Specular:           0.5,
+		ClearcoatRoughness: 0.03,
+		IOR:                1.5,
+	}
+}
+
+// NewPrincipled maps principled BSDF parameters onto a Material.
+func NewPrincipled(p Principled) Material {
+	m := Material{
+		Color:              p.BaseColor,
+		Emission:           geom.Tuple4{0, 0, 0},
+		RefractiveIndex:    1.0,
+		Roughness:          p.Roughness,
+		Metalness:          p.Metallic,
+		Specular:           p.Specular,
+		Clearcoat:          p.Clearcoat,
+		ClearcoatRoughness: p.ClearcoatRoughness,
+		Sheen:              p.Sheen,
+		Transmission:       p.Transmission,
+	}
+	if p.Transmission > 0.0 && p.IOR > 0.0 {
+		m.RefractiveIndex = p.IOR
+	}
+	return m
 }
 
 func NewDefaultMaterial() Material {
@@ -43,6 +118,39 @@ func NewGlass() Material {
 		Reflectivity:    0.05,
 	}
 }
+
+// NewColoredGlass returns glass that has the given color after light has travelled the given distance through it.
+// Thin parts will look almost clear, while thick parts get deeper in color.
+func NewColoredGlass(r, g, b, distance float64) Material {
+	m := NewGlass()
+	m.Absorption = AbsorptionFromColorAtDistance(geom.Tuple4{r, g, b}, distance)
+	return m
+}
+
+// AbsorptionFromColorAtDistance computes Beer-Lambert absorption coefficients such that white light has the given
+// color after travelling distance units through the medium.
+func AbsorptionFromColorAtDistance(color geom.Tuple4, distance float64) geom.Tuple4 {
+	var sigma geom.Tuple4
+	for i := 0; i < 3; i++ {
+		// a fully black channel would be infinitely absorbing, clamp it to something very dark instead
+		sigma[i] = -math.Log(math.Max(color[i], 1e-4)) / distance
+	}
+	return sigma
+}
+
+// NewDispersiveGlass returns glass that splits white light into a rainbow, e.g. flint glass (1.62, 36) for a prism.
+func NewDispersiveGlass(refractiveIndex, abbeNumber float64) Material {
+	m := NewGlass()
+	m.RefractiveIndex = refractiveIndex
+	m.AbbeNumber = abbeNumber
+	return m
+}
+
+// NewDiamond returns a material with the refractive index and dispersion of diamond.
+func NewDiamond() Material {
+	return NewDispersiveGlass(2.417, 55.3)
+}
+
 func NewMirror() Material {
 	return Material{
 		Color:           geom.Tuple4{1, 1, 1},
@@ -58,3 +166,73 @@ func NewLightBulb() Material {
 		RefractiveIndex: 1.0,
 	}
 }
+
+// NewMetal returns a conductor with the given color and roughness. The color is used as the reflectance directly.
+func NewMetal(r, g, b, roughness float64) Material {
+	return Material{
+		Color:           geom.Tuple4{r, g, b},
+		Emission:        geom.Tuple4{0, 0, 0},
+		RefractiveIndex: 1.0,
+		Roughness:       roughness,
+		Metalness:       1.0,
+	}
+}
+
+// NewConductor returns a metal whose color comes from the Fresnel equations of its complex index of refraction
+// eta + i*k, given per color channel.
+func NewConductor(eta, k geom.Tuple4, roughness float64) Material {
+	return Material{
+		Color:           geom.Tuple4{1, 1, 1},
+		Emission:        geom.Tuple4{0, 0, 0},
+		RefractiveIndex: 1.0,
+		Roughness:       roughness,
+		Metalness:       1.0,
+		Eta:             eta,
+		K:               k,
+	}
+}
+
+// NewFrostedGlass returns a glass material with a rough surface, i.e. blurry reflections and refractions.
+func NewFrostedGlass(roughness float64) Material {
+	return Material{
+		Color:           geom.Tuple4{1, 1, 1},
+		Emission:        geom.Tuple4{0, 0, 0},
+		RefractiveIndex: 1.52,
+		Roughness:       roughness,
+	}
+}
+
+// NewPlastic returns a glossy plastic, i.e. a colored diffuse base under a white specular layer.
+func NewPlastic(r, g, b float64) Material {
+	p := NewPrincipledParams()
+	p.BaseColor = geom.Tuple4{r, g, b}
+	p.Roughness = 0.25
+	return NewPrincipled(p)
+}
+
+// NewGold returns polished gold, using the measured complex index of refraction for the red, green and blue channels.
+func NewGold() Material {
+	return NewConductor(geom.Tuple4{0.143, 0.374, 1.442}, geom.Tuple4{3.983, 2.385, 1.603}, 0.2)
+}
+
+// NewVarnishedWood returns a rough, wood-colored base under a smooth clearcoat.
+func NewVarnishedWood() Material {
+	p := NewPrincipledParams()
+	p.BaseColor = geom.Tuple4{0.45, 0.25, 0.12}
+	p.Roughness = 0.7
+	p.Specular = 0.3
+	p.Clearcoat = 1.0
+	p.ClearcoatRoughness = 0.05
+	return NewPrincipled(p)
+}
+
+// SetNormalMap enables tangent-space normal mapping using texture textureID from the scene's standard textures. The
+// scale repeats the map that many times per unit (planes, cubes) or around the shape (spheres, cylinders), while
+// triangles use the texture coordinates of the mesh scaled by the same factors.
+func (m *Material) SetNormalMap(textureID uint8, scaleX, scaleY, strength float64) {
+	m.TexturedNM = true
+	m.TextureIDNM = textureID
+	m.TextureScaleXNM = scaleX
+	m.TextureScaleYNM = scaleY
+	m.StrengthNM = strength
+}
@@ -0,0 +1,65 @@
+package material
+
+import (
+	"math"
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPrincipled(t *testing.T) {
+	p := NewPrincipledParams()
+	p.BaseColor = geom.Tuple4{0.1, 0.2, 0.3}
+	p.Metallic = 0.5
+	p.Roughness = 0.4
+	p.Clearcoat = 1.0
+	p.Sheen = 0.25
+
+	m := NewPrincipled(p)
+	assert.Equal(t, geom.Tuple4{0.1, 0.2, 0.3}, m.Color)
+	assert.Equal(t, 0.5, m.Metalness)
+	assert.Equal(t, 0.4, m.Roughness)
+	assert.Equal(t, 0.5, m.Specular)
+	assert.Equal(t, 1.0, m.Clearcoat)
+	assert.Equal(t, 0.03, m.ClearcoatRoughness)
+	assert.Equal(t, 0.25, m.Sheen)
+
+	// without transmission, the IOR must not turn the material into glass
+	assert.Equal(t, 1.0, m.RefractiveIndex)
+}
+
+func TestNewPrincipledWithTransmission(t *testing.T) {
+	p := NewPrincipledParams()
+	p.Transmission = 0.7
+	p.IOR = 1.33
+
+	m := NewPrincipled(p)
+	assert.Equal(t, 0.7, m.Transmission)
+	assert.Equal(t, 1.33, m.RefractiveIndex)
+}
+
+func TestPresets(t *testing.T) {
+	plastic := NewPlastic(1, 0, 0)
+	assert.Equal(t, 0.0, plastic.Metalness)
+	assert.True(t, plastic.Specular > 0.0)
+
+	gold := NewGold()
+	assert.Equal(t, 1.0, gold.Metalness)
+	// gold reflects much more red than blue at normal incidence
+	assert.True(t, FresnelConductor(1.0, gold.Eta[0], gold.K[0]) > FresnelConductor(1.0, gold.Eta[2], gold.K[2]))
+
+	wood := NewVarnishedWood()
+	assert.Equal(t, 1.0, wood.Clearcoat)
+	assert.True(t, wood.ClearcoatRoughness < wood.Roughness)
+}
+
+func TestAbsorptionFromColorAtDistance(t *testing.T) {
+	sigma := AbsorptionFromColorAtDistance(geom.Tuple4{0.5, 0.25, 1.0}, 2.0)
+	assert.InDelta(t, 0.5, math.Exp(-sigma[0]*2.0), 1e-9)
+	assert.InDelta(t, 0.25, math.Exp(-sigma[1]*2.0), 1e-9)
+	assert.Equal(t, 0.0, sigma[2])
+
+	// a thinner slab of the same medium is lighter
+	assert.True(t, math.Exp(-sigma[0]*0.5) > 0.5)
+}
@@ -0,0 +1,236 @@
+package material
+
+import (
+	"math"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+)
+
+// This file contains the Go reference implementation of the procedural patterns evaluated by tracer.cl. Every pattern
+// maps a point in pattern space to a value in [0, 1], which is then used to blend between the two colors A and B, or
+// between the first channel of A and B for scalar uses such as roughness.
+
+type PatternType int32
+
+const (
+	CheckerPattern PatternType = iota + 1
+	StripePattern
+	GradientPattern
+	RingPattern
+	NoisePattern
+	MarblePattern
+	WoodPattern
+	VoronoiPattern
+)
+
+// patternDelta is the step used for the central differences of bump patterns, in pattern space.
+const patternDelta = 0.001
+
+type Pattern struct {
+	Type       PatternType
+	A          geom.Tuple4
+	B          geom.Tuple4
+	Octaves    int     // number of fBm octaves used by noise, marble and wood.
+	Turbulence float64 // how much fBm noise distorts the veins of marble and the rings of wood.
+	Transform  geom.Mat4x4
+	Inverse    geom.Mat4x4
+}
+
+// NewPattern returns a pattern blending from a to b. The pattern is evaluated in the object space of the shape it's
+// applied to, use SetTransform to scale, rotate or move it relative to the shape.
+func NewPattern(patternType PatternType, a, b geom.Tuple4) *Pattern {
+	return &Pattern{
+		Type:       patternType,
+		A:          a,
+		B:          b,
+		Octaves:    4,
+		Turbulence: 1.0,
+		Transform:  geom.New4x4(),
+		Inverse:    geom.New4x4(),
+	}
+}
+
+// SetTransform applies the transformation matrix to the pattern, just like the SetTransform of shapes.
+func (p *Pattern) SetTransform(transform geom.Mat4x4) {
+	p.Transform = geom.Multiply(p.Transform, transform)
+	p.Inverse = geom.Inverse(p.Transform)
+}
+
+// ValueAt returns the value of the pattern in [0, 1] at the given point in object space.
+func (p *Pattern) ValueAt(objectPoint geom.Tuple4) float64 {
+	point := geom.MultiplyByTuple(p.Inverse, objectPoint)
+	return patternValue(p.Type, p.Octaves, p.Turbulence, point[0], point[1], point[2])
+}
+
+// ColorAt returns the color of the pattern at the given point in object space.
+func (p *Pattern) ColorAt(objectPoint geom.Tuple4) geom.Tuple4 {
+	v := p.ValueAt(objectPoint)
+	var out geom.Tuple4
+	for i := 0; i < 3; i++ {
+		out[i] = p.A[i] + (p.B[i]-p.A[i])*v
+	}
+	out[3] = 1.0
+	return out
+}
+
+// ScalarAt returns the first channel of ColorAt, which is what scalar uses such as roughness read.
+func (p *Pattern) ScalarAt(objectPoint geom.Tuple4) float64 {
+	return p.A[0] + (p.B[0]-p.A[0])*p.ValueAt(objectPoint)
+}
+
+// GradientAt returns the gradient of ScalarAt in object space, using central differences. Bump patterns tilt the
+// normal against this gradient.
+func (p *Pattern) GradientAt(objectPoint geom.Tuple4) geom.Tuple4 {
+	var out geom.Tuple4
+	for i := 0; i < 3; i++ {
+		p1, p0 := objectPoint, objectPoint
+		p1[i] += patternDelta
+		p0[i] -= patternDelta
+		out[i] = (p.ScalarAt(p1) - p.ScalarAt(p0)) / (2 * patternDelta)
+	}
+	return out
+}
+
+func patternValue(patternType PatternType, octaves int, turbulence, x, y, z float64) float64 {
+	switch patternType {
+	case CheckerPattern:
+		return floorMod2(math.Floor(x) + math.Floor(y) + math.Floor(z))
+	case StripePattern:
+		return floorMod2(math.Floor(x))
+	case GradientPattern:
+		return x - math.Floor(x)
+	case RingPattern:
+		return floorMod2(math.Floor(math.Sqrt(x*x + z*z)))
+	case NoisePattern:
+		return clamp01(0.5 + 0.5*fbm(x, y, z, octaves))
+	case MarblePattern:
+		return 0.5 + 0.5*math.Sin((x+turbulence*fbm(x, y, z, octaves))*math.Pi)
+	case WoodPattern:
+		r := math.Sqrt(x*x+z*z) + turbulence*fbm(x, y, z, octaves)
+		return r - math.Floor(r)
+	case VoronoiPattern:
+		return clamp01(voronoi(x, y, z))
+	}
+	return 0.0
+}
+
+func floorMod2(v float64) float64 {
+	return v - 2.0*math.Floor(v/2.0)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0.0, math.Min(1.0, v))
+}
+
+// permutation is the permutation table from Ken Perlin's reference implementation of improved noise. The kernel uses
+// the very same table, so both sides produce the same noise.
+var permutation = [256]int{
+	151, 160, 137, 91, 90, 15, 131, 13, 201, 95, 96, 53, 194, 233, 7, 225, 140, 36, 103, 30, 69, 142, 8, 99, 37, 240,
+	21, 10, 23, 190, 6, 148, 247, 120, 234, 75, 0, 26, 197, 62, 94, 252, 219, 203, 117, 35, 11, 32, 57, 177, 33, 88,
+	237, 149, 56, 87, 174, 20, 125, 136, 171, 168, 68, 175, 74, 165, 71, 134, 139, 48, 27, 166, 77, 146, 158, 231, 83,
+	111, 229, 122, 60, 211, 133, 230, 220, 105, 92, 41, 55, 46, 245, 40, 244, 102, 143, 54, 65, 25, 63, 161, 1, 216,
+	80, 73, 209, 76, 132, 187, 208, 89, 18, 169, 200, 196, 135, 130, 116, 188, 159, 86, 164, 100, 109, 198, 173, 186,
+	3, 64, 52, 217, 226, 250, 124, 123, 5, 202, 38, 147, 118, 126, 255, 82, 85, 212, 207, 206, 59, 227, 47, 16, 58, 17,
+	182, 189, 28, 42, 223, 183, 170, 213, 119, 248, 152, 2, 44, 154, 163, 70, 221, 153, 101, 155, 167, 43, 172, 9, 129,
+	22, 39, 253, 19, 98, 108, 110, 79, 113, 224, 232, 178, 185, 112, 104, 218, 246, 97, 228, 251, 34, 242, 193, 238,
+	210, 144, 12, 191, 179, 162, 241, 81, 51, 145, 235, 249, 14, 239, 107, 49, 192, 214, 31, 181, 199, 106, 157, 184,
+	84, 204, 176, 115, 121, 50, 45, 127, 4, 150, 254, 138, 236, 205, 93, 222, 114, 67, 29, 24, 72, 243, 141, 128, 195,
+	78, 66, 215, 61, 156, 180,
+}
+
+func perm(i int) int {
+	return permutation[i&255]
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+func grad(hash int, x, y, z float64) float64 {
+	h := hash & 15
+	u := y
+	if h < 8 {
+		u = x
+	}
+	v := z
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+// perlin returns Ken Perlin's improved noise at the given point, roughly in [-1, 1] and 0 at every lattice point.
+func perlin(x, y, z float64) float64 {
+	fx, fy, fz := math.Floor(x), math.Floor(y), math.Floor(z)
+	X, Y, Z := int(fx)&255, int(fy)&255, int(fz)&255
+	x, y, z = x-fx, y-fy, z-fz
+	u, v, w := fade(x), fade(y), fade(z)
+
+	a := perm(X) + Y
+	aa := perm(a) + Z
+	ab := perm(a+1) + Z
+	b := perm(X+1) + Y
+	ba := perm(b) + Z
+	bb := perm(b+1) + Z
+
+	return lerp(w,
+		lerp(v,
+			lerp(u, grad(perm(aa), x, y, z), grad(perm(ba), x-1, y, z)),
+			lerp(u, grad(perm(ab), x, y-1, z), grad(perm(bb), x-1, y-1, z))),
+		lerp(v,
+			lerp(u, grad(perm(aa+1), x, y, z-1), grad(perm(ba+1), x-1, y, z-1)),
+			lerp(u, grad(perm(ab+1), x, y-1, z-1), grad(perm(bb+1), x-1, y-1, z-1))))
+}
+
+// fbm sums octaves of perlin noise, doubling the frequency and halving the amplitude for each octave. The sum is
+// normalized so that it stays roughly in [-1, 1] regardless of the number of octaves.
+func fbm(x, y, z float64, octaves int) float64 {
+	sum, amplitude, total := 0.0, 1.0, 0.0
+	for i := 0; i < octaves; i++ {
+		sum += amplitude * perlin(x, y, z)
+		total += amplitude
+		amplitude *= 0.5
+		x, y, z = x*2, y*2, z*2
+	}
+	if total == 0.0 {
+		return 0.0
+	}
+	return sum / total
+}
+
+// voronoiPoint returns the feature point of the unit cell with the given integer coordinates.
+func voronoiPoint(i, j, k int) (float64, float64, float64) {
+	h := perm(perm(perm(i)+j) + k)
+	return float64(i) + float64(perm(h))/255.0,
+		float64(j) + float64(perm(h+85))/255.0,
+		float64(k) + float64(perm(h+170))/255.0
+}
+
+// voronoi returns the distance to the closest feature point (F1 of Worley noise), where every unit cell holds one
+// feature point.
+func voronoi(x, y, z float64) float64 {
+	ci, cj, ck := int(math.Floor(x)), int(math.Floor(y)), int(math.Floor(z))
+	closest := math.MaxFloat64
+	for i := ci - 1; i <= ci+1; i++ {
+		for j := cj - 1; j <= cj+1; j++ {
+			for k := ck - 1; k <= ck+1; k++ {
+				px, py, pz := voronoiPoint(i, j, k)
+				dx, dy, dz := px-x, py-y, pz-z
+				closest = math.Min(closest, dx*dx+dy*dy+dz*dz)
+			}
+		}
+	}
+	return math.Sqrt(closest)
+}
@@ -0,0 +1,130 @@
+package material
+
+import (
+	"math"
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+var white = geom.NewColor(1, 1, 1)
+var black = geom.NewColor(0, 0, 0)
+
+func TestStripePattern(t *testing.T) {
+	p := NewPattern(StripePattern, white, black)
+
+	// constant in y and z
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0, 0, 0)))
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0, 1, 0)))
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0, 0, 2)))
+
+	// alternates in x
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0.9, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(1, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(-0.1, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(-1, 0, 0)))
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(-1.1, 0, 0)))
+}
+
+func TestCheckerPattern(t *testing.T) {
+	p := NewPattern(CheckerPattern, white, black)
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0, 0, 0)))
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0.99, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(1.01, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(0, 1.01, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(0, 0, 1.01)))
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(1.01, 1.01, 0)))
+}
+
+func TestGradientPattern(t *testing.T) {
+	p := NewPattern(GradientPattern, white, black)
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0, 0, 0)))
+	assert.Equal(t, geom.NewColor(0.75, 0.75, 0.75), p.ColorAt(geom.NewPoint(0.25, 0, 0)))
+	assert.Equal(t, geom.NewColor(0.5, 0.5, 0.5), p.ColorAt(geom.NewPoint(0.5, 0, 0)))
+	assert.Equal(t, geom.NewColor(0.25, 0.25, 0.25), p.ColorAt(geom.NewPoint(0.75, 0, 0)))
+}
+
+func TestRingPattern(t *testing.T) {
+	p := NewPattern(RingPattern, white, black)
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(0, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(1, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(0, 0, 1)))
+	// 0.708 = just slightly more than √2/2
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(0.708, 0, 0.708)))
+}
+
+func TestPatternTransform(t *testing.T) {
+	p := NewPattern(StripePattern, white, black)
+	p.SetTransform(geom.Scale(2, 2, 2))
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(1.5, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(2.5, 0, 0)))
+
+	p = NewPattern(StripePattern, white, black)
+	p.SetTransform(geom.Translate(0.5, 0, 0))
+	assert.Equal(t, white, p.ColorAt(geom.NewPoint(1.4, 0, 0)))
+	assert.Equal(t, black, p.ColorAt(geom.NewPoint(1.6, 0, 0)))
+}
+
+func TestPerlin(t *testing.T) {
+	// value from Ken Perlin's reference implementation
+	assert.InDelta(t, 0.13691995878400012, perlin(3.14, 42, 7), 1e-12)
+
+	// zero at every lattice point
+	assert.Equal(t, 0.0, perlin(1, 2, 3))
+	assert.Equal(t, 0.0, perlin(-4, 0, 17))
+
+	// repeats every 256 units
+	assert.InDelta(t, perlin(0.3, 0.6, 0.9), perlin(256.3, 0.6, -255.1), 1e-9)
+}
+
+func TestPermutationIsAPermutation(t *testing.T) {
+	seen := map[int]bool{}
+	for _, v := range permutation {
+		seen[v] = true
+	}
+	assert.Len(t, seen, 256)
+}
+
+func TestFBM(t *testing.T) {
+	// a single octave is plain perlin noise
+	assert.Equal(t, perlin(0.3, 0.6, 0.9), fbm(0.3, 0.6, 0.9, 1))
+
+	for i := 0; i < 1000; i++ {
+		x, y, z := float64(i)*0.137, float64(i)*0.071, float64(i)*-0.053
+		assert.True(t, math.Abs(fbm(x, y, z, 5)) <= 1.0)
+	}
+}
+
+func TestVoronoi(t *testing.T) {
+	// zero at a feature point
+	x, y, z := voronoiPoint(3, -2, 5)
+	assert.InDelta(t, 0.0, voronoi(x, y, z), 1e-12)
+
+	// never further away than the diagonal of a cell, since every cell has a feature point
+	for i := 0; i < 1000; i++ {
+		d := voronoi(float64(i)*0.137, float64(i)*0.071, float64(i)*-0.053)
+		assert.True(t, d >= 0.0 && d <= math.Sqrt(3))
+	}
+}
+
+func TestPatternValuesInRange(t *testing.T) {
+	for _, patternType := range []PatternType{CheckerPattern, StripePattern, GradientPattern, RingPattern, NoisePattern, MarblePattern, WoodPattern, VoronoiPattern} {
+		p := NewPattern(patternType, white, black)
+		for i := 0; i < 500; i++ {
+			v := p.ValueAt(geom.NewPoint(float64(i)*0.137-30, float64(i)*0.071, float64(i)*-0.053))
+			assert.True(t, v >= 0.0 && v <= 1.0, "pattern %d value %f out of range", patternType, v)
+		}
+	}
+}
+
+func TestPatternScalarAndGradient(t *testing.T) {
+	// roughness ramping from 0.2 to 0.6 along x
+	p := NewPattern(GradientPattern, geom.Tuple4{0.2, 0, 0}, geom.Tuple4{0.6, 0, 0})
+	assert.InDelta(t, 0.3, p.ScalarAt(geom.NewPoint(0.25, 0, 0)), 1e-12)
+
+	grad := p.GradientAt(geom.NewPoint(0.5, 0.3, 0.7))
+	assert.InDelta(t, 0.4, grad[0], 1e-6)
+	assert.InDelta(t, 0.0, grad[1], 1e-6)
+	assert.InDelta(t, 0.0, grad[2], 1e-6)
+}
@@ -21,6 +21,7 @@ func ParseObj(data string) *Obj {
 	// fill index 0 with placeholder
 	out.Verticies = append(out.Verticies, geom.NewPoint(0, 0, 0))
 	out.Normals = append(out.Normals, geom.NewVector(0, 0, 0))
+	out.TexCoords = append(out.TexCoords, geom.NewTuple())
 	rows := strings.Split(data, "\n")
 	var currentGroup = "DefaultGroup"
 	var currentMaterial = material.NewDefaultMaterial()
@@ -55,6 +56,14 @@ func ParseObj(data string) *Obj {
 				z, _ := strconv.ParseFloat(parts[3], 64)
 				out.Normals = append(out.Normals, geom.NewVector(x, y, z))
 
+			case "vt":
+				u, _ := strconv.ParseFloat(parts[1], 64)
+				v := 0.0
+				if len(parts) > 2 {
+					v, _ = strconv.ParseFloat(parts[2], 64)
+				}
+				out.TexCoords = append(out.TexCoords, geom.Tuple4{u, v, 0, 0})
+
 			case "f":
 				// 1/1/1 == vertex/texture/normal
 
@@ -81,10 +90,12 @@ func ParseObj(data string) *Obj {
 						idx2, _ := strconv.Atoi(subparts2[0])
 						idx3, _ := strconv.Atoi(subparts3[0])
 
-						// Future texture coordinates
-						_, _ = strconv.Atoi(subparts1[1])
-						_, _ = strconv.Atoi(subparts2[1])
-						_, _ = strconv.Atoi(subparts3[1])
+						// Texture coordinates, which may be left out as in 1//1
+						texIdx1, err1 := strconv.Atoi(subparts1[1])
+						texIdx2, err2 := strconv.Atoi(subparts2[1])
+						texIdx3, err3 := strconv.Atoi(subparts3[1])
+						hasUV := err1 == nil && err2 == nil && err3 == nil &&
+							out.validTexCoord(texIdx1) && out.validTexCoord(texIdx2) && out.validTexCoord(texIdx3)
 
 						// Normal
 						var normIdx1, normIdx2, normIdx3 int
@@ -102,6 +113,12 @@ func ParseObj(data string) *Obj {
 							out.Normals[normIdx2],
 							out.Normals[normIdx3])
 						tri.Material = currentMaterial
+						if hasUV {
+							tri.UV1 = out.TexCoords[texIdx1]
+							tri.UV2 = out.TexCoords[texIdx2]
+							tri.UV3 = out.TexCoords[texIdx3]
+							tri.HasUV = true
+						}
 						out.Groups[currentGroup].AddChild(tri)
 					}
 				}
@@ -126,14 +143,68 @@ func ParseObj(data string) *Obj {
 	for i := range out.Groups {
 		tris += len(out.Groups[i].Children)
 	}
+	if len(out.TexCoords) > 1 {
+		ComputeTangents(out.Triangles())
+	}
 	fmt.Println("Loaded object:")
 	fmt.Printf("Groups:    %d\n", len(out.Groups))
 	fmt.Printf("Triangles: %d\n", tris)
 	fmt.Printf("Verticies: %d\n", len(out.Verticies))
 	fmt.Printf("Normals:   %d\n", len(out.Normals))
+	fmt.Printf("TexCoords: %d\n", len(out.TexCoords)-1)
 	return out
 }
 
+// ComputeTangents computes per vertex tangents for normal mapping of triangles with texture coordinates. The face
+// tangents of all triangles sharing a vertex position are averaged, and then made perpendicular to the vertex normal.
+// Triangles without usable texture coordinates get an arbitrary tangent perpendicular to their normals.
+func ComputeTangents(tris []*shapes.Triangle) {
+	faceTangents := make([]geom.Tuple4, len(tris))
+	sums := make(map[geom.Tuple4]geom.Tuple4)
+	for i, t := range tris {
+		if !t.HasUV {
+			continue
+		}
+		tangent, ok := t.FaceTangent()
+		if !ok {
+			continue
+		}
+		faceTangents[i] = tangent
+		for _, p := range []geom.Tuple4{t.P1, t.P2, t.P3} {
+			sums[p] = geom.Add(sums[p], geom.NewVector(tangent[0], tangent[1], tangent[2]))
+		}
+	}
+
+	for i, t := range tris {
+		handedness := faceTangents[i][3]
+		if handedness == 0.0 {
+			handedness = 1.0
+		}
+		t.Tan1 = vertexTangent(sums[t.P1], faceTangents[i], t.N1, handedness)
+		t.Tan2 = vertexTangent(sums[t.P2], faceTangents[i], t.N2, handedness)
+		t.Tan3 = vertexTangent(sums[t.P3], faceTangents[i], t.N3, handedness)
+	}
+}
+
+// vertexTangent orthogonalizes the summed tangent against the vertex normal using Gram-Schmidt, falling back to the
+// face tangent or any perpendicular vector if needed.
+func vertexTangent(sum, face, normal geom.Tuple4, handedness float64) geom.Tuple4 {
+	n := normal
+	if geom.Magnitude(n) == 0.0 {
+		n = geom.NewVector(0, 1, 0)
+	}
+	n = geom.Normalize(n)
+	for _, candidate := range []geom.Tuple4{sum, geom.NewVector(face[0], face[1], face[2]), geom.NewVector(1, 0, 0), geom.NewVector(0, 0, 1)} {
+		t := geom.Sub(candidate, geom.MultiplyByScalar(n, geom.Dot(n, candidate)))
+		if geom.Magnitude(t) > 1e-6 {
+			t = geom.Normalize(t)
+			t[3] = handedness
+			return t
+		}
+	}
+	return geom.Tuple4{1, 0, 0, handedness}
+}
+
 func ComputeVertexNormals(tris []*shapes.Triangle) {
 	// brute force approach.
 	// for every triangle we already have the surface normal,
@@ -201,10 +272,28 @@ func avg(t geom.Tuple4) float64 {
 type Obj struct {
 	Verticies    []geom.Tuple4
 	Normals      []geom.Tuple4
+	TexCoords    []geom.Tuple4
 	Groups       map[string]*shapes.Group
 	IgnoredLines int
 }
 
+func (o *Obj) validTexCoord(idx int) bool {
+	return idx > 0 && idx < len(o.TexCoords)
+}
+
+// Triangles returns all triangles of all groups.
+func (o *Obj) Triangles() []*shapes.Triangle {
+	tris := make([]*shapes.Triangle, 0)
+	for _, g := range o.Groups {
+		for _, c := range g.Children {
+			if tri, ok := c.(*shapes.Triangle); ok {
+				tris = append(tris, tri)
+			}
+		}
+	}
+	return tris
+}
+
 func (o *Obj) ToGroup() *shapes.Group {
 	g := shapes.NewGroup()
 	g.Label = "ROOT"
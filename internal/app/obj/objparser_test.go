@@ -146,6 +146,42 @@ f 1/0/3 2/102/1 3/14/2`
 	assert.True(t, reflect.DeepEqual(dr1, dr2))
 }
 
+func TestFacesWithTextureCoordinates(t *testing.T) {
+	data := `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+vt 0 0
+vt 0.5 0
+vt 0 0.5
+vn 0 0 1
+f 1/1/1 2/2/1 3/3/1`
+	parser := ParseObj(data)
+	assert.Equal(t, geom.Tuple4{0.5, 0, 0, 0}, parser.TexCoords[2])
+
+	tri := parser.DefaultGroup().Children[0].(*shapes.Triangle)
+	assert.True(t, tri.HasUV)
+	assert.Equal(t, geom.Tuple4{0, 0.5, 0, 0}, tri.UV3)
+
+	// u increases along +X, and tangents are computed as part of parsing
+	assert.Equal(t, geom.Tuple4{1, 0, 0, 1}, tri.Tan1)
+	assert.Equal(t, geom.Tuple4{1, 0, 0, 1}, tri.Tan3)
+}
+
+func TestComputeTangentsIsPerpendicularToNormal(t *testing.T) {
+	tri := shapes.NewTriangle(geom.NewPoint(0, 0, 0), geom.NewPoint(1, 0, 0), geom.NewPoint(0, 1, 0),
+		geom.Normalize(geom.NewVector(0.3, 0, 1)), geom.NewVector(0, 0, 1), geom.NewVector(0, 0, 1))
+	tri.UV1 = geom.Tuple4{0, 0}
+	tri.UV2 = geom.Tuple4{1, 0}
+	tri.UV3 = geom.Tuple4{0, 1}
+	tri.HasUV = true
+
+	ComputeTangents([]*shapes.Triangle{tri})
+	assert.InDelta(t, 0.0, geom.Dot(tri.Tan1, tri.N1), 1e-9)
+	assert.InDelta(t, 1.0, geom.Magnitude(geom.NewVector(tri.Tan1[0], tri.Tan1[1], tri.Tan1[2])), 1e-9)
+	assert.Equal(t, 1.0, tri.Tan1[3])
+}
+
 func TestParseGopherMaterials(t *testing.T) {
 	data := `# Blender MTL File: 'gopher.blend'
 # Material Count: 7
@@ -0,0 +1,95 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/animation"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"math"
+)
+
+// AnimatedScene is a five second animation for render-sequence: the camera swings around a spinning gold ring while
+// a glass ball bounces next to it, and the aperture opens up towards the end.
+func AnimatedScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(-1.5, 0.8, -2.2), geom.NewPoint(0, 0.3, 0.5))
+
+		// floor
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetMaterial(material.NewDiffuse(0.8, 0.8, 0.8))
+
+		// back wall
+		backWall := shapes.NewPlane()
+		backWall.Label = "backwall"
+		backWall.SetTransform(geom.Translate(0, 0, 3))
+		backWall.SetTransform(geom.RotateX(math.Pi / 2))
+		backWall.SetMaterial(material.NewDiffuse(0.6, 0.6, 0.65))
+
+		// lightsource
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(0, 3, 0.5))
+		lightsource.SetTransform(geom.Scale(2, 0.01, 2))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(5, 5, 5)
+		lightsource.SetMaterial(light)
+
+		// gold ring standing on its edge, spinning around the vertical axis
+		ring := shapes.NewTorus(0.2)
+		ring.Label = "ring    "
+		ring.SetTransform(geom.Translate(0, 0.35, 0.5))
+		ring.SetTransform(geom.RotateX(math.Pi / 2))
+		ring.SetTransform(geom.Scale(0.3, 0.3, 0.3))
+		ring.SetMaterial(material.NewGold())
+
+		// glass ball resting on the floor, bouncing three times
+		ball := shapes.NewSphere()
+		ball.Label = "ball    "
+		ball.SetTransform(geom.Translate(0.9, 0.25, 0.3))
+		ball.SetTransform(geom.Scale(0.25, 0.25, 0.25))
+		ball.SetMaterial(material.NewGlass())
+
+		// parabolic hops of one second each, losing height with every bounce
+		bounces := make([]animation.Keyframe[geom.Tuple4], 0)
+		for i := 0; i < 3; i++ {
+			height := 0.6 / float64(i+1)
+			for s := 0.0; s < 1.0; s += 0.125 {
+				bounces = append(bounces, animation.Key(1.0+float64(i)+s, geom.NewVector(0, height*4*s*(1-s), 0)))
+			}
+		}
+		bounces = append(bounces, animation.Key(4.0, geom.NewVector(0, 0, 0)))
+
+		return &Scene{
+			Camera:  cam,
+			Objects: []shapes.Shape{lightsource, floor, backWall, ring, ball},
+			Animation: &animation.Animation{
+				Camera: animation.CameraAnimation{
+					From: animation.NewTrack(animation.Bezier,
+						animation.Key(0.0, geom.NewPoint(-1.5, 0.8, -2.2)),
+						animation.Key(2.5, geom.NewPoint(0, 1.2, -2.3)),
+						animation.Key(5.0, geom.NewPoint(1.5, 0.8, -2.2))),
+					Aperture: animation.NewTrack(animation.Linear,
+						animation.Key(3.0, 0.0),
+						animation.Key(5.0, 0.05)),
+				},
+				Objects: []*animation.ObjectAnimation{
+					{
+						Shape: ring,
+						// turning around the y axis of the world, which is the z axis of the ring standing on its edge
+						Rotation: animation.NewTrack(animation.Linear,
+							animation.Key(0.0, geom.NewVector(0, 0, 0)),
+							animation.Key(5.0, geom.NewVector(0, 0, 2*math.Pi))),
+					},
+					{
+						Shape:       ball,
+						Translation: animation.NewTrack(animation.Linear, bounces...),
+					},
+				},
+			},
+		}
+	}
+}
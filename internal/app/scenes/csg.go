@@ -0,0 +1,85 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"math"
+)
+
+// CSGScene shows constructive solid geometry: a glass lens (the intersection of two spheres), a bowl (a hollowed
+// sphere with its top cut off) and a block drilled through by two cylinders. Note that every operand is an object of
+// its own, and building a scene of more objects than the kernel holds fails.
+func CSGScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.9, -2.0), geom.NewPoint(0, 0.05, 0.3))
+
+		// floor
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetTransform(geom.Translate(0, -.2, 0))
+		floor.SetMaterial(material.NewDiffuse(0.8, 0.8, 0.8))
+
+		// back wall
+		backWall := shapes.NewPlane()
+		backWall.Label = "backwall"
+		backWall.SetTransform(geom.Translate(0, 0, 2))
+		backWall.SetTransform(geom.RotateX(math.Pi / 2))
+		backWall.SetMaterial(material.NewDiffuse(0.6, 0.6, 0.65))
+
+		// lightsource
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(0, 2.5, 0))
+		lightsource.SetTransform(geom.Scale(1.5, 0.01, 1.5))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(5, 5, 5)
+		lightsource.SetMaterial(light)
+
+		// biconvex lens standing on its rim, facing the camera
+		front := shapes.NewSphere()
+		front.SetTransform(geom.Translate(0, 0, 0.8))
+		back := shapes.NewSphere()
+		back.SetTransform(geom.Translate(0, 0, -0.8))
+		lens := shapes.NewCSG("intersection", front, back)
+		lens.Label = "lens    "
+		lens.SetTransform(geom.Translate(0, 0.02, 0.2))
+		lens.SetTransform(geom.Scale(0.35, 0.35, 0.35))
+		lens.SetMaterial(material.NewGlass())
+
+		// bowl: a sphere hollowed out by a smaller one, with the top half removed by a cube
+		outer := shapes.NewSphere()
+		inner := shapes.NewSphere()
+		inner.SetTransform(geom.Scale(0.9, 0.9, 0.9))
+		cutter := shapes.NewCube()
+		cutter.SetTransform(geom.Translate(0, 1, 0))
+		bowl := shapes.NewCSG("difference", shapes.NewCSG("difference", outer, inner), cutter)
+		bowl.Label = "bowl    "
+		bowl.SetTransform(geom.Translate(-0.75, 0.08, 0.6))
+		bowl.SetTransform(geom.Scale(0.28, 0.28, 0.28))
+		bowl.SetMaterial(material.NewPlastic(0.2, 0.45, 0.8))
+
+		// block drilled through along the x and z axes
+		block := shapes.NewCube()
+		drillX := shapes.NewCylinder()
+		drillX.SetTransform(geom.RotateZ(math.Pi / 2))
+		drillX.SetTransform(geom.Scale(0.5, 1, 0.5))
+		drillZ := shapes.NewCylinder()
+		drillZ.SetTransform(geom.RotateX(math.Pi / 2))
+		drillZ.SetTransform(geom.Scale(0.5, 1, 0.5))
+		drilled := shapes.NewCSG("difference", shapes.NewCSG("difference", block, drillX), drillZ)
+		drilled.Label = "drilled "
+		drilled.SetTransform(geom.Translate(0.75, 0, 0.6))
+		drilled.SetTransform(geom.RotateY(math.Pi / 6))
+		drilled.SetTransform(geom.Scale(0.2, 0.2, 0.2))
+		drilled.SetMaterial(material.NewMetal(0.8, 0.8, 0.8, 0.1))
+
+		return &Scene{
+			Camera:  cam,
+			Objects: []shapes.Shape{lightsource, floor, backWall, lens, bowl, drilled},
+		}
+	}
+}
@@ -19,9 +19,6 @@ func EnvironmentCubeMap() func() *Scene {
 		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.3, -2.7), geom.NewPoint(0, 0.45, 0))
 		//cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0.01, .05, 0.01), geom.NewPoint(0,0,0))
 
-		cam.FocalLength = cmd.Cfg.FocalLength
-		cam.Aperture = cmd.Cfg.Aperture
-
 		// cube
 		cube := shapes.NewCube()
 		cube.SetTransform(geom.Translate(0.1, -0.1, 0.1))
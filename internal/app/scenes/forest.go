@@ -0,0 +1,74 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/obj"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"io/ioutil"
+	"math"
+	"math/rand"
+)
+
+// ForestScene shows mesh instancing: a forest of 1000 teapots, each an instance of the same teapot mesh with its own
+// transform and color. The mesh is uploaded once, and the instances are divided into a BVH of their own.
+func ForestScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 2.5, -3.5), geom.NewPoint(0, 0, 4))
+
+		// floor
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetMaterial(material.NewDiffuse(0.8, 0.8, 0.8))
+
+		// lightsource
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(0, 8, 6))
+		lightsource.SetTransform(geom.Scale(12, 0.01, 12))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(3, 3, 3)
+		lightsource.SetMaterial(light)
+
+		data, err := ioutil.ReadFile("assets/teapot.obj")
+		if err != nil {
+			panic(err.Error())
+		}
+		teapot := obj.ParseObj(string(data)).ToGroup()
+		tris := make([]*shapes.Triangle, 0)
+		for i := range teapot.Children[0].(*shapes.Group).Children {
+			tris = append(tris, teapot.Children[0].(*shapes.Group).Children[i].(*shapes.Triangle))
+		}
+		obj.ComputeVertexNormals(tris)
+		teapot.Bounds()
+		shapes.Divide(teapot, 50)
+		teapot.Bounds()
+
+		// 40 x 25 teapots with random rotations and colors, except for every 10th which keeps the colors of the mesh
+		rnd := rand.New(rand.NewSource(1))
+		forest := shapes.NewGroup()
+		forest.Label = "forest"
+		for z := 0; z < 25; z++ {
+			for x := 0; x < 40; x++ {
+				inst := shapes.NewInstance(teapot)
+				inst.SetTransform(geom.Translate(float64(x)*0.5-9.75, 0, float64(z)*0.5))
+				inst.SetTransform(geom.RotateY(rnd.Float64() * 2 * math.Pi))
+				inst.SetTransform(geom.Scale(0.07, 0.07, 0.07))
+				if (z*40+x)%10 != 0 {
+					inst.SetMaterial(material.NewDiffuse(0.2+rnd.Float64()*0.7, 0.2+rnd.Float64()*0.7, 0.2+rnd.Float64()*0.7))
+				}
+				forest.AddChild(inst)
+			}
+		}
+		shapes.Divide(forest, 8)
+		forest.Bounds()
+
+		return &Scene{
+			Camera:  cam,
+			Objects: []shapes.Shape{lightsource, floor, forest},
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"math"
+)
+
+// MaterialBallScene renders a 4x3 grid of spheres sweeping the principled material parameters. Front row sweeps the
+// roughness of a plastic, the middle row sweeps metallic and the back row shows gold, varnished wood, cloth-like sheen
+// and frosted transmission. Note that the kernel only supports 16 objects, so the grid can't grow much more.
+func MaterialBallScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 1.1, -2.2), geom.NewPoint(0, 0, 0.45))
+
+		// floor
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetTransform(geom.Translate(0, -.2, 0))
+		floor.SetMaterial(material.NewDiffuse(0.8, 0.8, 0.8))
+
+		// back wall
+		backWall := shapes.NewPlane()
+		backWall.Label = "backwall"
+		backWall.SetTransform(geom.Translate(0, 0, 2))
+		backWall.SetTransform(geom.RotateX(math.Pi / 2))
+		backWall.SetMaterial(material.NewDiffuse(0.6, 0.6, 0.65))
+
+		// lightsource
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(0, 2.5, 0))
+		lightsource.SetTransform(geom.Scale(1.5, 0.01, 1.5))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(6, 6, 6)
+		lightsource.SetMaterial(light)
+
+		// front row: plastic with increasing roughness
+		var front []material.Material
+		for _, roughness := range []float64{0.05, 0.3, 0.6, 1.0} {
+			mtrl := material.NewPlastic(0.8, 0.1, 0.1)
+			mtrl.Roughness = roughness
+			front = append(front, mtrl)
+		}
+
+		// middle row: copper-colored principled material with increasing metallic
+		var middle []material.Material
+		for _, metallic := range []float64{0.0, 0.33, 0.66, 1.0} {
+			params := material.NewPrincipledParams()
+			params.BaseColor = geom.Tuple4{0.95, 0.64, 0.54}
+			params.Roughness = 0.3
+			params.Metallic = metallic
+			middle = append(middle, material.NewPrincipled(params))
+		}
+
+		// back row: presets and the remaining layers
+		cloth := material.NewPrincipledParams()
+		cloth.BaseColor = geom.Tuple4{0.2, 0.3, 0.6}
+		cloth.Roughness = 1.0
+		cloth.Specular = 0.0
+		cloth.Sheen = 1.0
+
+		frosted := material.NewPrincipledParams()
+		frosted.BaseColor = geom.Tuple4{1, 1, 1}
+		frosted.Roughness = 0.15
+		frosted.Transmission = 1.0
+
+		back := []material.Material{material.NewGold(), material.NewVarnishedWood(), material.NewPrincipled(cloth), material.NewPrincipled(frosted)}
+
+		objects := []shapes.Shape{lightsource, floor, backWall}
+		for row, materials := range [][]material.Material{front, middle, back} {
+			for col, mtrl := range materials {
+				ball := shapes.NewSphere()
+				ball.SetTransform(geom.Translate(-0.75+float64(col)*0.5, 0, float64(row)*0.5))
+				ball.SetTransform(geom.Scale(0.2, 0.2, 0.2))
+				ball.SetMaterial(mtrl)
+				objects = append(objects, ball)
+			}
+		}
+
+		return &Scene{
+			Camera:  cam,
+			Objects: objects,
+		}
+	}
+}
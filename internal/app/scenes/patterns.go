@@ -0,0 +1,123 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"math"
+)
+
+// PatternsScene shows the procedural patterns on a checkered floor: a marble sphere, a wooden cube, a metal sphere
+// whose roughness varies by noise, a hammered (voronoi bump) copper cylinder and a striped, ringed sphere pair.
+func PatternsScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 1.0, -2.0), geom.NewPoint(0, 0, 0.3))
+
+		// checkered floor, two checks per unit. The pattern is moved up a bit so that the surface at y=0 doesn't sit
+		// exactly on a cell boundary, which would give acne.
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetTransform(geom.Translate(0, -.2, 0))
+		floorMaterial := material.NewDiffuse(0.8, 0.8, 0.8)
+		floorMaterial.ColorPattern = material.NewPattern(material.CheckerPattern, geom.NewColor(0.85, 0.85, 0.85), geom.NewColor(0.15, 0.15, 0.15))
+		floorMaterial.ColorPattern.SetTransform(geom.Translate(0, 0.1, 0))
+		floorMaterial.ColorPattern.SetTransform(geom.Scale(0.5, 0.5, 0.5))
+		floor.SetMaterial(floorMaterial)
+
+		// back wall with a soft gradient
+		backWall := shapes.NewPlane()
+		backWall.Label = "backwall"
+		backWall.SetTransform(geom.Translate(0, 0, 2))
+		backWall.SetTransform(geom.RotateX(math.Pi / 2))
+		wallMaterial := material.NewDiffuse(0.6, 0.6, 0.65)
+		wallMaterial.ColorPattern = material.NewPattern(material.GradientPattern, geom.NewColor(0.7, 0.6, 0.5), geom.NewColor(0.4, 0.5, 0.7))
+		wallMaterial.ColorPattern.SetTransform(geom.Translate(-2, 0, 0))
+		wallMaterial.ColorPattern.SetTransform(geom.Scale(4, 4, 4))
+		backWall.SetMaterial(wallMaterial)
+
+		// lightsource
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(0, 2.5, 0))
+		lightsource.SetTransform(geom.Scale(1.5, 0.01, 1.5))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(6, 6, 6)
+		lightsource.SetMaterial(light)
+
+		// polished marble sphere
+		marble := shapes.NewSphere()
+		marble.Label = "marble  "
+		marble.SetTransform(geom.Translate(-0.8, 0.05, 0.3))
+		marble.SetTransform(geom.Scale(0.25, 0.25, 0.25))
+		marbleMaterial := material.NewPlastic(1, 1, 1)
+		marbleMaterial.Roughness = 0.1
+		marbleMaterial.ColorPattern = material.NewPattern(material.MarblePattern, geom.NewColor(0.95, 0.95, 0.92), geom.NewColor(0.25, 0.25, 0.3))
+		marbleMaterial.ColorPattern.Turbulence = 2.0
+		marbleMaterial.ColorPattern.Octaves = 6
+		marbleMaterial.ColorPattern.SetTransform(geom.Scale(0.4, 0.4, 0.4))
+		marble.SetMaterial(marbleMaterial)
+
+		// varnished wooden cube, the rings run along the y axis of the pattern
+		wood := shapes.NewCube()
+		wood.Label = "wood    "
+		wood.SetTransform(geom.Translate(-0.25, 0, 0.6))
+		wood.SetTransform(geom.RotateY(math.Pi / 6))
+		wood.SetTransform(geom.Scale(0.2, 0.2, 0.2))
+		woodMaterial := material.NewVarnishedWood()
+		woodMaterial.ColorPattern = material.NewPattern(material.WoodPattern, geom.NewColor(0.55, 0.33, 0.16), geom.NewColor(0.3, 0.16, 0.07))
+		woodMaterial.ColorPattern.Turbulence = 0.3
+		woodMaterial.ColorPattern.SetTransform(geom.Translate(2, 0, -1))
+		woodMaterial.ColorPattern.SetTransform(geom.RotateX(math.Pi / 2))
+		woodMaterial.ColorPattern.SetTransform(geom.Scale(0.25, 0.25, 0.25))
+		wood.SetMaterial(woodMaterial)
+
+		// metal with blotchy roughness
+		metal := shapes.NewSphere()
+		metal.Label = "metal   "
+		metal.SetTransform(geom.Translate(0.3, 0.05, 0.3))
+		metal.SetTransform(geom.Scale(0.25, 0.25, 0.25))
+		metalMaterial := material.NewMetal(0.9, 0.9, 0.9, 0.0)
+		metalMaterial.RoughnessPattern = material.NewPattern(material.NoisePattern, geom.Tuple4{0.0}, geom.Tuple4{0.6})
+		metalMaterial.RoughnessPattern.SetTransform(geom.Scale(0.3, 0.3, 0.3))
+		metal.SetMaterial(metalMaterial)
+
+		// hammered copper
+		copper := shapes.NewCylinderMMC(-1, 1, true)
+		copper.Label = "copper  "
+		copper.SetTransform(geom.Translate(0.9, 0.05, 0.6))
+		copper.SetTransform(geom.Scale(0.2, 0.25, 0.2))
+		copperMaterial := material.NewMetal(0.95, 0.64, 0.54, 0.15)
+		copperMaterial.BumpPattern = material.NewPattern(material.VoronoiPattern, geom.Tuple4{0.0}, geom.Tuple4{0.1})
+		copperMaterial.BumpPattern.SetTransform(geom.Scale(0.25, 0.25, 0.25))
+		copperMaterial.BumpStrength = 1.0
+		copper.SetMaterial(copperMaterial)
+
+		// stripes and rings
+		striped := shapes.NewSphere()
+		striped.Label = "stripes "
+		striped.SetTransform(geom.Translate(-0.35, -0.08, -0.1))
+		striped.SetTransform(geom.Scale(0.12, 0.12, 0.12))
+		stripedMaterial := material.NewDiffuse(1, 1, 1)
+		stripedMaterial.ColorPattern = material.NewPattern(material.StripePattern, geom.NewColor(0.9, 0.2, 0.2), geom.NewColor(0.9, 0.9, 0.9))
+		stripedMaterial.ColorPattern.SetTransform(geom.RotateZ(math.Pi / 4))
+		stripedMaterial.ColorPattern.SetTransform(geom.Scale(0.25, 0.25, 0.25))
+		striped.SetMaterial(stripedMaterial)
+
+		ringed := shapes.NewSphere()
+		ringed.Label = "rings   "
+		ringed.SetTransform(geom.Translate(0.0, -0.08, -0.2))
+		ringed.SetTransform(geom.Scale(0.12, 0.12, 0.12))
+		ringedMaterial := material.NewDiffuse(1, 1, 1)
+		ringedMaterial.ColorPattern = material.NewPattern(material.RingPattern, geom.NewColor(0.2, 0.4, 0.9), geom.NewColor(0.9, 0.9, 0.9))
+		ringedMaterial.ColorPattern.SetTransform(geom.Scale(0.2, 0.2, 0.2))
+		ringed.SetMaterial(ringedMaterial)
+
+		return &Scene{
+			Camera:  cam,
+			Objects: []shapes.Shape{lightsource, floor, backWall, marble, wood, metal, copper, striped, ringed},
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"math"
+)
+
+// PrimitivesScene shows the cone, disk and torus primitives: a small table lamp with an open cone shade and a glowing
+// disk, next to a gold ring, a plastic traffic cone and a tapered, capped cone.
+func PrimitivesScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.9, -2.0), geom.NewPoint(0, 0.1, 0.3))
+
+		// floor
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetTransform(geom.Translate(0, -.2, 0))
+		floor.SetMaterial(material.NewDiffuse(0.8, 0.8, 0.8))
+
+		// back wall
+		backWall := shapes.NewPlane()
+		backWall.Label = "backwall"
+		backWall.SetTransform(geom.Translate(0, 0, 2))
+		backWall.SetTransform(geom.RotateX(math.Pi / 2))
+		backWall.SetMaterial(material.NewDiffuse(0.6, 0.6, 0.65))
+
+		// lightsource
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(0, 2.5, 0))
+		lightsource.SetTransform(geom.Scale(1.5, 0.01, 1.5))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(5, 5, 5)
+		lightsource.SetMaterial(light)
+
+		// lamp: an open frustum as shade with a glowing disk under it, on a thin stand
+		shade := shapes.NewConeMMC(1, 2, false)
+		shade.Label = "shade   "
+		shade.SetTransform(geom.Translate(-0.7, 0.75, 0.6))
+		shade.SetTransform(geom.RotateX(math.Pi))
+		shade.SetTransform(geom.Scale(0.12, 0.2, 0.12))
+		shade.SetMaterial(material.NewDiffuse(0.9, 0.85, 0.7))
+
+		bulb := shapes.NewDisk()
+		bulb.Label = "bulb    "
+		bulb.SetTransform(geom.Translate(-0.7, 0.45, 0.6))
+		bulb.SetTransform(geom.Scale(0.1, 0.1, 0.1))
+		bulbMaterial := material.NewLightBulb()
+		bulbMaterial.Emission = geom.NewColor(4, 3.6, 3)
+		bulb.SetMaterial(bulbMaterial)
+
+		stand := shapes.NewCylinderMMC(-0.2, 0.45, true)
+		stand.Label = "stand   "
+		stand.SetTransform(geom.Translate(-0.7, 0, 0.6))
+		stand.SetTransform(geom.Scale(0.015, 1, 0.015))
+		stand.SetMaterial(material.NewMetal(0.8, 0.8, 0.8, 0.2))
+
+		// gold ring, standing on its edge
+		ring := shapes.NewTorus(0.2)
+		ring.Label = "ring    "
+		ring.SetTransform(geom.Translate(0, 0.04, 0.3))
+		ring.SetTransform(geom.RotateX(math.Pi / 2))
+		ring.SetTransform(geom.Scale(0.2, 0.2, 0.2))
+		ring.SetMaterial(material.NewGold())
+
+		// pointy traffic cone with its base at the floor
+		trafficCone := shapes.NewConeMMC(-1, 0, true)
+		trafficCone.Label = "cone    "
+		trafficCone.SetTransform(geom.Translate(0.6, 0.2, 0.3))
+		trafficCone.SetTransform(geom.Scale(0.15, 0.4, 0.15))
+		trafficCone.SetMaterial(material.NewPlastic(0.9, 0.35, 0.05))
+
+		// table leg tapering towards the floor, i.e. a capped frustum
+		leg := shapes.NewConeMMC(1, 3, true)
+		leg.Label = "leg     "
+		leg.SetTransform(geom.Translate(0.25, -0.4, 0.9))
+		leg.SetTransform(geom.Scale(0.03, 0.2, 0.03))
+		leg.SetMaterial(material.NewVarnishedWood())
+
+		return &Scene{
+			Camera:  cam,
+			Objects: []shapes.Shape{lightsource, floor, backWall, shade, bulb, stand, ring, trafficCone, leg},
+		}
+	}
+}
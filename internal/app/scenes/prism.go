@@ -0,0 +1,86 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"math"
+)
+
+// PrismScene shows chromatic dispersion: a flint glass prism lit by a small, bright light off to the side, casting
+// rainbow caustics onto the floor, next to a diamond sphere. Needs quite a lot of samples to converge.
+func PrismScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.5, -1.4), geom.NewPoint(0, -0.05, 0))
+
+		// floor
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetTransform(geom.Translate(0, -.2, 0))
+		floor.SetMaterial(material.NewDiffuse(0.9, 0.9, 0.9))
+
+		// back wall
+		backWall := shapes.NewPlane()
+		backWall.Label = "backwall"
+		backWall.SetTransform(geom.Translate(0, 0, 1))
+		backWall.SetTransform(geom.RotateX(math.Pi / 2))
+		backWall.SetMaterial(material.NewDiffuse(0.9, 0.9, 0.9))
+
+		// small and bright lightsource, placed low to the left so light passes sideways through the prism
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(-1.2, 0.3, 0))
+		lightsource.SetTransform(geom.Scale(0.05, 0.05, 0.05))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(300, 300, 300)
+		lightsource.SetMaterial(light)
+
+		// dense flint glass prism
+		glassPrism := prism(material.NewDispersiveGlass(1.75, 27.0))
+		glassPrism.Label = "prism   "
+		glassPrism.SetTransform(geom.Translate(-0.15, -0.2, 0))
+		glassPrism.SetTransform(geom.Scale(0.35, 0.35, 0.35))
+		glassPrism.Bounds()
+
+		// diamond
+		diamond := shapes.NewSphere()
+		diamond.Label = "diamond "
+		diamond.SetTransform(geom.Translate(0.4, -0.08, -0.25))
+		diamond.SetTransform(geom.Scale(0.12, 0.12, 0.12))
+		diamond.SetMaterial(material.NewDiamond())
+
+		return &Scene{
+			Camera:  cam,
+			Objects: []shapes.Shape{lightsource, floor, backWall, glassPrism, diamond},
+		}
+	}
+}
+
+// prism returns a triangular prism with an equilateral cross-section of side 1 in the XY plane, standing on y=0 and
+// extruded from z=-1 to z=1.
+func prism(mtrl material.Material) *shapes.Group {
+	h := math.Sqrt(3) / 2.0
+	a0, b0, c0 := geom.NewPoint(-0.5, 0, -1), geom.NewPoint(0.5, 0, -1), geom.NewPoint(0, h, -1)
+	a1, b1, c1 := geom.NewPoint(-0.5, 0, 1), geom.NewPoint(0.5, 0, 1), geom.NewPoint(0, h, 1)
+
+	group := shapes.NewGroup()
+	group.AddChildren(
+		// end caps
+		shapes.NewTriangleN(a0, b0, c0),
+		shapes.NewTriangleN(a1, c1, b1),
+		// bottom
+		shapes.NewTriangleN(a0, b1, b0),
+		shapes.NewTriangleN(a0, a1, b1),
+		// right
+		shapes.NewTriangleN(b0, b1, c1),
+		shapes.NewTriangleN(b0, c1, c0),
+		// left
+		shapes.NewTriangleN(c0, c1, a1),
+		shapes.NewTriangleN(c0, a1, a0),
+	)
+	group.SetMaterial(mtrl)
+	return group
+}
@@ -2,6 +2,7 @@ package scenes
 
 import (
 	"bytes"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/animation"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
 	"github.com/sirupsen/logrus"
@@ -25,6 +26,9 @@ type Scene struct {
 
 	// Cube textures use a 4:3 format with 6 sides forming a cross. Example is 4096x3072
 	CubeTextures []image.Image
+
+	// Animation keyframes the camera and objects for render-sequence, or is nil for still scenes
+	Animation *animation.Animation
 }
 
 func LoadImage(path string) image.Image {
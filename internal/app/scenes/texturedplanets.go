@@ -14,8 +14,6 @@ func TexturedPlanetsScene() func() *Scene {
 	return func() *Scene {
 
 		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.1, -1.5), geom.NewPoint(0, 0.05, 0))
-		cam.FocalLength = cmd.Cfg.FocalLength
-		cam.Aperture = cmd.Cfg.Aperture
 
 		// left wall
 		leftWall := shapes.NewPlane()
@@ -28,10 +26,7 @@ func TexturedPlanetsScene() func() *Scene {
 		leftWall.Material.TextureID = 0
 		leftWall.Material.TextureScaleX = 1.0
 		leftWall.Material.TextureScaleY = 1.0
-		leftWall.Material.TexturedNM = true
-		leftWall.Material.TextureIDNM = 3
-		leftWall.Material.TextureScaleXNM = 1.0
-		leftWall.Material.TextureScaleYNM = 1.0
+		leftWall.Material.SetNormalMap(3, 1.0, 1.0, 1.0)
 
 		//// right wall
 		rightWall := shapes.NewPlane()
@@ -43,10 +38,7 @@ func TexturedPlanetsScene() func() *Scene {
 		rightWall.Material.TextureID = 0
 		rightWall.Material.TextureScaleX = 1.0
 		rightWall.Material.TextureScaleY = 1.0
-		rightWall.Material.TexturedNM = true
-		rightWall.Material.TextureIDNM = 3
-		rightWall.Material.TextureScaleXNM = 1.0
-		rightWall.Material.TextureScaleYNM = 1.0
+		rightWall.Material.SetNormalMap(3, 1.0, 1.0, 1.0)
 
 		// floor
 		floor := shapes.NewPlane()
@@ -76,10 +68,7 @@ func TexturedPlanetsScene() func() *Scene {
 		backWall.Material.TextureID = 0
 		backWall.Material.TextureScaleX = 1.0
 		backWall.Material.TextureScaleY = 1.0
-		backWall.Material.TexturedNM = true
-		backWall.Material.TextureIDNM = 3
-		backWall.Material.TextureScaleXNM = 1.0
-		backWall.Material.TextureScaleYNM = 1.0
+		backWall.Material.SetNormalMap(3, 1.0, 1.0, 1.0)
 
 		// front wall
 		frontWall := shapes.NewPlane()
@@ -16,8 +16,6 @@ func TransparencyScene() func() *Scene {
 		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.1, -1.5), geom.NewPoint(0, 0.05, 0))
 		//cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0.01, .05, 0.01), geom.NewPoint(0,0,0))
 
-		cam.FocalLength = cmd.Cfg.FocalLength
-		cam.Aperture = cmd.Cfg.Aperture
 		// left wall
 		leftWall := shapes.NewPlane()
 		leftWall.Label = "leftwall"
@@ -63,7 +61,8 @@ func TransparencyScene() func() *Scene {
 		leftSphere.Label = "left_spr"
 		leftSphere.SetTransform(geom.Translate(-0.25, -0.28, 0.25))
 		leftSphere.SetTransform(geom.Scale(0.12, 0.12, 0.12))
-		leftSphere.SetMaterial(material.NewGlass()) //material.NewDiffuse(0.9, 0.8, 0.7))
+		// amber glass, about as deep in color as the sphere is wide
+		leftSphere.SetMaterial(material.NewColoredGlass(0.95, 0.65, 0.3, 0.24))
 
 		// middle sphere
 		middleSphere := shapes.NewSphere()
@@ -19,8 +19,6 @@ func GlassScene() func() *Scene {
 		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.1, -1.5), geom.NewPoint(0, 0.05, 0))
 		//cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0.01, .05, 0.01), geom.NewPoint(0,0,0))
 
-		cam.FocalLength = cmd.Cfg.FocalLength
-		cam.Aperture = cmd.Cfg.Aperture
 		// left wall
 		leftWall := shapes.NewPlane()
 		leftWall.Label = "leftwall"
@@ -76,7 +74,7 @@ func GlassScene() func() *Scene {
 		rightSphere.SetMaterial(material.NewGlass())
 
 		// teapot model
-		mtrl := material.NewGlass()
+		mtrl := material.NewColoredGlass(0.55, 0.8, 0.65, 0.15)
 		mtrl.Reflectivity = 0.0
 		glassModel := glass(mtrl)
 		glassModel.Label = "glass   "
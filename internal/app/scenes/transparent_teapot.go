@@ -18,8 +18,6 @@ func TransparentTeapotScene() func() *Scene {
 		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0, 0.1, -1.5), geom.NewPoint(0, 0.05, 0))
 		//cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(0.01, .05, 0.01), geom.NewPoint(0,0,0))
 
-		cam.FocalLength = cmd.Cfg.FocalLength
-		cam.Aperture = cmd.Cfg.Aperture
 		// left wall
 		leftWall := shapes.NewPlane()
 		leftWall.Label = "leftwall"
@@ -72,9 +70,11 @@ func TransparentTeapotScene() func() *Scene {
 		rightSphere.Label = "right_spr"
 		rightSphere.SetTransform(geom.Translate(0.25, -0.28, 0.25))
 		rightSphere.SetTransform(geom.Scale(0.12, 0.12, 0.12))
-		rightSphere.SetMaterial(material.NewGlass())
+		rightSphere.SetMaterial(material.NewColoredGlass(0.6, 0.8, 0.95, 0.24))
 
-		// teapot model
+		// teapot model. The mesh isn't closed, some of its edges belong to a single triangle, so it can't be refracted
+		// through as a solid volume. Its glass is infinitely thin instead, see the refractive index of -1 in tracer.cl,
+		// which means absorption can't tint it, and only the right sphere shows colored glass in this scene.
 		mtrl := material.NewGlass()
 		mtrl.RefractiveIndex = -1.0
 		mtrl.Reflectivity = 0.2
@@ -0,0 +1,91 @@
+package scenes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/animation"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"math"
+)
+
+// WheelScene shows motion blur: a wheel spinning four turns per second, photographed with a 1/48 second shutter,
+// next to a ball that stays sharp.
+func WheelScene() func() *Scene {
+	return func() *Scene {
+
+		cam := camera.NewCamera(cmd.Cfg.Width, cmd.Cfg.Height, math.Pi/3, geom.NewPoint(-0.3, 0.9, -2.2), geom.NewPoint(0.3, 0.55, 0.5))
+		cam.ShutterClose = 1.0 / 48.0
+
+		// floor
+		floor := shapes.NewPlane()
+		floor.Label = "floor   "
+		floor.SetMaterial(material.NewDiffuse(0.8, 0.8, 0.8))
+
+		// back wall
+		backWall := shapes.NewPlane()
+		backWall.Label = "backwall"
+		backWall.SetTransform(geom.Translate(0, 0, 3))
+		backWall.SetTransform(geom.RotateX(math.Pi / 2))
+		backWall.SetMaterial(material.NewDiffuse(0.6, 0.6, 0.65))
+
+		// lightsource
+		lightsource := shapes.NewSphere()
+		lightsource.Label = "light   "
+		lightsource.SetTransform(geom.Translate(0, 3, 0))
+		lightsource.SetTransform(geom.Scale(2, 0.01, 2))
+		light := material.NewLightBulb()
+		light.Emission = geom.NewColor(5, 5, 5)
+		lightsource.SetMaterial(light)
+
+		// the wheel stands on its tyre facing the camera, with its hub at the origin of the group so that it spins
+		// around the hub
+		tyre := shapes.NewTorus(0.2)
+		tyre.Label = "tyre    "
+		tyre.SetTransform(geom.RotateX(math.Pi / 2))
+		tyre.SetTransform(geom.Scale(0.5, 0.5, 0.5))
+		tyre.SetMaterial(material.NewDiffuse(0.1, 0.1, 0.1))
+
+		hub := shapes.NewCylinderMMC(-0.06, 0.06, true)
+		hub.Label = "hub     "
+		hub.SetTransform(geom.RotateX(math.Pi / 2))
+		hub.SetTransform(geom.Scale(0.07, 1, 0.07))
+		hub.SetMaterial(material.NewGold())
+
+		wheel := shapes.NewGroup()
+		wheel.Label = "wheel   "
+		wheel.SetTransform(geom.Translate(0, 0.62, 0.5))
+		wheel.AddChildren(tyre, hub)
+		for i := 0; i < 6; i++ {
+			spoke := shapes.NewCylinderMMC(0, 0.45, true)
+			spoke.Label = "spoke   "
+			spoke.SetTransform(geom.RotateZ(float64(i) * math.Pi / 3))
+			spoke.SetTransform(geom.Scale(0.02, 1, 0.02))
+			spoke.SetMaterial(material.NewDiffuse(0.8, 0.1, 0.1))
+			wheel.AddChild(spoke)
+		}
+
+		// blue ball standing still next to the wheel
+		ball := shapes.NewSphere()
+		ball.Label = "ball    "
+		ball.SetTransform(geom.Translate(1.0, 0.3, 0.3))
+		ball.SetTransform(geom.Scale(0.3, 0.3, 0.3))
+		ball.SetMaterial(material.NewDiffuse(0.2, 0.3, 0.8))
+
+		return &Scene{
+			Camera:  cam,
+			Objects: []shapes.Shape{lightsource, floor, backWall, wheel, ball},
+			Animation: &animation.Animation{
+				Objects: []*animation.ObjectAnimation{
+					{
+						Shape: wheel,
+						Rotation: animation.NewTrack(animation.Linear,
+							animation.Key(0.0, geom.NewVector(0, 0, 0)),
+							animation.Key(5.0, geom.NewVector(0, 0, 40*math.Pi))),
+					},
+				},
+			},
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package shapes
 
 import (
+	"math"
+
 	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
 )
@@ -46,3 +48,13 @@ func min(values ...float64) float64 {
 	}
 	return c
 }
+
+// intersectEpsilon is the tolerance used by the reference intersection code, the same as EPSILON in tracer.cl.
+const intersectEpsilon = 0.0001
+
+// azimuth returns how far around the Y axis the point is, in [0, 1), increasing counterclockwise as seen from above.
+// It's the same as the u of SphericalMap.
+func azimuth(p geom.Tuple4) float64 {
+	theta := math.Atan2(p[0], p[2])
+	return 1 - (theta/(2*math.Pi) + 0.5)
+}
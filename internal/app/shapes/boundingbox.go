@@ -95,6 +95,13 @@ func BoundsOf(shape Shape) *BoundingBox {
 			box.MergeWith(cbox)
 		}
 		return box
+	case *Instance:
+		// use the cached bounds of the mesh, which may be shared by thousands of instances
+		return TransformBoundingBox(val.Mesh.BoundingBox, val.Mesh.GetTransform())
+	case *CSG:
+		box := ParentSpaceBounds(val.Left)
+		box.MergeWith(ParentSpaceBounds(val.Right))
+		return box
 	//case *Cube:
 	//	return NewBoundingBoxF(-1, -1, -1, 1, 1, 1)
 	//case *Sphere:
@@ -112,6 +119,14 @@ func BoundsOf(shape Shape) *BoundingBox {
 	//	}
 	//
 	//	return NewBoundingBoxF(-limit, val.MinY, -limit, limit, val.MaxY, limit)
+	case *Cone:
+		limit := math.Max(math.Abs(val.MinY), math.Abs(val.MaxY))
+		return NewBoundingBoxF(-limit, val.MinY, -limit, limit, val.MaxY, limit)
+	case *Disk:
+		return NewBoundingBoxF(-1, 0, -1, 1, 0, 1)
+	case *Torus:
+		outer := 1 + val.MinorRadius
+		return NewBoundingBoxF(-outer, -val.MinorRadius, -outer, outer, val.MinorRadius, outer)
 	case *Triangle:
 		bb := NewEmptyBoundingBox()
 		bb.Add(val.P1)
@@ -68,22 +68,33 @@ func TestAddPointToBoundingBox(t *testing.T) {
 //	assert.Equal(t, geom.NewPoint(1, 3, 1), box.Max)
 //}
 
-//
-//func TestBoundsOfInfiniteCone(t *testing.T) {
-//	c := NewCone()
-//	box := BoundsOf(c)
-//	assert.Equal(t, geom.NewPoint(math.Inf(-1), math.Inf(-1), math.Inf(-1)), box.Min)
-//	assert.Equal(t, geom.NewPoint(math.Inf(1), math.Inf(1), math.Inf(1)), box.Max)
-//}
-//
-//func TestBoundsOfFiniteCone(t *testing.T) {
-//	c := NewCone()
-//	c.MinY = -5
-//	c.MaxY = 3
-//	box := BoundsOf(c)
-//	assert.Equal(t, NewPoint(-5, -5, -5), box.Min)
-//	assert.Equal(t, NewPoint(5, 3, 5), box.Max)
-//}
+func TestBoundsOfInfiniteCone(t *testing.T) {
+	c := NewCone()
+	box := BoundsOf(c)
+	assert.Equal(t, geom.NewPoint(math.Inf(-1), math.Inf(-1), math.Inf(-1)), box.Min)
+	assert.Equal(t, geom.NewPoint(math.Inf(1), math.Inf(1), math.Inf(1)), box.Max)
+}
+
+func TestBoundsOfFiniteCone(t *testing.T) {
+	c := NewCone()
+	c.MinY = -5
+	c.MaxY = 3
+	box := BoundsOf(c)
+	assert.Equal(t, geom.NewPoint(-5, -5, -5), box.Min)
+	assert.Equal(t, geom.NewPoint(5, 3, 5), box.Max)
+}
+
+func TestBoundsOfDisk(t *testing.T) {
+	box := BoundsOf(NewDisk())
+	assert.Equal(t, geom.NewPoint(-1, 0, -1), box.Min)
+	assert.Equal(t, geom.NewPoint(1, 0, 1), box.Max)
+}
+
+func TestBoundsOfTorus(t *testing.T) {
+	box := BoundsOf(NewTorus(0.25))
+	assert.Equal(t, geom.NewPoint(-1.25, -0.25, -1.25), box.Min)
+	assert.Equal(t, geom.NewPoint(1.25, 0.25, 1.25), box.Max)
+}
 
 func TestBoundsOfTriangle(t *testing.T) {
 	p1 := geom.NewPoint(-3, 7, 2)
@@ -188,17 +199,16 @@ func TestQueryBBTransformInParentSpace(t *testing.T) {
 //	assert.Equal(t, geom.NewPoint(4, 7, 4.5), box.Max)
 //}
 
-//
-//func TestCSGBoundingBoxContainsAllItsChildren(t *testing.T) {
-//
-//	left := NewSphere()
-//	right := NewSphere()
-//	right.SetTransform(geom.Translate(2, 3, 4))
-//	csg := NewCSG("difference", left, right)
-//	box := BoundsOf(csg)
-//	assert.Equal(t, geom.NewPoint(-1, -1, -1), box.Min)
-//	assert.Equal(t, geom.NewPoint(3, 4, 5), box.Max)
-//}
+func TestCSGBoundingBoxContainsAllItsChildren(t *testing.T) {
+
+	left := NewSphere()
+	right := NewSphere()
+	right.SetTransform(geom.Translate(2, 3, 4))
+	csg := NewCSG("difference", left, right)
+	box := BoundsOf(csg)
+	assert.Equal(t, geom.NewPoint(-1, -1, -1), box.Min)
+	assert.Equal(t, geom.NewPoint(3, 4, 5), box.Max)
+}
 
 func TestIntersectBoundingBoxWithRayAtOrigin(t *testing.T) {
 
@@ -91,9 +91,9 @@ func MakeSubGroup(g *Group, shapes ...Shape) {
 
 func Divide(s Shape, threshold int) {
 	switch g := s.(type) {
-	//case *CSG:
-	//	Divide(g.Left, threshold)
-	//	Divide(g.Right, threshold)
+	case *CSG:
+		Divide(g.Left, threshold)
+		Divide(g.Right, threshold)
 	case *Group:
 		if threshold <= len(g.Children) {
 			// split members of group into left, right or remain
@@ -152,29 +152,28 @@ func TestName(t *testing.T) {
 
 }
 
-//
-//func TestSubdivideCSGShape(t *testing.T) {
-//	s1 := NewSphere()
-//	s1.SetTransform(geom.Translate(-1.5, 0, 0))
-//	s2 := NewSphere()
-//	s2.SetTransform(geom.Translate(1.5, 0, 0))
-//	s3 := NewSphere()
-//	s3.SetTransform(geom.Translate(0, 0, -1.5))
-//	s4 := NewSphere()
-//	s4.SetTransform(geom.Translate(0, 0, 1.5))
-//
-//	// groups
-//	left := NewGroup()
-//	left.AddChildren(s1, s2)
-//
-//	right := NewGroup()
-//	right.AddChildren(s3, s4)
-//
-//	csg := NewCSG("difference", left, right)
-//	Divide(csg, 1)
-//
-//	assert.True(t, left.Children[0].(*Group).Children[0].ID() == s1.ID())
-//	assert.True(t, left.Children[1].(*Group).Children[0].ID() == s2.ID())
-//	assert.True(t, right.Children[0].(*Group).Children[0].ID() == s3.ID())
-//	assert.True(t, right.Children[1].(*Group).Children[0].ID() == s4.ID())
-//}
+func TestSubdivideCSGShape(t *testing.T) {
+	s1 := NewSphere()
+	s1.SetTransform(geom.Translate(-1.5, 0, 0))
+	s2 := NewSphere()
+	s2.SetTransform(geom.Translate(1.5, 0, 0))
+	s3 := NewSphere()
+	s3.SetTransform(geom.Translate(0, 0, -1.5))
+	s4 := NewSphere()
+	s4.SetTransform(geom.Translate(0, 0, 1.5))
+
+	// groups
+	left := NewGroup()
+	left.AddChildren(s1, s2)
+
+	right := NewGroup()
+	right.AddChildren(s3, s4)
+
+	csg := NewCSG("difference", left, right)
+	Divide(csg, 1)
+
+	assert.True(t, left.Children[0].(*Group).Children[0].ID() == s1.ID())
+	assert.True(t, left.Children[1].(*Group).Children[0].ID() == s2.ID())
+	assert.True(t, right.Children[0].(*Group).Children[0].ID() == s3.ID())
+	assert.True(t, right.Children[1].(*Group).Children[0].ID() == s4.ID())
+}
@@ -0,0 +1,142 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// NewCone returns an infinite double-napped cone around the Y axis, i.e. x² + z² = y². The radius at any y is |y|.
+func NewCone() *Cone {
+	return &Cone{
+		Basic: Basic{
+			Id:               rand.Int63(),
+			Transform:        geom.New4x4(),
+			Inverse:          geom.New4x4(),
+			InverseTranspose: geom.New4x4(),
+			Material:         material.NewDefaultMaterial(),
+		},
+		MinY: math.Inf(-1),
+		MaxY: math.Inf(1),
+	}
+}
+
+// NewConeMMC returns a cone truncated at min and max, with caps if closed. E.g. min -1 and max 0 is a pointy cone with
+// radius 1 at the bottom, while min 1 and max 2 is a frustum such as a lamp shade or a tapered table leg.
+func NewConeMMC(min, max float64, closed bool) *Cone {
+	c := NewCone()
+	c.MinY = min
+	c.MaxY = max
+	c.Closed = closed
+	return c
+}
+
+type Cone struct {
+	Basic
+	parent Shape
+	MinY   float64
+	MaxY   float64
+	Closed bool
+}
+
+func (c *Cone) ID() int64 {
+	return c.Id
+}
+func (c *Cone) Lbl() string {
+	return c.Label
+}
+func (c *Cone) GetTransform() geom.Mat4x4 {
+	return c.Transform
+}
+func (c *Cone) GetInverse() geom.Mat4x4 {
+	return c.Inverse
+}
+func (c *Cone) GetInverseTranspose() geom.Mat4x4 {
+	return c.InverseTranspose
+}
+
+func (c *Cone) SetTransform(transform geom.Mat4x4) {
+	c.Transform = geom.Multiply(c.Transform, transform)
+	c.Inverse = geom.Inverse(c.Transform)
+	c.InverseTranspose = geom.Transpose(c.Inverse)
+}
+
+func (c *Cone) GetMaterial() material.Material {
+	return c.Material
+}
+
+func (c *Cone) SetMaterial(material material.Material) {
+	c.Material = material
+}
+
+func (c *Cone) GetParent() Shape {
+	return c.parent
+}
+func (c *Cone) SetParent(shape Shape) {
+	c.parent = shape
+}
+
+// IntersectLocal is the reference implementation of intersectCone in tracer.cl.
+func (c *Cone) IntersectLocal(ray geom.Ray) []Intersection {
+	o, d := ray.Origin, ray.Direction
+	xs := make([]Intersection, 0, 4)
+
+	a := d[0]*d[0] - d[1]*d[1] + d[2]*d[2]
+	b := 2*o[0]*d[0] - 2*o[1]*d[1] + 2*o[2]*d[2]
+	cc := o[0]*o[0] - o[1]*o[1] + o[2]*o[2]
+
+	if math.Abs(a) < intersectEpsilon {
+		// the ray is parallel to one of the halves, so there's a single intersection with the other half
+		if math.Abs(b) >= intersectEpsilon {
+			t := -cc / (2 * b)
+			if y := o[1] + t*d[1]; y > c.MinY && y < c.MaxY {
+				xs = append(xs, Intersection{T: t, S: c})
+			}
+		}
+	} else if disc := b*b - 4*a*cc; disc >= 0 {
+		t0 := (-b - math.Sqrt(disc)) / (2 * a)
+		t1 := (-b + math.Sqrt(disc)) / (2 * a)
+		for _, t := range []float64{t0, t1} {
+			if y := o[1] + t*d[1]; y > c.MinY && y < c.MaxY {
+				xs = append(xs, Intersection{T: t, S: c})
+			}
+		}
+	}
+
+	if c.Closed && math.Abs(d[1]) >= intersectEpsilon {
+		// the caps have the radius of the cone at that y
+		for _, capY := range []float64{c.MinY, c.MaxY} {
+			t := (capY - o[1]) / d[1]
+			x, z := o[0]+t*d[0], o[2]+t*d[2]
+			if x*x+z*z <= capY*capY {
+				xs = append(xs, Intersection{T: t, S: c})
+			}
+		}
+	}
+	sort.Sort(Intersections(xs))
+	return xs
+}
+
+// NormalAtLocal is the reference implementation of the cone normal in tracer.cl.
+func (c *Cone) NormalAtLocal(point geom.Tuple4) geom.Tuple4 {
+	dist := point[0]*point[0] + point[2]*point[2]
+	if c.Closed && dist < c.MaxY*c.MaxY && point[1] >= c.MaxY-intersectEpsilon {
+		return geom.NewVector(0, 1, 0)
+	}
+	if c.Closed && dist < c.MinY*c.MinY && point[1] <= c.MinY+intersectEpsilon {
+		return geom.NewVector(0, -1, 0)
+	}
+	y := math.Sqrt(dist)
+	if point[1] > 0 {
+		y = -y
+	}
+	return geom.NewVector(point[0], y, point[2])
+}
+
+// ConeMap returns the texture coordinates of a point on the side of a cone. u goes around the Y axis just like
+// SphericalMap, while v is y, i.e. textures repeat once per unit along the axis. Caps are mapped like planes.
+func ConeMap(p geom.Tuple4) (float64, float64) {
+	return azimuth(p), p[1]
+}
@@ -0,0 +1,69 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func normalizedRay(origin, direction geom.Tuple4) geom.Ray {
+	return geom.NewRay(origin, geom.Normalize(direction))
+}
+
+func TestIntersectCone(t *testing.T) {
+	tests := []struct {
+		origin, direction geom.Tuple4
+		t0, t1            float64
+	}{
+		{geom.NewPoint(0, 0, -5), geom.NewVector(0, 0, 1), 5, 5},
+		{geom.NewPoint(0, 0, -5), geom.NewVector(1, 1, 1), 8.66025, 8.66025},
+		{geom.NewPoint(1, 1, -5), geom.NewVector(-0.5, -1, 1), 4.55006, 49.44994},
+	}
+	c := NewCone()
+	for _, tc := range tests {
+		xs := c.IntersectLocal(normalizedRay(tc.origin, tc.direction))
+		assert.Len(t, xs, 2)
+		assert.InDelta(t, tc.t0, xs[0].T, 1e-4)
+		assert.InDelta(t, tc.t1, xs[1].T, 1e-4)
+	}
+}
+
+func TestIntersectConeParallelToHalf(t *testing.T) {
+	xs := NewCone().IntersectLocal(normalizedRay(geom.NewPoint(0, 0, -1), geom.NewVector(0, 1, 1)))
+	assert.Len(t, xs, 1)
+	assert.InDelta(t, 0.35355, xs[0].T, 1e-4)
+}
+
+func TestIntersectConeCaps(t *testing.T) {
+	tests := []struct {
+		origin, direction geom.Tuple4
+		count             int
+	}{
+		{geom.NewPoint(0, 0, -5), geom.NewVector(0, 1, 0), 0},
+		{geom.NewPoint(0, 0, -0.25), geom.NewVector(0, 1, 1), 2},
+		{geom.NewPoint(0, 0, -0.25), geom.NewVector(0, 1, 0), 4},
+	}
+	c := NewConeMMC(-0.5, 0.5, true)
+	for _, tc := range tests {
+		assert.Len(t, c.IntersectLocal(normalizedRay(tc.origin, tc.direction)), tc.count)
+	}
+}
+
+func TestConeNormal(t *testing.T) {
+	c := NewCone()
+	assert.Equal(t, geom.NewVector(0, 0, 0), c.NormalAtLocal(geom.NewPoint(0, 0, 0)))
+	assert.Equal(t, geom.NewVector(1, -math.Sqrt(2), 1), c.NormalAtLocal(geom.NewPoint(1, 1, 1)))
+	assert.Equal(t, geom.NewVector(-1, 1, 0), c.NormalAtLocal(geom.NewPoint(-1, -1, 0)))
+
+	capped := NewConeMMC(-1, 0, true)
+	assert.Equal(t, geom.NewVector(0, -1, 0), capped.NormalAtLocal(geom.NewPoint(0.5, -1, 0)))
+}
+
+func TestConeMap(t *testing.T) {
+	u, v := ConeMap(geom.NewPoint(0, 0.25, -0.25))
+	assert.Equal(t, 0.0, u)
+	assert.Equal(t, 0.25, v)
+	u, _ = ConeMap(geom.NewPoint(0.5, 0.5, 0))
+	assert.Equal(t, 0.25, u)
+}
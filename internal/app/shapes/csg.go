@@ -0,0 +1,83 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"math/rand"
+)
+
+// NewCSG returns a constructive solid geometry node combining left and right using the operation, which is one of
+// "union", "intersection" or "difference" (left minus right). The operands may be primitives, groups or other CSG
+// nodes and keep their own materials. The transform of the CSG node applies to both operands.
+func NewCSG(operation string, left, right Shape) *CSG {
+	c := &CSG{
+		Basic: Basic{
+			Id:               rand.Int63(),
+			Transform:        geom.New4x4(),
+			Inverse:          geom.New4x4(),
+			InverseTranspose: geom.New4x4(),
+			Material:         material.NewDefaultMaterial(),
+		},
+		Operation:   operation,
+		Left:        left,
+		Right:       right,
+		BoundingBox: NewEmptyBoundingBox(),
+	}
+	left.SetParent(c)
+	right.SetParent(c)
+	c.Bounds()
+	return c
+}
+
+type CSG struct {
+	Basic
+	parent      Shape
+	Operation   string
+	Left        Shape
+	Right       Shape
+	BoundingBox *BoundingBox
+}
+
+func (c *CSG) ID() int64 {
+	return c.Id
+}
+func (c *CSG) Lbl() string {
+	return c.Label
+}
+func (c *CSG) GetTransform() geom.Mat4x4 {
+	return c.Transform
+}
+func (c *CSG) GetInverse() geom.Mat4x4 {
+	return c.Inverse
+}
+func (c *CSG) GetInverseTranspose() geom.Mat4x4 {
+	return c.InverseTranspose
+}
+
+func (c *CSG) SetTransform(transform geom.Mat4x4) {
+	c.Transform = geom.Multiply(c.Transform, transform)
+	c.Inverse = geom.Inverse(c.Transform)
+	c.InverseTranspose = geom.Transpose(c.Inverse)
+}
+
+func (c *CSG) GetMaterial() material.Material {
+	return c.Material
+}
+
+// SetMaterial sets the material of both operands, which is what's used when rendering.
+func (c *CSG) SetMaterial(material material.Material) {
+	c.Material = material
+	c.Left.SetMaterial(material)
+	c.Right.SetMaterial(material)
+}
+
+func (c *CSG) GetParent() Shape {
+	return c.parent
+}
+func (c *CSG) SetParent(shape Shape) {
+	c.parent = shape
+}
+
+func (c *CSG) Bounds() {
+	c.BoundingBox = BoundsOf(c)
+}
@@ -0,0 +1,91 @@
+package shapes
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewCSG(t *testing.T) {
+	s1 := NewSphere()
+	s2 := NewCube()
+	c := NewCSG("union", s1, s2)
+	assert.Equal(t, "union", c.Operation)
+	assert.Equal(t, s1, c.Left)
+	assert.Equal(t, s2, c.Right)
+	assert.Equal(t, c, s1.GetParent())
+	assert.Equal(t, c, s2.GetParent())
+}
+
+func TestIntersectionAllowed(t *testing.T) {
+	testcases := []struct {
+		op       string
+		lhit     bool
+		inl      bool
+		inr      bool
+		expected bool
+	}{
+		{"union", true, true, true, false},
+		{"union", true, true, false, true},
+		{"union", true, false, true, false},
+		{"union", true, false, false, true},
+		{"union", false, true, true, false},
+		{"union", false, true, false, false},
+		{"union", false, false, true, true},
+		{"union", false, false, false, true},
+		{"intersection", true, true, true, true},
+		{"intersection", true, true, false, false},
+		{"intersection", true, false, true, true},
+		{"intersection", true, false, false, false},
+		{"intersection", false, true, true, true},
+		{"intersection", false, true, false, true},
+		{"intersection", false, false, true, false},
+		{"intersection", false, false, false, false},
+		{"difference", true, true, true, false},
+		{"difference", true, true, false, true},
+		{"difference", true, false, true, false},
+		{"difference", true, false, false, true},
+		{"difference", false, true, true, true},
+		{"difference", false, true, false, true},
+		{"difference", false, false, true, false},
+		{"difference", false, false, false, false},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expected, IntersectionAllowed(tc.op, tc.lhit, tc.inl, tc.inr), "%v %v %v %v", tc.op, tc.lhit, tc.inl, tc.inr)
+	}
+}
+
+func TestFilterIntersections(t *testing.T) {
+	testcases := []struct {
+		op     string
+		first  int
+		second int
+	}{
+		{"union", 0, 3},
+		{"intersection", 1, 2},
+		{"difference", 0, 1},
+	}
+	for _, tc := range testcases {
+		s1 := NewSphere()
+		s2 := NewCube()
+		c := NewCSG(tc.op, s1, s2)
+		xs := []Intersection{{T: 1, S: s1}, {T: 2, S: s2}, {T: 3, S: s1}, {T: 4, S: s2}}
+		result := FilterIntersections(c, xs)
+		assert.Len(t, result, 2)
+		assert.Equal(t, xs[tc.first], result[0])
+		assert.Equal(t, xs[tc.second], result[1])
+	}
+}
+
+func TestFilterIntersectionsOfNestedOperands(t *testing.T) {
+	s1 := NewSphere()
+	s2 := NewSphere()
+	g := NewGroup()
+	g.AddChildren(s1, s2)
+	s3 := NewCube()
+	c := NewCSG("difference", NewCSG("union", s3, NewCube()), g)
+
+	// the second child of the group must also be recognized as part of the right operand
+	xs := []Intersection{{T: 1, S: s3}, {T: 2, S: s2}, {T: 3, S: s2}, {T: 4, S: s3}}
+	result := FilterIntersections(c, xs)
+	assert.Len(t, result, 4)
+}
@@ -63,3 +63,16 @@ func (c *Cube) GetParent() Shape {
 func (c *Cube) SetParent(shape Shape) {
 	c.parent = shape
 }
+
+// IntersectLocal is the reference implementation of intersectCube in tracer.cl, i.e. the slab test of the unit cube.
+func (c *Cube) IntersectLocal(ray geom.Ray) []Intersection {
+	xtmin, xtmax := checkAxisForBB(ray.Origin[0], ray.Direction[0], -1, 1)
+	ytmin, ytmax := checkAxisForBB(ray.Origin[1], ray.Direction[1], -1, 1)
+	ztmin, ztmax := checkAxisForBB(ray.Origin[2], ray.Direction[2], -1, 1)
+	tmin := max(xtmin, ytmin, ztmin)
+	tmax := min(xtmax, ytmax, ztmax)
+	if tmin > tmax {
+		return nil
+	}
+	return []Intersection{{T: tmin, S: c}, {T: tmax, S: c}}
+}
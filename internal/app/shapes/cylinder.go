@@ -87,3 +87,26 @@ func (c *Cylinder) SetParent(shape Shape) {
 }
 
 func (c *Cylinder) Init() {}
+
+// IntersectLocal is the reference implementation of intersectCylinder in tracer.cl. Like the kernel, it ignores the
+// caps of closed cylinders.
+func (c *Cylinder) IntersectLocal(ray geom.Ray) []Intersection {
+	o, d := ray.Origin, ray.Direction
+	a := d[0]*d[0] + d[2]*d[2]
+	if math.Abs(a) < intersectEpsilon {
+		return nil
+	}
+	b := 2*o[0]*d[0] + 2*o[2]*d[2]
+	cc := o[0]*o[0] + o[2]*o[2] - 1
+	disc := b*b - 4*a*cc
+	if disc < 0 {
+		return nil
+	}
+	xs := make([]Intersection, 0, 2)
+	for _, t := range []float64{(-b - math.Sqrt(disc)) / (2 * a), (-b + math.Sqrt(disc)) / (2 * a)} {
+		if y := o[1] + t*d[1]; y > c.MinY && y < c.MaxY {
+			xs = append(xs, Intersection{T: t, S: c})
+		}
+	}
+	return xs
+}
@@ -0,0 +1,86 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"math"
+	"math/rand"
+)
+
+// NewDisk returns a disk with radius 1 in the XZ plane, facing up, e.g. a cap or the face of a lamp.
+func NewDisk() *Disk {
+	return &Disk{
+		Basic: Basic{
+			Id:               rand.Int63(),
+			Transform:        geom.New4x4(),
+			Inverse:          geom.New4x4(),
+			InverseTranspose: geom.New4x4(),
+			Material:         material.NewDefaultMaterial(),
+		},
+	}
+}
+
+type Disk struct {
+	Basic
+	parent Shape
+}
+
+func (d *Disk) ID() int64 {
+	return d.Id
+}
+func (d *Disk) Lbl() string {
+	return d.Label
+}
+func (d *Disk) GetTransform() geom.Mat4x4 {
+	return d.Transform
+}
+func (d *Disk) GetInverse() geom.Mat4x4 {
+	return d.Inverse
+}
+func (d *Disk) GetInverseTranspose() geom.Mat4x4 {
+	return d.InverseTranspose
+}
+
+func (d *Disk) SetTransform(transform geom.Mat4x4) {
+	d.Transform = geom.Multiply(d.Transform, transform)
+	d.Inverse = geom.Inverse(d.Transform)
+	d.InverseTranspose = geom.Transpose(d.Inverse)
+}
+
+func (d *Disk) GetMaterial() material.Material {
+	return d.Material
+}
+
+func (d *Disk) SetMaterial(material material.Material) {
+	d.Material = material
+}
+
+func (d *Disk) GetParent() Shape {
+	return d.parent
+}
+func (d *Disk) SetParent(shape Shape) {
+	d.parent = shape
+}
+
+// IntersectLocal is the reference implementation of intersectDisk in tracer.cl.
+func (d *Disk) IntersectLocal(ray geom.Ray) []Intersection {
+	if math.Abs(ray.Direction[1]) < intersectEpsilon {
+		return nil
+	}
+	t := -ray.Origin[1] / ray.Direction[1]
+	x, z := ray.Origin[0]+t*ray.Direction[0], ray.Origin[2]+t*ray.Direction[2]
+	if x*x+z*z > 1.0 {
+		return nil
+	}
+	return []Intersection{{T: t, S: d}}
+}
+
+func (d *Disk) NormalAtLocal(point geom.Tuple4) geom.Tuple4 {
+	return geom.NewVector(0, 1, 0)
+}
+
+// DiskMap returns the texture coordinates of a point on a disk, which maps the full texture onto the disk once with
+// v increasing towards +Z.
+func DiskMap(p geom.Tuple4) (float64, float64) {
+	return (p[0] + 1) * 0.5, (p[2] + 1) * 0.5
+}
@@ -0,0 +1,23 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIntersectDisk(t *testing.T) {
+	d := NewDisk()
+	xs := d.IntersectLocal(geom.NewRay(geom.NewPoint(0.5, 2, 0.5), geom.NewVector(0, -1, 0)))
+	assert.Len(t, xs, 1)
+	assert.Equal(t, 2.0, xs[0].T)
+
+	// outside the radius
+	assert.Len(t, d.IntersectLocal(geom.NewRay(geom.NewPoint(0.8, 2, 0.8), geom.NewVector(0, -1, 0))), 0)
+	// parallel
+	assert.Len(t, d.IntersectLocal(geom.NewRay(geom.NewPoint(0, 1, 0), geom.NewVector(1, 0, 0))), 0)
+
+	u, v := DiskMap(geom.NewPoint(-1, 0, 0.5))
+	assert.Equal(t, 0.0, u)
+	assert.Equal(t, 0.75, v)
+}
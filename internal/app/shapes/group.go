@@ -23,6 +23,10 @@ type Group struct {
 	BoundingBox *BoundingBox
 
 	CastShadow bool
+
+	// HasMaterial is set by SetMaterial. The material then applies to all triangles in the group and its subgroups,
+	// except for their colors, unless an enclosing group has a material too.
+	HasMaterial bool
 }
 
 func NewGroup() *Group {
@@ -79,6 +83,7 @@ func (g *Group) GetMaterial() material.Material {
 
 func (g *Group) SetMaterial(material material.Material) {
 	g.Material = material
+	g.HasMaterial = true
 	//for _, c := range g.Children {
 	//	c.SetMaterial(material)
 	//}
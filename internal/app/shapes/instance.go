@@ -0,0 +1,74 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"math/rand"
+)
+
+// NewInstance returns an instance of the mesh, i.e. a reference to the mesh with its own transform and optionally its
+// own material. The mesh is only uploaded once no matter how many instances refer to it, so the mesh should be divided
+// and have its bounds computed before creating instances. Place many instances in a group and Divide it to get a
+// top-level BVH over the instances.
+func NewInstance(mesh *Group) *Instance {
+	return &Instance{
+		Basic: Basic{
+			Id:               rand.Int63(),
+			Transform:        geom.New4x4(),
+			Inverse:          geom.New4x4(),
+			InverseTranspose: geom.New4x4(),
+			Material:         material.NewDefaultMaterial(),
+		},
+		Mesh: mesh,
+	}
+}
+
+type Instance struct {
+	Basic
+	parent Shape
+	Mesh   *Group
+
+	// HasMaterial is set by SetMaterial. The color and emission of the material then override those of the mesh
+	// triangles, otherwise the mesh is rendered with its own colors.
+	HasMaterial bool
+}
+
+func (i *Instance) ID() int64 {
+	return i.Id
+}
+func (i *Instance) Lbl() string {
+	return i.Label
+}
+func (i *Instance) GetTransform() geom.Mat4x4 {
+	return i.Transform
+}
+func (i *Instance) GetInverse() geom.Mat4x4 {
+	return i.Inverse
+}
+func (i *Instance) GetInverseTranspose() geom.Mat4x4 {
+	return i.InverseTranspose
+}
+
+func (i *Instance) SetTransform(transform geom.Mat4x4) {
+	i.Transform = geom.Multiply(i.Transform, transform)
+	i.Inverse = geom.Inverse(i.Transform)
+	i.InverseTranspose = geom.Transpose(i.Inverse)
+}
+
+func (i *Instance) GetMaterial() material.Material {
+	return i.Material
+}
+
+func (i *Instance) SetMaterial(material material.Material) {
+	i.Material = material
+	i.HasMaterial = true
+}
+
+// GetParent returns the parent of the instance. Note that the mesh has no parent since it may be shared by many
+// instances.
+func (i *Instance) GetParent() Shape {
+	return i.parent
+}
+func (i *Instance) SetParent(shape Shape) {
+	i.parent = shape
+}
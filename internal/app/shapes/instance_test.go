@@ -0,0 +1,75 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewInstance(t *testing.T) {
+	mesh := NewGroup()
+	mesh.AddChild(NewTriangle3P(geom.NewPoint(0, 1, 0), geom.NewPoint(-1, 0, 0), geom.NewPoint(1, 0, 0)))
+	inst := NewInstance(mesh)
+	assert.Equal(t, mesh, inst.Mesh)
+	assert.False(t, inst.HasMaterial)
+
+	inst.SetMaterial(material.NewDiffuse(1, 0, 0))
+	assert.True(t, inst.HasMaterial)
+}
+
+func TestInstanceDoesNotAdoptMesh(t *testing.T) {
+	mesh := NewGroup()
+	g := NewGroup()
+	g.AddChildren(NewInstance(mesh), NewInstance(mesh))
+	assert.Nil(t, mesh.GetParent())
+	assert.Equal(t, g, g.Children[0].GetParent())
+}
+
+func TestInstanceBounds(t *testing.T) {
+	mesh := NewGroup()
+	mesh.AddChild(NewTriangle3P(geom.NewPoint(0, 1, 0), geom.NewPoint(-1, 0, 0), geom.NewPoint(1, 0, 0)))
+	inst := NewInstance(mesh)
+	inst.SetTransform(geom.Translate(5, 0, 0))
+
+	box := BoundsOf(inst)
+	assert.Equal(t, geom.NewPoint(-1, 0, 0), box.Min)
+	assert.Equal(t, geom.NewPoint(1, 1, 0), box.Max)
+
+	box = ParentSpaceBounds(inst)
+	assert.Equal(t, geom.NewPoint(4, 0, 0), box.Min)
+	assert.Equal(t, geom.NewPoint(6, 1, 0), box.Max)
+
+	// the bounds of the mesh itself must be left alone
+	box.MergeWith(NewBoundingBoxF(-10, -10, -10, 10, 10, 10))
+	assert.Equal(t, geom.NewPoint(-1, 0, 0), mesh.BoundingBox.Min)
+}
+
+func TestInstanceBoundsOfTransformedMesh(t *testing.T) {
+	mesh := NewGroup()
+	mesh.AddChild(NewTriangle3P(geom.NewPoint(0, 1, 0), geom.NewPoint(-1, 0, 0), geom.NewPoint(1, 0, 0)))
+	mesh.SetTransform(geom.Translate(0, 2, 0))
+	inst := NewInstance(mesh)
+
+	box := BoundsOf(inst)
+	assert.Equal(t, geom.NewPoint(-1, 2, 0), box.Min)
+	assert.Equal(t, geom.NewPoint(1, 3, 0), box.Max)
+}
+
+func TestDivideGroupOfInstances(t *testing.T) {
+	mesh := NewGroup()
+	mesh.AddChild(NewTriangle3P(geom.NewPoint(0, 1, 0), geom.NewPoint(-1, 0, 0), geom.NewPoint(1, 0, 0)))
+
+	i1 := NewInstance(mesh)
+	i1.SetTransform(geom.Translate(-5, 0, 0))
+	i2 := NewInstance(mesh)
+	i2.SetTransform(geom.Translate(5, 0, 0))
+	g := NewGroup()
+	g.AddChildren(i1, i2)
+	Divide(g, 1)
+
+	assert.Len(t, g.Children, 2)
+	assert.Equal(t, i1, g.Children[0].(*Group).Children[0])
+	assert.Equal(t, i2, g.Children[1].(*Group).Children[0])
+	assert.Len(t, mesh.Children, 1)
+}
@@ -119,45 +119,42 @@ func IntersectionAllowed(op string, lhit, inl, inr bool) bool {
 	return false
 }
 
-//
-//func FilterIntersections(csg *CSG, xs []Intersection) []Intersection {
-//	// begin outside of both children
-//	inl := false
-//	inr := false
-//	// prepare a list to receive the filtered intersections
-//	result := make([]Intersection, 0)
-//	for idx, i := range xs {
-//		// if i.object is part of the "left" child, then lhit is true
-//		lhit := includes(csg.Left, i.S)
-//		if IntersectionAllowed(csg.Operation, lhit, inl, inr) {
-//			result = append(result, xs[idx])
-//		}
-//		// depending on which object was hit, toggle either inl or inr
-//		if lhit {
-//			inl = !inl
-//		} else {
-//			inr = !inr
-//		}
-//
-//	}
-//	return result
-//}
+// FilterIntersections is the reference implementation of the CSG filtering in tracer.cl. Given the intersections of
+// both operands sorted by T, it returns those that lie on the surface of the combined shape.
+func FilterIntersections(csg *CSG, xs []Intersection) []Intersection {
+	// begin outside of both children
+	inl := false
+	inr := false
+	// prepare a list to receive the filtered intersections
+	result := make([]Intersection, 0)
+	for idx, i := range xs {
+		// if i.object is part of the "left" child, then lhit is true
+		lhit := includes(csg.Left, i.S)
+		if IntersectionAllowed(csg.Operation, lhit, inl, inr) {
+			result = append(result, xs[idx])
+		}
+		// depending on which object was hit, toggle either inl or inr
+		if lhit {
+			inl = !inl
+		} else {
+			inr = !inr
+		}
+
+	}
+	return result
+}
 
 func includes(left Shape, object Shape) bool {
 	switch t := left.(type) {
 	case *Group:
 		for _, child := range t.Children {
-			if child.ID() == object.ID() {
+			if includes(child, object) {
 				return true
-			} else {
-				return includes(child, object)
 			}
 		}
 		return false
-	//case *CSG:
-	//	a := includes(t.Left, object)
-	//	b := includes(t.Right, object)
-	//	return a || b
+	case *CSG:
+		return includes(t.Left, object) || includes(t.Right, object)
 	default:
 		return left.ID() == object.ID()
 	}
@@ -3,6 +3,7 @@ package shapes
 import (
 	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"math"
 	"math/rand"
 )
 
@@ -70,3 +71,11 @@ func (p *Plane) GetParent() Shape {
 func (p *Plane) SetParent(shape Shape) {
 	p.parent = shape
 }
+
+// IntersectLocal is the reference implementation of intersectPlane in tracer.cl.
+func (p *Plane) IntersectLocal(ray geom.Ray) []Intersection {
+	if math.Abs(ray.Direction[1]) <= intersectEpsilon {
+		return nil
+	}
+	return []Intersection{{T: -ray.Origin[1] / ray.Direction[1], S: p}}
+}
@@ -0,0 +1,52 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"sort"
+)
+
+// localIntersector is implemented by the primitives, which intersect rays in their object space.
+type localIntersector interface {
+	IntersectLocal(ray geom.Ray) []Intersection
+}
+
+// Intersect returns the intersections of the ray, given in the space of the parent of the shape, with the shape sorted
+// by T. It follows the intersection code of tracer.cl, and is used for the few rays traced on the Go side, such as
+// the autofocus ray. Groups and instances are intersected through their children without any bounding box tests.
+func Intersect(shape Shape, ray geom.Ray) []Intersection {
+	local := geom.NewRay(geom.MultiplyByTuple(shape.GetInverse(), ray.Origin), geom.MultiplyByTuple(shape.GetInverse(), ray.Direction))
+
+	var xs []Intersection
+	switch s := shape.(type) {
+	case localIntersector:
+		xs = s.IntersectLocal(local)
+	case *Group:
+		for _, child := range s.Children {
+			xs = append(xs, Intersect(child, local)...)
+		}
+	case *Instance:
+		xs = Intersect(s.Mesh, local)
+	case *CSG:
+		xs = append(Intersect(s.Left, local), Intersect(s.Right, local)...)
+		sort.Sort(Intersections(xs))
+		return FilterIntersections(s, xs)
+	}
+	sort.Sort(Intersections(xs))
+	return xs
+}
+
+// Hit returns the closest intersection of the ray with any of the shapes in front of the ray origin, or false if the
+// ray misses all of them.
+func Hit(shapes []Shape, ray geom.Ray) (Intersection, bool) {
+	var hit Intersection
+	found := false
+	for _, shape := range shapes {
+		for _, x := range Intersect(shape, ray) {
+			if x.T > intersectEpsilon && (!found || x.T < hit.T) {
+				hit = x
+				found = true
+			}
+		}
+	}
+	return hit, found
+}
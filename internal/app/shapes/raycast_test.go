@@ -0,0 +1,73 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIntersectTransformedSphere(t *testing.T) {
+	s := NewSphere()
+	s.SetTransform(geom.Translate(0, 0, 5))
+	s.SetTransform(geom.Scale(2, 2, 2))
+
+	xs := Intersect(s, geom.NewRay(geom.NewPoint(0, 0, 0), geom.NewVector(0, 0, 1)))
+	assert.Len(t, xs, 2)
+	assert.InEpsilon(t, 3.0, xs[0].T, geom.Epsilon)
+	assert.InEpsilon(t, 7.0, xs[1].T, geom.Epsilon)
+}
+
+func TestIntersectGroup(t *testing.T) {
+	near := NewSphere()
+	near.SetTransform(geom.Translate(0, 0, -3))
+	far := NewCube()
+	far.SetTransform(geom.Translate(0, 0, 3))
+	g := NewGroup()
+	g.AddChildren(far, near)
+	g.SetTransform(geom.Translate(0, 0, 10))
+
+	xs := Intersect(g, geom.NewRay(geom.NewPoint(0, 0, 0), geom.NewVector(0, 0, 1)))
+	assert.Len(t, xs, 4)
+	assert.InEpsilon(t, 6.0, xs[0].T, geom.Epsilon)
+	assert.Equal(t, near, xs[0].S)
+	assert.InEpsilon(t, 12.0, xs[2].T, geom.Epsilon)
+	assert.Equal(t, far, xs[2].S)
+}
+
+func TestIntersectCSGDifference(t *testing.T) {
+	cube := NewCube()
+	hole := NewSphere()
+	hole.SetTransform(geom.Scale(1.2, 1.2, 1.2))
+	c := NewCSG("difference", cube, hole)
+
+	// the sphere sticks out of all faces of the cube, so there is nothing left to hit in the middle
+	xs := Intersect(c, geom.NewRay(geom.NewPoint(0, 0, -5), geom.NewVector(0, 0, 1)))
+	assert.Len(t, xs, 0)
+
+	// in the corners, the cube is hit on both sides
+	xs = Intersect(c, geom.NewRay(geom.NewPoint(0.9, 0.9, -5), geom.NewVector(0, 0, 1)))
+	assert.Len(t, xs, 2)
+	assert.InEpsilon(t, 4.0, xs[0].T, geom.Epsilon)
+	assert.InEpsilon(t, 6.0, xs[1].T, geom.Epsilon)
+}
+
+func TestHit(t *testing.T) {
+	floor := NewPlane()
+	floor.SetTransform(geom.Translate(0, -1, 0))
+	s := NewSphere()
+	s.SetTransform(geom.Translate(0, 0, -5))
+	shapes := []Shape{floor, s}
+
+	hit, ok := Hit(shapes, geom.NewRay(geom.NewPoint(0, 0, 0), geom.NewVector(0, 0, -1)))
+	assert.True(t, ok)
+	assert.InEpsilon(t, 4.0, hit.T, geom.Epsilon)
+	assert.Equal(t, s, hit.S)
+
+	// from inside the sphere, only the intersection in front of the origin counts
+	hit, ok = Hit(shapes, geom.NewRay(geom.NewPoint(0, 0, -5), geom.NewVector(0, 0, -1)))
+	assert.True(t, ok)
+	assert.InEpsilon(t, 1.0, hit.T, geom.Epsilon)
+
+	_, ok = Hit(shapes, geom.NewRay(geom.NewPoint(0, 0, 0), geom.NewVector(0, 1, 0)))
+	assert.False(t, ok)
+}
@@ -3,6 +3,7 @@ package shapes
 import (
 	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"math"
 	"math/rand"
 	"time"
 )
@@ -71,3 +72,19 @@ func (s *Sphere) SetMaterial(m material.Material) {
 func (s *Sphere) SetParent(shape Shape) {
 	s.parent = shape
 }
+
+// IntersectLocal is the reference implementation of intersectSphere in tracer.cl, returning both intersections of
+// the unit sphere sorted by T.
+func (s *Sphere) IntersectLocal(ray geom.Ray) []Intersection {
+	vecToCenter := geom.Sub(ray.Origin, geom.NewPoint(0, 0, 0))
+	a := geom.Dot(ray.Direction, ray.Direction)
+	b := 2.0 * geom.Dot(ray.Direction, vecToCenter)
+	c := geom.Dot(vecToCenter, vecToCenter) - 1.0
+	discriminant := b*b - 4*a*c
+	if discriminant <= 0.0 {
+		return nil
+	}
+	t1 := (-b - math.Sqrt(discriminant)) / (2 * a)
+	t2 := (-b + math.Sqrt(discriminant)) / (2 * a)
+	return []Intersection{{T: t1, S: s}, {T: t2, S: s}}
+}
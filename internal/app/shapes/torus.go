@@ -0,0 +1,154 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"math"
+	"math/rand"
+)
+
+const (
+	// torusMaxSteps caps the number of steps used to bracket the roots of the torus along a ray.
+	torusMaxSteps = 512
+	// torusBisections is the number of bisections used to refine each bracketed root.
+	torusBisections = 40
+)
+
+// NewTorus returns a torus (ring) lying in the XZ plane around the Y axis. The center of the tube has radius 1, while
+// the tube itself has radius minorRadius.
+func NewTorus(minorRadius float64) *Torus {
+	return &Torus{
+		Basic: Basic{
+			Id:               rand.Int63(),
+			Transform:        geom.New4x4(),
+			Inverse:          geom.New4x4(),
+			InverseTranspose: geom.New4x4(),
+			Material:         material.NewDefaultMaterial(),
+		},
+		MinorRadius: minorRadius,
+	}
+}
+
+type Torus struct {
+	Basic
+	parent      Shape
+	MinorRadius float64
+}
+
+func (t *Torus) ID() int64 {
+	return t.Id
+}
+func (t *Torus) Lbl() string {
+	return t.Label
+}
+func (t *Torus) GetTransform() geom.Mat4x4 {
+	return t.Transform
+}
+func (t *Torus) GetInverse() geom.Mat4x4 {
+	return t.Inverse
+}
+func (t *Torus) GetInverseTranspose() geom.Mat4x4 {
+	return t.InverseTranspose
+}
+
+func (t *Torus) SetTransform(transform geom.Mat4x4) {
+	t.Transform = geom.Multiply(t.Transform, transform)
+	t.Inverse = geom.Inverse(t.Transform)
+	t.InverseTranspose = geom.Transpose(t.Inverse)
+}
+
+func (t *Torus) GetMaterial() material.Material {
+	return t.Material
+}
+
+func (t *Torus) SetMaterial(material material.Material) {
+	t.Material = material
+}
+
+func (t *Torus) GetParent() Shape {
+	return t.parent
+}
+func (t *Torus) SetParent(shape Shape) {
+	t.parent = shape
+}
+
+// IntersectLocal is the reference implementation of intersectTorus in tracer.cl. Like for the other shapes, the
+// intersections behind the ray origin are returned too, which CSG needs to know whether the ray starts inside.
+//
+// The torus is (|p|² + 1 - r²)² - 4(x² + z²) = 0, which is a quartic along the ray. Rather than solving the quartic
+// analytically, which is notoriously unstable, the ray is clipped against the bounding sphere, stepped in steps of half
+// the tube radius to bracket sign changes, and each bracketed root is refined by bisection. Rays that merely graze the
+// tube may be missed, which slightly thins the silhouette.
+func (t *Torus) IntersectLocal(ray geom.Ray) []Intersection {
+	r := t.MinorRadius
+	o, d := ray.Origin, ray.Direction
+	dirLen := math.Sqrt(d[0]*d[0] + d[1]*d[1] + d[2]*d[2])
+	if r <= 0 || dirLen == 0 {
+		return nil
+	}
+	dx, dy, dz := d[0]/dirLen, d[1]/dirLen, d[2]/dirLen
+
+	// clip against the bounding sphere
+	b := o[0]*dx + o[1]*dy + o[2]*dz
+	disc := b*b - (o[0]*o[0] + o[1]*o[1] + o[2]*o[2] - (1+r)*(1+r))
+	if disc < 0 {
+		return nil
+	}
+	s0 := -b - math.Sqrt(disc)
+	s1 := -b + math.Sqrt(disc)
+
+	// move the origin up to the bounding sphere for precision, then set up the quartic in s along the unit direction
+	ox, oy, oz := o[0]+dx*s0, o[1]+dy*s0, o[2]+dz*s0
+	m := ox*dx + oy*dy + oz*dz
+	k := ox*ox + oy*oy + oz*oz + 1 - r*r
+	c3 := 4 * m
+	c2 := 4*m*m + 2*k - 4*(dx*dx+dz*dz)
+	c1 := 4*m*k - 8*(ox*dx+oz*dz)
+	c0 := k*k - 4*(ox*ox+oz*oz)
+	f := func(s float64) float64 {
+		return (((s+c3)*s+c2)*s+c1)*s + c0
+	}
+
+	span := s1 - s0
+	steps := int(math.Ceil(span / (0.5 * r)))
+	if steps < 16 {
+		steps = 16
+	} else if steps > torusMaxSteps {
+		steps = torusMaxSteps
+	}
+	step := span / float64(steps)
+
+	xs := make([]Intersection, 0, 4)
+	prevS, prevF := 0.0, f(0)
+	for i := 1; i <= steps && len(xs) < 4; i++ {
+		s := float64(i) * step
+		fs := f(s)
+		if (prevF < 0) != (fs < 0) {
+			lo, hi, flo := prevS, s, prevF
+			for j := 0; j < torusBisections; j++ {
+				mid := (lo + hi) * 0.5
+				if fm := f(mid); (fm < 0) == (flo < 0) {
+					lo, flo = mid, fm
+				} else {
+					hi = mid
+				}
+			}
+			xs = append(xs, Intersection{T: (s0 + (lo+hi)*0.5) / dirLen, S: t})
+		}
+		prevS, prevF = s, fs
+	}
+	return xs
+}
+
+// NormalAtLocal returns the gradient of the torus function at the point, which is the (unnormalized) normal.
+func (t *Torus) NormalAtLocal(point geom.Tuple4) geom.Tuple4 {
+	q := point[0]*point[0] + point[1]*point[1] + point[2]*point[2] + 1 - t.MinorRadius*t.MinorRadius
+	return geom.NewVector(point[0]*(q-2), point[1]*q, point[2]*(q-2))
+}
+
+// TorusMap returns the texture coordinates of a point on a torus. u goes around the Y axis just like SphericalMap,
+// while v goes around the tube, starting and ending at the inner equator and passing the outer equator at 0.5.
+func TorusMap(p geom.Tuple4) (float64, float64) {
+	rho := math.Sqrt(p[0]*p[0] + p[2]*p[2])
+	return azimuth(p), math.Atan2(p[1], rho-1)/(2*math.Pi) + 0.5
+}
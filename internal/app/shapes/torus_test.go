@@ -0,0 +1,74 @@
+package shapes
+
+import (
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestIntersectTorus(t *testing.T) {
+	torus := NewTorus(0.25)
+
+	// through both sides of the ring
+	xs := torus.IntersectLocal(geom.NewRay(geom.NewPoint(0, 0, -5), geom.NewVector(0, 0, 1)))
+	assert.Len(t, xs, 4)
+	for i, expected := range []float64{3.75, 4.25, 5.75, 6.25} {
+		assert.InDelta(t, expected, xs[i].T, 1e-9)
+	}
+
+	// t is in units of the ray direction, which doesn't need to be normalized
+	xs = torus.IntersectLocal(geom.NewRay(geom.NewPoint(0, 0, -5), geom.NewVector(0, 0, 2)))
+	assert.Len(t, xs, 4)
+	assert.InDelta(t, 1.875, xs[0].T, 1e-9)
+
+	// straight down through the tube
+	xs = torus.IntersectLocal(geom.NewRay(geom.NewPoint(1, 5, 0), geom.NewVector(0, -1, 0)))
+	assert.Len(t, xs, 2)
+	assert.InDelta(t, 4.75, xs[0].T, 1e-9)
+	assert.InDelta(t, 5.25, xs[1].T, 1e-9)
+
+	// through the hole
+	assert.Len(t, torus.IntersectLocal(geom.NewRay(geom.NewPoint(0, 5, 0), geom.NewVector(0, -1, 0))), 0)
+
+	// from inside the tube, the intersection behind the origin is included too
+	xs = torus.IntersectLocal(geom.NewRay(geom.NewPoint(1, 0, 0), geom.NewVector(0, 1, 0)))
+	assert.Len(t, xs, 2)
+	assert.InDelta(t, -0.25, xs[0].T, 1e-9)
+	assert.InDelta(t, 0.25, xs[1].T, 1e-9)
+}
+
+func TestIntersectTorusHitsAreOnTheSurface(t *testing.T) {
+	torus := NewTorus(0.3)
+	rnd := rand.New(rand.NewSource(1))
+	hits := 0
+	for i := 0; i < 1000; i++ {
+		origin := geom.NewPoint(rnd.Float64()*8-4, rnd.Float64()*8-4, rnd.Float64()*8-4)
+		target := geom.NewPoint(rnd.Float64()*2-1, rnd.Float64()*0.6-0.3, rnd.Float64()*2-1)
+		ray := geom.NewRay(origin, geom.Sub(target, origin))
+		for _, x := range torus.IntersectLocal(ray) {
+			p := geom.Add(ray.Origin, geom.MultiplyByScalar(ray.Direction, x.T))
+			rho := math.Sqrt(p[0]*p[0] + p[2]*p[2])
+			// distance to the center of the tube must be the minor radius
+			assert.InDelta(t, 0.3, math.Sqrt((rho-1)*(rho-1)+p[1]*p[1]), 1e-6)
+			hits++
+		}
+	}
+	assert.True(t, hits > 500)
+}
+
+func TestTorusNormal(t *testing.T) {
+	torus := NewTorus(0.25)
+	assert.Equal(t, geom.NewVector(1, 0, 0), geom.Normalize(torus.NormalAtLocal(geom.NewPoint(1.25, 0, 0))))
+	assert.Equal(t, geom.NewVector(-1, 0, 0), geom.Normalize(torus.NormalAtLocal(geom.NewPoint(0.75, 0, 0))))
+	assert.Equal(t, geom.NewVector(0, 1, 0), geom.Normalize(torus.NormalAtLocal(geom.NewPoint(1, 0.25, 0))))
+}
+
+func TestTorusMap(t *testing.T) {
+	u, v := TorusMap(geom.NewPoint(1.25, 0, 0))
+	assert.Equal(t, 0.25, u)
+	assert.Equal(t, 0.5, v)
+	_, v = TorusMap(geom.NewPoint(1, 0.25, 0))
+	assert.Equal(t, 0.75, v)
+}
@@ -100,6 +100,19 @@ type Triangle struct {
 	Material material.Material
 	Label    string
 
+	// UV1-UV3 are the texture coordinates (u, v in x and y) of each vertex, used for normal mapping. HasUV tells if
+	// the mesh provided them at all.
+	UV1   geom.Tuple4
+	UV2   geom.Tuple4
+	UV3   geom.Tuple4
+	HasUV bool
+
+	// Tan1-Tan3 are the per vertex tangents (pointing towards increasing u), with the handedness of the bitangent
+	// stored in w. See FaceTangent.
+	Tan1 geom.Tuple4
+	Tan2 geom.Tuple4
+	Tan3 geom.Tuple4
+
 	D00   float64
 	D01   float64
 	D11   float64
@@ -113,6 +126,35 @@ type Triangle struct {
 	dirCrossE2    geom.Tuple4
 }
 
+// FaceTangent computes the tangent of the triangle from its texture coordinates, i.e. the direction in which u
+// increases. The w component holds the handedness, +1 if cross(N, tangent) points towards increasing v, -1 otherwise.
+// Returns false if the triangle has degenerate texture coordinates.
+func (s *Triangle) FaceTangent() (geom.Tuple4, bool) {
+	du1 := s.UV2[0] - s.UV1[0]
+	dv1 := s.UV2[1] - s.UV1[1]
+	du2 := s.UV3[0] - s.UV1[0]
+	dv2 := s.UV3[1] - s.UV1[1]
+	r := du1*dv2 - du2*dv1
+	if math.Abs(r) < TriThreshold {
+		return geom.Tuple4{}, false
+	}
+	tangent := geom.DivideByScalar(geom.Sub(geom.MultiplyByScalar(s.E1, dv2), geom.MultiplyByScalar(s.E2, dv1)), r)
+	bitangent := geom.DivideByScalar(geom.Sub(geom.MultiplyByScalar(s.E2, du1), geom.MultiplyByScalar(s.E1, du2)), r)
+	if geom.Magnitude(tangent) < TriThreshold {
+		return geom.Tuple4{}, false
+	}
+	tangent = geom.Normalize(tangent)
+
+	// N is computed as cross(E2, E1), so use the geometric normal from E1 x E2 to get a consistent handedness.
+	n := geom.Cross(s.E1, s.E2)
+	handedness := 1.0
+	if geom.Dot(geom.Cross(n, tangent), bitangent) < 0.0 {
+		handedness = -1.0
+	}
+	tangent[3] = handedness
+	return tangent, true
+}
+
 // Barycentric computes barycentric coordinates (u, v, w) for point p with respect to triangle defined by pre-computed
 // vectors E1 and E2, which was derived into points d00, d01, d11 and denominator in constructor func.
 func (s *Triangle) Barycentric(p geom.Tuple4, u *float64, v *float64, w *float64) {
@@ -180,10 +222,8 @@ func (s *Triangle) IntersectLocal(ray geom.Ray) []Intersection {
 	if v < 0 || (u+v) > 1 {
 		return nil
 	}
-	_ = f * geom.DotPtr(&s.E2, &s.originCrossE1)
-	return nil
-	//s.xs[0] = NewIntersectionUV(tdist, s, u, v)
-	//return s.xs
+	tdist := f * geom.DotPtr(&s.E2, &s.originCrossE1)
+	return []Intersection{{T: tdist, S: s, U: u, V: v}}
 }
 
 func (s *Triangle) NormalAtLocal(point geom.Tuple4, intersection *Intersection) geom.Tuple4 {
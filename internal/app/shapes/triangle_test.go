@@ -17,10 +17,36 @@ func TestSetup(t *testing.T) {
 //	assert.Equal(t, 0.2, i.U)
 //	assert.Equal(t, 0.4, i.V)
 //}
-//func TestIntersectWithTriStoresUV(t *testing.T) {
-//	tri := DefaultTriangle()
-//	r := geom.NewRay(geom.NewPoint(-0.2, 0.3, -2), geom.NewVector(0, 0, 1))
-//	xs := tri.IntersectLocal(r)
-//	assert.InEpsilon(t, 0.45, xs[0].U, geom.Epsilon)
-//	assert.InEpsilon(t, 0.25, xs[0].V, geom.Epsilon)
-//}
+
+func TestIntersectWithTriStoresUV(t *testing.T) {
+	tri := DefaultTriangle()
+	r := geom.NewRay(geom.NewPoint(-0.2, 0.3, -2), geom.NewVector(0, 0, 1))
+	xs := tri.IntersectLocal(r)
+	assert.InEpsilon(t, 2.0, xs[0].T, geom.Epsilon)
+	assert.InEpsilon(t, 0.45, xs[0].U, geom.Epsilon)
+	assert.InEpsilon(t, 0.25, xs[0].V, geom.Epsilon)
+}
+
+func TestFaceTangent(t *testing.T) {
+	// triangle in the XY plane, with u along +X and v along +Y
+	tri := NewTriangleN(geom.NewPoint(0, 0, 0), geom.NewPoint(2, 0, 0), geom.NewPoint(0, 2, 0))
+	tri.UV1 = geom.Tuple4{0, 0}
+	tri.UV2 = geom.Tuple4{1, 0}
+	tri.UV3 = geom.Tuple4{0, 1}
+
+	tangent, ok := tri.FaceTangent()
+	assert.True(t, ok)
+	assert.Equal(t, geom.Tuple4{1, 0, 0, 1}, tangent)
+
+	// mirrored v flips the handedness but not the tangent
+	tri.UV3 = geom.Tuple4{0, -1}
+	tangent, ok = tri.FaceTangent()
+	assert.True(t, ok)
+	assert.Equal(t, geom.Tuple4{1, 0, 0, -1}, tangent)
+}
+
+func TestFaceTangentDegenerateUV(t *testing.T) {
+	tri := DefaultTriangle()
+	_, ok := tri.FaceTangent()
+	assert.False(t, ok)
+}
@@ -3,14 +3,19 @@ package tracer
 import (
 	"fmt"
 	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/animation"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/camera"
 	canvas2 "github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/scenes"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"github.com/eriklupander/pathtracer-ocl/internal/ocl"
 	"github.com/sirupsen/logrus"
 	"image"
 	"image/png"
 	"math"
 	"os"
+	"sort"
 	"time"
 )
 
@@ -19,14 +24,237 @@ var originPoint = geom.NewPoint(0, 0, 0)
 func Render(sceneFactory func() *scenes.Scene) {
 
 	st := time.Now()
-	canvas := canvas2.NewCanvas(cmd.Cfg.Width, cmd.Cfg.Height)
 
-	// Create the render contexts, one per worker
-	renderContext := NewCtx(0, sceneFactory(), canvas, cmd.Cfg.Samples)
-	renderContext.renderPixelPathTracer(cmd.Cfg.Width, cmd.Cfg.Height)
+	scene := sceneFactory()
+
+	tracer := newTracer()
+	defer tracer.Release()
+	rendered := renderFrame(tracer, scene, scene.Camera, 0, cmd.Cfg.Seed)
+	writeRawImage(rendered.canvas, "experiment.raw")
 
 	logrus.Infof("Finished in %v\n", time.Now().Sub(st))
-	writeImagePNG(canvas, fmt.Sprintf("out-%v-%vx%v.png", cmd.Cfg.Samples, cmd.Cfg.Width, cmd.Cfg.Height))
+	writeRenderedImage(rendered, "")
+}
+
+// RenderSequence renders the --frames of the animation of the scene, where frame n shows the scene at n / --fps
+// seconds, into numbered images. The kernel is built once and reused for all frames. Frame n is rendered with
+// --seed + n, so that the noise doesn't stand still while the scene moves.
+func RenderSequence(sceneFactory func() *scenes.Scene) {
+	first, last, err := animation.ParseFrames(cmd.Cfg.Frames)
+	if err != nil {
+		logrus.Fatalf("--frames: %v", err)
+	}
+	if cmd.Cfg.FPS <= 0 {
+		logrus.Fatalf("--fps must be positive, got %v", cmd.Cfg.FPS)
+	}
+
+	st := time.Now()
+	scene := sceneFactory()
+	if scene.Animation == nil {
+		logrus.Warnf("Scene %v is not animated, all frames will be the same", cmd.Cfg.Scene)
+		scene.Animation = &animation.Animation{}
+	}
+	sceneCamera := scene.Camera
+
+	tracer := newTracer()
+	defer tracer.Release()
+	for frame := first; frame <= last; frame++ {
+		frameStart := time.Now()
+		rendered := renderFrame(tracer, scene, sceneCamera, float64(frame)/cmd.Cfg.FPS, cmd.Cfg.Seed+uint32(frame))
+		logrus.Infof("Frame %d (%d-%d) finished in %v", frame, first, last, time.Since(frameStart))
+		writeRenderedImage(rendered, fmt.Sprintf("-%04d", frame))
+	}
+	logrus.Infof("Finished %d frames in %v", last-first+1, time.Since(st))
+}
+
+// renderedImage is a rendered image with the heatmap of adaptive sampling, if --adaptive-threshold is set, and the
+// images of the --aovs.
+type renderedImage struct {
+	canvas  *canvas2.Canvas
+	heatmap *canvas2.Canvas
+	aovs    map[ocl.AOV]*canvas2.Canvas
+}
+
+// writeRenderedImage writes the image, its heatmap and a preview of each of its AOVs as PNGs, and the AOVs as they are
+// as .raw files. The file names end with the samples, the size and the suffix.
+func writeRenderedImage(rendered renderedImage, suffix string) {
+	name := fmt.Sprintf("%v-%vx%v%s", cmd.Cfg.Samples, rendered.canvas.W, rendered.canvas.H, suffix)
+	writeImagePNG(rendered.canvas, fmt.Sprintf("out-%s.png", name))
+	if rendered.heatmap != nil {
+		writeImagePNG(rendered.heatmap, fmt.Sprintf("heatmap-%s.png", name))
+	}
+	aovs := make([]ocl.AOV, 0, len(rendered.aovs))
+	for aov := range rendered.aovs {
+		aovs = append(aovs, aov)
+	}
+	sort.Slice(aovs, func(i, j int) bool { return aovs[i] < aovs[j] })
+	for _, aov := range aovs {
+		writeImagePNG(aov.Preview(rendered.aovs[aov]), fmt.Sprintf("aov-%v-%s.png", aov, name))
+		writeRawImage(rendered.aovs[aov], fmt.Sprintf("aov-%v-%s.raw", aov, name))
+	}
+}
+
+// parseAOVs returns the AOVs of --aovs.
+func parseAOVs() []ocl.AOV {
+	aovs, err := ocl.ParseAOVs(cmd.Cfg.AOVs)
+	if err != nil {
+		logrus.Fatalf("--aovs: %v", err)
+	}
+	return aovs
+}
+
+// newTracer builds the kernel for the device, path depths, sampler, filter and AOVs of the flags.
+func newTracer() *ocl.Tracer {
+	sampler, err := ocl.ParseSampler(cmd.Cfg.Sampler)
+	if err != nil {
+		logrus.Fatalf("--sampler: %v", err)
+	}
+	filter, err := ocl.ParseFilter(cmd.Cfg.Filter)
+	if err != nil {
+		logrus.Fatalf("--filter: %v", err)
+	}
+	radius := cmd.Cfg.FilterRadius
+	if radius == 0 {
+		radius = filter.DefaultRadius()
+	}
+	if radius < 0 || radius > ocl.MaxFilterRadius {
+		logrus.Fatalf("--filter-radius must be between 0 and %v pixels, got %v", ocl.MaxFilterRadius, radius)
+	}
+	return ocl.NewTracer(cmd.Cfg.DeviceIndex, cmd.Cfg.MaxDepth, cmd.Cfg.RRDepth, sampler, ocl.PixelFilter{Filter: filter, Radius: radius}, len(parseAOVs()) > 0)
+}
+
+// renderFrame renders the scene as seen by a copy of sceneCamera at the time, in seconds, of its animation, with the
+// random numbers of the seed, and returns the image with its heatmap and AOVs, see renderImage. Objects
+// which the animation moves while the shutter of the camera is open are motion blurred: the scene is built once with
+// the objects where they are at shutter close, and rendered with them where they are at shutter open. The camera isn't
+// blurred, it's rendered where the animation has it at shutter open.
+func renderFrame(tracer *ocl.Tracer, scene *scenes.Scene, sceneCamera camera.Camera, time float64, seed uint32) renderedImage {
+	scene.Camera = sceneCamera
+	applyShutterFlags(&scene.Camera)
+	open, close := scene.Camera.ShutterOpen, scene.Camera.ShutterClose
+
+	var endObjects []ocl.CLObject
+	if scene.Animation != nil {
+		if close > open {
+			scene.Animation.ApplyObjects(time + close)
+			var err error
+			endObjects, _, _, _, _, _, err = ocl.BuildSceneBufferCL(scene.Objects)
+			if err != nil {
+				logrus.Fatalf("Failed to build the scene at shutter close: %v", err)
+			}
+		}
+		scene.Animation.Apply(&scene.Camera, time+open)
+	}
+	applyCameraFlags(&scene.Camera, scene.Objects)
+	return renderImage(tracer, scene, endObjects, seed)
+}
+
+// renderImage renders the scene, or the stereo pair of the scene if --stereo is set, into a new canvas. If endObjects
+// isn't nil, objects are motion blurred on their way to where they are in endObjects, see ocl.AddMotion. Both eyes of
+// stereo pairs use the same seed. With --adaptive-threshold, pixels take more samples until their relative error is
+// below it, and renderImage also returns a heatmap of the samples of each pixel, otherwise nil. The AOVs of --aovs are
+// rendered along with the image, laid out the same way.
+func renderImage(tracer *ocl.Tracer, scene *scenes.Scene, endObjects []ocl.CLObject, seed uint32) renderedImage {
+	// Stereo pairs render one eye after the other into their half of a canvas twice the size of the image
+	width, height := cmd.Cfg.Width, cmd.Cfg.Height
+	var rightX, rightY int
+	switch cmd.Cfg.Stereo {
+	case "":
+	case "side-by-side":
+		rightX = width
+		width *= 2
+	case "over-under":
+		rightY = height
+		height *= 2
+	default:
+		logrus.Fatalf("--stereo: unknown layout %q, expected side-by-side or over-under", cmd.Cfg.Stereo)
+	}
+	canvas := canvas2.NewCanvas(width, height)
+
+	// Create the render contexts, one per worker
+	renderContext := NewCtx(0, scene, canvas, cmd.Cfg.Samples, seed, tracer)
+	renderContext.endObjects = endObjects
+	var heatmap *canvas2.Canvas
+	if cmd.Cfg.AdaptiveThreshold > 0 {
+		if cmd.Cfg.MaxSamples < cmd.Cfg.Samples {
+			logrus.Fatalf("--max-samples %d is less than --samples %d", cmd.Cfg.MaxSamples, cmd.Cfg.Samples)
+		}
+		heatmap = canvas2.NewCanvas(width, height)
+		renderContext.adaptiveThreshold = cmd.Cfg.AdaptiveThreshold
+		renderContext.maxSamples = cmd.Cfg.MaxSamples
+		renderContext.heatmap = heatmap
+	}
+	aovs := map[ocl.AOV]*canvas2.Canvas{}
+	for _, aov := range parseAOVs() {
+		aovs[aov] = canvas2.NewCanvas(width, height)
+	}
+	renderContext.aovs = aovs
+	if cmd.Cfg.Stereo == "" {
+		renderContext.renderPixelPathTracer(scene.Camera, 0, 0)
+	} else {
+		renderContext.renderPixelPathTracer(scene.Camera.EyeCamera(camera.LeftEye), 0, 0)
+		renderContext.renderPixelPathTracer(scene.Camera.EyeCamera(camera.RightEye), rightX, rightY)
+	}
+	return renderedImage{canvas: canvas, heatmap: heatmap, aovs: aovs}
+}
+
+// applyShutterFlags overrides the shutter of the scene camera with the shutter flags that are set. Unlike the other
+// camera flags, they are needed before the scene is animated for the time of the image.
+func applyShutterFlags(cam *camera.Camera) {
+	if cmd.Cfg.ShutterOpen != 0 {
+		cam.ShutterOpen = cmd.Cfg.ShutterOpen
+	}
+	if cmd.Cfg.ShutterClose != 0 {
+		cam.ShutterClose = cmd.Cfg.ShutterClose
+	}
+}
+
+// applyCameraFlags overrides the projection, the eyes and the lens of the scene camera with those of the camera flags that are
+// set, and finally focuses on the --autofocus pixel if given.
+func applyCameraFlags(cam *camera.Camera, objects []shapes.Shape) {
+	if cmd.Cfg.Projection != "" {
+		projection, err := camera.ParseProjection(cmd.Cfg.Projection)
+		if err != nil {
+			logrus.Fatalf("--projection: %v", err)
+		}
+		cam.Projection = projection
+	}
+	if cmd.Cfg.OrthoScale > 0 {
+		cam.OrthoScale = cmd.Cfg.OrthoScale
+	}
+	if cmd.Cfg.IPD > 0 {
+		cam.IPD = cmd.Cfg.IPD
+	}
+	if cmd.Cfg.Convergence > 0 {
+		cam.Convergence = cmd.Cfg.Convergence
+	}
+	if cmd.Cfg.Aperture > 0 {
+		cam.Aperture = cmd.Cfg.Aperture
+	}
+	if cmd.Cfg.FStop > 0 {
+		cam.SetFStop(cmd.Cfg.FStop)
+	}
+	if cmd.Cfg.FocusDistance > 0 {
+		cam.FocusDistance = cmd.Cfg.FocusDistance
+	}
+	if cmd.Cfg.Blades > 0 {
+		cam.Blades = cmd.Cfg.Blades
+	}
+	if cmd.Cfg.BladeRotation != 0 {
+		cam.BladeRotation = cmd.Cfg.BladeRotation * math.Pi / 180
+	}
+	if len(cmd.Cfg.Autofocus) == 0 {
+		return
+	}
+	if len(cmd.Cfg.Autofocus) != 2 {
+		logrus.Fatalf("--autofocus takes the pixel to focus on as x,y, got %v", cmd.Cfg.Autofocus)
+	}
+	x, y := cmd.Cfg.Autofocus[0], cmd.Cfg.Autofocus[1]
+	if cam.Autofocus(objects, x, y) {
+		logrus.Infof("Autofocus at %d,%d: focus distance %.3f", x, y, cam.FocusDistance)
+	} else {
+		logrus.Warnf("Autofocus at %d,%d hit nothing, keeping focus distance %.3f", x, y, cam.FocusDistance)
+	}
 }
 
 func writeImagePNG(canvas *canvas2.Canvas, filename string) {
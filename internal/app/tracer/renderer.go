@@ -1,17 +1,14 @@
 package tracer
 
 import (
-	"github.com/eriklupander/pathtracer-ocl/cmd"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/adaptive"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/raw"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
-	"math/rand"
 	"os"
-	"time"
 
 	camera2 "github.com/eriklupander/pathtracer-ocl/internal/app/camera"
 	canvas2 "github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
-	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/scenes"
 	"github.com/eriklupander/pathtracer-ocl/internal/ocl"
 )
@@ -20,56 +17,117 @@ type Ctx struct {
 	Id      int
 	scene   *scenes.Scene
 	canvas  *canvas2.Canvas
-	camera  camera2.Camera
 	samples int
-	rnd     *rand.Rand
+	seed    uint32
+	tracer  *ocl.Tracer
+
+	// endObjects are the objects of the scene at shutter close, for motion blur. Nil if nothing moves.
+	endObjects []ocl.CLObject
+
+	// With an adaptive threshold above 0, pixels whose relative error is above it take up to maxSamples samples, see
+	// adaptive.Stats, and the samples of each pixel are drawn into the heatmap if it isn't nil.
+	adaptiveThreshold float64
+	maxSamples        int
+	heatmap           *canvas2.Canvas
+
+	// aovs are the images of the AOVs to render along with the image, if any.
+	aovs map[ocl.AOV]*canvas2.Canvas
 }
 
-func NewCtx(id int, scene *scenes.Scene, canvas *canvas2.Canvas, samples int) *Ctx {
+func NewCtx(id int, scene *scenes.Scene, canvas *canvas2.Canvas, samples int, seed uint32, tracer *ocl.Tracer) *Ctx {
 	return &Ctx{
 		Id:      id,
 		scene:   scene,
 		canvas:  canvas,
-		camera:  scene.Camera,
 		samples: samples,
-		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		seed:    seed,
+		tracer:  tracer,
+
+		maxSamples: samples,
 	}
 }
 
-// for the OCL pathtracer, call this in the main thread and pre-calculate all rays.
-func (ctx *Ctx) renderPixelPathTracer(width, height int) {
+// for the OCL pathtracer, call this in the main thread and pre-calculate all rays. The image of the camera is written
+// to the canvas with its top left corner at offsetX, offsetY, so the eyes of stereo pairs can share a canvas.
+func (ctx *Ctx) renderPixelPathTracer(camera camera2.Camera, offsetX, offsetY int) {
 
-	sceneObjects, triangles, groups := ocl.BuildSceneBufferCL(ctx.scene.Objects)
+	sceneObjects, triangles, groups, instances, materials, patterns, err := ocl.BuildSceneBufferCL(ctx.scene.Objects)
+	if err != nil {
+		logrus.Fatalf("Failed to build the scene: %v", err)
+	}
+	if ctx.endObjects != nil {
+		ocl.AddMotion(sceneObjects, ctx.endObjects)
+	}
 
-	clCamera := ocl.CLCamera{
-		Width:       int32(ctx.camera.Width),
-		Height:      int32(ctx.camera.Height),
-		Fov:         ctx.camera.Fov,
-		PixelSize:   ctx.camera.PixelSize,
-		HalfWidth:   ctx.camera.HalfWidth,
-		HalfHeight:  ctx.camera.HalfHeight,
-		Aperture:    ctx.camera.Aperture,
-		FocalLength: ctx.camera.FocalLength,
-		//Transform:   ctx.camera.Transform,
-		Inverse: ctx.camera.Inverse,
-		Padding: [72]byte{},
+	// Render the scene, in passes until every pixel has converged if sampling adaptively. The samples of all passes are
+	// splatted into the film, while the stats only tell which pixels need more samples
+	film := canvas2.NewAccumulator(camera.Width, camera.Height)
+	stats := adaptive.NewStats(camera.Width, camera.Height, ctx.samples, ctx.maxSamples, ctx.adaptiveThreshold)
+	var aovs *ocl.AOVBuffer
+	if len(ctx.aovs) > 0 {
+		aovs = ocl.NewAOVBuffer(camera.Width, camera.Height)
+	}
+	for pass := 1; ; pass++ {
+		samples, firstSample := stats.NextPass()
+		if samples == nil {
+			break
+		}
+		if ctx.adaptiveThreshold > 0 {
+			logrus.Infof("Adaptive pass %d: sampling %d of %d pixels from sample %d on", pass, stats.Active(), len(samples), firstSample)
+		}
+		result := ctx.tracer.Trace(sceneObjects, triangles, groups, instances, materials, patterns, samples, firstSample, ctx.seed, clCamera(camera), film, aovs, ctx.scene.Textures, ctx.scene.SphereTextures, ctx.scene.CubeTextures)
+		stats.Add(samples, result)
+	}
+	if ctx.adaptiveThreshold > 0 {
+		logrus.Infof("Adaptive sampling took %.1f samples per pixel on average", stats.MeanSamples())
 	}
 
-	// Render the scene
-	result := ocl.Trace(sceneObjects, triangles, groups, cmd.Cfg.DeviceIndex, ctx.samples, clCamera, ctx.scene.Textures, ctx.scene.SphereTextures, ctx.scene.CubeTextures)
+	var heatmap *canvas2.Canvas
+	if ctx.heatmap != nil {
+		heatmap = stats.Heatmap()
+	}
+	for i := 0; i < camera.Width*camera.Height; i++ {
+		x := i % camera.Width
+		y := i / camera.Width
+		ctx.canvas.WritePixelMutex(offsetX+x, offsetY+y, film.ColorAt(x, y))
+		if heatmap != nil {
+			ctx.heatmap.WritePixelMutex(offsetX+x, offsetY+y, heatmap.Pixels[i])
+		}
+		for aov, image := range ctx.aovs {
+			image.WritePixelMutex(offsetX+x, offsetY+y, aovs.At(aov, i))
+		}
+	}
+}
 
-	// result now contains RGBA values for each pixel,
-	// write .raw file
-	rawData := raw.WriteRawImage(result, width, height)
-	if err := ioutil.WriteFile("experiment.raw", rawData, os.FileMode(0755)); err != nil {
-		logrus.WithError(err).Error("error writing .raw file to disk")
+func clCamera(camera camera2.Camera) ocl.CLCamera {
+	return ocl.CLCamera{
+		Width:         int32(camera.Width),
+		Height:        int32(camera.Height),
+		Fov:           camera.Fov,
+		PixelSize:     camera.PixelSize,
+		HalfWidth:     camera.HalfWidth,
+		HalfHeight:    camera.HalfHeight,
+		Aperture:      camera.Aperture,
+		FocusDistance: camera.FocusDistance,
+		//Transform:   camera.Transform,
+		Inverse:       camera.Inverse,
+		BladeRotation: camera.BladeRotation,
+		Blades:        int32(camera.Blades),
+		Projection:    int32(camera.Projection),
+		OrthoScale:    camera.OrthoScale,
+		EyeOffset:     camera.EyeOffset,
+		Padding:       [40]byte{},
 	}
+}
 
-	j := 0
-	for i := 0; i < len(result); i += 4 {
-		x := j % width
-		y := j / width
-		ctx.canvas.WritePixelMutex(x, y, geom.NewColor(result[i], result[i+1], result[i+2]))
-		j++
+// writeRawImage writes the canvas to the .raw file.
+func writeRawImage(canvas *canvas2.Canvas, filename string) {
+	data := make([]float64, 0, len(canvas.Pixels)*4)
+	for _, p := range canvas.Pixels {
+		data = append(data, p[0], p[1], p[2], 1.0)
+	}
+	rawData := raw.WriteRawImage(data, canvas.W, canvas.H)
+	if err := ioutil.WriteFile(filename, rawData, os.FileMode(0755)); err != nil {
+		logrus.WithError(err).Error("error writing .raw file to disk")
 	}
 }
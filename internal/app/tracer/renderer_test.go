@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"github.com/eriklupander/pathtracer-ocl/cmd"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/scenes"
+	"github.com/eriklupander/pathtracer-ocl/internal/ocl"
+	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
@@ -13,9 +16,32 @@ func TestPathTracer_Render(t *testing.T) {
 	cmd.Cfg.Width = 1
 	cmd.Cfg.Height = 1
 	canvas := canvas.NewCanvas(1, 1)
-	testee := NewCtx(1, scenes.OCLScene()(), canvas, 1)
+	scene := scenes.OCLScene()()
+	tracer := ocl.NewTracer(cmd.Cfg.DeviceIndex, cmd.Cfg.MaxDepth, cmd.Cfg.RRDepth, ocl.Sobol, ocl.PixelFilter{Filter: ocl.Box, Radius: 0.5}, false)
+	defer tracer.Release()
+	testee := NewCtx(1, scene, canvas, 1, 0, tracer)
 
-	testee.renderPixelPathTracer(1, 1)
+	testee.renderPixelPathTracer(scene.Camera, 0, 0)
+}
+
+// TestPathTracer_RenderIsReproducible renders 4x4 pixels, a single batch of 16 work-items. It only proves anything
+// about races between work-items when the device puts all 16 pixels in the same work group, i.e. when its work group
+// size is at least 16.
+func TestPathTracer_RenderIsReproducible(t *testing.T) {
+	cmd.FromConfig()
+	cmd.Cfg.Width = 4
+	cmd.Cfg.Height = 4
+	scene := scenes.OCLScene()()
+	tracer := ocl.NewTracer(cmd.Cfg.DeviceIndex, cmd.Cfg.MaxDepth, cmd.Cfg.RRDepth, ocl.Sobol, ocl.PixelFilter{Filter: ocl.Box, Radius: 0.5}, false)
+	defer tracer.Release()
+
+	render := func(seed uint32) []geom.Tuple4 {
+		canvas := canvas.NewCanvas(4, 4)
+		NewCtx(1, scene, canvas, 4, seed, tracer).renderPixelPathTracer(scene.Camera, 0, 0)
+		return canvas.Pixels
+	}
+	assert.Equal(t, render(42), render(42))
+	assert.NotEqual(t, render(42), render(43))
 }
 
 func Test_ConvertToHex(t *testing.T) {
@@ -0,0 +1,222 @@
+package ocl
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+)
+
+// AOV is an arbitrary output variable, an image of some property of the first hits of the samples of each pixel which
+// the kernel outputs next to the colors when built with AOVS, for denoisers and compositing.
+type AOV int
+
+const (
+	// Albedo is the color of the surface at the first hit, before any lighting. Pixels are black where samples miss.
+	Albedo AOV = iota
+	// Normal is the world space normal at the first hit, facing the camera.
+	Normal
+	// Depth is the distance from the camera to the first hit.
+	Depth
+	// Position is the world space position of the first hit.
+	Position
+	// ObjectID is the index of the first hit object into the objects of the scene, -1 where nothing is hit.
+	ObjectID
+	// MaterialID is the ID of the material of the first hit object, see materialID, -1 where nothing is hit.
+	MaterialID
+	// Direct is the light that reaches the camera straight from light sources or off a single surface.
+	Direct
+	// Indirect is the light that reaches the camera off two surfaces or more, so that Direct + Indirect is the image.
+	Indirect
+)
+
+// aovStride is the number of reals the kernel outputs per pixel with AOVS, see AOV_STRIDE. Each pixel holds, 4 reals
+// each:
+//   - the mean albedo of the samples, and the share of them that hit something
+//   - the mean normal of those that hit something, and their mean depth
+//   - their mean position
+//   - the object ID and the material ID of the first sample that hit something
+//   - the mean direct light of the samples
+//   - the mean indirect light of the samples
+const aovStride = 24
+
+var aovNames = []string{"albedo", "normal", "depth", "position", "object-id", "material-id", "direct", "indirect"}
+
+func (a AOV) String() string {
+	if a < 0 || int(a) >= len(aovNames) {
+		return fmt.Sprintf("AOV(%d)", int(a))
+	}
+	return aovNames[a]
+}
+
+// ParseAOVs returns the AOVs of the passed names, such as "albedo" and "normal", without duplicates.
+func ParseAOVs(names []string) ([]AOV, error) {
+	var aovs []AOV
+	seen := map[AOV]bool{}
+	for _, name := range names {
+		aov := AOV(-1)
+		for i, n := range aovNames {
+			if n == name {
+				aov = AOV(i)
+			}
+		}
+		if aov < 0 {
+			return nil, fmt.Errorf("unknown AOV %q, expected some of %v", name, aovNames)
+		}
+		if !seen[aov] {
+			seen[aov] = true
+			aovs = append(aovs, aov)
+		}
+	}
+	return aovs, nil
+}
+
+// AOVBuffer accumulates the AOVs of the pixels of an image over the passes of adaptive sampling. Albedo and light are
+// averaged over all samples of a pixel, normals, depth and positions over the samples that hit something, and the IDs
+// are those of the first pass that hit something.
+type AOVBuffer struct {
+	W, H int
+
+	samples, hits                              []float64
+	albedo, normal, position, direct, indirect []geom.Tuple4
+	depth                                      []float64
+	objectIDs, materialIDs                     []int
+}
+
+// NewAOVBuffer returns an empty AOV buffer of a width x height image.
+func NewAOVBuffer(width, height int) *AOVBuffer {
+	n := width * height
+	b := &AOVBuffer{
+		W:           width,
+		H:           height,
+		samples:     make([]float64, n),
+		hits:        make([]float64, n),
+		albedo:      make([]geom.Tuple4, n),
+		normal:      make([]geom.Tuple4, n),
+		position:    make([]geom.Tuple4, n),
+		direct:      make([]geom.Tuple4, n),
+		indirect:    make([]geom.Tuple4, n),
+		depth:       make([]float64, n),
+		objectIDs:   make([]int, n),
+		materialIDs: make([]int, n),
+	}
+	for i := range b.objectIDs {
+		b.objectIDs[i], b.materialIDs[i] = -1, -1
+	}
+	return b
+}
+
+// add adds the AOV output of a batch of rows of the kernel, starting at row rowOffset, to the buffer, where pixel i of
+// the image took samples[i] samples.
+func (b *AOVBuffer) add(samples []uint32, output []float64, rowOffset int) {
+	for j := 0; j*aovStride < len(output); j++ {
+		i := rowOffset*b.W + j
+		if i >= len(b.samples) {
+			return
+		}
+		n := float64(samples[i])
+		if n == 0 {
+			continue
+		}
+		aov := output[j*aovStride : (j+1)*aovStride]
+		hits := aov[3] * n
+		b.samples[i] += n
+		b.hits[i] += hits
+		b.albedo[i] = geom.Add(b.albedo[i], geom.NewColor(aov[0]*n, aov[1]*n, aov[2]*n))
+		b.normal[i] = geom.Add(b.normal[i], geom.NewVector(aov[4]*hits, aov[5]*hits, aov[6]*hits))
+		b.depth[i] += aov[7] * hits
+		b.position[i] = geom.Add(b.position[i], geom.NewVector(aov[8]*hits, aov[9]*hits, aov[10]*hits))
+		if b.objectIDs[i] == -1 && aov[12] >= 0 {
+			b.objectIDs[i], b.materialIDs[i] = int(aov[12]), int(aov[13])
+		}
+		b.direct[i] = geom.Add(b.direct[i], geom.NewColor(aov[16]*n, aov[17]*n, aov[18]*n))
+		b.indirect[i] = geom.Add(b.indirect[i], geom.NewColor(aov[20]*n, aov[21]*n, aov[22]*n))
+	}
+}
+
+// At returns the value of the AOV at pixel i. Depth and the IDs are returned in all three channels, and normals are
+// normalized.
+func (b *AOVBuffer) At(aov AOV, i int) geom.Tuple4 {
+	mean := func(sum geom.Tuple4, n float64) geom.Tuple4 {
+		if n == 0 {
+			return geom.NewColor(0, 0, 0)
+		}
+		return geom.NewColor(sum[0]/n, sum[1]/n, sum[2]/n)
+	}
+	scalar := func(v float64) geom.Tuple4 {
+		return geom.NewColor(v, v, v)
+	}
+	switch aov {
+	case Albedo:
+		return mean(b.albedo[i], b.samples[i])
+	case Normal:
+		n := b.normal[i]
+		return mean(n, math.Sqrt(n[0]*n[0]+n[1]*n[1]+n[2]*n[2]))
+	case Depth:
+		if b.hits[i] == 0 {
+			return scalar(0)
+		}
+		return scalar(b.depth[i] / b.hits[i])
+	case Position:
+		return mean(b.position[i], b.hits[i])
+	case ObjectID:
+		return scalar(float64(b.objectIDs[i]))
+	case MaterialID:
+		return scalar(float64(b.materialIDs[i]))
+	case Direct:
+		return mean(b.direct[i], b.samples[i])
+	case Indirect:
+		return mean(b.indirect[i], b.samples[i])
+	default:
+		return geom.NewColor(0, 0, 0)
+	}
+}
+
+// Preview returns a viewable version of an image of the AOV: normals are mapped from [-1, 1] to [0, 1], depth and
+// positions are scaled from the smallest to the largest value of each channel to [0, 1], and each ID gets a color of
+// its own, black for -1. The others are left as they are.
+func (a AOV) Preview(image *canvas.Canvas) *canvas.Canvas {
+	preview := canvas.NewCanvas(image.W, image.H)
+	switch a {
+	case Normal:
+		for i, n := range image.Pixels {
+			preview.Pixels[i] = geom.NewColor(n[0]*0.5+0.5, n[1]*0.5+0.5, n[2]*0.5+0.5)
+		}
+	case Depth, Position:
+		min, max := [3]float64{math.Inf(1), math.Inf(1), math.Inf(1)}, [3]float64{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+		for _, p := range image.Pixels {
+			for c := 0; c < 3; c++ {
+				min[c], max[c] = math.Min(min[c], p[c]), math.Max(max[c], p[c])
+			}
+		}
+		for i, p := range image.Pixels {
+			var scaled [3]float64
+			for c := 0; c < 3; c++ {
+				if max[c] > min[c] {
+					scaled[c] = (p[c] - min[c]) / (max[c] - min[c])
+				}
+			}
+			preview.Pixels[i] = geom.NewColor(scaled[0], scaled[1], scaled[2])
+		}
+	case ObjectID, MaterialID:
+		for i, p := range image.Pixels {
+			preview.Pixels[i] = idColor(int(p[0]))
+		}
+	default:
+		copy(preview.Pixels, image.Pixels)
+	}
+	return preview
+}
+
+// idColor returns a color for the ID that differs from those of the IDs next to it, black for -1.
+func idColor(id int) geom.Tuple4 {
+	if id < 0 {
+		return geom.NewColor(0, 0, 0)
+	}
+	h := uint32(id+1) * 2654435761
+	h ^= h >> 15
+	h *= 2246822519
+	h ^= h >> 13
+	return geom.NewColor(0.2+0.8*float64(h&0xff)/255, 0.2+0.8*float64(h>>8&0xff)/255, 0.2+0.8*float64(h>>16&0xff)/255)
+}
@@ -0,0 +1,96 @@
+package ocl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAOVs(t *testing.T) {
+	aovs, err := ParseAOVs([]string{"albedo", "normal", "depth", "albedo"})
+	assert.NoError(t, err)
+	assert.Equal(t, []AOV{Albedo, Normal, Depth}, aovs)
+
+	all := []AOV{Albedo, Normal, Depth, Position, ObjectID, MaterialID, Direct, Indirect}
+	names := make([]string, len(all))
+	for i, aov := range all {
+		names[i] = aov.String()
+	}
+	aovs, err = ParseAOVs(names)
+	assert.NoError(t, err)
+	assert.Equal(t, all, aovs)
+
+	_, err = ParseAOVs([]string{"albedo", "motion"})
+	assert.Error(t, err)
+}
+
+func TestAOVStrideMatchesKernel(t *testing.T) {
+	assert.True(t, strings.Contains(kernelSource, "#define AOV_STRIDE 24"))
+	assert.Equal(t, 24, aovStride)
+}
+
+// aovOutput fakes the AOV output of the kernel for a pixel.
+func aovOutput(albedo, coverage, depth float64, normal [3]float64, objectID, materialID, direct, indirect float64) []float64 {
+	out := make([]float64, aovStride)
+	out[0], out[1], out[2], out[3] = albedo, albedo, albedo, coverage
+	out[4], out[5], out[6], out[7] = normal[0], normal[1], normal[2], depth
+	out[8], out[9], out[10] = depth, 0, -depth
+	out[12], out[13] = objectID, materialID
+	out[16], out[17], out[18] = direct, direct, direct
+	out[20], out[21], out[22] = indirect, indirect, indirect
+	return out
+}
+
+func TestAOVBuffer_Add(t *testing.T) {
+	buffer := NewAOVBuffer(2, 2)
+
+	// the first row is traced in one batch, where the first pixel misses everything with half of its 4 samples, and
+	// the second pixel takes no samples
+	samples := []uint32{4, 0, 0, 0}
+	buffer.add(samples, append(aovOutput(0.4, 0.5, 2, [3]float64{0, 1, 0}, 3, 1, 0.2, 0.1), make([]float64, aovStride)...), 0)
+	// a second pass adds 4 samples to the first pixel that all hit another object further away
+	buffer.add(samples, append(aovOutput(0.8, 1, 5, [3]float64{1, 0, 0}, 5, 2, 0.4, 0.3), make([]float64, aovStride)...), 0)
+	// the second row, in a batch of its own
+	samples = []uint32{0, 0, 2, 2}
+	buffer.add(samples, append(aovOutput(0, 0, 0, [3]float64{}, -1, -1, 0, 0), aovOutput(1, 1, 3, [3]float64{0, 0, 1}, 0, 0, 1, 0)...), 1)
+
+	assert.InDelta(t, 0.6, buffer.At(Albedo, 0)[1], 1e-9)
+	assert.InDelta(t, 4.0, buffer.At(Depth, 0)[0], 1e-9, "the 2 hits at 2 and the 4 at 5")
+	assert.InDelta(t, 4.0, buffer.At(Position, 0)[0], 1e-9)
+	normal := buffer.At(Normal, 0)
+	assert.InDelta(t, 2/2.236068, normal[0], 1e-6)
+	assert.InDelta(t, 1/2.236068, normal[1], 1e-6)
+	assert.Equal(t, geom.NewColor(3, 3, 3), buffer.At(ObjectID, 0), "the first pass that hit something")
+	assert.Equal(t, geom.NewColor(1, 1, 1), buffer.At(MaterialID, 0))
+	assert.InDelta(t, 0.3, buffer.At(Direct, 0)[0], 1e-9)
+	assert.InDelta(t, 0.2, buffer.At(Indirect, 0)[0], 1e-9)
+
+	for _, aov := range []AOV{Albedo, Normal, Depth, Position, Direct, Indirect} {
+		assert.Equal(t, geom.NewColor(0, 0, 0), buffer.At(aov, 1), "%v of a pixel without samples", aov)
+		assert.Equal(t, geom.NewColor(0, 0, 0), buffer.At(aov, 2), "%v of a pixel that missed", aov)
+	}
+	assert.Equal(t, geom.NewColor(-1, -1, -1), buffer.At(ObjectID, 2))
+	assert.Equal(t, geom.NewColor(-1, -1, -1), buffer.At(MaterialID, 2))
+	assert.Equal(t, geom.NewColor(0, 0, 0), buffer.At(ObjectID, 3))
+	assert.Equal(t, geom.NewColor(3, 3, 3), buffer.At(Depth, 3))
+}
+
+func TestAOV_Preview(t *testing.T) {
+	image := canvas.NewCanvas(3, 1)
+	image.Pixels[0] = geom.NewColor(-1, 0, 1)
+	image.Pixels[1] = geom.NewColor(1, 2, 3)
+	image.Pixels[2] = geom.NewColor(3, 2, 1)
+
+	assert.Equal(t, geom.NewColor(0, 0.5, 1), Normal.Preview(image).Pixels[0])
+	assert.Equal(t, geom.NewColor(0.5, 1, 1), Position.Preview(image).Pixels[1])
+	assert.Equal(t, geom.NewColor(1, 1, 0), Position.Preview(image).Pixels[2])
+	assert.Equal(t, image.Pixels, Albedo.Preview(image).Pixels)
+
+	ids := ObjectID.Preview(image)
+	assert.Equal(t, geom.NewColor(0, 0, 0), ids.Pixels[0])
+	assert.NotEqual(t, ids.Pixels[1], ids.Pixels[2])
+	assert.Equal(t, ids.Pixels[1], idColor(1))
+}
@@ -0,0 +1,133 @@
+package ocl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+)
+
+// Filter selects the reconstruction filter that weighs the samples of the pixels around each pixel. It is passed to the
+// kernel as FILTER when building it, see the filters in tracer.cl.
+type Filter int
+
+const (
+	// Box averages the samples within the radius, which is the plain average of the samples of the pixel for the
+	// default radius of half a pixel.
+	Box Filter = iota
+	// Tent weighs samples by their distance to the pixel, falling linearly to 0 at the radius.
+	Tent
+	// Gaussian weighs samples by a Gaussian with the radius at three standard deviations, a little soft.
+	Gaussian
+	// Mitchell is the Mitchell-Netravali filter with B = C = 1/3, whose negative lobes keep edges sharp.
+	Mitchell
+	// BlackmanHarris is the 4-term Blackman-Harris window, close to a Gaussian but sharper, and without ringing.
+	BlackmanHarris
+)
+
+// MaxFilterRadius is the largest radius of filters, in pixels.
+const MaxFilterRadius = 3.0
+
+var filterNames = []string{"box", "tent", "gaussian", "mitchell", "blackman-harris"}
+
+func (f Filter) String() string {
+	if f < 0 || int(f) >= len(filterNames) {
+		return fmt.Sprintf("Filter(%d)", int(f))
+	}
+	return filterNames[f]
+}
+
+// ParseFilter returns the filter of the passed name, such as "mitchell".
+func ParseFilter(name string) (Filter, error) {
+	for i, n := range filterNames {
+		if n == name {
+			return Filter(i), nil
+		}
+	}
+	return Box, fmt.Errorf("unknown filter %q, expected one of %v", name, filterNames)
+}
+
+// DefaultRadius returns the radius of the filter, in pixels, unless another one is given.
+func (f Filter) DefaultRadius() float64 {
+	switch f {
+	case Tent:
+		return 1
+	case Gaussian:
+		return 1.5
+	case Mitchell, BlackmanHarris:
+		return 2
+	default:
+		return 0.5
+	}
+}
+
+// PixelFilter is a reconstruction filter and its radius in pixels.
+type PixelFilter struct {
+	Filter Filter
+	Radius float64
+}
+
+// extent returns how many pixels the filter reaches from the pixel of a sample in each direction, given that samples
+// are within half a pixel of the centre of their pixel.
+func (p PixelFilter) extent() int {
+	return int(math.Ceil(p.Radius - 0.5))
+}
+
+// buildOptions returns the defines of the filter for building the kernel.
+func (p PixelFilter) buildOptions() string {
+	return fmt.Sprintf("-D FILTER=%d -D FILTER_RADIUS=%s -D FILTER_EXTENT=%d", p.Filter, strconv.FormatFloat(p.Radius, 'f', -1, 64), p.extent())
+}
+
+// outputStride returns the number of reals the kernel outputs per pixel with the filter, see OUTPUT_STRIDE.
+func (p PixelFilter) outputStride() int {
+	width := 2*p.extent() + 1
+	return 4 + 4*width*width
+}
+
+// weight returns the weight of the filter at the distance x from the centre of a pixel. It is the reference
+// implementation of filter1D in tracer.cl.
+func (p PixelFilter) weight(x float64) float64 {
+	r := p.Radius
+	x = math.Abs(x)
+	if x > r {
+		return 0
+	}
+	switch p.Filter {
+	case Tent:
+		return r - x
+	case Gaussian:
+		alpha := 4.5 / (r * r)
+		return math.Exp(-alpha*x*x) - math.Exp(-alpha*r*r)
+	case Mitchell:
+		t := 2 * x / r
+		if t < 1 {
+			return (7*t*t*t - 12*t*t + 16.0/3) / 6
+		}
+		return (-7.0/3*t*t*t + 12*t*t - 20*t + 32.0/3) / 6
+	case BlackmanHarris:
+		t := 2 * math.Pi * (0.5 + 0.5*x/r)
+		return 0.35875 - 0.48829*math.Cos(t) + 0.14128*math.Cos(2*t) - 0.01168*math.Cos(3*t)
+	default:
+		return 1
+	}
+}
+
+// splat adds the splats of a batch of rows of the kernel output, starting at row rowOffset, to the film. Each pixel of
+// the output holds the splats of its samples into the pixels around it after its mean color, see OUTPUT_STRIDE, and
+// the film drops those outside the image.
+func (p PixelFilter) splat(film *canvas.Accumulator, output []float64, rowOffset int) {
+	extent, stride := p.extent(), p.outputStride()
+	width := 2*extent + 1
+	for i := 0; i*stride < len(output); i++ {
+		x, y := i%film.W, rowOffset+i/film.W
+		if y >= film.H {
+			return
+		}
+		pixel := output[i*stride+4 : (i+1)*stride]
+		for k := 0; k < width*width; k++ {
+			film.Splat(x+k%width-extent, y+k/width-extent, geom.NewColor(pixel[k*4], pixel[k*4+1], pixel[k*4+2]), pixel[k*4+3])
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package ocl
+
+import (
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	for _, filter := range []Filter{Box, Tent, Gaussian, Mitchell, BlackmanHarris} {
+		parsed, err := ParseFilter(filter.String())
+		assert.NoError(t, err)
+		assert.Equal(t, filter, parsed)
+	}
+	_, err := ParseFilter("lanczos")
+	assert.Error(t, err)
+}
+
+func TestPixelFilter_Extent(t *testing.T) {
+	assert.Equal(t, 0, PixelFilter{Filter: Box, Radius: 0.5}.extent())
+	assert.Equal(t, 1, PixelFilter{Filter: Tent, Radius: 1}.extent())
+	assert.Equal(t, 1, PixelFilter{Filter: Gaussian, Radius: 1.5}.extent())
+	assert.Equal(t, 2, PixelFilter{Filter: Mitchell, Radius: 2}.extent())
+	assert.Equal(t, 3, PixelFilter{Filter: BlackmanHarris, Radius: MaxFilterRadius}.extent())
+	assert.Equal(t, 4+4*25, PixelFilter{Filter: Mitchell, Radius: 2}.outputStride())
+}
+
+func TestPixelFilter_Weight(t *testing.T) {
+	for _, filter := range []Filter{Box, Tent, Gaussian, Mitchell, BlackmanHarris} {
+		f := PixelFilter{Filter: filter, Radius: filter.DefaultRadius()}
+		assert.Equal(t, 0.0, f.weight(f.Radius+0.01), "%v", filter)
+		assert.Equal(t, f.weight(0.3), f.weight(-0.3), "%v", filter)
+		if filter != Box {
+			assert.Greater(t, f.weight(0), f.weight(0.4), "%v", filter)
+			assert.InDelta(t, 0.0, f.weight(f.Radius), 1e-4, "%v", filter)
+		}
+	}
+	assert.Equal(t, 1.0, PixelFilter{Filter: Box, Radius: 0.5}.weight(0.5))
+	assert.Equal(t, 0.75, PixelFilter{Filter: Tent, Radius: 1}.weight(0.25))
+	assert.InDelta(t, 1.0, PixelFilter{Filter: BlackmanHarris, Radius: 2}.weight(0), 1e-9)
+
+	// Mitchell-Netravali is continuous where its pieces meet, and negative beyond
+	mitchell := PixelFilter{Filter: Mitchell, Radius: 2}
+	assert.InDelta(t, mitchell.weight(0.999999), mitchell.weight(1.000001), 1e-5)
+	assert.Less(t, mitchell.weight(1.5), 0.0)
+}
+
+// The weights of tent and Mitchell-Netravali filters of their default radius for the pixels around a sample add up to
+// the same anywhere in a pixel, so that they don't add a pattern to flat areas.
+func TestPixelFilter_WeightsAddUpEverywhere(t *testing.T) {
+	for _, filter := range []Filter{Tent, Mitchell} {
+		f := PixelFilter{Filter: filter, Radius: filter.DefaultRadius()}
+		for _, x := range []float64{0, 0.1, 0.25, 0.5, 0.7, 0.99} {
+			sum := 0.0
+			for dx := -3; dx <= 3; dx++ {
+				sum += f.weight(float64(dx) + 0.5 - x)
+			}
+			assert.InDelta(t, 1.0, sum, 1e-9, "%v at %v", filter, x)
+		}
+	}
+}
+
+func TestPixelFilter_Splat(t *testing.T) {
+	// a tent filter reaching one pixel around, on a 3x2 image rendered in batches of a row
+	f := PixelFilter{Filter: Tent, Radius: 1}
+	film := canvas.NewAccumulator(3, 2)
+	stride := f.outputStride()
+	output := make([]float64, 3*stride)
+
+	// the samples of pixel 0, 0 splat into itself and its neighbour to the right, those of pixel 2, 0 into itself
+	// and the pixel below it
+	output[4+4*4], output[4+4*4+3] = 0.5, 1
+	output[4+4*5], output[4+4*5+3] = 0.25, 0.5
+	output[2*stride+4+4*4+1], output[2*stride+4+4*4+3] = 1, 1
+	output[2*stride+4+4*7+1], output[2*stride+4+4*7+3] = 0.5, 1
+	// splats outside the image are dropped
+	output[4] = 100
+	output[7] = 1
+
+	f.splat(film, output, 0)
+	f.splat(film, make([]float64, 3*stride), 1)
+
+	assert.Equal(t, geom.NewColor(0.5, 0, 0), film.ColorAt(0, 0))
+	assert.Equal(t, geom.NewColor(0.5, 0, 0), film.ColorAt(1, 0))
+	assert.Equal(t, geom.NewColor(0, 1, 0), film.ColorAt(2, 0))
+	assert.Equal(t, geom.NewColor(0, 0.5, 0), film.ColorAt(2, 1))
+	assert.Equal(t, geom.NewColor(0, 0, 0), film.ColorAt(0, 1))
+}
@@ -0,0 +1,165 @@
+package ocl
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// clField is a field of a packed struct of tracer.cl with its offset and size in bytes.
+type clField struct {
+	Name   string
+	Type   string
+	Offset int
+	Size   int
+}
+
+// clStruct is a packed struct of tracer.cl, see parseCLStructs.
+type clStruct struct {
+	Name   string
+	Fields []clField
+	Size   int
+}
+
+// structPairs maps the packed structs of tracer.cl to the Go structs uploaded to them, for the double and the single
+// precision build of the kernel respectively.
+var structPairs = []struct {
+	clName string
+	double reflect.Type
+	single reflect.Type
+}{
+	{"camera", reflect.TypeOf(CLCamera{}), reflect.TypeOf(CLCamera32{})},
+	{"group", reflect.TypeOf(CLGroup{}), reflect.TypeOf(CLGroup32{})},
+	{"instance", reflect.TypeOf(CLInstance{}), reflect.TypeOf(CLInstance32{})},
+	{"object", reflect.TypeOf(CLObject{}), reflect.TypeOf(CLObject32{})},
+	{"pattern", reflect.TypeOf(CLPattern{}), reflect.TypeOf(CLPattern32{})},
+	{"triangle", reflect.TypeOf(CLTriangle{}), reflect.TypeOf(CLTriangle32{})},
+	{"material", reflect.TypeOf(CLMaterial{}), reflect.TypeOf(CLMaterial32{})},
+}
+
+var (
+	packedStructRe = regexp.MustCompile(`(?s)typedef struct __attribute__\(\(packed\)\) \w+ \{(.*?)\} (\w+);`)
+	clFieldRe      = regexp.MustCompile(`^((?:unsigned )?\w+) (\w+)(?:\[(\d+)\])?;$`)
+	lineCommentRe  = regexp.MustCompile(`//.*`)
+)
+
+// clTypeSize returns the size in bytes of the OpenCL scalar and vector types used in the packed structs. real is a
+// double unless the kernel is built with USE_FLOAT.
+func clTypeSize(typ string, useFloat bool) (int, bool) {
+	realSize := 8
+	if useFloat {
+		realSize = 4
+	}
+	switch typ {
+	case "bool", "char", "unsigned char":
+		return 1, true
+	case "int", "unsigned int", "float":
+		return 4, true
+	case "long":
+		return 8, true
+	case "real":
+		return realSize, true
+	case "real2":
+		return 2 * realSize, true
+	case "real4":
+		return 4 * realSize, true
+	case "real16":
+		return 16 * realSize, true
+	}
+	return 0, false
+}
+
+// parseCLStructs computes the field offsets of all packed structs of the kernel source, keyed by their typedef
+// name. Only one field per line is supported, which is how tracer.cl declares them anyway.
+func parseCLStructs(source string, useFloat bool) (map[string]clStruct, error) {
+	structs := make(map[string]clStruct)
+	for _, match := range packedStructRe.FindAllStringSubmatch(source, -1) {
+		s := clStruct{Name: match[2]}
+		for _, line := range strings.Split(match[1], "\n") {
+			line = strings.Join(strings.Fields(lineCommentRe.ReplaceAllString(line, "")), " ")
+			if line == "" {
+				continue
+			}
+			parts := clFieldRe.FindStringSubmatch(line)
+			if parts == nil {
+				return nil, fmt.Errorf("struct %s: cannot parse field %q", s.Name, line)
+			}
+			size, ok := clTypeSize(parts[1], useFloat)
+			if !ok {
+				return nil, fmt.Errorf("struct %s: unknown type %s of field %s", s.Name, parts[1], parts[2])
+			}
+			if parts[3] != "" {
+				n, _ := strconv.Atoi(parts[3])
+				size *= n
+			}
+			s.Fields = append(s.Fields, clField{Name: parts[2], Type: parts[1], Offset: s.Size, Size: size})
+			s.Size += size
+		}
+		structs[s.Name] = s
+	}
+	return structs, nil
+}
+
+// isFloatType tells whether the element type of a Go field is a floating point type, which must correspond to
+// real or float fields of the kernel structs and never to integers.
+func isFloatType(t reflect.Type) bool {
+	for t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+}
+
+// compareLayout returns a description of each difference between the Go struct and its kernel counterpart. Field
+// names are compared case-insensitively, as the Go fields are exported.
+func compareLayout(goType reflect.Type, cl clStruct) []string {
+	var diffs []string
+	if int(goType.Size()) != cl.Size {
+		diffs = append(diffs, fmt.Sprintf("%s is %d bytes but %s is %d bytes", goType.Name(), goType.Size(), cl.Name, cl.Size))
+	}
+	if goType.NumField() != len(cl.Fields) {
+		diffs = append(diffs, fmt.Sprintf("%s has %d fields but %s has %d", goType.Name(), goType.NumField(), cl.Name, len(cl.Fields)))
+	}
+	for i := 0; i < goType.NumField() && i < len(cl.Fields); i++ {
+		goField, clField := goType.Field(i), cl.Fields[i]
+		name := goType.Name() + "." + goField.Name
+		switch {
+		case !strings.EqualFold(goField.Name, clField.Name):
+			diffs = append(diffs, fmt.Sprintf("%s does not match %s.%s", name, cl.Name, clField.Name))
+		case int(goField.Offset) != clField.Offset:
+			diffs = append(diffs, fmt.Sprintf("%s is at offset %d but %s.%s is at %d", name, goField.Offset, cl.Name, clField.Name, clField.Offset))
+		case int(goField.Type.Size()) != clField.Size:
+			diffs = append(diffs, fmt.Sprintf("%s is %d bytes but %s.%s is %d bytes", name, goField.Type.Size(), cl.Name, clField.Name, clField.Size))
+		case isFloatType(goField.Type) != (strings.HasPrefix(clField.Type, "real") || clField.Type == "float"):
+			diffs = append(diffs, fmt.Sprintf("%s is a %v but %s.%s is a %s", name, goField.Type, cl.Name, clField.Name, clField.Type))
+		}
+	}
+	return diffs
+}
+
+// checkLayouts verifies that the Go structs uploaded to the kernel have the same size and field offsets as the packed
+// structs of the kernel source, for the double or the single precision build of the kernel.
+func checkLayouts(source string, useFloat bool) error {
+	structs, err := parseCLStructs(source, useFloat)
+	if err != nil {
+		return err
+	}
+	var diffs []string
+	for _, pair := range structPairs {
+		cl, ok := structs[pair.clName]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("struct %s not found in kernel source", pair.clName))
+			continue
+		}
+		goType := pair.double
+		if useFloat {
+			goType = pair.single
+		}
+		diffs = append(diffs, compareLayout(goType, cl)...)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("struct layouts differ from the kernel:\n%s", strings.Join(diffs, "\n"))
+	}
+	return nil
+}
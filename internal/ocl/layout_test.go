@@ -0,0 +1,76 @@
+package ocl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLayouts(t *testing.T) {
+	assert.NoError(t, checkLayouts(kernelSource, false))
+	assert.NoError(t, checkLayouts(kernelSource, true))
+}
+
+func TestParseCLStructs(t *testing.T) {
+	structs, err := parseCLStructs(kernelSource, false)
+	assert.NoError(t, err)
+	for _, pair := range structPairs {
+		assert.Contains(t, structs, pair.clName)
+	}
+	// tag_ray is not packed and never uploaded as a buffer
+	assert.NotContains(t, structs, "ray")
+
+	cam := structs["camera"]
+	assert.Equal(t, 256, cam.Size)
+	assert.Equal(t, clField{Name: "inverse", Type: "real16", Offset: 56, Size: 128}, cam.Fields[8])
+	assert.Equal(t, clField{Name: "padding", Type: "char", Offset: 216, Size: 40}, cam.Fields[14])
+
+	structs, err = parseCLStructs(kernelSource, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 156, structs["camera"].Size)
+	assert.Equal(t, 764, structs["object"].Size)
+}
+
+func TestParseCLStructsUnknownType(t *testing.T) {
+	source := `typedef struct __attribute__((packed)) tag_thing {
+    real4 a;   // 32 bytes
+    half b;    // unsupported
+} thing;`
+	_, err := parseCLStructs(source, false)
+	assert.EqualError(t, err, "struct thing: unknown type half of field b")
+}
+
+func TestCheckLayoutsDetectsDrift(t *testing.T) {
+	// growing the padding of the kernel camera shifts its size but no offsets
+	source := strings.Replace(kernelSource, "char padding[40];", "char padding[48];", 1)
+	err := checkLayouts(source, false)
+	assert.EqualError(t, err, "struct layouts differ from the kernel:\n"+
+		"CLCamera is 256 bytes but camera is 264 bytes\n"+
+		"CLCamera.Padding is 40 bytes but camera.padding is 48 bytes")
+
+	// a real field turned into an int moves all following fields of the double build
+	source = strings.Replace(kernelSource, "real pixelSize;", "int pixelSize;", 1)
+	err = checkLayouts(source, false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "CLCamera.PixelSize is 8 bytes but camera.pixelSize is 4 bytes")
+	assert.Contains(t, err.Error(), "CLCamera.HalfWidth is at offset 24 but camera.halfWidth is at 20")
+
+	// in the single precision build the sizes match, but not the kind of number
+	err = checkLayouts(source, true)
+	assert.EqualError(t, err, "struct layouts differ from the kernel:\n"+
+		"CLCamera32.PixelSize is a float32 but camera.pixelSize is a int")
+}
+
+func TestCompareLayoutFieldNames(t *testing.T) {
+	type renamed struct {
+		Min [4]float64
+		Max [4]float64
+	}
+	cl := clStruct{Name: "box", Size: 64, Fields: []clField{
+		{Name: "min", Type: "real4", Offset: 0, Size: 32},
+		{Name: "extent", Type: "real4", Offset: 32, Size: 32},
+	}}
+	assert.Equal(t, []string{"renamed.Max does not match box.extent"}, compareLayout(reflect.TypeOf(renamed{}), cl))
+}
@@ -0,0 +1,133 @@
+package ocl
+
+import (
+	"math"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/sirupsen/logrus"
+)
+
+// objectMotion is how an object moves in world space while the shutter is open, as a screw motion: a rotation around
+// the axis through Pivot by Rotation[3] radians combined with a translation along that axis, after scaling by a
+// symmetric matrix. Unlike interpolating the translation, the screw motion keeps objects spinning around an axis away
+// from their origin on their circle, such as the spokes of a wheel.
+type objectMotion struct {
+	Translation geom.Tuple4 // translation along the axis at shutter close, w == 0
+	Rotation    geom.Tuple4 // unit axis, with the angle at shutter close in w
+	Pivot       geom.Tuple4 // a point of the axis, w == 0
+	Scale       geom.Tuple4 // xx, yy and zz of the scale matrix at shutter close, w == 1
+	ScaleShear  geom.Tuple4 // xy, xz and yz of the scale matrix at shutter close, w == 0
+}
+
+// motionOf returns the motion of an object from the transform start at shutter open to end at shutter close.
+// Rotations are taken the short way, so an object may turn at most half a turn while the shutter is open.
+func motionOf(start, end geom.Mat4x4) objectMotion {
+	// delta moves the object from where it is at shutter open to where it is at shutter close
+	delta := geom.Multiply(end, geom.Inverse(start))
+	offset := geom.NewVector(delta[3], delta[7], delta[11])
+
+	// polar decomposition of the upper 3x3 part into a rotation times a symmetric scale matrix
+	linear := delta
+	linear[3], linear[7], linear[11] = 0, 0, 0
+	rotation := linear
+	for i := 0; i < 32; i++ {
+		inverseTranspose := geom.Transpose(geom.Inverse(rotation))
+		for j := range rotation {
+			rotation[j] = 0.5 * (rotation[j] + inverseTranspose[j])
+		}
+	}
+	scale := geom.Multiply(geom.Transpose(rotation), linear)
+
+	axis, angle := axisAngle(rotation)
+	motion := objectMotion{
+		Translation: offset,
+		Rotation:    geom.NewTupleOf(axis[0], axis[1], axis[2], angle),
+		Scale:       geom.NewTupleOf(scale[0], scale[5], scale[10], 1),
+		ScaleShear:  geom.NewVector(0.5*(scale[1]+scale[4]), 0.5*(scale[2]+scale[8]), 0.5*(scale[6]+scale[9])),
+	}
+	if angle > 1e-9 {
+		// the pivot is the point of the plane through the origin perpendicular to the axis that only moves along
+		// the axis, which leaves the translation along the axis
+		perpendicular := geom.Sub(offset, geom.MultiplyByScalar(axis, geom.Dot(axis, offset)))
+		motion.Pivot = geom.MultiplyByScalar(geom.Add(perpendicular, geom.MultiplyByScalar(geom.Cross(axis, perpendicular), 1/math.Tan(angle/2))), 0.5)
+		motion.Translation = geom.Add(geom.Sub(offset, motion.Pivot), geom.MultiplyByTuple(rotation, motion.Pivot))
+	}
+	return motion
+}
+
+// axisAngle returns the unit axis and the angle in [0, π] of the rotation matrix, by way of its quaternion.
+func axisAngle(m geom.Mat4x4) (geom.Tuple4, float64) {
+	var w, x, y, z float64
+	trace := m[0] + m[5] + m[10]
+	switch {
+	case trace > 0:
+		s := math.Sqrt(trace+1) * 2
+		w, x, y, z = 0.25*s, (m[9]-m[6])/s, (m[2]-m[8])/s, (m[4]-m[1])/s
+	case m[0] > m[5] && m[0] > m[10]:
+		s := math.Sqrt(1+m[0]-m[5]-m[10]) * 2
+		w, x, y, z = (m[9]-m[6])/s, 0.25*s, (m[1]+m[4])/s, (m[2]+m[8])/s
+	case m[5] > m[10]:
+		s := math.Sqrt(1+m[5]-m[0]-m[10]) * 2
+		w, x, y, z = (m[2]-m[8])/s, (m[1]+m[4])/s, 0.25*s, (m[6]+m[9])/s
+	default:
+		s := math.Sqrt(1+m[10]-m[0]-m[5]) * 2
+		w, x, y, z = (m[4]-m[1])/s, (m[2]+m[8])/s, (m[6]+m[9])/s, 0.25*s
+	}
+	if w < 0 {
+		w, x, y, z = -w, -x, -y, -z
+	}
+	sin := math.Sqrt(x*x + y*y + z*z)
+	if sin < 1e-12 {
+		return geom.NewVector(0, 1, 0), 0
+	}
+	return geom.NewVector(x/sin, y/sin, z/sin), 2 * math.Atan2(sin, w)
+}
+
+// undo maps p, a point or a vector in world space, to where it was at shutter open relative to the object at time s of
+// the shutter interval, 0 being shutter open and 1 shutter close. Multiplied by the inverse transform of the object at
+// shutter open, this gives p in the space of the object at time s. It is the reference implementation of undoMotion
+// in tracer.cl.
+func (m objectMotion) undo(p geom.Tuple4, s float64) geom.Tuple4 {
+	w := p[3]
+	v := geom.Sub(p, geom.MultiplyByScalar(geom.Add(m.Pivot, geom.MultiplyByScalar(m.Translation, s)), w))
+	v[3] = 0
+	v = geom.Add(rotateAroundAxis(v, m.Rotation, -m.Rotation[3]*s), geom.MultiplyByScalar(m.Pivot, w))
+
+	// the scale matrix at time s, from the identity at shutter open
+	scale := geom.NewIdentityMatrix()
+	scale[0], scale[5], scale[10] = 1+(m.Scale[0]-1)*s, 1+(m.Scale[1]-1)*s, 1+(m.Scale[2]-1)*s
+	scale[1], scale[2], scale[6] = m.ScaleShear[0]*s, m.ScaleShear[1]*s, m.ScaleShear[2]*s
+	scale[4], scale[8], scale[9] = scale[1], scale[2], scale[6]
+	v = geom.MultiplyByTuple(geom.Inverse(scale), v)
+	v[3] = w
+	return v
+}
+
+// rotateAroundAxis rotates the vector v around the unit axis by the angle, using Rodrigues' rotation formula.
+func rotateAroundAxis(v, axis geom.Tuple4, angle float64) geom.Tuple4 {
+	a := geom.NewVector(axis[0], axis[1], axis[2])
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	return geom.Add(geom.Add(geom.MultiplyByScalar(v, cos), geom.MultiplyByScalar(geom.Cross(a, v), sin)), geom.MultiplyByScalar(a, geom.Dot(a, v)*(1-cos)))
+}
+
+// AddMotion sets up motion blur for the objects built from the scene at shutter open, given the objects built from the
+// same scene at shutter close. Objects whose transform differs between the two move while the shutter is open, see
+// objectMotion, while all others stay sharp. The triangles of meshes are moved with the group object they belong to,
+// so the subgroups of a moving mesh can't move on their own.
+func AddMotion(objects, end []CLObject) {
+	if len(objects) != len(end) {
+		logrus.Fatalf("motion blur needs the same objects at shutter open and close, got %d and %d", len(objects), len(end))
+	}
+	for i := range objects {
+		if objects[i].Transform == end[i].Transform {
+			continue
+		}
+		motion := motionOf(objects[i].Transform, end[i].Transform)
+		objects[i].HasMotion = true
+		objects[i].MotionTranslation = motion.Translation
+		objects[i].MotionRotation = motion.Rotation
+		objects[i].MotionPivot = motion.Pivot
+		objects[i].MotionScale = motion.Scale
+		objects[i].MotionScaleShear = motion.ScaleShear
+	}
+}
@@ -0,0 +1,65 @@
+package ocl
+
+import (
+	"math"
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMotionOfMatchesShutterOpenAndClose(t *testing.T) {
+	start := geom.Multiply(geom.Multiply(geom.Translate(1, 2, 3), geom.RotateX(0.3)), geom.Scale(2, 2, 2))
+	end := geom.Multiply(geom.Multiply(geom.Multiply(geom.Translate(2, 1, 4), geom.RotateX(0.3)), geom.RotateY(1)), geom.Scale(2, 3, 1))
+	motion := motionOf(start, end)
+
+	for _, p := range []geom.Tuple4{geom.NewPoint(0, 0, 0), geom.NewPoint(1, -2, 5), geom.NewVector(0.5, 1, -1)} {
+		assertTupleInDelta(t, geom.MultiplyByTuple(geom.Inverse(start), p), geom.MultiplyByTuple(geom.Inverse(start), motion.undo(p, 0)))
+		assertTupleInDelta(t, geom.MultiplyByTuple(geom.Inverse(end), p), geom.MultiplyByTuple(geom.Inverse(start), motion.undo(p, 1)))
+	}
+	// what's left of the translation is along the rotation axis
+	assert.InDelta(t, 0.0, geom.Magnitude(geom.Cross(motion.Translation, motion.Rotation)), geom.Epsilon)
+}
+
+func TestMotionOfSpinsAroundPivot(t *testing.T) {
+	// a spoke one unit from the hub of a wheel, turning a quarter turn around the hub
+	spoke := geom.Multiply(geom.Translate(1, 0, 0), geom.Scale(0.1, 0.5, 0.1))
+	start := spoke
+	end := geom.Multiply(geom.RotateZ(math.Pi/2), spoke)
+	motion := motionOf(start, end)
+	assert.InDelta(t, math.Pi/2, motion.Rotation[3], geom.Epsilon)
+
+	// half way through, the spoke is half way along the circle rather than on the chord between its ends
+	halfWay := geom.NewPoint(math.Sqrt(0.5), math.Sqrt(0.5), 0)
+	assertTupleInDelta(t, geom.NewPoint(0, 0, 0), geom.MultiplyByTuple(geom.Inverse(start), motion.undo(halfWay, 0.5)))
+	assertTupleInDelta(t, geom.NewVector(0, 0, 0), motion.Pivot)
+}
+
+func TestMotionOfTranslation(t *testing.T) {
+	motion := motionOf(geom.Scale(2, 2, 2), geom.Multiply(geom.Translate(4, 0, 0), geom.Scale(2, 2, 2)))
+	assert.Equal(t, 0.0, motion.Rotation[3])
+	assertTupleInDelta(t, geom.NewVector(4, 0, 0), motion.Translation)
+	assertTupleInDelta(t, geom.NewTupleOf(1, 1, 1, 1), motion.Scale)
+}
+
+func TestAxisAngleHalfTurn(t *testing.T) {
+	axis, angle := axisAngle(geom.RotateY(math.Pi))
+	assert.InDelta(t, math.Pi, angle, geom.Epsilon)
+	assert.InDelta(t, 1.0, math.Abs(axis[1]), geom.Epsilon)
+}
+
+func TestAddMotion(t *testing.T) {
+	objects := []CLObject{{Transform: geom.Translate(1, 0, 0)}, {Transform: geom.Translate(0, 1, 0)}}
+	end := []CLObject{{Transform: geom.Translate(1, 0, 0)}, {Transform: geom.Translate(0, 2, 0)}}
+	AddMotion(objects, end)
+
+	assert.False(t, objects[0].HasMotion)
+	assert.True(t, objects[1].HasMotion)
+	assertTupleInDelta(t, geom.NewVector(0, 1, 0), objects[1].MotionTranslation)
+}
+
+func assertTupleInDelta(t *testing.T, expected, actual geom.Tuple4) {
+	for i := 0; i < 4; i++ {
+		assert.InDelta(t, expected[i], actual[i], geom.Epsilon)
+	}
+}
@@ -2,11 +2,13 @@ package ocl
 
 import (
 	_ "embed"
+	"fmt"
 	"image"
-	"math/rand"
+	"strings"
 	"time"
 	"unsafe"
 
+	"github.com/eriklupander/pathtracer-ocl/internal/app/canvas"
 	"github.com/jgillich/go-opencl/cl"
 	"github.com/sirupsen/logrus"
 )
@@ -23,31 +25,56 @@ type CLRay struct {
 }
 
 type CLObject struct {
-	Transform        [16]float64 // 128 bytes
-	Inverse          [16]float64 // 128 bytes
-	InverseTranspose [16]float64 // 128 bytes
-	Color            [4]float64  // 32 bytes
-	Emission         [4]float64  // 32 bytes == 448
-	RefractiveIndex  float64     // 8 bytes
-	Type             int64       // 8 bytes
-	MinY             float64     // 8 bytes
-	MaxY             float64     // 8 bytes
-	Reflectivity     float64     // 8 bytes
-	TextureScaleX    float64
-	TextureScaleY    float64
-	TextureScaleXNM  float64
-	TextureScaleYNM  float64
-	BBMin            [4]float64 // 32 bytes
-	BBMax            [4]float64 // 32 bytes == 504 + 64 == 568
-	ChildCount       int32      // 4 bytes                 572
-	Children         [64]int32  // 64x4 == 256             828
-	IsTextured       bool       // 1 byte
-	TextureIndex     uint8      // 1 byte
-	IsTexturedNM     bool       // 1 byte
-	TextureIndexNM   uint8      // 1 byte
-	IsEnvMap         bool       // 1 byte
-	Label            [8]byte
-	Padding5         [167]byte
+	Transform          [16]float64 // 128 bytes
+	Inverse            [16]float64 // 128 bytes
+	InverseTranspose   [16]float64 // 128 bytes
+	Color              [4]float64  // 32 bytes
+	Emission           [4]float64  // 32 bytes (448 bytes)
+	RefractiveIndex    float64     // 8 bytes
+	Type               int32       // 4 bytes
+	Padding1           int32       // 4 bytes
+	MinY               float64     // 8 bytes
+	MaxY               float64     // 8 bytes
+	Reflectivity       float64     // 8 bytes
+	TextureScaleX      float64
+	TextureScaleY      float64
+	TextureScaleXNM    float64
+	TextureScaleYNM    float64
+	Roughness          float64    // 8 bytes
+	Metalness          float64    // 8 bytes
+	Eta                [4]float64 // 32 bytes
+	K                  [4]float64 // 32 bytes
+	Specular           float64    // 8 bytes
+	Clearcoat          float64    // 8 bytes
+	ClearcoatRoughness float64    // 8 bytes
+	Sheen              float64    // 8 bytes
+	Transmission       float64    // 8 bytes
+	Absorption         [4]float64 // 32 bytes
+	AbbeNumber         float64    // 8 bytes
+	MinorRadius        float64    // 8 bytes, tube radius of tori
+	BBMin              [4]float64 // 32 bytes
+	BBMax              [4]float64 // 32 bytes (752 bytes)
+	ChildCount         int32      // 4 bytes
+	Children           [62]int32  // 248 bytes (1004 bytes)
+	StrengthNM         float32    // 4 bytes
+	IsTextured         bool       // 1 byte
+	TextureIndex       uint8      // 1 byte
+	IsTexturedNM       bool       // 1 byte
+	TextureIndexNM     uint8      // 1 byte
+	IsEnvMap           bool       // 1 byte
+	Label              [8]byte    // 8 bytes
+	ColorPattern       uint8      // 1 byte, index+1 into the patterns, 0 == none
+	RoughnessPattern   uint8      // 1 byte
+	BumpPattern        uint8      // 1 byte (1024 bytes)
+	MotionTranslation  [4]float64 // 32 bytes, motion blur, see objectMotion
+	MotionRotation     [4]float64 // 32 bytes
+	MotionPivot        [4]float64 // 32 bytes
+	MotionScale        [4]float64 // 32 bytes
+	MotionScaleShear   [4]float64 // 32 bytes (1184 bytes)
+	MaterialID         int32      // 4 bytes, see materialID
+	HasMotion          bool       // 1 byte
+	Padding            [27]byte   // 27 bytes
+	// Total 1216 bytes
 }
 
 type CLGroup struct {
@@ -59,66 +86,131 @@ type CLGroup struct {
 	TriCount        int32      // 4 bytes
 	ChildGroupCount int32      // 4 bytes, should always be 2 or 0
 	Children        [2]int32   // 8 bytes, allow 2 subgroups.
-	Padding         [108]byte  // padding, 108 bytes (can be used as a label)
+	InstOffset      int32      // 4 bytes, index of the first instance in this group
+	InstCount       int32      // 4 bytes
+	Padding         [100]byte  // padding, 100 bytes (can be used as a label)
 	// Total 256 bytes
 }
 
+// CLInstance is an instance of a mesh, whose BVH is stored once in the groups buffer with Root as its root group.
+type CLInstance struct {
+	Transform        [16]float64 // 128 bytes
+	Inverse          [16]float64 // 128 bytes
+	InverseTranspose [16]float64 // 128 bytes (384 bytes)
+	Root             int32       // 4 bytes
+	Material         int32       // 4 bytes, index into the materials overriding those of the triangles, -1 if none
+	Padding          [120]byte
+	// Total 512 bytes
+}
+
+// CLTriangle is a triangle of a mesh. The edges are computed by the kernel, and the material is shared with other
+// triangles through the materials buffer.
 type CLTriangle struct {
-	P1      [4]float64 // 32 bytes
-	P2      [4]float64 // 32 bytes
-	P3      [4]float64 // 32 bytes
-	E1      [4]float64 // 32 bytes (128)
-	E2      [4]float64 // 32 bytes
-	N1      [4]float64 // 32 bytes
-	N2      [4]float64 // 32 bytes
-	N3      [4]float64 // 32 bytes (256 here)
-	Color   [4]float64 // 32 bytes (288 bytes)
-	Padding [224]byte
+	P1       [4]float64 // 32 bytes
+	P2       [4]float64 // 32 bytes
+	P3       [4]float64 // 32 bytes
+	N1       [4]float64 // 32 bytes
+	N2       [4]float64 // 32 bytes
+	N3       [4]float64 // 32 bytes (192 bytes)
+	UV1      [2]float64 // 16 bytes
+	UV2      [2]float64 // 16 bytes
+	UV3      [2]float64 // 16 bytes (240 bytes)
+	Tan1     [4]float64 // 32 bytes
+	Tan2     [4]float64 // 32 bytes
+	Tan3     [4]float64 // 32 bytes (336 bytes)
+	Material int32      // 4 bytes, index into the materials
+	Padding  [4]byte
+	// Total 344 bytes
+}
+
+// CLMaterial is the material of triangles, which is applied to the object of the intersected triangle by the kernel.
+type CLMaterial struct {
+	Color              [4]float64 // 32 bytes
+	Emission           [4]float64 // 32 bytes
+	Eta                [4]float64 // 32 bytes
+	K                  [4]float64 // 32 bytes
+	Absorption         [4]float64 // 32 bytes (160 bytes)
+	RefractiveIndex    float64    // 8 bytes
+	Reflectivity       float64    // 8 bytes
+	Roughness          float64    // 8 bytes
+	Metalness          float64    // 8 bytes
+	Specular           float64    // 8 bytes
+	Clearcoat          float64    // 8 bytes
+	ClearcoatRoughness float64    // 8 bytes
+	Sheen              float64    // 8 bytes
+	Transmission       float64    // 8 bytes
+	AbbeNumber         float64    // 8 bytes (240 bytes)
+	TextureScaleX      float64    // 8 bytes
+	TextureScaleY      float64    // 8 bytes
+	TextureScaleXNM    float64    // 8 bytes
+	TextureScaleYNM    float64    // 8 bytes (272 bytes)
+	StrengthNM         float32    // 4 bytes
+	IsTextured         bool       // 1 byte
+	TextureIndex       uint8      // 1 byte
+	IsTexturedNM       bool       // 1 byte
+	TextureIndexNM     uint8      // 1 byte (280 bytes)
+	ColorPattern       uint8      // 1 byte, index+1 into the patterns, 0 == none
+	RoughnessPattern   uint8      // 1 byte
+	BumpPattern        uint8      // 1 byte (283 bytes)
+	Padding1           uint8      // 1 byte
+	MaterialID         int32      // 4 bytes, see materialID (288 bytes)
+	Padding            [224]byte
 	// Total 512 bytes
 }
 
+type CLPattern struct {
+	Inverse    [16]float64 // 128 bytes
+	A          [4]float64  // 32 bytes
+	B          [4]float64  // 32 bytes (192 bytes)
+	Turbulence float64     // 8 bytes
+	Strength   float64     // 8 bytes, only used by bump patterns
+	Type       int32       // 4 bytes
+	Octaves    int32       // 4 bytes (216 bytes)
+	Padding    [40]byte
+	// Total 256 bytes
+}
+
 type CLBoundingBox struct {
 	Min [4]float64 // 32 bytes
 	Max [4]float64 // 32 bytes
 }
 
 type CLCamera struct {
-	Width       int32       // 4
-	Height      int32       // 8
-	Fov         float64     // 16
-	PixelSize   float64     // 24
-	HalfWidth   float64     // 32
-	HalfHeight  float64     // 40
-	Aperture    float64     // 48
-	FocalLength float64     // 56
-	Inverse     [16]float64 // 128 + 56 == 184
-	Padding     [72]byte    // 256-72 == 184
+	Width         int32       // 4 bytes
+	Height        int32       // 4 bytes
+	Fov           float64     // 8 bytes
+	PixelSize     float64     // 8 bytes
+	HalfWidth     float64     // 8 bytes
+	HalfHeight    float64     // 8 bytes
+	Aperture      float64     // 8 bytes, radius of the lens
+	FocusDistance float64     // 8 bytes (56 bytes)
+	Inverse       [16]float64 // 128 bytes (184 bytes)
+	BladeRotation float64     // 8 bytes
+	Blades        int32       // 4 bytes, number of aperture blades, less than 3 for a circular aperture (196 bytes)
+	Projection    int32       // 4 bytes, see camera.Projection
+	OrthoScale    float64     // 8 bytes (208 bytes)
+	EyeOffset     float64     // 8 bytes, see camera.Camera (216 bytes)
+	Padding       [40]byte
+	// Total 256 bytes
 }
 
-// Trace is the entry point for transforming input data into their OpenCL representations, setting up boilerplate
-// and calling the entry kernel. Should return a slice of float64 RGBA RGBA RGBA once finished.
-func Trace(objects []CLObject, triangles []CLTriangle, groups []CLGroup, deviceIndex, samples int, camera CLCamera, textures []image.Image, sphereTextures []image.Image, cubeTextures []image.Image) []float64 {
-	numPixels := int(camera.Width * camera.Height)
-	logrus.Infof("trace with %d objects %dx%d", len(objects), camera.Width, camera.Height)
-
-	// This is a weird fix for when the scene contains no model-related triangles, but we need to transmit something
-	// over to OpenCL...
-	if len(triangles) == 0 {
-		triangles = append(triangles, CLTriangle{
-			P1: [4]float64{},
-			P2: [4]float64{},
-			P3: [4]float64{},
-			E1: [4]float64{},
-			E2: [4]float64{},
-			N1: [4]float64{},
-			N2: [4]float64{},
-			N3: [4]float64{},
-		})
-	}
-	if len(groups) == 0 {
-		groups = append(groups, CLGroup{Children: [2]int32{}, Padding: [108]byte{}})
-	}
+// Tracer holds the OpenCL context, queue and compiled kernel of a device, so that sequences of frames are traced
+// without building the kernel for each of them. Release it when done.
+type Tracer struct {
+	context       *cl.Context
+	queue         *cl.CommandQueue
+	program       *cl.Program
+	kernel        *cl.Kernel
+	workGroupSize int
+	useFloat      bool
+	filter        PixelFilter
+	aovs          bool
+}
 
+// NewTracer sets up the device with the passed index and builds the kernel for it, with the max path depth, the
+// depth from which russian roulette kicks in, the sampler of the random numbers of the paths and the reconstruction
+// filter of the pixels. With aovs set, the kernel also outputs the AOVs of the pixels, see Trace.
+func NewTracer(deviceIndex, maxDepth, rrDepth int, sampler Sampler, filter PixelFilter, aovs bool) *Tracer {
 	platforms, err := cl.GetPlatforms()
 	if err != nil {
 		logrus.Fatalf("Failed to get platforms: %+v", err)
@@ -141,6 +233,15 @@ func Trace(objects []CLObject, triangles []CLTriangle, groups []CLGroup, deviceI
 	device := devices[deviceIndex] // 0 == CPU 1 == iGPU 2 == GPU
 	logrus.Infof("Using device %d %v", deviceIndex, devices[deviceIndex].Name())
 
+	// Devices without support for doubles use the single precision build of the kernel
+	useFloat := !supportsDoubles(device.Extensions())
+	if useFloat {
+		logrus.Infof("Device %v does not support doubles, using single precision", device.Name())
+	}
+	if err := checkLayouts(kernelSource, useFloat); err != nil {
+		logrus.Fatalf("Kernel struct check failed: %v", err)
+	}
+
 	// 1. Select a device to use.
 	//    On my mac           : 0 == CPU, 1 == Iris GPU, 2 == GeForce 750M GPU
 	//    On my windows AMD PC: 0 == Gefore RTX2080
@@ -163,8 +264,8 @@ func Trace(objects []CLObject, triangles []CLTriangle, groups []CLGroup, deviceI
 		logrus.Fatalf("CreateProgramWithSource failed: %+v", err)
 	}
 
-	// 3.2 Build the OpenCL program
-	if err := program.BuildProgram(nil, ""); err != nil {
+	// 3.2 Build the OpenCL program, passing path depth limits, the sampler, the filter and the AOVs as preprocessor defines
+	if err := program.BuildProgram(nil, buildOptions(maxDepth, rrDepth, sampler, filter, aovs, useFloat)); err != nil {
 		logrus.Fatalf("BuildProgram failed: %+v", err)
 	}
 
@@ -174,14 +275,6 @@ func Trace(objects []CLObject, triangles []CLTriangle, groups []CLGroup, deviceI
 		logrus.Fatalf("CreateKernel failed: %+v", err)
 	}
 
-	// Prepare textures
-	texturesArrayMemObj := prepareTextures(context, textures)
-	defer texturesArrayMemObj.Release()
-	sphereTexturesArrayMemObj := prepareTextures(context, sphereTextures)
-	defer sphereTexturesArrayMemObj.Release()
-	cubeTexturesArrayMemObj := prepareTextures(context, cubeTextures)
-	defer cubeTexturesArrayMemObj.Release()
-
 	// 4. Some kind of error-check where we make sure the parameters passed are supported?
 	for i := 0; i < 4; i++ {
 		_, err := kernel.ArgName(i)
@@ -201,7 +294,77 @@ func Trace(objects []CLObject, triangles []CLTriangle, groups []CLGroup, deviceI
 	}
 	logrus.Infof("Work group size: %d", workGroupSize)
 
+	return &Tracer{
+		context:       context,
+		queue:         queue,
+		program:       program,
+		kernel:        kernel,
+		workGroupSize: workGroupSize,
+		useFloat:      useFloat,
+		filter:        filter,
+		aovs:          aovs,
+	}
+}
+
+// Release frees the kernel and the OpenCL context of the tracer.
+func (t *Tracer) Release() {
+	t.kernel.Release()
+	t.program.Release()
+	t.queue.Release()
+	t.context.Release()
+}
+
+// Trace renders the scene as seen by the camera, splats the samples into the film with the filter of the tracer and
+// returns the mean RGBA values of the samples of each pixel, row by row, where alpha is the mean of their squared
+// luminance. Pixel i takes samples[i] samples, numbered from firstSample on so that passes of adaptive sampling
+// continue where the previous pass left off, and pixels without samples are black. All random numbers of the kernel
+// derive from the seed, so the same scene, seed and device always give the same image. If aovs isn't nil, the AOVs
+// of the pixels are added to it, which takes a tracer built with aovs set.
+func (t *Tracer) Trace(objects []CLObject, triangles []CLTriangle, groups []CLGroup, instances []CLInstance, materials []CLMaterial, patterns []CLPattern, samples []uint32, firstSample int, seed uint32, camera CLCamera, film *canvas.Accumulator, aovs *AOVBuffer, textures []image.Image, sphereTextures []image.Image, cubeTextures []image.Image) []float64 {
+	numPixels := int(camera.Width * camera.Height)
+	if len(samples) != numPixels {
+		logrus.Fatalf("got the samples of %d pixels for %dx%d pixels", len(samples), camera.Width, camera.Height)
+	}
+	if aovs != nil && !t.aovs {
+		logrus.Fatalf("the kernel was built without AOVs")
+	}
+	logrus.Infof("trace with %d objects %dx%d", len(objects), camera.Width, camera.Height)
+
+	// This is a weird fix for when the scene contains no model-related triangles, but we need to transmit something
+	// over to OpenCL...
+	if len(triangles) == 0 {
+		triangles = append(triangles, CLTriangle{
+			P1: [4]float64{},
+			P2: [4]float64{},
+			P3: [4]float64{},
+			N1: [4]float64{},
+			N2: [4]float64{},
+			N3: [4]float64{},
+		})
+	}
+	if len(groups) == 0 {
+		groups = append(groups, CLGroup{Children: [2]int32{}, Padding: [100]byte{}})
+	}
+	if len(instances) == 0 {
+		instances = append(instances, CLInstance{})
+	}
+	if len(materials) == 0 {
+		materials = append(materials, CLMaterial{})
+	}
+	if len(patterns) == 0 {
+		patterns = append(patterns, CLPattern{})
+	}
+
+	// Prepare textures
+	texturesArrayMemObj := prepareTextures(t.context, textures)
+	defer texturesArrayMemObj.Release()
+	sphereTexturesArrayMemObj := prepareTextures(t.context, sphereTextures)
+	defer sphereTexturesArrayMemObj.Release()
+	cubeTexturesArrayMemObj := prepareTextures(t.context, cubeTextures)
+	defer cubeTexturesArrayMemObj.Release()
+
 	// Make sure the WGS is never greater than the total number of items we're going to process
+	workGroupSize := t.workGroupSize
 	if workGroupSize > numPixels {
 		workGroupSize = numPixels
 	}
@@ -211,20 +374,77 @@ func Trace(objects []CLObject, triangles []CLTriangle, groups []CLGroup, deviceI
 
 	// split work into batches in order to avoid kernels running for more than 10 seconds
 	// otherwise, the GPU driver will kill us.
+	scene := newCLScene(objects, triangles, groups, instances, materials, patterns, camera, t.useFloat)
 	results := make([]float64, 0)
 	batchSize := 4
 	if batchSize > numPixels {
 		batchSize = numPixels
 	}
 	for y := 0; int32(y) < camera.Height; y += batchSize {
+		// batches of pixels that take no samples, such as converged pixels of adaptive sampling, are skipped
+		end := (y + batchSize) * scene.width
+		if end > numPixels {
+			end = numPixels
+		}
+		if !anySamples(samples[y*scene.width : end]) {
+			results = append(results, make([]float64, batchSize*scene.width*4)...)
+			continue
+		}
 		st := time.Now()
-		results = append(results, computeBatch(objects, triangles, groups, camera, context, kernel, queue, samples, workGroupSize, y, batchSize, texturesArrayMemObj, sphereTexturesArrayMemObj, cubeTexturesArrayMemObj)...)
+		stride := t.filter.outputStride()
+		output, aovOutput := computeBatch(scene, t.context, t.kernel, t.queue, samples, firstSample, seed, workGroupSize, y, batchSize, stride, t.aovs, texturesArrayMemObj, sphereTexturesArrayMemObj, cubeTexturesArrayMemObj)
+		for i := 0; i < len(output); i += stride {
+			results = append(results, output[i:i+4]...)
+		}
+		t.filter.splat(film, output, y)
+		if aovs != nil {
+			aovs.add(samples, aovOutput, y)
+		}
 		logrus.Infof("%d/%d lines done in %v", y+batchSize, camera.Height, time.Since(st))
 	}
 
 	return results
 }
 
+func anySamples(samples []uint32) bool {
+	for _, n := range samples {
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// buildOptions returns the OpenCL compiler options used to pass the max path depth, the depth from which russian
+// roulette path termination kicks in, the sampler, the filter and whether to output AOVs to the kernel. useFloat
+// selects the single precision build of the kernel, where unsuffixed literals such as 1.0 must be floats too.
+func buildOptions(maxDepth, rrDepth int, sampler Sampler, filter PixelFilter, aovs, useFloat bool) string {
+	if maxDepth < 1 {
+		maxDepth = 1
+	}
+	if rrDepth < 0 {
+		rrDepth = 0
+	}
+	options := fmt.Sprintf("-D MAX_OBJECTS=%d -D MAX_DEPTH=%d -D RR_DEPTH=%d -D SAMPLER=%d %s", maxObjects, maxDepth, rrDepth, sampler, filter.buildOptions())
+	if aovs {
+		options += " -D AOVS=1"
+	}
+	if useFloat {
+		options += " -D USE_FLOAT -cl-single-precision-constant"
+	}
+	return options
+}
+
+// supportsDoubles tells if a device with the passed extensions supports double precision.
+func supportsDoubles(extensions string) bool {
+	for _, extension := range strings.Fields(extensions) {
+		if extension == "cl_khr_fp64" {
+			return true
+		}
+	}
+	return false
+}
+
 func prepareTextures(context *cl.Context, textures []image.Image) *cl.MemObject {
 	var memObj *cl.MemObject
 	if len(textures) > 0 {
@@ -253,97 +473,111 @@ func prepareTextures(context *cl.Context, textures []image.Image) *cl.MemObject
 	return memObj
 }
 
-func computeBatch(objects []CLObject, triangles []CLTriangle, groups []CLGroup, camera CLCamera, context *cl.Context, kernel *cl.Kernel, queue *cl.CommandQueue, samples, workGroupSize, rowOffset, rowsPerBatch int, texturesMemObj *cl.MemObject, sphereTexturesMemObj *cl.MemObject, cubeTexturesMemObj *cl.MemObject) []float64 {
-	pixelsInBatch := rowsPerBatch * int(camera.Width)
+// clBuffer is the raw data of a buffer passed to the kernel, i.e. of a slice of structs matching those of tracer.cl.
+type clBuffer struct {
+	ptr  unsafe.Pointer
+	size int
+}
 
-	// populate seed of random numbers, OpenCL can't do random by itself AFAIK
-	seed := make([]float64, pixelsInBatch)
-	for i := 0; i < pixelsInBatch; i++ {
-		seed[i] = rand.Float64()
-	}
+func bufferOf[T any](data []T) clBuffer {
+	return clBuffer{ptr: unsafe.Pointer(&data[0]), size: int(unsafe.Sizeof(data[0])) * len(data)}
+}
 
-	// 5. Time to start loading data into GPU memory
+// clScene holds the scene buffers passed to the kernel, in double precision or, if useFloat is set, in single
+// precision for the USE_FLOAT build of the kernel.
+type clScene struct {
+	objects, triangles, groups, instances, materials, patterns, camera clBuffer
+	numObjects                                                         int
+	width                                                              int
+	useFloat                                                           bool
+}
 
-	// 5.1 create OpenCL buffers (memory) for the pre-computed rays and scene objects.
-	// Note that we're allocating 64 bytes per ray (8xfloat64) and 1024 bytes per scene object.
-	// Remember - each float64 uses 8 bytes.
+func newCLScene(objects []CLObject, triangles []CLTriangle, groups []CLGroup, instances []CLInstance, materials []CLMaterial, patterns []CLPattern, camera CLCamera, useFloat bool) *clScene {
+	scene := &clScene{numObjects: len(objects), width: int(camera.Width), useFloat: useFloat}
+	if useFloat {
+		scene.objects = bufferOf(toFloat32(objects, object32))
+		scene.triangles = bufferOf(toFloat32(triangles, triangle32))
+		scene.groups = bufferOf(toFloat32(groups, group32))
+		scene.instances = bufferOf(toFloat32(instances, instance32))
+		scene.materials = bufferOf(toFloat32(materials, material32))
+		scene.patterns = bufferOf(toFloat32(patterns, pattern32))
+		scene.camera = bufferOf([]CLCamera32{camera32(camera)})
+	} else {
+		scene.objects = bufferOf(objects)
+		scene.triangles = bufferOf(triangles)
+		scene.groups = bufferOf(groups)
+		scene.instances = bufferOf(instances)
+		scene.materials = bufferOf(materials)
+		scene.patterns = bufferOf(patterns)
+		scene.camera = bufferOf([]CLCamera{camera})
+	}
+	return scene
+}
 
-	objectsBuffer, err := context.CreateEmptyBuffer(cl.MemReadOnly, 1024*len(objects))
+// writeBuffer creates a read-only OpenCL buffer and uploads the data into it.
+func writeBuffer(context *cl.Context, queue *cl.CommandQueue, name string, data clBuffer) *cl.MemObject {
+	buffer, err := context.CreateEmptyBuffer(cl.MemReadOnly, data.size)
 	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for objects input: %+v", err)
+		logrus.Fatalf("CreateBuffer failed for %s input: %+v", name, err)
 	}
-	defer objectsBuffer.Release()
-
-	trianglesBuffer, err := context.CreateEmptyBuffer(cl.MemReadOnly, 512*len(triangles))
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for triangles input: %+v", err)
+	if _, err := queue.EnqueueWriteBuffer(buffer, true, 0, data.size, data.ptr, nil); err != nil {
+		logrus.Fatalf("EnqueueWriteBuffer for %s failed: %+v", name, err)
 	}
-	defer trianglesBuffer.Release()
-
-	groupsBuffer, err := context.CreateEmptyBuffer(cl.MemReadOnly, 256*len(groups))
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for groups input: %+v", err)
-	}
-	defer groupsBuffer.Release()
+	return buffer
+}
 
-	seedBuffer, err := context.CreateEmptyBuffer(cl.MemReadOnly, 8*len(seed))
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for seedBuffer input: %+v", err)
-	}
-	defer seedBuffer.Release()
+// computeBatch traces rowsPerBatch rows from rowOffset on and returns the output of the kernel, and its AOV output if
+// withAOVs is set.
+func computeBatch(scene *clScene, context *cl.Context, kernel *cl.Kernel, queue *cl.CommandQueue, samples []uint32, firstSample int, seed uint32, workGroupSize, rowOffset, rowsPerBatch, outputStride int, withAOVs bool, texturesMemObj *cl.MemObject, sphereTexturesMemObj *cl.MemObject, cubeTexturesMemObj *cl.MemObject) ([]float64, []float64) {
+	pixelsInBatch := rowsPerBatch * scene.width
 
-	cameraBuffer, err := context.CreateEmptyBuffer(cl.MemReadOnly, 256)
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for camera input: %+v", err)
-	}
+	// 5. Time to start loading data into GPU memory, i.e. create OpenCL buffers (memory) for the scene and upload the
+	//    actual data into them.
+	objectsBuffer := writeBuffer(context, queue, "objects", scene.objects)
+	defer objectsBuffer.Release()
+	trianglesBuffer := writeBuffer(context, queue, "triangles", scene.triangles)
+	defer trianglesBuffer.Release()
+	groupsBuffer := writeBuffer(context, queue, "groups", scene.groups)
+	defer groupsBuffer.Release()
+	instancesBuffer := writeBuffer(context, queue, "instances", scene.instances)
+	defer instancesBuffer.Release()
+	materialsBuffer := writeBuffer(context, queue, "materials", scene.materials)
+	defer materialsBuffer.Release()
+	patternsBuffer := writeBuffer(context, queue, "patterns", scene.patterns)
+	defer patternsBuffer.Release()
+	cameraBuffer := writeBuffer(context, queue, "camera", scene.camera)
 	defer cameraBuffer.Release()
-
-	// 5.2 create OpenCL buffer (memory) for the output data, we want RGBA per ray, i.e. 4 float64 per ray.
-	// So, we'll need 32 bytes to store the final computed color for each ray. Remember, we pass 1 ray per pixel.
-	output, err := context.CreateEmptyBuffer(cl.MemReadOnly, pixelsInBatch*32)
+	blueNoiseBuffer := writeBuffer(context, queue, "blue noise", bufferOf(blueNoiseMask()))
+	defer blueNoiseBuffer.Release()
+	samplesBuffer := writeBuffer(context, queue, "samples", bufferOf(samples))
+	defer samplesBuffer.Release()
+
+	// 5.2 create OpenCL buffer (memory) for the output data, we want RGBA per ray followed by the splats of its
+	//     samples, i.e. outputStride reals per ray.
+	realSize := 8
+	if scene.useFloat {
+		realSize = 4
+	}
+	output, err := context.CreateEmptyBuffer(cl.MemWriteOnly, pixelsInBatch*outputStride*realSize)
 	if err != nil {
 		logrus.Fatalf("CreateBuffer failed for output: %+v", err)
 	}
 	defer output.Release()
 
-	// 5.3 This is where we connect our input to the command queue and upload the actual data into GPU memory
-	//     The rayDataPtr is a pointer to the first element of the rays slice,
-	//     while rayDataTotalSizeBytes is the total length of the ray data, in bytes - i.e len(rays) * 64.
-	objectsDataPtr := unsafe.Pointer(&objects[0])
-	objectsDataSize := int(unsafe.Sizeof(objects[0])) * len(objects)
-	if _, err := queue.EnqueueWriteBuffer(objectsBuffer, true, 0, objectsDataSize, objectsDataPtr, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer for objects failed: %+v", err)
-	}
-
-	trianglesDataPtr := unsafe.Pointer(&triangles[0])
-	trianglesDataSize := int(unsafe.Sizeof(triangles[0])) * len(triangles)
-	if _, err := queue.EnqueueWriteBuffer(trianglesBuffer, true, 0, trianglesDataSize, trianglesDataPtr, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer for triangles failed: %+v", err)
-	}
-
-	groupsDataPtr := unsafe.Pointer(&groups[0])
-	groupsDataSize := int(unsafe.Sizeof(groups[0])) * len(groups)
-	if _, err := queue.EnqueueWriteBuffer(groupsBuffer, true, 0, groupsDataSize, groupsDataPtr, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer for groups failed: %+v", err)
+	// 5.3 and for the AOVs, aovStride reals per ray. The kernel doesn't touch it when built without AOVs, but every
+	//     argument needs a buffer.
+	aovSize := 1
+	if withAOVs {
+		aovSize = pixelsInBatch * aovStride
 	}
-
-	seedDataPtr := unsafe.Pointer(&seed[0])
-	seedDataSize := int(unsafe.Sizeof(seed[0])) * len(seed)
-	if _, err := queue.EnqueueWriteBuffer(seedBuffer, true, 0, seedDataSize, seedDataPtr, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer for seed failed: %+v", err)
-	}
-
-	cameraDataPtr := unsafe.Pointer(&camera)
-	cameraDataSize := int(unsafe.Sizeof(camera))
-	if _, err := queue.EnqueueWriteBuffer(cameraBuffer, true, 0, cameraDataSize, cameraDataPtr, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer for camera failed: %+v", err)
+	aovOutput, err := context.CreateEmptyBuffer(cl.MemWriteOnly, aovSize*realSize)
+	if err != nil {
+		logrus.Fatalf("CreateBuffer failed for AOV output: %+v", err)
 	}
-
-	// Texture experiment
-	//queue.EnqueueWriteImage(memObj, true, []int{0}, []int{0}, 0, 0, )
+	defer aovOutput.Release()
 
 	// 5.4 Kernel is our program and here we explicitly bind our parameters to it
-	if err := kernel.SetArgs(objectsBuffer, uint32(len(objects)), trianglesBuffer, groupsBuffer, output, seedBuffer, uint32(samples), cameraBuffer, uint32(rowOffset), texturesMemObj, sphereTexturesMemObj, cubeTexturesMemObj); err != nil {
+	if err := kernel.SetArgs(objectsBuffer, uint32(scene.numObjects), trianglesBuffer, groupsBuffer, instancesBuffer, materialsBuffer, patternsBuffer, output, aovOutput, seed, blueNoiseBuffer, samplesBuffer, uint32(firstSample), cameraBuffer, uint32(rowOffset), texturesMemObj, sphereTexturesMemObj, cubeTexturesMemObj); err != nil {
 		logrus.Fatalf("SetKernelArgs failed: %+v", err)
 	}
 
@@ -357,20 +591,36 @@ func computeBatch(objects []CLObject, triangles []CLTriangle, groups []CLGroup,
 		logrus.Fatalf("Finish failed: %+v", err)
 	}
 
-	// 9. Allocate storage for loading the output from the OpenCL program, 4 float64 per cast ray. RGBA
-	results := make([]float64, pixelsInBatch*4)
-
-	// 10. The EnqueueReadBuffer copies the data in the OpenCL "output" buffer into the "results" slice.
-	dataPtrOut := unsafe.Pointer(&results[0])
-	resSize := unsafe.Sizeof(results[0])
-	sizePerEntry := int(resSize)
-	dataSizeOut := sizePerEntry * len(results)
-
-	if _, err := queue.EnqueueReadBuffer(output, true, 0, dataSizeOut, dataPtrOut, nil); err != nil {
-		logrus.Fatalf("EnqueueReadBuffer failed: %+v", err)
+	// 9. Allocate storage for loading the output from the OpenCL program, outputStride reals per cast ray.
+	// 10. The EnqueueReadBuffer copies the data in the OpenCL "output" buffer into the results slice.
+	results := readReals(queue, output, pixelsInBatch*outputStride, scene.useFloat)
+	var aovResults []float64
+	if withAOVs {
+		aovResults = readReals(queue, aovOutput, aovSize, scene.useFloat)
 	}
 
 	queue.Flush()
 
+	return results, aovResults
+}
+
+// readReals reads n reals from the buffer, which are floats in the USE_FLOAT build of the kernel.
+func readReals(queue *cl.CommandQueue, buffer *cl.MemObject, n int, useFloat bool) []float64 {
+	results := make([]float64, n)
+	if useFloat {
+		results32 := make([]float32, n)
+		readBuffer(queue, buffer, bufferOf(results32))
+		for i := range results32 {
+			results[i] = float64(results32[i])
+		}
+	} else {
+		readBuffer(queue, buffer, bufferOf(results))
+	}
 	return results
 }
+
+func readBuffer(queue *cl.CommandQueue, buffer *cl.MemObject, data clBuffer) {
+	if _, err := queue.EnqueueReadBuffer(buffer, true, 0, data.size, data.ptr, nil); err != nil {
+		logrus.Fatalf("EnqueueReadBuffer failed: %+v", err)
+	}
+}
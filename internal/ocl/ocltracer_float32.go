@@ -1,18 +1,10 @@
 package ocl
 
-// This file provides a broken-ish implementation using float32 rather than float64. The underlying reason is not fully
-// evident, but objects being passed as structs tend to mess up some seemingly arbitrary values from Go-land to OpenCL-land,
-// which does not happen with the float64 -> double codebase.
-import (
-	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"time"
-	"unsafe"
-
-	"github.com/jgillich/go-opencl/cl"
-	"github.com/sirupsen/logrus"
-)
+// This file holds the single precision counterparts of the structs passed to the kernel. They're used with the
+// USE_FLOAT build of tracer.cl on devices without support for doubles (cl_khr_fp64), such as many integrated GPUs.
+// Each struct has the same fields as its double precision counterpart with float32 in place of float64, and is laid
+// out without any padding added by Go so that it matches the packed struct of the kernel field by field. See
+// ocltracer_float32_test.go for the expected offsets.
 
 type CLRay32 struct {
 	Origin    [4]float32
@@ -20,249 +12,347 @@ type CLRay32 struct {
 }
 
 type CLObject32 struct {
-	Transform        [16]float32 // 64 bytes 16x4
-	Inverse          [16]float32 // 64 bytes
-	InverseTranspose [16]float32 // 64 bytes
-	Color            [4]float32  // 16 bytes
-	Emission         [4]float32  // 16 bytes
-	RefractiveIndex  float32     // 4 bytes
-	Type             int32       // 4 bytes
-	Padding          [6]int32    // 24 bytes
-	// Total: 64x4 == 256 bytes, nice Power of 64
+	Transform          [16]float32 // 64 bytes
+	Inverse            [16]float32 // 64 bytes
+	InverseTranspose   [16]float32 // 64 bytes
+	Color              [4]float32  // 16 bytes
+	Emission           [4]float32  // 16 bytes (224 bytes)
+	RefractiveIndex    float32     // 4 bytes
+	Type               int32       // 4 bytes
+	Padding1           int32       // 4 bytes
+	MinY               float32     // 4 bytes
+	MaxY               float32     // 4 bytes
+	Reflectivity       float32     // 4 bytes
+	TextureScaleX      float32     // 4 bytes
+	TextureScaleY      float32     // 4 bytes
+	TextureScaleXNM    float32     // 4 bytes
+	TextureScaleYNM    float32     // 4 bytes
+	Roughness          float32     // 4 bytes
+	Metalness          float32     // 4 bytes (272 bytes)
+	Eta                [4]float32  // 16 bytes
+	K                  [4]float32  // 16 bytes (304 bytes)
+	Specular           float32     // 4 bytes
+	Clearcoat          float32     // 4 bytes
+	ClearcoatRoughness float32     // 4 bytes
+	Sheen              float32     // 4 bytes
+	Transmission       float32     // 4 bytes (324 bytes)
+	Absorption         [4]float32  // 16 bytes
+	AbbeNumber         float32     // 4 bytes
+	MinorRadius        float32     // 4 bytes (348 bytes)
+	BBMin              [4]float32  // 16 bytes
+	BBMax              [4]float32  // 16 bytes (380 bytes)
+	ChildCount         int32       // 4 bytes
+	Children           [62]int32   // 248 bytes (632 bytes)
+	StrengthNM         float32     // 4 bytes
+	IsTextured         bool        // 1 byte
+	TextureIndex       uint8       // 1 byte
+	IsTexturedNM       bool        // 1 byte
+	TextureIndexNM     uint8       // 1 byte
+	IsEnvMap           bool        // 1 byte
+	Label              [8]byte     // 8 bytes
+	ColorPattern       uint8       // 1 byte
+	RoughnessPattern   uint8       // 1 byte
+	BumpPattern        uint8       // 1 byte (652 bytes)
+	MotionTranslation  [4]float32  // 16 bytes
+	MotionRotation     [4]float32  // 16 bytes
+	MotionPivot        [4]float32  // 16 bytes
+	MotionScale        [4]float32  // 16 bytes
+	MotionScaleShear   [4]float32  // 16 bytes (732 bytes)
+	MaterialID         int32       // 4 bytes
+	HasMotion          bool        // 1 byte
+	Padding            [27]byte    // 27 bytes
+	// Total 764 bytes
 }
 
-// Trace32 is the entry point for transforming input data into their OpenCL representations, setting up boilerplate
-// and calling the entry kernel. Should return a slice of float32 RGBA RGBA RGBA once finished.
-func Trace32(rays []CLRay32, objects []CLObject32, width, height int) []float32 {
-	dumpObjects(objects)
-
-	platforms, err := cl.GetPlatforms()
-	if err != nil {
-		logrus.Fatalf("Failed to get platforms: %+v", err)
-	}
-	platform := platforms[0]
-
-	devices, err := platform.GetDevices(cl.DeviceTypeAll)
-	if err != nil {
-		logrus.Fatalf("Failed to get devices: %+v", err)
-	}
-	if len(devices) == 0 {
-		logrus.Fatalf("GetDevices returned no devices")
-	}
-	deviceIndex := 0
-
-	if deviceIndex < 0 {
-		deviceIndex = 0
-	}
-	device := devices[deviceIndex] // 0 == CPU 1 == iGPU 2 == GPU
-	logrus.Infof("Using device %d %v", deviceIndex, devices[deviceIndex].Name())
-
-	// 1. Select a device to use. On my mac: 0 == CPU, 1 == Iris GPU, 2 == GeForce 750M GPU
-	// Use selected device to create an OpenCL context
-	context, err := cl.CreateContext([]*cl.Device{device})
-	if err != nil {
-		logrus.Fatalf("CreateContext failed: %+v", err)
-	}
-
-	// 2. Create a "Command Queue" bound to the selected device
-	queue, err := context.CreateCommandQueue(device, 0)
-	if err != nil {
-		logrus.Fatalf("CreateCommandQueue failed: %+v", err)
-	}
-
-	// 3.0 Read kernel source from disk
-	kernelBytes, err := ioutil.ReadFile("internal/ocl/tracer_float4.cl")
-	if err != nil {
-		logrus.Fatalf("reading kernel source failed: %+v", err)
-	}
-	// 3.1 Create an OpenCL "program" from the source code.
-	program, err := context.CreateProgramWithSource([]string{string(kernelBytes)})
-	if err != nil {
-		logrus.Fatalf("CreateProgramWithSource failed: %+v", err)
-	}
-
-	// 3.2 Build the OpenCL program (compile it?)
-	if err := program.BuildProgram(nil, ""); err != nil {
-		logrus.Fatalf("BuildProgram failed: %+v", err)
-	}
-
-	// 3.3 Create the actual Kernel with a name, the Kernel is what we call when we want to execute something.
-	kernel, err := program.CreateKernel("trace")
-	if err != nil {
-		logrus.Fatalf("CreateKernel failed: %+v", err)
-	}
-
-	// 4. Some kind of error-check where we make sure the parameters passed are supported?
-	for i := 0; i < 4; i++ {
-		name, err := kernel.ArgName(i)
-		if err == cl.ErrUnsupported {
-			logrus.Errorf("GetKernelArgInfo for arg: %d ErrUnsupported", i)
-			break
-		} else if err != nil {
-			logrus.Errorf("GetKernelArgInfo for name failed: %+v", err)
-			break
-		} else {
-			logrus.Infof("Kernel arg %d: %s", i, name)
-		}
-	}
+type CLGroup32 struct {
+	BBMin           [4]float32 // 16 bytes
+	BBMax           [4]float32 // 16 bytes
+	Color           [4]float32 // 16 bytes
+	Emission        [4]float32 // 16 bytes (64 bytes)
+	TriOffset       int32      // 4 bytes
+	TriCount        int32      // 4 bytes
+	ChildGroupCount int32      // 4 bytes
+	Children        [2]int32   // 8 bytes
+	InstOffset      int32      // 4 bytes
+	InstCount       int32      // 4 bytes (92 bytes)
+	Padding         [100]byte
+	// Total 192 bytes
+}
 
-	// split work into batches in order to avoid kernels running for more than 10 seconds
-	// otherwise, the GPU driver will kill us.
-	results := make([]float32, 0)
-	for y := 0; y < height; y += 8 {
-		st := time.Now()
-		results = append(results, computeBatch32(rays[y*width:y*width+width*8], objects, context, kernel, queue)...)
-		logrus.Infof("%d/%d lines done in %v\n", y, height, time.Since(st))
-	}
+type CLInstance32 struct {
+	Transform        [16]float32 // 64 bytes
+	Inverse          [16]float32 // 64 bytes
+	InverseTranspose [16]float32 // 64 bytes (192 bytes)
+	Root             int32       // 4 bytes
+	Material         int32       // 4 bytes
+	Padding          [120]byte
+	// Total 320 bytes
+}
 
-	return results
+type CLTriangle32 struct {
+	P1       [4]float32 // 16 bytes
+	P2       [4]float32 // 16 bytes
+	P3       [4]float32 // 16 bytes
+	N1       [4]float32 // 16 bytes
+	N2       [4]float32 // 16 bytes
+	N3       [4]float32 // 16 bytes (96 bytes)
+	UV1      [2]float32 // 8 bytes
+	UV2      [2]float32 // 8 bytes
+	UV3      [2]float32 // 8 bytes (120 bytes)
+	Tan1     [4]float32 // 16 bytes
+	Tan2     [4]float32 // 16 bytes
+	Tan3     [4]float32 // 16 bytes (168 bytes)
+	Material int32      // 4 bytes
+	Padding  [4]byte
+	// Total 176 bytes
 }
 
-func dumpObjects(objects []CLObject32) {
-	for y := 0; y < len(objects); y++ {
-		fmt.Printf("obj: transform:\n")
-		for x := 0; x < 16; x++ {
-			fmt.Printf("object: %d elem: %d: %f ", y, x, objects[y].Transform[x])
-			fmt.Printf("\n")
-		}
-		fmt.Printf("\n")
-		fmt.Printf("obj: inverse:\n")
-		for x := 0; x < 16; x++ {
-			fmt.Printf("object: %d elem: %d: %f ", y, x, objects[y].Inverse[x])
-			fmt.Printf("\n")
-		}
-		fmt.Printf("\n")
-		fmt.Printf("obj: inverse transpose:\n")
-		for x := 0; x < 16; x++ {
-			fmt.Printf("object: %d elem: %d: %f ", y, x, objects[y].InverseTranspose[x])
-			fmt.Printf("\n")
-		}
-		fmt.Printf("\n")
-		fmt.Printf("obj: color:\n")
-		for x := 0; x < 4; x++ {
-			fmt.Printf("object: %d elem: %d: %f ", y, x, objects[y].Color[x])
-			fmt.Printf("\n")
-		}
-		fmt.Printf("\n")
-		fmt.Printf("obj: emission:\n")
-		for x := 0; x < 4; x++ {
-			fmt.Printf("object: %d elem: %d: %f ", y, x, objects[y].Emission[x])
-			fmt.Printf("\n")
-		}
-	}
-	fmt.Printf("\n")
+type CLMaterial32 struct {
+	Color              [4]float32 // 16 bytes
+	Emission           [4]float32 // 16 bytes
+	Eta                [4]float32 // 16 bytes
+	K                  [4]float32 // 16 bytes
+	Absorption         [4]float32 // 16 bytes (80 bytes)
+	RefractiveIndex    float32    // 4 bytes
+	Reflectivity       float32    // 4 bytes
+	Roughness          float32    // 4 bytes
+	Metalness          float32    // 4 bytes
+	Specular           float32    // 4 bytes
+	Clearcoat          float32    // 4 bytes
+	ClearcoatRoughness float32    // 4 bytes
+	Sheen              float32    // 4 bytes
+	Transmission       float32    // 4 bytes
+	AbbeNumber         float32    // 4 bytes (120 bytes)
+	TextureScaleX      float32    // 4 bytes
+	TextureScaleY      float32    // 4 bytes
+	TextureScaleXNM    float32    // 4 bytes
+	TextureScaleYNM    float32    // 4 bytes (136 bytes)
+	StrengthNM         float32    // 4 bytes
+	IsTextured         bool       // 1 byte
+	TextureIndex       uint8      // 1 byte
+	IsTexturedNM       bool       // 1 byte
+	TextureIndexNM     uint8      // 1 byte (144 bytes)
+	ColorPattern       uint8      // 1 byte
+	RoughnessPattern   uint8      // 1 byte
+	BumpPattern        uint8      // 1 byte (147 bytes)
+	Padding1           uint8      // 1 byte
+	MaterialID         int32      // 4 bytes (152 bytes)
+	Padding            [224]byte
+	// Total 376 bytes
 }
 
-func computeBatch32(rays []CLRay32, objects []CLObject32, context *cl.Context, kernel *cl.Kernel, queue *cl.CommandQueue) []float32 {
-	// 5. Time to start loading data into GPU memory
-	raySizeBytes := int(unsafe.Sizeof(rays[0]))
-	objectSizeBytes := int(unsafe.Sizeof(objects[0]))
+type CLPattern32 struct {
+	Inverse    [16]float32 // 64 bytes
+	A          [4]float32  // 16 bytes
+	B          [4]float32  // 16 bytes (96 bytes)
+	Turbulence float32     // 4 bytes
+	Strength   float32     // 4 bytes
+	Type       int32       // 4 bytes
+	Octaves    int32       // 4 bytes (112 bytes)
+	Padding    [40]byte
+	// Total 152 bytes
+}
 
-	// 5.1 create OpenCL buffers (memory) for the input data. Note that we're allocating 9x bytes the size of data.
-	//     since each float32 uses 8 bytes.
-	inputRays, err := context.CreateEmptyBuffer(cl.MemReadOnly, raySizeBytes*len(rays))
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for vectors input: %+v", err)
-	}
-	defer inputRays.Release()
+type CLCamera32 struct {
+	Width         int32       // 4 bytes
+	Height        int32       // 4 bytes
+	Fov           float32     // 4 bytes
+	PixelSize     float32     // 4 bytes
+	HalfWidth     float32     // 4 bytes
+	HalfHeight    float32     // 4 bytes
+	Aperture      float32     // 4 bytes
+	FocusDistance float32     // 4 bytes (32 bytes)
+	Inverse       [16]float32 // 64 bytes (96 bytes)
+	BladeRotation float32     // 4 bytes
+	Blades        int32       // 4 bytes (104 bytes)
+	Projection    int32       // 4 bytes
+	OrthoScale    float32     // 4 bytes (112 bytes)
+	EyeOffset     float32     // 4 bytes (116 bytes)
+	Padding       [40]byte
+	// Total 156 bytes
+}
 
-	inputObjects, err := context.CreateEmptyBuffer(cl.MemReadOnly, objectSizeBytes*len(objects))
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for objects input: %+v", err)
-	}
-	defer inputObjects.Release()
+func vec32(v [4]float64) [4]float32 {
+	return [4]float32{float32(v[0]), float32(v[1]), float32(v[2]), float32(v[3])}
+}
 
-	seed := make([]float32, len(rays))
-	for i := 0; i < len(rays); i++ {
-		seed[i] = rand.Float32()
-	}
+func uv32(v [2]float64) [2]float32 {
+	return [2]float32{float32(v[0]), float32(v[1])}
+}
 
-	seedNumbers, err := context.CreateEmptyBuffer(cl.MemReadOnly, 4*len(seed))
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for vectors input: %+v", err)
+func mat32(m [16]float64) [16]float32 {
+	out := [16]float32{}
+	for i := range m {
+		out[i] = float32(m[i])
 	}
-	defer seedNumbers.Release()
+	return out
+}
 
-	// 5.2 create OpenCL buffers (memory) for the output data, we want RGBA per ray, and each ray consists of 8 float32
-	// However, this is the buffer size IN BYTES, so to get RGBA from let's say 1000 rays:
-	// if len(ray) == 8000 we actually have 1000 "real" rays.
-	// To get RGBA for 1000 real rays, we need 4000 float32, which equals 16000 bytes. Thus len(rays) * 4.
-	output, err := context.CreateEmptyBuffer(cl.MemReadOnly, len(rays)*16)
-	if err != nil {
-		logrus.Fatalf("CreateBuffer failed for output: %+v", err)
+// toFloat32 converts each element of in using the passed func.
+func toFloat32[T, U any](in []T, convert func(T) U) []U {
+	out := make([]U, len(in))
+	for i := range in {
+		out[i] = convert(in[i])
 	}
-	defer output.Release()
-
-	// 5.3 This is where we connect our input to the command queue, and upload the actual data into GPU memory
-	//     The rayDataPtr:s seems to be a point to the first element of the input,
-	//     while rayDataTotalSizeBytes should be the total length of the data, in bytes.
+	return out
+}
 
-	rayDataPtr := unsafe.Pointer(&rays[0])
-	rayDataTotalSizeBytes := raySizeBytes * len(rays)
-	if _, err := queue.EnqueueWriteBuffer(inputRays, true, 0, rayDataTotalSizeBytes, rayDataPtr, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer failed: %+v", err)
+func object32(o CLObject) CLObject32 {
+	return CLObject32{
+		Transform:          mat32(o.Transform),
+		Inverse:            mat32(o.Inverse),
+		InverseTranspose:   mat32(o.InverseTranspose),
+		Color:              vec32(o.Color),
+		Emission:           vec32(o.Emission),
+		RefractiveIndex:    float32(o.RefractiveIndex),
+		Type:               o.Type,
+		MinY:               float32(o.MinY),
+		MaxY:               float32(o.MaxY),
+		Reflectivity:       float32(o.Reflectivity),
+		TextureScaleX:      float32(o.TextureScaleX),
+		TextureScaleY:      float32(o.TextureScaleY),
+		TextureScaleXNM:    float32(o.TextureScaleXNM),
+		TextureScaleYNM:    float32(o.TextureScaleYNM),
+		Roughness:          float32(o.Roughness),
+		Metalness:          float32(o.Metalness),
+		Eta:                vec32(o.Eta),
+		K:                  vec32(o.K),
+		Specular:           float32(o.Specular),
+		Clearcoat:          float32(o.Clearcoat),
+		ClearcoatRoughness: float32(o.ClearcoatRoughness),
+		Sheen:              float32(o.Sheen),
+		Transmission:       float32(o.Transmission),
+		Absorption:         vec32(o.Absorption),
+		AbbeNumber:         float32(o.AbbeNumber),
+		MinorRadius:        float32(o.MinorRadius),
+		BBMin:              vec32(o.BBMin),
+		BBMax:              vec32(o.BBMax),
+		ChildCount:         o.ChildCount,
+		Children:           o.Children,
+		StrengthNM:         o.StrengthNM,
+		IsTextured:         o.IsTextured,
+		TextureIndex:       o.TextureIndex,
+		IsTexturedNM:       o.IsTexturedNM,
+		TextureIndexNM:     o.TextureIndexNM,
+		IsEnvMap:           o.IsEnvMap,
+		Label:              o.Label,
+		ColorPattern:       o.ColorPattern,
+		RoughnessPattern:   o.RoughnessPattern,
+		BumpPattern:        o.BumpPattern,
+		MotionTranslation:  vec32(o.MotionTranslation),
+		MotionRotation:     vec32(o.MotionRotation),
+		MotionPivot:        vec32(o.MotionPivot),
+		MotionScale:        vec32(o.MotionScale),
+		MotionScaleShear:   vec32(o.MotionScaleShear),
+		MaterialID:         o.MaterialID,
+		HasMotion:          o.HasMotion,
 	}
+}
 
-	dataPtrVec2 := unsafe.Pointer(&objects[0])
-	dataSizeVec2 := objectSizeBytes * len(objects)
-	if _, err := queue.EnqueueWriteBuffer(inputObjects, true, 0, dataSizeVec2, dataPtrVec2, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer failed: %+v", err)
+func group32(g CLGroup) CLGroup32 {
+	return CLGroup32{
+		BBMin:           vec32(g.BBMin),
+		BBMax:           vec32(g.BBMax),
+		Color:           vec32(g.Color),
+		Emission:        vec32(g.Emission),
+		TriOffset:       g.TriOffset,
+		TriCount:        g.TriCount,
+		ChildGroupCount: g.ChildGroupCount,
+		Children:        g.Children,
+		InstOffset:      g.InstOffset,
+		InstCount:       g.InstCount,
+		Padding:         g.Padding,
 	}
+}
 
-	dataPtrVec3 := unsafe.Pointer(&seed[0])
-	dataSizeVec3 := int(unsafe.Sizeof(seed[0])) * len(seed)
-	if _, err := queue.EnqueueWriteBuffer(seedNumbers, true, 0, dataSizeVec3, dataPtrVec3, nil); err != nil {
-		logrus.Fatalf("EnqueueWriteBuffer failed: %+v", err)
+func instance32(i CLInstance) CLInstance32 {
+	return CLInstance32{
+		Transform:        mat32(i.Transform),
+		Inverse:          mat32(i.Inverse),
+		InverseTranspose: mat32(i.InverseTranspose),
+		Root:             i.Root,
+		Material:         i.Material,
 	}
+}
 
-	// 5.4 Kernel is our program and here we explicitly bind our 4 parameters to it
-	if err := kernel.SetArgs(inputRays, inputObjects, uint32(len(objects)), output, seedNumbers); err != nil {
-		logrus.Fatalf("SetKernelArgs failed: %+v", err)
+func triangle32(t CLTriangle) CLTriangle32 {
+	return CLTriangle32{
+		P1:       vec32(t.P1),
+		P2:       vec32(t.P2),
+		P3:       vec32(t.P3),
+		N1:       vec32(t.N1),
+		N2:       vec32(t.N2),
+		N3:       vec32(t.N3),
+		UV1:      uv32(t.UV1),
+		UV2:      uv32(t.UV2),
+		UV3:      uv32(t.UV3),
+		Tan1:     vec32(t.Tan1),
+		Tan2:     vec32(t.Tan2),
+		Tan3:     vec32(t.Tan3),
+		Material: t.Material,
 	}
+}
 
-	// 6. Determine device's WorkGroup size. This is probably how many items the GPU can process at a time.
-	//local, err := kernel.WorkGroupSize(device)
-	//if err != nil {
-	//	logrus.Fatalf("WorkGroupSize failed: %+v", err)
-	//}
-	//logrus.Infof("Work group size: %d", local)
-	//size, _ := kernel.PreferredWorkGroupSizeMultiple(nil)
-	//logrus.Infof("Preferred Work Group Size Multiple: %d", size)
-
-	// 6.1 calc local/global sizes. This stuff is passed on to the "enqueue". I think it's purpose is to handle
-	//     cases where the data set size isn't divideable by the WG size
-	//global := len(rays) / 8 // number of items to process, e.g. 32768
-	//d := global % local     // given the preferred WG size, d is
-	//logrus.Infof("Global: %d, D: %d", global, d)
-	//if d != 0 {
-	//	global += local - d
-	//}
-	//logrus.Infof("Global after applying D: %d, D: %d", global, d)
-
-	// 7. Finally, start work! Enqueue executes the loaded args on the specified kernel.
-	if _, err := queue.EnqueueNDRangeKernel(kernel, nil, []int{len(rays)}, nil, nil); err != nil {
-		logrus.Fatalf("EnqueueNDRangeKernel failed: %+v", err)
+func material32(m CLMaterial) CLMaterial32 {
+	return CLMaterial32{
+		Color:              vec32(m.Color),
+		Emission:           vec32(m.Emission),
+		Eta:                vec32(m.Eta),
+		K:                  vec32(m.K),
+		Absorption:         vec32(m.Absorption),
+		RefractiveIndex:    float32(m.RefractiveIndex),
+		Reflectivity:       float32(m.Reflectivity),
+		Roughness:          float32(m.Roughness),
+		Metalness:          float32(m.Metalness),
+		Specular:           float32(m.Specular),
+		Clearcoat:          float32(m.Clearcoat),
+		ClearcoatRoughness: float32(m.ClearcoatRoughness),
+		Sheen:              float32(m.Sheen),
+		Transmission:       float32(m.Transmission),
+		AbbeNumber:         float32(m.AbbeNumber),
+		TextureScaleX:      float32(m.TextureScaleX),
+		TextureScaleY:      float32(m.TextureScaleY),
+		TextureScaleXNM:    float32(m.TextureScaleXNM),
+		TextureScaleYNM:    float32(m.TextureScaleYNM),
+		StrengthNM:         m.StrengthNM,
+		IsTextured:         m.IsTextured,
+		TextureIndex:       m.TextureIndex,
+		IsTexturedNM:       m.IsTexturedNM,
+		TextureIndexNM:     m.TextureIndexNM,
+		ColorPattern:       m.ColorPattern,
+		RoughnessPattern:   m.RoughnessPattern,
+		BumpPattern:        m.BumpPattern,
+		MaterialID:         m.MaterialID,
 	}
+}
 
-	// 8. Finish() blocks the main goroutine until the OpenCL queue is empty, i.e. all calculations are done
-	if err := queue.Finish(); err != nil {
-		logrus.Fatalf("Finish failed: %+v", err)
+func pattern32(p CLPattern) CLPattern32 {
+	return CLPattern32{
+		Inverse:    mat32(p.Inverse),
+		A:          vec32(p.A),
+		B:          vec32(p.B),
+		Turbulence: float32(p.Turbulence),
+		Strength:   float32(p.Strength),
+		Type:       p.Type,
+		Octaves:    p.Octaves,
 	}
+}
 
-	// 9. Allocate storage for loading the output from the OpenCL program, 4 float32 per cast ray. RGBA
-	results := make([]float32, len(rays)*4)
-
-	// 10. The EnqueueReadBuffer copies the data in the OpenCL "output" buffer into the "results" slice.
-	dataPtrOut := unsafe.Pointer(&results[0])
-	resSize := unsafe.Sizeof(results[0])
-	sizePerEntry := int(resSize)
-	dataSizeOut := sizePerEntry * len(results)
-
-	if _, err := queue.EnqueueReadBuffer(output, true, 0, dataSizeOut, dataPtrOut, nil); err != nil {
-		logrus.Fatalf("EnqueueReadBuffer failed: %+v", err)
+func camera32(c CLCamera) CLCamera32 {
+	return CLCamera32{
+		Width:         c.Width,
+		Height:        c.Height,
+		Fov:           float32(c.Fov),
+		PixelSize:     float32(c.PixelSize),
+		HalfWidth:     float32(c.HalfWidth),
+		HalfHeight:    float32(c.HalfHeight),
+		Aperture:      float32(c.Aperture),
+		FocusDistance: float32(c.FocusDistance),
+		Inverse:       mat32(c.Inverse),
+		BladeRotation: float32(c.BladeRotation),
+		Blades:        c.Blades,
+		Projection:    c.Projection,
+		OrthoScale:    float32(c.OrthoScale),
+		EyeOffset:     float32(c.EyeOffset),
 	}
-
-	queue.Flush()
-
-	return results
 }
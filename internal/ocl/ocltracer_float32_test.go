@@ -0,0 +1,26 @@
+package ocl
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestObject32(t *testing.T) {
+	o := CLObject{Type: 4, RefractiveIndex: 1.5, ChildCount: 2, Label: [8]byte{'g'}, BumpPattern: 3}
+	o.Transform[3] = 2.5
+	o.BBMax = [4]float64{1, 2, 3, 1}
+	o.Children[1] = 7
+	o.MotionRotation = [4]float64{0, 1, 0, 0.5}
+	o.HasMotion = true
+
+	o32 := object32(o)
+	assert.Equal(t, int32(4), o32.Type)
+	assert.Equal(t, float32(1.5), o32.RefractiveIndex)
+	assert.Equal(t, float32(2.5), o32.Transform[3])
+	assert.Equal(t, [4]float32{1, 2, 3, 1}, o32.BBMax)
+	assert.Equal(t, int32(7), o32.Children[1])
+	assert.Equal(t, o.Label, o32.Label)
+	assert.Equal(t, uint8(3), o32.BumpPattern)
+	assert.Equal(t, [4]float32{0, 1, 0, 0.5}, o32.MotionRotation)
+	assert.True(t, o32.HasMotion)
+}
@@ -0,0 +1,21 @@
+package ocl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupportsDoubles(t *testing.T) {
+	assert.True(t, supportsDoubles("cl_khr_byte_addressable_store cl_khr_fp64 cl_khr_icd"))
+	assert.False(t, supportsDoubles("cl_khr_byte_addressable_store cl_khr_fp16 cl_khr_icd"))
+	assert.False(t, supportsDoubles(""))
+}
+
+func TestBuildOptions(t *testing.T) {
+	box := PixelFilter{Filter: Box, Radius: 0.5}
+	assert.Equal(t, "-D MAX_OBJECTS=16 -D MAX_DEPTH=10 -D RR_DEPTH=4 -D SAMPLER=1 -D FILTER=0 -D FILTER_RADIUS=0.5 -D FILTER_EXTENT=0", buildOptions(10, 4, Sobol, box, false, false))
+	assert.Equal(t, "-D MAX_OBJECTS=16 -D MAX_DEPTH=10 -D RR_DEPTH=4 -D SAMPLER=0 -D FILTER=0 -D FILTER_RADIUS=0.5 -D FILTER_EXTENT=0 -D USE_FLOAT -cl-single-precision-constant", buildOptions(10, 4, Independent, box, false, true))
+	assert.Equal(t, "-D MAX_OBJECTS=16 -D MAX_DEPTH=10 -D RR_DEPTH=4 -D SAMPLER=1 -D FILTER=3 -D FILTER_RADIUS=2 -D FILTER_EXTENT=2", buildOptions(10, 4, Sobol, PixelFilter{Filter: Mitchell, Radius: 2}, false, false))
+	assert.Equal(t, "-D MAX_OBJECTS=16 -D MAX_DEPTH=10 -D RR_DEPTH=4 -D SAMPLER=1 -D FILTER=0 -D FILTER_RADIUS=0.5 -D FILTER_EXTENT=0 -D AOVS=1", buildOptions(10, 4, Sobol, box, true, false))
+}
@@ -0,0 +1,321 @@
+package ocl
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// Sampler selects how the kernel draws the random numbers of the samples of a pixel. It is passed to the kernel as
+// SAMPLER when building it, see the samplers in tracer.cl.
+type Sampler int
+
+const (
+	// Independent draws independent random numbers from a PCG generator per sample.
+	Independent Sampler = iota
+	// Sobol draws the points of the first four dimensions of the Sobol sequence for every two dimensions of a path,
+	// Owen scrambled and shuffled per pixel and pair of dimensions, which stratifies them over the samples of the
+	// pixel.
+	Sobol
+	// BlueNoise draws the same Owen scrambled Sobol points in every pixel, shifted per pixel by a blue noise mask, so
+	// that neighbouring pixels err in different directions and what noise remains is blue.
+	BlueNoise
+)
+
+var samplerNames = []string{"independent", "sobol", "blue-noise"}
+
+func (s Sampler) String() string {
+	if s < 0 || int(s) >= len(samplerNames) {
+		return fmt.Sprintf("Sampler(%d)", int(s))
+	}
+	return samplerNames[s]
+}
+
+// ParseSampler returns the sampler of the passed name, such as "sobol".
+func ParseSampler(name string) (Sampler, error) {
+	for i, n := range samplerNames {
+		if n == name {
+			return Sampler(i), nil
+		}
+	}
+	return Independent, fmt.Errorf("unknown sampler %q, expected one of %v", name, samplerNames)
+}
+
+// The dimensions of a path, each of which is a 2D point. Every two dimensions from an even one on are stratified
+// together by the Sobol samplers, so the pixel goes with the BSDF sample of the first bounce, which matter the most.
+// The other dimensions of bounce b are those from bounceDimension + b*dimensionsPerBounce on, where the BSDF sample of
+// later bounces goes with their light sample. Keep in sync with tracer.cl.
+const (
+	pixelDimension      = 0
+	firstBSDFDimension  = 1
+	lensDimension       = 2
+	timeDimension       = 3
+	bounceDimension     = 4
+	dimensionsPerBounce = 16
+
+	bsdfDimension  = 0
+	lightDimension = 1
+	rrDimension    = 2
+	lobeDimension  = 3
+)
+
+// blueNoiseSize is the width and height of the blue noise mask of the BlueNoise sampler.
+const blueNoiseSize = 64
+
+// sampler is the reference implementation of the samplers of tracer.cl, giving the same random numbers as the kernel
+// for the same seed, pixel and sample.
+type sampler struct {
+	kind      Sampler
+	rng       pcg32
+	seed      uint32
+	index     uint32
+	dimension uint32
+	x, y      uint32
+}
+
+func newSampler(kind Sampler, seed, x, y, width, sample uint32) *sampler {
+	s := &sampler{kind: kind, rng: newPCG32(seed, y*width+x, sample), index: sample, x: x, y: y}
+	if kind == BlueNoise {
+		s.seed = pcgHash(seed)
+	} else {
+		s.seed = pcgHash(seed ^ pcgHash(y*width+x))
+	}
+	return s
+}
+
+// sample2D returns the point of the next dimension, in [0, 1)².
+func (s *sampler) sample2D() (float64, float64) {
+	d := s.dimension
+	s.dimension++
+	if s.kind == Independent {
+		return s.rng.real(), s.rng.real()
+	}
+	seed := hashCombine(s.seed, pcgHash(d>>1))
+	u, v := owenSobol(s.index, 2*(d&1), seed)
+	if s.kind == BlueNoise {
+		mask := blueNoiseMask()
+		u += blueNoise(mask, s.x, s.y, hashCombine(seed, pcgHash(2*(d&1))))
+		v += blueNoise(mask, s.x, s.y, hashCombine(seed, pcgHash(2*(d&1)+1)))
+		u -= math.Floor(u)
+		v -= math.Floor(v)
+	}
+	return u, v
+}
+
+// pcg32 is the reference implementation of the PCG generator of tracer.cl.
+type pcg32 struct {
+	state, inc uint64
+}
+
+func newPCG32(seed, pixel, sample uint32) pcg32 {
+	h := pcgHash(seed ^ pcgHash(pixel^pcgHash(sample)))
+	rng := pcg32{inc: uint64(pixel)<<1 | 1}
+	rng.next()
+	rng.state += uint64(h)<<32 | uint64(pcgHash(h))
+	rng.next()
+	return rng
+}
+
+func (r *pcg32) next() uint32 {
+	old := r.state
+	r.state = old*6364136223846793005 + r.inc
+	xorshifted := uint32(((old >> 18) ^ old) >> 27)
+	return bits.RotateLeft32(xorshifted, -int(old>>59))
+}
+
+func (r *pcg32) real() float64 {
+	return toUnit(r.next())
+}
+
+// toUnit maps 32 random bits to [0, 1), keeping 24 bits so that floats hold the result exactly.
+func toUnit(x uint32) float64 {
+	return float64(x>>8) / (1 << 24)
+}
+
+// pcgHash is the PCG hash of Jarzynski and Olano, "Hash Functions for GPU Rendering".
+func pcgHash(v uint32) uint32 {
+	state := v*747796405 + 2891336453
+	word := ((state >> ((state >> 28) + 4)) ^ state) * 277803737
+	return (word >> 22) ^ word
+}
+
+func hashCombine(seed, v uint32) uint32 {
+	return seed ^ (v + (seed << 6) + (seed >> 2))
+}
+
+// nestedUniformScramble Owen scrambles x, using the hash of Laine and Karras as in Burley, "Practical Hash-based
+// Owen Scrambling".
+func nestedUniformScramble(x, seed uint32) uint32 {
+	x = bits.Reverse32(x)
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+	return bits.Reverse32(x)
+}
+
+// sobolDirections are the direction numbers of the first four dimensions of the Sobol sequence, from the primitive
+// polynomials and initial direction numbers of Joe and Kuo. The first dimension is the van der Corput sequence.
+var sobolDirections = [4][32]uint32{
+	directions(0, 0, nil),
+	directions(1, 0, []uint32{1}),
+	directions(2, 1, []uint32{1, 3}),
+	directions(3, 1, []uint32{1, 3, 1}),
+}
+
+// directions returns the direction numbers of the dimension of the Sobol sequence with the primitive polynomial of
+// degree s and coefficients a, and the initial direction numbers m.
+func directions(s, a uint32, m []uint32) [32]uint32 {
+	var v [32]uint32
+	if s == 0 {
+		for k := range v {
+			v[k] = 1 << (31 - k)
+		}
+		return v
+	}
+	for k := uint32(0); k < 32; k++ {
+		if k < s {
+			v[k] = m[k] << (31 - k)
+			continue
+		}
+		v[k] = v[k-s] ^ (v[k-s] >> s)
+		for j := uint32(1); j < s; j++ {
+			if (a>>(s-1-j))&1 != 0 {
+				v[k] ^= v[k-j]
+			}
+		}
+	}
+	return v
+}
+
+// sobol returns point index of dimension dim of the Sobol sequence.
+func sobol(index, dim uint32) uint32 {
+	var x uint32
+	for k := 0; index != 0; index, k = index>>1, k+1 {
+		if index&1 != 0 {
+			x ^= sobolDirections[dim][k]
+		}
+	}
+	return x
+}
+
+// owenSobol returns point index of the dimensions dim and dim + 1 of the first four dimensions of the Sobol sequence,
+// shuffled and Owen scrambled by the seed.
+func owenSobol(index, dim, seed uint32) (float64, float64) {
+	index = nestedUniformScramble(index, seed)
+	x := nestedUniformScramble(sobol(index, dim), hashCombine(seed, pcgHash(dim)))
+	y := nestedUniformScramble(sobol(index, dim+1), hashCombine(seed, pcgHash(dim+1)))
+	return toUnit(x), toUnit(y)
+}
+
+// blueNoise returns the value of the blue noise mask at the pixel, with the mask shifted around by the seed.
+func blueNoise(mask []uint16, x, y, seed uint32) float64 {
+	offset := pcgHash(seed)
+	x = (x + offset) % blueNoiseSize
+	y = (y + offset>>16) % blueNoiseSize
+	return (float64(mask[y*blueNoiseSize+x]) + 0.5) / (blueNoiseSize * blueNoiseSize)
+}
+
+var (
+	blueNoiseOnce  sync.Once
+	blueNoiseRanks []uint16
+)
+
+// blueNoiseMask returns the blue noise mask of the BlueNoise sampler, blueNoiseSize² ranks in row order.
+func blueNoiseMask() []uint16 {
+	blueNoiseOnce.Do(func() {
+		blueNoiseRanks = voidAndCluster(blueNoiseSize, 1.5)
+	})
+	return blueNoiseRanks
+}
+
+// voidAndCluster returns the ranks of a size x size blue noise dither array, made with Ulichney's void-and-cluster
+// method and a Gaussian filter of the passed sigma that wraps around the edges. The initial pattern comes from a
+// fixed seed, so the array is the same every time.
+func voidAndCluster(size int, sigma float64) []uint16 {
+	n := size * size
+	filter := make([]float64, n)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := math.Min(float64(x), float64(size-x)), math.Min(float64(y), float64(size-y))
+			filter[y*size+x] = math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+		}
+	}
+
+	// energy is the filtered pattern, high in clusters and low in voids
+	pattern := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(p int) {
+		pattern[p] = !pattern[p]
+		sign := 1.0
+		if !pattern[p] {
+			sign = -1.0
+		}
+		px, py := p%size, p/size
+		for y := 0; y < size; y++ {
+			row := ((y - py + size) % size) * size
+			for x := 0; x < size; x++ {
+				energy[y*size+x] += sign * filter[row+(x-px+size)%size]
+			}
+		}
+	}
+	tightestCluster := func() int {
+		best := -1
+		for p := range pattern {
+			if pattern[p] && (best < 0 || energy[p] > energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for p := range pattern {
+			if !pattern[p] && (best < 0 || energy[p] < energy[best]) {
+				best = p
+			}
+		}
+		return best
+	}
+
+	// a tenth of the pixels at random, spread evenly by moving the tightest cluster to the largest void until the
+	// tightest cluster is the largest void, which takes far fewer moves than there are pixels
+	rng := newPCG32(0, 0, 0)
+	ones := n / 10
+	for placed := 0; placed < ones; {
+		if p := int(rng.next() % uint32(n)); !pattern[p] {
+			toggle(p)
+			placed++
+		}
+	}
+	for i := 0; i < n; i++ {
+		cluster := tightestCluster()
+		toggle(cluster)
+		void := largestVoid()
+		toggle(void)
+		if void == cluster {
+			break
+		}
+	}
+
+	// the pixels of the initial pattern are ranked by removing the tightest cluster one by one, while all others
+	// are ranked by filling the largest void one by one
+	ranks := make([]uint16, n)
+	initialPattern := append([]bool(nil), pattern...)
+	initialEnergy := append([]float64(nil), energy...)
+	for rank := ones - 1; rank >= 0; rank-- {
+		cluster := tightestCluster()
+		toggle(cluster)
+		ranks[cluster] = uint16(rank)
+	}
+	copy(pattern, initialPattern)
+	copy(energy, initialEnergy)
+	for rank := ones; rank < n; rank++ {
+		void := largestVoid()
+		toggle(void)
+		ranks[void] = uint16(rank)
+	}
+	return ranks
+}
@@ -0,0 +1,156 @@
+package ocl
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSampler(t *testing.T) {
+	for _, sampler := range []Sampler{Independent, Sobol, BlueNoise} {
+		parsed, err := ParseSampler(sampler.String())
+		assert.NoError(t, err)
+		assert.Equal(t, sampler, parsed)
+	}
+	_, err := ParseSampler("halton")
+	assert.Error(t, err)
+}
+
+func TestSobolDirectionsMatchKernel(t *testing.T) {
+	start := strings.Index(kernelSource, "SOBOL_DIRECTIONS[4][32] = {")
+	end := strings.Index(kernelSource[start:], "};")
+	numbers := regexp.MustCompile(`0x[0-9a-f]{8}u`).FindAllString(kernelSource[start:start+end], -1)
+	assert.Len(t, numbers, 4*32)
+	for i, number := range numbers {
+		v, err := strconv.ParseUint(strings.TrimSuffix(number, "u"), 0, 32)
+		assert.NoError(t, err)
+		assert.Equal(t, sobolDirections[i/32][i%32], uint32(v), "direction %d of dimension %d", i%32, i/32)
+	}
+}
+
+func TestOwenSobolIsStratified(t *testing.T) {
+	// the first 16 points of each dimension fall into the 16 intervals of width 1/16 once each, and the first two
+	// dimensions into the 16 boxes of every shape of area 1/16 once each
+	xs, ys, zs, ws := make([]float64, 16), make([]float64, 16), make([]float64, 16), make([]float64, 16)
+	for i := uint32(0); i < 16; i++ {
+		xs[i], ys[i] = owenSobol(i, 0, 1234)
+		zs[i], ws[i] = owenSobol(i, 2, 1234)
+	}
+	for _, dimension := range [][]float64{xs, ys, zs, ws} {
+		assertStratified(t, dimension, make([]float64, 16), 16, 1)
+	}
+	for _, columns := range []int{1, 2, 4, 8, 16} {
+		assertStratified(t, xs, ys, columns, 16/columns)
+	}
+}
+
+func assertStratified(t *testing.T, xs, ys []float64, columns, rows int) {
+	boxes := map[int]int{}
+	for i := range xs {
+		boxes[int(ys[i]*float64(rows))*columns+int(xs[i]*float64(columns))]++
+	}
+	assert.Len(t, boxes, columns*rows, "%dx%d boxes", columns, rows)
+}
+
+// Test_SamplerConvergence measures the RMSE of each sampler over 64x64 pixels of an analytic scene: a pixel half
+// covered by the edge of a diffuse surface, lit by a quarter of the sky above 30° elevation. The surface is sampled
+// with pixel jitter, and the light with uniform hemisphere samples of the BSDF dimension of the first bounce.
+func Test_SamplerConvergence(t *testing.T) {
+	// the edge x + 0.6y = 0.8 covers half the pixel, and the cosine weighted solid angle of the light is
+	// π/2 * sin²(60°)/2
+	expected := 0.5 * math.Pi / 2 * 0.375
+	estimate := func(kind Sampler, x, y, samples uint32) float64 {
+		sum := 0.0
+		for n := uint32(0); n < samples; n++ {
+			s := newSampler(kind, 7, x, y, blueNoiseSize, n)
+			s.dimension = pixelDimension
+			px, py := s.sample2D()
+			s.dimension = firstBSDFDimension
+			cosTheta, phi := s.sample2D()
+			if px+0.6*py < 0.8 && cosTheta > 0.5 && phi < 0.25 {
+				sum += 2 * math.Pi * cosTheta
+			}
+		}
+		return sum / float64(samples)
+	}
+	rmse := func(kind Sampler, samples uint32) float64 {
+		sum := 0.0
+		for y := uint32(0); y < blueNoiseSize; y++ {
+			for x := uint32(0); x < blueNoiseSize; x++ {
+				err := estimate(kind, x, y, samples) - expected
+				sum += err * err
+			}
+		}
+		return math.Sqrt(sum / (blueNoiseSize * blueNoiseSize))
+	}
+
+	independent := rmse(Independent, 256)
+	testCases := []struct {
+		sampler Sampler
+		// the RMSE at 256 samples relative to that of the independent sampler
+		maxRelativeRMSE float64
+		// the RMSE at 16 samples relative to that at 256 samples, 4 for plain Monte Carlo
+		minSpeedup float64
+	}{
+		{Independent, 1.0, 3.5},
+		{Sobol, 0.4, 10},
+		{BlueNoise, 0.6, 5},
+	}
+	for _, tc := range testCases {
+		low, high := rmse(tc.sampler, 16), rmse(tc.sampler, 256)
+		t.Logf("%v: RMSE %.5f at 16 spp, %.5f at 256 spp", tc.sampler, low, high)
+		assert.LessOrEqual(t, high, independent*tc.maxRelativeRMSE, "%v", tc.sampler)
+		assert.Greater(t, low/high, tc.minSpeedup, "%v", tc.sampler)
+	}
+}
+
+// TestBlueNoiseSamplerSpreadsError checks that the error of neighbouring pixels is negatively correlated with the
+// blue noise sampler, i.e. that the noise is blue, while it is uncorrelated with the other samplers.
+func TestBlueNoiseSamplerSpreadsError(t *testing.T) {
+	correlation := func(kind Sampler) float64 {
+		errs := make([]float64, blueNoiseSize*blueNoiseSize)
+		for y := uint32(0); y < blueNoiseSize; y++ {
+			for x := uint32(0); x < blueNoiseSize; x++ {
+				u, _ := newSampler(kind, 7, x, y, blueNoiseSize, 0).sample2D()
+				errs[y*blueNoiseSize+x] = u - 0.5
+			}
+		}
+		sum, variance := 0.0, 0.0
+		for y := 0; y < blueNoiseSize; y++ {
+			for x := 0; x < blueNoiseSize; x++ {
+				err := errs[y*blueNoiseSize+x]
+				right := errs[y*blueNoiseSize+(x+1)%blueNoiseSize]
+				below := errs[((y+1)%blueNoiseSize)*blueNoiseSize+x]
+				sum += err * (right + below) / 2
+				variance += err * err
+			}
+		}
+		return sum / variance
+	}
+	assert.Less(t, correlation(BlueNoise), -0.1)
+	assert.InDelta(t, 0.0, correlation(Sobol), 0.05)
+	assert.InDelta(t, 0.0, correlation(Independent), 0.05)
+}
+
+func TestBlueNoiseMask(t *testing.T) {
+	mask := blueNoiseMask()
+
+	// every rank once
+	seen := make([]bool, len(mask))
+	for _, rank := range mask {
+		seen[rank] = true
+	}
+	assert.NotContains(t, seen, false)
+
+	// neighbours differ more than the third of the range they would on average for white noise
+	sum := 0.0
+	for i, rank := range mask {
+		x, y := i%blueNoiseSize, i/blueNoiseSize
+		sum += math.Abs(float64(rank) - float64(mask[y*blueNoiseSize+(x+1)%blueNoiseSize]))
+	}
+	assert.Greater(t, sum/float64(len(mask)), 1.15*float64(len(mask))/3)
+}
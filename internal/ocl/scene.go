@@ -1,106 +1,607 @@
 package ocl
 
 import (
+	"fmt"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
 	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
 )
 
+// maxObjects is the largest number of top level objects the kernel can hold, see MAX_OBJECTS in tracer.cl. CSG
+// operands and the primitives of groups are objects of their own, so they count towards it.
+const maxObjects = 16
+
 // All below should go into a struct to avoid package-scoped state.
 var globalTriangleOffset = int32(0)
 var globalGroupOffset = int32(-1)
 
 var triangles = make([]CLTriangle, 0) // global list of ALL triangles
 var groups = make([]CLGroup, 0)       // global list of ALL groups
+var instances = make([]CLInstance, 0) // global list of ALL mesh instances
+var materials = make([]CLMaterial, 0) // global list of ALL triangle materials
+var patterns = make([]CLPattern, 0)   // global list of ALL patterns
+
+// builtMaterials maps materials to their index in materials, so that triangles sharing a material share its entry.
+var builtMaterials = make(map[material.Material]int32)
+
+// materialIDs numbers the materials of objects and triangles in the order they are built, for the material ID AOV.
+var materialIDs = make(map[material.Material]int32)
 
-func BuildSceneBufferCL(in []shapes.Shape) ([]CLObject, []CLTriangle, []CLGroup) {
+// builtGroups maps groups to their index in groups, so that meshes shared by many instances are only built once.
+var builtGroups = make(map[builtGroupKey]int32)
+
+// builtGroupKey identifies a built group. The same group built with another transform or inherited material is another
+// set of triangles.
+type builtGroupKey struct {
+	group     *shapes.Group
+	transform geom.Mat4x4
+	inherits  bool
+	inherited material.Material
+}
+
+// BuildSceneBufferCL converts the shapes to the buffers of the kernel. Each call starts over with empty triangle,
+// group, instance, material and pattern lists, so it may be called again for every frame of an animation. Shapes the
+// kernel doesn't support, and CSG nodes with unknown operations, are rejected with an error.
+func BuildSceneBufferCL(in []shapes.Shape) ([]CLObject, []CLTriangle, []CLGroup, []CLInstance, []CLMaterial, []CLPattern, error) {
+	resetSceneBuffers()
 
 	objs := make([]CLObject, 0)
 	for i := range in {
-		lbl := [8]byte{0, 0, 0, 0, 0, 0, 0, 0}
-		copy(lbl[:], in[i].Lbl())
-
-		obj := CLObject{}
-		obj.Label = lbl
-		obj.Transform = in[i].GetTransform()
-		obj.Inverse = in[i].GetInverse()
-		obj.InverseTranspose = in[i].GetInverseTranspose()
-		obj.Color = in[i].GetMaterial().Color
-		obj.Emission = in[i].GetMaterial().Emission
-		obj.RefractiveIndex = in[i].GetMaterial().RefractiveIndex
-		obj.Children = initToMinus1()
-
-		if in[i].GetMaterial().Textured {
-			obj.IsTextured = true
-			obj.TextureIndex = in[i].GetMaterial().TextureID
-			obj.TextureScaleX = in[i].GetMaterial().TextureScaleX
-			obj.TextureScaleY = in[i].GetMaterial().TextureScaleY
-		}
-		if in[i].GetMaterial().TexturedNM {
-			obj.IsTexturedNM = true
-			obj.TextureIndexNM = in[i].GetMaterial().TextureIDNM
-			obj.TextureScaleXNM = in[i].GetMaterial().TextureScaleXNM
-			obj.TextureScaleYNM = in[i].GetMaterial().TextureScaleYNM
-		}
-		obj.IsEnvMap = in[i].GetMaterial().IsEnvMap
-
-		switch in[i].(type) {
-		case *shapes.Plane:
-			obj.Type = 0
-		case *shapes.Sphere:
-			obj.Type = 1
-		case *shapes.Cylinder:
-			obj.Type = 2
-			obj.MinY = in[i].(*shapes.Cylinder).MinY
-			obj.MaxY = in[i].(*shapes.Cylinder).MaxY
-		case *shapes.Cube:
-			obj.Type = 3
-		case *shapes.Group:
-			obj.Type = 4
-			obj.BBMin = in[i].(*shapes.Group).BoundingBox.Min
-			obj.BBMax = in[i].(*shapes.Group).BoundingBox.Max
-
-			// Let's try a hack. If the group's direct children contains max 2 groups, we can pass it "as is". Otherwise,
-			// we need to split it into N children on this obj
-			// we need to loop over the groups and add each one as a child index to this obj
-			idx := 0
-			for j := range in[i].(*shapes.Group).Children {
-				group, ok := in[i].(*shapes.Group).Children[j].(*shapes.Group)
-				if ok {
-					obj.Children[idx] = BuildCLGroup(group)
-					idx++
-					obj.ChildCount++
+		var err error
+		if objs, err = appendCLObject(objs, in[i], nil); err != nil {
+			return nil, nil, nil, nil, nil, nil, err
+		}
+	}
+	if len(objs) > maxObjects {
+		return nil, nil, nil, nil, nil, nil, fmt.Errorf("the scene has %d objects, max is %d", len(objs), maxObjects)
+	}
+	return objs, triangles, groups, instances, materials, patterns, nil
+}
+
+func resetSceneBuffers() {
+	globalTriangleOffset = 0
+	globalGroupOffset = -1
+	triangles = make([]CLTriangle, 0)
+	groups = make([]CLGroup, 0)
+	instances = make([]CLInstance, 0)
+	materials = make([]CLMaterial, 0)
+	patterns = make([]CLPattern, 0)
+	builtMaterials = make(map[material.Material]int32)
+	materialIDs = make(map[material.Material]int32)
+	builtGroups = make(map[builtGroupKey]int32)
+}
+
+// appendCLObject appends the OpenCL representation of the shape to objs. CSG nodes are flattened in pre-order, i.e. the
+// node followed by the objects of its left and then its right operand, with children[0] and children[1] of the node
+// pointing at its operands and children[2] at the first object after them. parentTransform is the transform of the
+// enclosing CSG node, or nil for top-level shapes.
+func appendCLObject(objs []CLObject, shape shapes.Shape, parentTransform *geom.Mat4x4) ([]CLObject, error) {
+	lbl := [8]byte{0, 0, 0, 0, 0, 0, 0, 0}
+	copy(lbl[:], shape.Lbl())
+
+	obj := CLObject{}
+	obj.Label = lbl
+	obj.Transform = shape.GetTransform()
+	obj.Inverse = shape.GetInverse()
+	obj.InverseTranspose = shape.GetInverseTranspose()
+	if parentTransform != nil {
+		// operands of CSG nodes are stored in world space, since the kernel has no notion of parents
+		obj.Transform = geom.Multiply(*parentTransform, obj.Transform)
+		obj.Inverse = geom.Inverse(obj.Transform)
+		obj.InverseTranspose = geom.Transpose(obj.Inverse)
+	}
+	obj.Color = shape.GetMaterial().Color
+	obj.Emission = shape.GetMaterial().Emission
+	obj.RefractiveIndex = shape.GetMaterial().RefractiveIndex
+	obj.Children = initToMinus1()
+
+	if shape.GetMaterial().Textured {
+		obj.IsTextured = true
+		obj.TextureIndex = shape.GetMaterial().TextureID
+		obj.TextureScaleX = shape.GetMaterial().TextureScaleX
+		obj.TextureScaleY = shape.GetMaterial().TextureScaleY
+	}
+	if shape.GetMaterial().TexturedNM {
+		obj.IsTexturedNM = true
+		obj.TextureIndexNM = shape.GetMaterial().TextureIDNM
+		obj.TextureScaleXNM = shape.GetMaterial().TextureScaleXNM
+		obj.TextureScaleYNM = shape.GetMaterial().TextureScaleYNM
+		obj.StrengthNM = float32(shape.GetMaterial().StrengthNM)
+	}
+	obj.IsEnvMap = shape.GetMaterial().IsEnvMap
+	obj.Roughness = shape.GetMaterial().Roughness
+	obj.Metalness = shape.GetMaterial().Metalness
+	obj.Eta = shape.GetMaterial().Eta
+	obj.K = shape.GetMaterial().K
+	obj.Specular = shape.GetMaterial().Specular
+	obj.Clearcoat = shape.GetMaterial().Clearcoat
+	obj.ClearcoatRoughness = shape.GetMaterial().ClearcoatRoughness
+	obj.Sheen = shape.GetMaterial().Sheen
+	obj.Transmission = shape.GetMaterial().Transmission
+	obj.Absorption = shape.GetMaterial().Absorption
+	obj.AbbeNumber = shape.GetMaterial().AbbeNumber
+	obj.MaterialID = materialID(shape.GetMaterial())
+	var err error
+	if obj.ColorPattern, err = addCLPattern(shape.GetMaterial().ColorPattern, 0.0); err != nil {
+		return nil, err
+	}
+	if obj.RoughnessPattern, err = addCLPattern(shape.GetMaterial().RoughnessPattern, 0.0); err != nil {
+		return nil, err
+	}
+	if obj.BumpPattern, err = addCLPattern(shape.GetMaterial().BumpPattern, shape.GetMaterial().BumpStrength); err != nil {
+		return nil, err
+	}
+
+	switch shape.(type) {
+	case *shapes.Plane:
+		obj.Type = 0
+	case *shapes.Sphere:
+		obj.Type = 1
+	case *shapes.Cylinder:
+		obj.Type = 2
+		obj.MinY = shape.(*shapes.Cylinder).MinY
+		obj.MaxY = shape.(*shapes.Cylinder).MaxY
+	case *shapes.Cube:
+		obj.Type = 3
+	case *shapes.Cone:
+		obj.Type = 5
+		if shape.(*shapes.Cone).Closed {
+			obj.Type = 6
+		}
+		obj.MinY = shape.(*shapes.Cone).MinY
+		obj.MaxY = shape.(*shapes.Cone).MaxY
+	case *shapes.Disk:
+		obj.Type = 7
+	case *shapes.Torus:
+		obj.Type = 8
+		obj.MinorRadius = shape.(*shapes.Torus).MinorRadius
+	case *shapes.Group:
+		obj.Type = 4
+		obj.BBMin = shape.(*shapes.Group).BoundingBox.Min
+		obj.BBMax = shape.(*shapes.Group).BoundingBox.Max
+
+		// The triangles and instances directly in the group are put in a group of their own, while each subgroup
+		// becomes a child of the object, with its transform baked into it. Any other shapes are added as objects of
+		// their own, see appendGroupPrimitives.
+		var inherited *material.Material
+		if shape.(*shapes.Group).HasMaterial {
+			inherited = &shape.(*shapes.Group).Material
+		}
+		bounds := shapes.NewEmptyBoundingBox()
+		tris := make([]*shapes.Triangle, 0)
+		clInstances := make([]CLInstance, 0)
+		subgroups := make([]*shapes.Group, 0)
+		for _, child := range shape.(*shapes.Group).Children {
+			switch c := child.(type) {
+			case *shapes.Group:
+				subgroups = append(subgroups, c)
+			case *shapes.Triangle:
+				tris = append(tris, c)
+				bounds.MergeWith(shapes.BoundsOf(c))
+			case *shapes.Instance:
+				clInstance, err := newCLInstance(c, c.GetTransform(), c.GetInverse(), c.GetInverseTranspose())
+				if err != nil {
+					return nil, err
+				}
+				clInstances = append(clInstances, clInstance)
+				bounds.MergeWith(shapes.ParentSpaceBounds(c))
+			}
+		}
+		idx := 0
+		if len(tris) > 0 || len(clInstances) > 0 {
+			if obj.Children[idx], err = buildCLLeafGroup(bounds, tris, clInstances, inherited); err != nil {
+				return nil, err
+			}
+			idx++
+		}
+		for i, subgroup := range subgroups {
+			if idx == len(obj.Children)-1 && i < len(subgroups)-1 {
+				// out of children, so the remaining subgroups are linked through a group of their own
+				if obj.Children[idx], err = buildCLLinkGroup(subgroups[i:], geom.New4x4(), inherited); err != nil {
+					return nil, err
 				}
+				idx++
+				break
 			}
+			if obj.Children[idx], err = BuildCLGroup(subgroup, subgroup.GetTransform(), inherited); err != nil {
+				return nil, err
+			}
+			idx++
+		}
+		obj.ChildCount = int32(idx)
+
+	case *shapes.Triangle:
+		// a top-level triangle becomes a group object holding a single group with just the triangle
+		tri := shape.(*shapes.Triangle)
+		bounds := shapes.BoundsOf(tri)
+		obj.Type = 4
+		obj.BBMin = bounds.Min
+		obj.BBMax = bounds.Max
+		if obj.Children[0], err = buildCLLeafGroup(bounds, []*shapes.Triangle{tri}, nil, nil); err != nil {
+			return nil, err
+		}
+		obj.ChildCount = 1
+
+	case *shapes.Instance:
+		// A top-level instance becomes a group object holding a single node with just the instance. The object has
+		// the transform of the instance, so the instance itself gets an identity transform.
+		inst := shape.(*shapes.Instance)
+		bounds := shapes.BoundsOf(inst)
+		obj.Type = 4
+		obj.BBMin = bounds.Min
+		obj.BBMax = bounds.Max
+		clInstance, err := newCLInstance(inst, geom.New4x4(), geom.New4x4(), geom.New4x4())
+		if err != nil {
+			return nil, err
+		}
+		if obj.Children[0], err = buildCLLeafGroup(bounds, nil, []CLInstance{clInstance}, nil); err != nil {
+			return nil, err
+		}
+		obj.ChildCount = 1
+
+	case *shapes.CSG:
+		switch shape.(*shapes.CSG).Operation {
+		case "union":
+			obj.Type = 9
+		case "intersection":
+			obj.Type = 10
+		case "difference":
+			obj.Type = 11
+		default:
+			return nil, fmt.Errorf("unknown CSG operation %q of %v", shape.(*shapes.CSG).Operation, shape.Lbl())
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported shape type %T of %v", shape, shape.Lbl())
+	}
+
+	obj.Reflectivity = shape.GetMaterial().Reflectivity
 
+	objs = append(objs, obj)
+
+	if group, ok := shape.(*shapes.Group); ok {
+		if obj.ChildCount == 0 {
+			// nothing for the kernel to traverse, e.g. a group of primitives only
+			objs = objs[:len(objs)-1]
+		}
+		return appendGroupPrimitives(objs, group, geom.Mat4x4(obj.Transform))
+	}
+
+	if csg, ok := shape.(*shapes.CSG); ok {
+		idx := len(objs) - 1
+		transform := geom.Mat4x4(obj.Transform)
+		objs[idx].ChildCount = 2
+		objs[idx].Children[0] = int32(len(objs))
+		if objs, err = appendCLObject(objs, csg.Left, &transform); err != nil {
+			return nil, err
+		}
+		objs[idx].Children[1] = int32(len(objs))
+		if objs, err = appendCLObject(objs, csg.Right, &transform); err != nil {
+			return nil, err
+		}
+		objs[idx].Children[2] = int32(len(objs))
+		return objs, nil
+	}
+	return objs, nil
+}
+
+// appendGroupPrimitives appends all shapes in the group and its subgroups which aren't triangles or instances, since the
+// kernel can't traverse those as part of the group. They're added as objects of their own instead, with the transforms
+// of the groups they're in applied, and count towards maxObjects.
+func appendGroupPrimitives(objs []CLObject, group *shapes.Group, transform geom.Mat4x4) ([]CLObject, error) {
+	var err error
+	for _, child := range group.Children {
+		switch c := child.(type) {
+		case *shapes.Triangle, *shapes.Instance:
+		case *shapes.Group:
+			objs, err = appendGroupPrimitives(objs, c, geom.Multiply(transform, c.GetTransform()))
 		default:
-			obj.Type = 999
+			objs, err = appendCLObject(objs, c, &transform)
+		}
+		if err != nil {
+			return nil, err
 		}
+	}
+	return objs, nil
+}
+
+// addCLPattern appends the pattern to patterns and returns its index + 1, which is how objects refer to patterns
+// since 0 means no pattern.
+func addCLPattern(pattern *material.Pattern, strength float64) (uint8, error) {
+	if pattern == nil {
+		return 0, nil
+	}
+	if len(patterns) >= 255 {
+		return 0, fmt.Errorf("too many patterns in scene, max is 255")
+	}
+	patterns = append(patterns, CLPattern{
+		Inverse:    pattern.Inverse,
+		A:          pattern.A,
+		B:          pattern.B,
+		Turbulence: pattern.Turbulence,
+		Strength:   strength,
+		Type:       int32(pattern.Type),
+		Octaves:    int32(pattern.Octaves),
+		Padding:    [40]byte{},
+	})
+	return uint8(len(patterns)), nil
+}
 
-		obj.Reflectivity = in[i].GetMaterial().Reflectivity
+// addCLMaterial returns the index of the material in materials, appending it unless already there.
+func addCLMaterial(m material.Material) (int32, error) {
+	if idx, ok := builtMaterials[m]; ok {
+		return idx, nil
+	}
+	colorPattern, err := addCLPattern(m.ColorPattern, 0.0)
+	if err != nil {
+		return 0, err
+	}
+	roughnessPattern, err := addCLPattern(m.RoughnessPattern, 0.0)
+	if err != nil {
+		return 0, err
+	}
+	bumpPattern, err := addCLPattern(m.BumpPattern, m.BumpStrength)
+	if err != nil {
+		return 0, err
+	}
+	clMaterial := CLMaterial{
+		Color:              m.Color,
+		Emission:           m.Emission,
+		Eta:                m.Eta,
+		K:                  m.K,
+		Absorption:         m.Absorption,
+		RefractiveIndex:    m.RefractiveIndex,
+		Reflectivity:       m.Reflectivity,
+		Roughness:          m.Roughness,
+		Metalness:          m.Metalness,
+		Specular:           m.Specular,
+		Clearcoat:          m.Clearcoat,
+		ClearcoatRoughness: m.ClearcoatRoughness,
+		Sheen:              m.Sheen,
+		Transmission:       m.Transmission,
+		AbbeNumber:         m.AbbeNumber,
+		ColorPattern:       colorPattern,
+		RoughnessPattern:   roughnessPattern,
+		BumpPattern:        bumpPattern,
+		MaterialID:         materialID(m),
+		Padding:            [224]byte{},
+	}
+	if m.Textured {
+		clMaterial.IsTextured = true
+		clMaterial.TextureIndex = m.TextureID
+		clMaterial.TextureScaleX = m.TextureScaleX
+		clMaterial.TextureScaleY = m.TextureScaleY
+	}
+	if m.TexturedNM {
+		clMaterial.IsTexturedNM = true
+		clMaterial.TextureIndexNM = m.TextureIDNM
+		clMaterial.TextureScaleXNM = m.TextureScaleXNM
+		clMaterial.TextureScaleYNM = m.TextureScaleYNM
+		clMaterial.StrengthNM = float32(m.StrengthNM)
+	}
+	materials = append(materials, clMaterial)
+	builtMaterials[m] = int32(len(materials) - 1)
+	return int32(len(materials) - 1), nil
+}
 
-		// finally, pad!
-		obj.Padding5 = [167]byte{}
+// materialID returns the ID of the material, numbering new materials from 0 on.
+func materialID(m material.Material) int32 {
+	if id, ok := materialIDs[m]; ok {
+		return id
+	}
+	materialIDs[m] = int32(len(materialIDs))
+	return materialIDs[m]
+}
 
-		objs = append(objs, obj)
+// triangleMaterial returns the index of the material of the triangle. A material inherited from a group replaces that of
+// the triangle, except for the color, since .obj models often use a color per part but no other material properties.
+func triangleMaterial(tri *shapes.Triangle, inherited *material.Material) (int32, error) {
+	m := tri.GetMaterial()
+	if inherited != nil {
+		color := m.Color
+		m = *inherited
+		m.Color = color
 	}
-	return objs, triangles, groups
+	return addCLMaterial(m)
 }
 
-func initToMinus1() [64]int32 {
-	out := [64]int32{}
-	for i := 0; i < 64; i++ {
+func initToMinus1() [62]int32 {
+	out := [62]int32{}
+	for i := 0; i < len(out); i++ {
 		out[i] = -1
 	}
 	return out
 }
 
-func BuildCLGroup(group *shapes.Group) int32 {
-	groups = append(groups, CLGroup{Children: [2]int32{}, Padding: [108]byte{}})
+// newCLInstance returns the OpenCL representation of the instance with the passed transform, building the mesh of the
+// instance unless already built. The transform of the mesh itself is baked into the mesh.
+func newCLInstance(inst *shapes.Instance, transform, inverse, inverseTranspose geom.Mat4x4) (CLInstance, error) {
+	root, err := BuildCLGroup(inst.Mesh, inst.Mesh.GetTransform(), nil)
+	if err != nil {
+		return CLInstance{}, err
+	}
+	out := CLInstance{
+		Transform:        transform,
+		Inverse:          inverse,
+		InverseTranspose: inverseTranspose,
+		Root:             root,
+		Material:         -1,
+		Padding:          [120]byte{},
+	}
+	if inst.HasMaterial {
+		if out.Material, err = addCLMaterial(inst.GetMaterial()); err != nil {
+			return CLInstance{}, err
+		}
+	}
+	return out, nil
+}
+
+// newCLTriangle returns the OpenCL representation of the triangle, see triangleMaterial for inherited.
+func newCLTriangle(tri *shapes.Triangle, inherited *material.Material) (CLTriangle, error) {
+	materialIndex, err := triangleMaterial(tri, inherited)
+	if err != nil {
+		return CLTriangle{}, err
+	}
+	return CLTriangle{
+		P1:       tri.P1,
+		P2:       tri.P2,
+		P3:       tri.P3,
+		N1:       tri.N1,
+		N2:       tri.N2,
+		N3:       tri.N3,
+		UV1:      [2]float64{tri.UV1[0], tri.UV1[1]},
+		UV2:      [2]float64{tri.UV2[0], tri.UV2[1]},
+		UV3:      [2]float64{tri.UV3[0], tri.UV3[1]},
+		Tan1:     tri.Tan1,
+		Tan2:     tri.Tan2,
+		Tan3:     tri.Tan3,
+		Material: materialIndex,
+		Padding:  [4]byte{},
+	}, nil
+}
+
+// transformCLTriangle returns the triangle with the transform baked into its vertices, normals and tangents.
+func transformCLTriangle(tri CLTriangle, transform geom.Mat4x4) CLTriangle {
+	inverseTranspose := geom.Transpose(geom.Inverse(transform))
+	point := func(p [4]float64) [4]float64 {
+		return geom.MultiplyByTuple(transform, p)
+	}
+	vector := func(v [4]float64) [4]float64 {
+		v[3] = 0.0
+		return geom.MultiplyByTuple(transform, v)
+	}
+	normal := func(n [4]float64) [4]float64 {
+		n[3] = 0.0
+		out := geom.MultiplyByTuple(inverseTranspose, n)
+		out[3] = 0.0
+		return geom.Normalize(out)
+	}
+	// the handedness of the bitangent flips if the transform mirrors the triangle
+	handedness := 1.0
+	if geom.Determinant4x4(transform) < 0 {
+		handedness = -1.0
+	}
+	tangent := func(t [4]float64) [4]float64 {
+		out := vector(t)
+		out[3] = t[3] * handedness
+		return out
+	}
+
+	tri.P1, tri.P2, tri.P3 = point(tri.P1), point(tri.P2), point(tri.P3)
+	tri.N1, tri.N2, tri.N3 = normal(tri.N1), normal(tri.N2), normal(tri.N3)
+	tri.Tan1, tri.Tan2, tri.Tan3 = tangent(tri.Tan1), tangent(tri.Tan2), tangent(tri.Tan3)
+	return tri
+}
+
+// buildCLLeafGroup appends a group without subgroups holding just the passed triangles and instances, returning the
+// index of the group. It's used for triangles and instances that aren't part of a group the kernel can traverse, e.g.
+// those directly in a top-level group.
+func buildCLLeafGroup(bounds *shapes.BoundingBox, tris []*shapes.Triangle, clInstances []CLInstance, inherited *material.Material) (int32, error) {
+	groups = append(groups, CLGroup{
+		BBMin:           bounds.Min,
+		BBMax:           bounds.Max,
+		TriOffset:       globalTriangleOffset,
+		TriCount:        int32(len(tris)),
+		ChildGroupCount: -1,
+		InstOffset:      int32(len(instances)),
+		InstCount:       int32(len(clInstances)),
+		Padding:         [100]byte{},
+	})
+	globalGroupOffset++
+	for _, tri := range tris {
+		clTriangle, err := newCLTriangle(tri, inherited)
+		if err != nil {
+			return 0, err
+		}
+		triangles = append(triangles, clTriangle)
+	}
+	globalTriangleOffset += int32(len(tris))
+	instances = append(instances, clInstances...)
+	return globalGroupOffset, nil
+}
+
+// linkCLSubgroups builds the subgroups and links them as children of the group with index parent, which has the passed
+// transform baked into it and passes on the inherited material. Since the kernel only supports binary trees, groups
+// with more than two subgroups link the rest through a group of their own.
+func linkCLSubgroups(parent int32, subgroups []*shapes.Group, transform geom.Mat4x4, inherited *material.Material) error {
+	// the children are built before being assigned since building appends to, and may reallocate, groups
+	switch len(subgroups) {
+	case 0:
+		// mark as having no subgroups
+		groups[parent].ChildGroupCount = int32(-1)
+	case 1:
+		left, err := BuildCLGroup(subgroups[0], geom.Multiply(transform, subgroups[0].GetTransform()), inherited)
+		if err != nil {
+			return err
+		}
+		groups[parent].Children[0] = left
+		groups[parent].ChildGroupCount = 1
+	default:
+		left, err := BuildCLGroup(subgroups[0], geom.Multiply(transform, subgroups[0].GetTransform()), inherited)
+		if err != nil {
+			return err
+		}
+		right := int32(0)
+		if len(subgroups) == 2 {
+			right, err = BuildCLGroup(subgroups[1], geom.Multiply(transform, subgroups[1].GetTransform()), inherited)
+		} else {
+			right, err = buildCLLinkGroup(subgroups[1:], transform, inherited)
+		}
+		if err != nil {
+			return err
+		}
+		groups[parent].Children = [2]int32{left, right}
+		groups[parent].ChildGroupCount = 2
+	}
+	return nil
+}
+
+// buildCLLinkGroup appends a group without triangles whose children are the passed subgroups, returning the index of
+// the group. The transform and inherited material are those of the group the subgroups belong to.
+func buildCLLinkGroup(subgroups []*shapes.Group, transform geom.Mat4x4, inherited *material.Material) (int32, error) {
+	bounds := shapes.NewEmptyBoundingBox()
+	for _, subgroup := range subgroups {
+		bounds.MergeWith(shapes.TransformBoundingBox(subgroup.BoundingBox, geom.Multiply(transform, subgroup.GetTransform())))
+	}
+	groups = append(groups, CLGroup{BBMin: bounds.Min, BBMax: bounds.Max, Padding: [100]byte{}})
+	globalGroupOffset++
+	link := globalGroupOffset
+	if err := linkCLSubgroups(link, subgroups, transform, inherited); err != nil {
+		return 0, err
+	}
+	return link, nil
+}
+
+// BuildCLGroup appends the group and its subgroups, returning the index of the group. The kernel only applies the
+// transform of the top-level object, so the transform from the group's space to that of the object (i.e. including the
+// transform of the group itself) is baked into the triangles, bounds and instances of the group. The triangles get the
+// material inherited from the outermost group with a material, if any, see triangleMaterial.
+func BuildCLGroup(group *shapes.Group, transform geom.Mat4x4, inherited *material.Material) (int32, error) {
+	if inherited == nil && group.HasMaterial {
+		inherited = &group.Material
+	}
+	key := builtGroupKey{group: group, transform: transform}
+	if inherited != nil {
+		key.inherits = true
+		key.inherited = *inherited
+	}
+	if id, ok := builtGroups[key]; ok {
+		return id, nil
+	}
+	identity := geom.Equals(transform, geom.New4x4())
+	groups = append(groups, CLGroup{Children: [2]int32{}, Padding: [100]byte{}})
 	globalGroupOffset++
 	localGroupID := globalGroupOffset
+	builtGroups[key] = localGroupID
 	// materials are tricky. .obj allows changing materials within a group (gopher's eyes for example)
-	// so we need to pass color and emission for every single triangle over to OpenCL... :(
-	groups[localGroupID].BBMin = group.BoundingBox.Min
-	groups[localGroupID].BBMax = group.BoundingBox.Max
+	// so every triangle refers to its own material in the materials buffer.
+	bounds := group.BoundingBox
+	if !identity {
+		bounds = shapes.TransformBoundingBox(bounds, transform)
+	}
+	groups[localGroupID].BBMin = bounds.Min
+	groups[localGroupID].BBMax = bounds.Max
 
 	// for troubleshooting, pass label to padding
 	for i, b := range group.Label {
@@ -114,17 +615,12 @@ func BuildCLGroup(group *shapes.Group) int32 {
 	for _, child := range group.Children {
 		tri, ok := child.(*shapes.Triangle)
 		if ok {
-			clTriangle := CLTriangle{
-				P1:      tri.P1,
-				P2:      tri.P2,
-				P3:      tri.P3,
-				E1:      tri.E1,
-				E2:      tri.E2,
-				N1:      tri.N1,
-				N2:      tri.N2,
-				N3:      tri.N3,
-				Color:   tri.GetMaterial().Color,
-				Padding: [224]byte{},
+			clTriangle, err := newCLTriangle(tri, inherited)
+			if err != nil {
+				return 0, err
+			}
+			if !identity {
+				clTriangle = transformCLTriangle(clTriangle, transform)
 			}
 			triangles = append(triangles, clTriangle)
 			localTrianglesAdded++
@@ -133,23 +629,35 @@ func BuildCLGroup(group *shapes.Group) int32 {
 	groups[localGroupID].TriCount = localTrianglesAdded
 	globalTriangleOffset += localTrianglesAdded
 
+	// Then the instances of THIS group. Their meshes are built first, so that the instances end up next to each other.
+	localInstances := make([]CLInstance, 0)
+	for _, child := range group.Children {
+		inst, ok := child.(*shapes.Instance)
+		if ok {
+			instTransform := geom.Multiply(transform, inst.GetTransform())
+			instInverse := geom.Inverse(instTransform)
+			clInstance, err := newCLInstance(inst, instTransform, instInverse, geom.Transpose(instInverse))
+			if err != nil {
+				return 0, err
+			}
+			localInstances = append(localInstances, clInstance)
+		}
+	}
+	groups[localGroupID].InstOffset = int32(len(instances))
+	groups[localGroupID].InstCount = int32(len(localInstances))
+	instances = append(instances, localInstances...)
+
 	// Once we're done with the triangles, start iterating over any subgroups
-	// Start by recording CURRENT offset
-	numSumGroups := 0
+	subgroups := make([]*shapes.Group, 0)
 	for _, child := range group.Children {
 		grChild, ok := child.(*shapes.Group)
 		if ok {
-			// if group, recurse
-			groups[localGroupID].Children[numSumGroups] = BuildCLGroup(grChild)
-			numSumGroups++
+			subgroups = append(subgroups, grChild)
 		}
 	}
-	if numSumGroups > 0 {
-		groups[localGroupID].ChildGroupCount = int32(numSumGroups)
-	} else {
-		// mark as having no subgroups
-		groups[localGroupID].ChildGroupCount = int32(-1)
+	if err := linkCLSubgroups(localGroupID, subgroups, transform, inherited); err != nil {
+		return 0, err
 	}
 
-	return localGroupID
+	return localGroupID, nil
 }
@@ -0,0 +1,327 @@
+package ocl
+
+import (
+	"math"
+	"testing"
+
+	"github.com/eriklupander/pathtracer-ocl/internal/app/geom"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/material"
+	"github.com/eriklupander/pathtracer-ocl/internal/app/shapes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSceneBufferCL_TopLevelTriangle(t *testing.T) {
+	tri := shapes.NewTriangle3P(geom.NewPoint(0, 0, 0), geom.NewPoint(1, 0, 0), geom.NewPoint(0, 1, 0))
+
+	objs, tris, groups, _, _, _, err := BuildSceneBufferCL([]shapes.Shape{tri})
+	assert.NoError(t, err)
+
+	// a group object holding a group with just the triangle
+	assert.Len(t, objs, 1)
+	assert.Equal(t, int32(4), objs[0].Type)
+	assert.Equal(t, int32(1), objs[0].ChildCount)
+	assert.Equal(t, int32(0), objs[0].Children[0])
+	assert.Len(t, groups, 1)
+	assert.Equal(t, int32(0), groups[0].TriOffset)
+	assert.Equal(t, int32(1), groups[0].TriCount)
+	assert.Equal(t, int32(-1), groups[0].ChildGroupCount)
+	assert.Len(t, tris, 1)
+	assert.Equal(t, [4]float64(tri.P2), tris[0].P2)
+}
+
+func TestBuildSceneBufferCL_MixedGroup(t *testing.T) {
+	sphere := shapes.NewSphere()
+	tri := shapes.NewTriangle3P(geom.NewPoint(0, 0, 0), geom.NewPoint(1, 0, 0), geom.NewPoint(0, 1, 0))
+	subTri := shapes.NewTriangle3P(geom.NewPoint(0, 0, 1), geom.NewPoint(1, 0, 1), geom.NewPoint(0, 1, 1))
+	subgroup := shapes.NewGroup()
+	subgroup.AddChild(subTri)
+	group := shapes.NewGroup()
+	group.AddChildren(sphere, tri, subgroup)
+
+	objs, tris, groups, _, _, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+
+	// the group object traverses a group of the triangle directly in the group and the subgroup, while the sphere
+	// becomes an object of its own
+	assert.Len(t, objs, 2)
+	assert.Equal(t, int32(4), objs[0].Type)
+	assert.Equal(t, int32(2), objs[0].ChildCount)
+	assert.Equal(t, []int32{0, 1, -1}, objs[0].Children[:3])
+	assert.Equal(t, int32(1), objs[1].Type)
+
+	assert.Len(t, groups, 2)
+	assert.Len(t, tris, 2)
+	assert.Equal(t, [4]float64(tri.P1), tris[groups[0].TriOffset].P1)
+	assert.Equal(t, int32(1), groups[0].TriCount)
+	assert.Equal(t, [4]float64(subTri.P1), tris[groups[1].TriOffset].P1)
+	assert.Equal(t, int32(1), groups[1].TriCount)
+}
+
+func TestBuildSceneBufferCL_GroupOfPrimitivesOnly(t *testing.T) {
+	group := shapes.NewGroup()
+	group.AddChildren(shapes.NewSphere(), shapes.NewCube())
+
+	objs, _, groups, _, _, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+	assert.Len(t, objs, 2, "nothing for the kernel to traverse in the group itself")
+	assert.Equal(t, int32(1), objs[0].Type)
+	assert.Equal(t, int32(3), objs[1].Type)
+	assert.Empty(t, groups)
+}
+
+func TestBuildSceneBufferCL_RejectsGroupsOfTooManyPrimitives(t *testing.T) {
+	group := shapes.NewGroup()
+	for i := 0; i <= maxObjects; i++ {
+		group.AddChild(shapes.NewSphere())
+	}
+
+	_, _, _, _, _, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the scene has 17 objects, max is 16")
+	}
+}
+
+// unsupportedShape is a shape the kernel knows nothing about.
+type unsupportedShape struct {
+	*shapes.Sphere
+}
+
+func TestBuildSceneBufferCL_RejectsUnsupportedShapes(t *testing.T) {
+	_, _, _, _, _, _, err := BuildSceneBufferCL([]shapes.Shape{unsupportedShape{shapes.NewSphere()}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported shape type ocl.unsupportedShape")
+	}
+
+	// also within groups and CSG operands
+	group := shapes.NewGroup()
+	group.AddChild(unsupportedShape{shapes.NewSphere()})
+	_, _, _, _, _, _, err = BuildSceneBufferCL([]shapes.Shape{group})
+	assert.Error(t, err)
+	_, _, _, _, _, _, err = BuildSceneBufferCL([]shapes.Shape{shapes.NewCSG("union", shapes.NewSphere(), unsupportedShape{shapes.NewSphere()})})
+	assert.Error(t, err)
+
+	_, _, _, _, _, _, err = BuildSceneBufferCL([]shapes.Shape{shapes.NewCSG("xor", shapes.NewSphere(), shapes.NewCube())})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `unknown CSG operation "xor"`)
+	}
+}
+
+func TestBuildSceneBufferCL_LimitsObjects(t *testing.T) {
+	in := []shapes.Shape{shapes.NewCSG("difference", shapes.NewSphere(), shapes.NewCube())}
+	for len(in) < maxObjects-2 {
+		in = append(in, shapes.NewSphere())
+	}
+	objs, _, _, _, _, _, err := BuildSceneBufferCL(in)
+	assert.NoError(t, err)
+	assert.Len(t, objs, maxObjects)
+
+	// every CSG operand is an object of its own
+	_, _, _, _, _, _, err = BuildSceneBufferCL(append(in, shapes.NewSphere()))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the scene has 17 objects, max is 16")
+	}
+}
+
+func TestBuildSceneBufferCL_LimitsPatterns(t *testing.T) {
+	patterned := func() material.Material {
+		mtrl := material.NewDefaultMaterial()
+		mtrl.ColorPattern = material.NewPattern(material.CheckerPattern, geom.NewColor(1, 1, 1), geom.NewColor(0, 0, 0))
+		return mtrl
+	}
+	group := shapes.NewGroup()
+	for i := 0; i < 255; i++ {
+		tri := newTestTriangle(float64(i))
+		tri.SetMaterial(patterned())
+		group.AddChild(tri)
+	}
+	group.Bounds()
+	_, _, _, _, _, patterns, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+	assert.Len(t, patterns, 255)
+
+	// one more, whether of a triangle or an object, is too many
+	tri := newTestTriangle(255)
+	tri.SetMaterial(patterned())
+	_, _, _, _, _, _, err = BuildSceneBufferCL([]shapes.Shape{group, tri})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "too many patterns in scene, max is 255")
+	}
+	sphere := shapes.NewSphere()
+	sphere.SetMaterial(patterned())
+	_, _, _, _, _, _, err = BuildSceneBufferCL([]shapes.Shape{group, sphere})
+	assert.Error(t, err)
+}
+
+// newTestTriangle returns the triangle (0,0,z), (1,0,z), (0,1,z) with vertex normals along +z.
+func newTestTriangle(z float64) *shapes.Triangle {
+	n := geom.NewVector(0, 0, 1)
+	return shapes.NewTriangle(geom.NewPoint(0, 0, z), geom.NewPoint(1, 0, z), geom.NewPoint(0, 1, z), n, n, n)
+}
+
+// newTestGroup returns a group with the transform and children, with its bounds computed like the scenes do.
+func newTestGroup(transform geom.Mat4x4, children ...shapes.Shape) *shapes.Group {
+	group := shapes.NewGroup()
+	group.SetTransform(transform)
+	group.AddChildren(children...)
+	group.Bounds()
+	return group
+}
+
+func assertTriangleInDelta(t *testing.T, expected [6]geom.Tuple4, actual CLTriangle) {
+	for i, v := range [][4]float64{actual.P1, actual.P2, actual.P3, actual.N1, actual.N2, actual.N3} {
+		assertTupleInDelta(t, expected[i], v)
+	}
+}
+
+func TestBuildCLGroup_BakesSubgroupTransforms(t *testing.T) {
+	// a translated subgroup, and a rotated subgroup with a translated subgroup of its own
+	translated := newTestGroup(geom.Translate(1, 2, 3), newTestTriangle(0))
+	inner := newTestGroup(geom.Translate(0, 0, 5), newTestTriangle(0))
+	rotated := newTestGroup(geom.RotateY(math.Pi/2), inner)
+	group := newTestGroup(geom.New4x4(), translated, rotated)
+
+	objs, tris, groups, _, _, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+	assert.Len(t, objs, 1)
+	assert.Equal(t, int32(2), objs[0].ChildCount)
+	assert.Len(t, tris, 2)
+
+	z := geom.NewVector(0, 0, 1)
+	g := groups[objs[0].Children[0]]
+	assert.Equal(t, int32(1), g.TriCount)
+	assertTriangleInDelta(t, [6]geom.Tuple4{geom.NewPoint(1, 2, 3), geom.NewPoint(2, 2, 3), geom.NewPoint(1, 3, 3), z, z, z}, tris[g.TriOffset])
+	assertTupleInDelta(t, geom.NewPoint(1, 2, 3), g.BBMin)
+	assertTupleInDelta(t, geom.NewPoint(2, 3, 3), g.BBMax)
+
+	// the rotation turns +z into +x, and the inner group is rotated along with its own translation
+	g = groups[objs[0].Children[1]]
+	assert.Equal(t, int32(0), g.TriCount)
+	assert.Equal(t, int32(1), g.ChildGroupCount)
+	assertTupleInDelta(t, geom.NewPoint(5, 0, -1), g.BBMin)
+	assertTupleInDelta(t, geom.NewPoint(5, 1, 0), g.BBMax)
+	g = groups[g.Children[0]]
+	assert.Equal(t, int32(1), g.TriCount)
+	x := geom.NewVector(1, 0, 0)
+	assertTriangleInDelta(t, [6]geom.Tuple4{geom.NewPoint(5, 0, 0), geom.NewPoint(5, 0, -1), geom.NewPoint(5, 1, 0), x, x, x}, tris[g.TriOffset])
+	assertTupleInDelta(t, geom.NewPoint(5, 0, -1), g.BBMin)
+	assertTupleInDelta(t, geom.NewPoint(5, 1, 0), g.BBMax)
+}
+
+func TestBuildCLGroup_LinksMoreThanTwoSubgroups(t *testing.T) {
+	// the third subgroup of a translated subgroup is linked through a group of its own, which bakes in the translation
+	// too
+	subgroup := newTestGroup(geom.Translate(0, 10, 0),
+		newTestGroup(geom.New4x4(), newTestTriangle(1)),
+		newTestGroup(geom.New4x4(), newTestTriangle(2)),
+		newTestGroup(geom.Translate(2, 0, 0), newTestTriangle(3)))
+	group := newTestGroup(geom.New4x4(), subgroup)
+
+	objs, tris, groups, _, _, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+	g := groups[objs[0].Children[0]]
+	assert.Equal(t, int32(2), g.ChildGroupCount)
+
+	link := groups[g.Children[1]]
+	assert.Equal(t, int32(0), link.TriCount)
+	assert.Equal(t, int32(2), link.ChildGroupCount)
+	assertTupleInDelta(t, geom.NewPoint(0, 10, 2), link.BBMin)
+	assertTupleInDelta(t, geom.NewPoint(3, 11, 3), link.BBMax)
+
+	z := geom.NewVector(0, 0, 1)
+	third := groups[link.Children[1]]
+	assertTriangleInDelta(t, [6]geom.Tuple4{geom.NewPoint(2, 10, 3), geom.NewPoint(3, 10, 3), geom.NewPoint(2, 11, 3), z, z, z}, tris[third.TriOffset])
+	assertTupleInDelta(t, geom.NewPoint(2, 10, 3), third.BBMin)
+	assertTupleInDelta(t, geom.NewPoint(3, 11, 3), third.BBMax)
+}
+
+func TestBuildCLGroup_BakesTransformIntoInstances(t *testing.T) {
+	mesh := newTestGroup(geom.New4x4(), newTestTriangle(0))
+	inst := shapes.NewInstance(mesh)
+	inst.SetTransform(geom.Scale(2, 2, 2))
+	group := newTestGroup(geom.New4x4(), newTestGroup(geom.Translate(1, 0, 0), inst))
+
+	objs, _, groups, instances, _, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+	g := groups[objs[0].Children[0]]
+	assert.Equal(t, int32(1), g.InstCount)
+	transform := geom.Multiply(geom.Translate(1, 0, 0), geom.Scale(2, 2, 2))
+	assert.True(t, geom.Equals(transform, instances[g.InstOffset].Transform))
+	assert.True(t, geom.Equals(geom.Inverse(transform), instances[g.InstOffset].Inverse))
+	assertTupleInDelta(t, geom.NewPoint(1, 0, 0), g.BBMin)
+	assertTupleInDelta(t, geom.NewPoint(3, 2, 0), g.BBMax)
+}
+
+func TestBuildSceneBufferCL_TrianglesShareMaterials(t *testing.T) {
+	rough := material.NewDefaultMaterial()
+	rough.Roughness = 0.5
+	a, b, c := newTestTriangle(0), newTestTriangle(1), newTestTriangle(2)
+	b.SetMaterial(rough)
+	group := newTestGroup(geom.New4x4(), a, b, c)
+
+	_, tris, _, _, materials, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+
+	// the equal default materials of the first and last triangle share an entry, and each triangle points at its own
+	assert.Len(t, materials, 2)
+	assert.Equal(t, []int32{0, 1, 0}, []int32{tris[0].Material, tris[1].Material, tris[2].Material})
+	assert.Equal(t, 0.5, materials[tris[1].Material].Roughness)
+	assert.Equal(t, material.NewDefaultMaterial().Roughness, materials[tris[0].Material].Roughness)
+}
+
+func TestBuildSceneBufferCL_GroupMaterialKeepsTriangleColors(t *testing.T) {
+	red, blue := newTestTriangle(0), newTestTriangle(1)
+	redMaterial, blueMaterial := material.NewDefaultMaterial(), material.NewDefaultMaterial()
+	redMaterial.Color = geom.NewColor(1, 0, 0)
+	blueMaterial.Color = geom.NewColor(0, 0, 1)
+	blueMaterial.Reflectivity = 0.9
+	red.SetMaterial(redMaterial)
+	blue.SetMaterial(blueMaterial)
+
+	glossy := material.NewDefaultMaterial()
+	glossy.Color = geom.NewColor(0, 1, 0)
+	glossy.Roughness = 0.3
+	glossy.Metalness = 1
+	// the material of the outer group applies to the triangles of its subgroups too
+	group := newTestGroup(geom.New4x4(), red, newTestGroup(geom.New4x4(), blue))
+	group.SetMaterial(glossy)
+
+	_, tris, _, _, materials, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+	assert.Len(t, materials, 2)
+	for i, color := range []geom.Tuple4{redMaterial.Color, blueMaterial.Color} {
+		m := materials[tris[i].Material]
+		assert.Equal(t, [4]float64(color), m.Color)
+		assert.Equal(t, 0.3, m.Roughness)
+		assert.Equal(t, 1.0, m.Metalness)
+		assert.Equal(t, glossy.Reflectivity, m.Reflectivity, "the reflectivity of the triangle is overridden too")
+	}
+}
+
+func TestBuildSceneBufferCL_InstancesShareTheirMesh(t *testing.T) {
+	mesh := newTestGroup(geom.New4x4(), newTestTriangle(0), newTestGroup(geom.Translate(0, 0, 1), newTestTriangle(0)))
+	first := shapes.NewInstance(mesh)
+	first.SetTransform(geom.Translate(-5, 0, 0))
+	second := shapes.NewInstance(mesh)
+	second.SetTransform(geom.Multiply(geom.Translate(5, 0, 0), geom.RotateY(1)))
+	gold := material.NewDefaultMaterial()
+	gold.Color = geom.NewColor(1, 0.8, 0.2)
+	second.SetMaterial(gold)
+	group := newTestGroup(geom.New4x4(), first, second)
+
+	_, tris, groups, instances, materials, _, err := BuildSceneBufferCL([]shapes.Shape{group})
+	assert.NoError(t, err)
+
+	// the mesh is built once: its group and subgroup and their two triangles, next to the group of the instances
+	assert.Len(t, tris, 2)
+	assert.Len(t, groups, 3)
+	assert.Len(t, instances, 2)
+	assert.Equal(t, instances[0].Root, instances[1].Root)
+
+	// while each instance has its own transform and material
+	assert.True(t, geom.Equals(first.GetTransform(), instances[0].Transform))
+	assert.True(t, geom.Equals(first.GetInverse(), instances[0].Inverse))
+	assert.True(t, geom.Equals(second.GetTransform(), instances[1].Transform))
+	assert.True(t, geom.Equals(second.GetInverse(), instances[1].Inverse))
+	assert.Equal(t, int32(-1), instances[0].Material)
+	assert.Equal(t, [4]float64(gold.Color), materials[instances[1].Material].Color)
+}
@@ -1,159 +1,284 @@
-__constant double PI = 3.14159265359f;
-__constant unsigned int MAX_EFFECTIVE_BOUNCES = 4;
-__constant unsigned int MAX_BOUNCES = 10;
-__constant double EPSILON = 0.0001;
+// The kernel is built either in double precision, which is the default, or in single precision by defining USE_FLOAT
+// for devices without cl_khr_fp64. All floating point values, including those of the structs below, are of type real.
+// The byte counts in the comments of the structs are those of the double precision build, see ocltracer_float32.go
+// for the Go structs of the single precision build. The packed structs are compared field by field with their Go
+// counterparts by checkLayouts in layout.go, so keep one field per line and the field names in sync.
+#ifdef USE_FLOAT
+typedef float real;
+typedef float2 real2;
+typedef float4 real4;
+typedef float16 real16;
+#define convert_real convert_float
+#define REAL_MAX FLT_MAX
+#else
+#pragma OPENCL EXTENSION cl_khr_fp64 : enable
+typedef double real;
+typedef double2 real2;
+typedef double4 real4;
+typedef double16 real16;
+#define convert_real convert_double
+#define REAL_MAX DBL_MAX
+#endif
+
+__constant real PI = 3.14159265359f;
+#ifdef USE_FLOAT
+// floats need a larger offset to avoid self-intersections of bounced rays
+__constant real EPSILON = 0.001;
+#else
+__constant real EPSILON = 0.0001;
+#endif
+
+// MAX_OBJECTS is the number of top level objects the kernel copies to local memory, passed from maxObjects on the Go side.
+#ifndef MAX_OBJECTS
+#define MAX_OBJECTS 16
+#endif
+
+// MAX_DEPTH and RR_DEPTH are normally passed as build options (-D) from the Go side, see --max-depth and --rr-depth.
+// MAX_DEPTH is the hard cap on the number of bounces per path, while paths with more than RR_DEPTH bounces are
+// terminated by russian roulette based on their remaining throughput.
+#ifndef MAX_DEPTH
+#define MAX_DEPTH 10
+#endif
+#ifndef RR_DEPTH
+#define RR_DEPTH 4
+#endif
 
 typedef struct __attribute__((packed)) tag_camera {
     int width;          // 4 bytes
     int height;         // 4 bytes
-    double fov;         // 8 bytes
-    double pixelSize;   // 8 bytes
-    double halfWidth;   // 8 bytes
-    double halfHeight;  // 8 bytes
-    double aperture;    // 8 bytes
-    double focalLength; // 8 bytes
-    double16 inverse;   // 128 bytes
-    char padding[72];   // 72 bytes
+    real fov;         // 8 bytes
+    real pixelSize;   // 8 bytes
+    real halfWidth;   // 8 bytes
+    real halfHeight;  // 8 bytes
+    real aperture;      // 8 bytes, radius of the lens, 0 == pinhole
+    real focusDistance; // 8 bytes, distance to the plane in focus along the view direction
+    real16 inverse;     // 128 bytes
+    real bladeRotation; // 8 bytes
+    int blades;         // 4 bytes, number of aperture blades, < 3 == circular aperture
+    int projection;     // 4 bytes, 0 == perspective, 1 == orthographic, 2 == fisheye, 3 == equirectangular
+    real orthoScale;    // 8 bytes, scale of the image plane of orthographic cameras
+    real eyeOffset;     // 8 bytes, distance of the eye from the centre of the head for equirectangular stereo
+    char padding[40];   // 40 bytes
 } camera;
 
 typedef struct tag_ray {
-    double4 origin;
-    double4 direction;
+    real4 origin;
+    real4 direction;
 } ray;
 
 typedef struct __attribute__((packed)) tag_group {
-    double4 bbMin;       // 32 bytes
-    double4 bbMax;       // 32 bytes
-    double4 color;       // 32 bytes
-    double4 emission;    // 32 bytes
+    real4 bbMin;       // 32 bytes
+    real4 bbMax;       // 32 bytes
+    real4 color;       // 32 bytes
+    real4 emission;    // 32 bytes
     int triOffset;       // 4 bytes
     int triCount;        // 4 bytes
     int childGroupCount; // 4 bytes, should always be 2 or 0
     int children[2];     // 8 bytes, we only allow binary trees.
-    char padding[108];   // padding, 108 bytes (can be used as a label)
+    int instOffset;      // 4 bytes, index of the first instance in this group
+    int instCount;       // 4 bytes
+    char padding[100];   // padding, 100 bytes (can be used as a label)
                          // Total 256 bytes
 } group;
 
+typedef struct __attribute__((packed)) tag_instance {
+    real16 transform;        // 128 bytes
+    real16 inverse;          // 128 bytes
+    real16 inverseTranspose; // 128 bytes (384 bytes)
+    int root;                  // 4 bytes, index of the root group of the mesh
+    int material;              // 4 bytes, index into materials overriding those of the triangles, -1 if none
+    char padding[120];         // 120 bytes
+                               // Total 512 bytes
+} instance;
+
 typedef struct __attribute__((packed)) tag_object {
-    double16 transform;        // 128 bytes 16x4
-    double16 inverse;          // 128 bytes
-    double16 inverseTranspose; // 128 bytes
-    double4 color;             // 32 bytes
-    double4 emission;          // 32 bytes
-    double refractiveIndex;    // 8 bytes
-    long type;                 // 8 bytes
-    double minY;               // 8 bytes. Used for cylinders.
-    double maxY;               // 8 bytes. Used for cylinders.
-    double reflectivity;       // 8 bytes
-    double textureScaleX;
-    double textureScaleY;
-    double textureScaleXNM;
-    double textureScaleYNM;
-    double4 bbMin;             // 32 bytes
-    double4 bbMax;             // 32 bytes                     // 576
+    real16 transform;        // 128 bytes 16x4
+    real16 inverse;          // 128 bytes
+    real16 inverseTranspose; // 128 bytes
+    real4 color;             // 32 bytes
+    real4 emission;          // 32 bytes
+    real refractiveIndex;    // 8 bytes
+    int type;                  // 4 bytes
+    int padding1;              // 4 bytes, keeps the reals below aligned in the Go structs
+    real minY;               // 8 bytes. Used for cylinders and cones.
+    real maxY;               // 8 bytes. Used for cylinders and cones.
+    real reflectivity;       // 8 bytes
+    real textureScaleX;
+    real textureScaleY;
+    real textureScaleXNM;
+    real textureScaleYNM;
+    real roughness;          // 8 bytes. 0 == smooth
+    real metalness;          // 8 bytes. Probability of a conductor (metal) bounce
+    real4 eta;               // 32 bytes. Complex IOR of conductors, real part
+    real4 k;                 // 32 bytes. Complex IOR of conductors, imaginary part
+    real specular;           // 8 bytes. Principled dielectric specular layer, 0.5 == 4% reflectance
+    real clearcoat;          // 8 bytes. Principled clearcoat layer strength
+    real clearcoatRoughness; // 8 bytes
+    real sheen;              // 8 bytes
+    real transmission;       // 8 bytes. Probability of refraction, 0 means always if refractiveIndex != 1
+    real4 absorption;        // 32 bytes. Beer-Lambert absorption coefficients while inside the object
+    real abbeNumber;         // 8 bytes. Dispersion of refractive objects, 0 == none
+    real minorRadius;        // 8 bytes. Tube radius of tori
+    real4 bbMin;             // 32 bytes
+    real4 bbMax;             // 32 bytes                     // 752
     int childCount;            // 4 bytes. Used for groups to know which "group" that's the root group.
-    int children[64];          // 256 bytes
+    int children[62];          // 248 bytes. For CSG nodes: left operand, right operand and end of the subtree.
+    float strengthNM;   // 4 bytes
     bool isTextured;           // 1 byte
     unsigned char textureIndex;// 1 byte
     bool isTexturedNM;           // 1 byte
     unsigned char textureIndexNM;// 1 byte
-    bool isRefraction;               // 1 byte
+    bool isEnvMap;                   // 1 byte
     char label[8];               // 8 bytes
-    char padding5[167];          // ==> 1024
+    unsigned char colorPattern;    // 1 byte, index+1 into patterns, 0 == none
+    unsigned char roughnessPattern;// 1 byte
+    unsigned char bumpPattern;     // 1 byte ==> 1024
+    real4 motionTranslation; // 32 bytes. Motion blur in world space: translation along the rotation axis at shutter close
+    real4 motionRotation;    // 32 bytes. Unit rotation axis, with the angle at shutter close in w
+    real4 motionPivot;       // 32 bytes. A point of the rotation axis
+    real4 motionScale;       // 32 bytes. xx, yy and zz of the symmetric scale matrix at shutter close
+    real4 motionScaleShear;  // 32 bytes. xy, xz and yz of the scale matrix ==> 1184
+    int materialID;          // 4 bytes. Identifies the material for the material ID AOV
+    bool hasMotion;          // 1 byte. If false, the object stays put while the shutter is open
+    char padding[27];        // 27 bytes ==> 1216
 } object;
 
+typedef struct __attribute__((packed)) tag_pattern {
+    real16 inverse;     // 128 bytes
+    real4 a;            // 32 bytes
+    real4 b;            // 32 bytes (192 bytes)
+    real turbulence;    // 8 bytes
+    real strength;      // 8 bytes, only used by bump patterns
+    int type;             // 4 bytes
+    int octaves;          // 4 bytes (216 bytes)
+    char padding[40];     // 40 bytes
+} pattern;                // 256 total
+
 typedef struct tag_intersection_old {
     unsigned int objectIndex;
-    double t;
-    double4 color;      // while color and emission can be read from the "object" referenced by objectIndex,
-    double4 emission;   // 3D models organized into BVH trees needs to get their material from the intersected group of the tree.
+    real t;
+    real4 color;      // while color and emission can be read from the "object" referenced by objectIndex,
+    real4 emission;   // 3D models organized into BVH trees needs to get their material from the intersected group of the tree.
 } intersection_old;
 
-typedef struct tag_bounce {
-    double4 point;
-    double cos;
-    double4 color;
-    double4 emission;
-    double4 normal;
-    double refractiveIndex;
-    bool isRefraction;
-} bounce;
-
 typedef struct __attribute__((packed)) tag_triangle {
-    double4 p1;           // 32 bytes
-    double4 p2;           // 32 bytes
-    double4 p3;           // 32 bytes
-    double4 e1;           // 32 bytes
-    double4 e2;           // 32 bytes
-    double4 n1;           // 32 bytes
-    double4 n2;           // 32 bytes
-    double4 n3;           // 32 bytes
-    double4 color;        // 32 bytes (288 bytes)
-    char	padding[224]; // 224 bytes
-} triangle;               // 512 total
+    real4 p1;           // 32 bytes
+    real4 p2;           // 32 bytes
+    real4 p3;           // 32 bytes
+    real4 n1;           // 32 bytes
+    real4 n2;           // 32 bytes
+    real4 n3;           // 32 bytes (192 bytes)
+    real2 uv1;          // 16 bytes, texture coordinates
+    real2 uv2;          // 16 bytes
+    real2 uv3;          // 16 bytes (240 bytes)
+    real4 tan1;         // 32 bytes, tangents with bitangent handedness in w
+    real4 tan2;         // 32 bytes
+    real4 tan3;         // 32 bytes (336 bytes)
+    int material;         // 4 bytes, index into materials
+    char padding[4];      // 4 bytes
+} triangle;               // 344 total
+
+// material holds the material properties of triangles, which replace those of the object when a triangle is hit. See
+// applyMaterial.
+typedef struct __attribute__((packed)) tag_material {
+    real4 color;                  // 32 bytes
+    real4 emission;               // 32 bytes
+    real4 eta;                    // 32 bytes
+    real4 k;                      // 32 bytes
+    real4 absorption;             // 32 bytes (160 bytes)
+    real refractiveIndex;         // 8 bytes
+    real reflectivity;            // 8 bytes
+    real roughness;               // 8 bytes
+    real metalness;               // 8 bytes
+    real specular;                // 8 bytes
+    real clearcoat;               // 8 bytes
+    real clearcoatRoughness;      // 8 bytes
+    real sheen;                   // 8 bytes
+    real transmission;            // 8 bytes
+    real abbeNumber;              // 8 bytes (240 bytes)
+    real textureScaleX;           // 8 bytes
+    real textureScaleY;           // 8 bytes
+    real textureScaleXNM;         // 8 bytes
+    real textureScaleYNM;         // 8 bytes (272 bytes)
+    float strengthNM;               // 4 bytes
+    bool isTextured;                // 1 byte
+    unsigned char textureIndex;     // 1 byte
+    bool isTexturedNM;              // 1 byte
+    unsigned char textureIndexNM;   // 1 byte (280 bytes)
+    unsigned char colorPattern;     // 1 byte, index+1 into patterns, 0 == none
+    unsigned char roughnessPattern; // 1 byte
+    unsigned char bumpPattern;      // 1 byte (283 bytes)
+    char padding1;                  // 1 byte
+    int materialID;                 // 4 bytes (288 bytes)
+    char padding[224];              // 224 bytes
+} material;                         // 512 total
 
 // used as an internal data structure
 typedef struct tag_context {
-    double intersections[64]; // = {0};   // t of an intersection (MOVE TO LOCAL)
+    real intersections[64]; // = {0};   // t of an intersection (MOVE TO LOCAL)
     unsigned int xsObjects[64]; // = {0}; // index maps to each xs above, value to objects
-    double4 xsTriangle[64]; // = {0};
-    double4 xsTriangleColor[64]; // = {0};
-    double4 xsTriangleEmission[64]; // = {0};
+    real4 xsTriangle[64]; // = {0};
+    int xsMaterial[64];             // index of the material of the intersected triangle
+    int xsTriangleIndex[64];        // index of the intersected triangle, used for normal mapping
+    real2 xsTriangleBary[64];     // barycentric u, v of the intersection
+    int xsInstance[64];             // index of the intersected mesh instance, -1 if none
 } context;
 
+#define MAX_INTERSECTIONS 64
+
 typedef struct intersection_tag {
-    double t;
+    real t;
     int lowestIntersectionIndex;
     int normalIndex;
 } intersection;
 
-inline double maxX(double a, double b, double c) { return max(max(a, b), c); }
-inline double minX(double a, double b, double c) { return min(min(a, b), c); }
+inline real maxX(real a, real b, real c) { return max(max(a, b), c); }
+inline real minX(real a, real b, real c) { return min(min(a, b), c); }
 
-inline double2 cubeUVFrontCross(double4 point) {
-	double u = fmod(point.x+1.0, 2) / 2.0;
-	double v = fmod(point.y+1.0, 2) / 2.0;
-	double2 uv = (double2)(0.25 + u*0.25, 0.6666666-v*0.333333);
+inline real2 cubeUVFrontCross(real4 point) {
+	real u = fmod(point.x+1.0, 2) / 2.0;
+	real v = fmod(point.y+1.0, 2) / 2.0;
+	real2 uv = (real2)(0.25 + u*0.25, 0.6666666-v*0.333333);
 	return uv;
 }
-inline double2 cubeUVBackCross(double4 point) {
-	double u = fmod(1.0-point.x, 2) / 2.0;
-	double v = fmod(point.y+1.0, 2) / 2.0;
-	double2 uv = (double2)(0.75+u*0.25, 0.6666666-v*0.333333);
+inline real2 cubeUVBackCross(real4 point) {
+	real u = fmod(1.0-point.x, 2) / 2.0;
+	real v = fmod(point.y+1.0, 2) / 2.0;
+	real2 uv = (real2)(0.75+u*0.25, 0.6666666-v*0.333333);
 	return uv;
 }
-inline double2 cubeUVLeftCross(double4 point) {
-	double u = fmod(point.z+1.0, 2) / 2.0;
-	double v = fmod(point.y+1.0, 2) / 2.0;
-	double2 uv = (double2)(u*0.25, 0.6666666-v*0.333333);
+inline real2 cubeUVLeftCross(real4 point) {
+	real u = fmod(point.z+1.0, 2) / 2.0;
+	real v = fmod(point.y+1.0, 2) / 2.0;
+	real2 uv = (real2)(u*0.25, 0.6666666-v*0.333333);
 	return uv;
 }
-inline double2 cubeUVRightCross(double4 point) {
-	double u = fmod(1.0-point.z, 2) / 2.0;
-	double v = fmod(point.y+1.0, 2) / 2.0;
-	double2 uv = (double2)(0.5+u*0.25, 0.6666666-v*0.333333);
+inline real2 cubeUVRightCross(real4 point) {
+	real u = fmod(1.0-point.z, 2) / 2.0;
+	real v = fmod(point.y+1.0, 2) / 2.0;
+	real2 uv = (real2)(0.5+u*0.25, 0.6666666-v*0.333333);
 	return uv;
 }
-inline double2 cubeUVTopCross(double4 point) {
-	double u = fmod(point.x+1.0, 2) / 2.0;
-	double v = fmod(1.0-point.z, 2) / 2.0;
-	double2 uv = (double2)(0.25+u*0.25, 1.0-v*0.333333);
+inline real2 cubeUVTopCross(real4 point) {
+	real u = fmod(point.x+1.0, 2) / 2.0;
+	real v = fmod(1.0-point.z, 2) / 2.0;
+	real2 uv = (real2)(0.25+u*0.25, 1.0-v*0.333333);
 	return uv;
 }
-inline double2 cubeUVBottomCross(double4 point) {
-	double u = fmod(point.x+1.0, 2) / 2.0;
-	double v = fmod(point.z+1.0, 2) / 2.0;
-	double2 uv = (double2)(0.25+u*0.25, v*0.333333);
+inline real2 cubeUVBottomCross(real4 point) {
+	real u = fmod(point.x+1.0, 2) / 2.0;
+	real v = fmod(point.z+1.0, 2) / 2.0;
+	real2 uv = (real2)(0.25+u*0.25, v*0.333333);
 	return uv;
 }
 
 
-inline double2 cubeUV(double4 point) {
+inline real2 cubeUV(real4 point) {
 
-	double absX = fabs(point[0]);
-	double absY = fabs(point[1]);
-	double absZ = fabs(point[2]);
-	double coord = maxX(absX, absY, absZ);
+	real absX = fabs(point[0]);
+	real absY = fabs(point[1]);
+	real absZ = fabs(point[2]);
+	real coord = maxX(absX, absY, absZ);
 
 	if (coord == point[0]) {
 		return cubeUVRightCross(point); // right
@@ -175,82 +300,100 @@ inline double2 cubeUV(double4 point) {
 }
 
 
-inline double2 sphericalMap(double4 p) {
+inline real2 sphericalMap(real4 p) {
 
 	// compute the azimuthal angle
 	// -π < theta <= π
 	// angle increases clockwise as viewed from above,
 	// which is opposite of what we want, but we'll fix it later.
-	double theta = atan2(p.x, p.z);
+	real theta = atan2(p.x, p.z);
 
 	// vec is the vector pointing from the sphere's origin (the world origin)
 	// to the point, which will also happen to be exactly equal to the sphere's
 	// radius.
-	double4 vec = (double4)(p.x, p.y, p.z, 0.0);
-	double radius = length(vec);
+	real4 vec = (real4)(p.x, p.y, p.z, 0.0);
+	real radius = length(vec);
 
 	// compute the polar angle
 	// 0 <= phi <= π
-	double phi = acos(p.y / radius);
+	real phi = acos(p.y / radius);
 
 	// -0.5 < raw_u <= 0.5
-	double rawU = theta / (2.0 * PI);
+	real rawU = theta / (2.0 * PI);
 
 	// 0 <= u < 1
 	// here's also where we fix the direction of u. Subtract it from 1,
 	// so that it increases counterclockwise as viewed from above.
-	double u = 1 - (rawU + 0.5);
+	real u = 1 - (rawU + 0.5);
 
 	// we want v to be 0 at the south pole of the sphere,
 	// and 1 at the north pole, so we have to "flip it over"
 	// by subtracting it from 1.
-	double v = 1 - phi/PI;
+	real v = 1 - phi/PI;
 
-    double2 res;
+    real2 res;
     res.x = u;
     res.y = v;
 	return res;
 }
 
-inline int round2(double number) {
+// isConeCap returns true if the object space point is on one of the caps of a capped cone.
+inline bool isConeCap(object obj, real4 localPoint) {
+    real dist = localPoint.x * localPoint.x + localPoint.z * localPoint.z;
+    return obj.type == 6 && ((dist < obj.maxY * obj.maxY && localPoint.y >= obj.maxY - EPSILON) ||
+                             (dist < obj.minY * obj.minY && localPoint.y <= obj.minY + EPSILON));
+}
+
+// shapeST returns the texture coordinates of cones, disks and tori at the object space point, with t increasing
+// downwards in the image. See ConeMap, DiskMap and TorusMap in the shapes package.
+inline real2 shapeST(object obj, real4 localPoint) {
+    if (obj.type == 7) {
+        // DISK, the full texture once with the top of the image towards +Z
+        return (real2)((localPoint.x + 1.0) * 0.5, 1.0 - (localPoint.z + 1.0) * 0.5);
+    } else if (obj.type == 8) {
+        // TORUS, u around the Y axis and v around the tube
+        real rho = sqrt(localPoint.x * localPoint.x + localPoint.z * localPoint.z);
+        real v = atan2(localPoint.y, rho - 1.0) / (2.0 * PI) + 0.5;
+        return (real2)(sphericalMap(localPoint).x, 1.0 - v);
+    } else if (isConeCap(obj, localPoint)) {
+        // cone caps are mapped like planes
+        return (real2)(localPoint.x, localPoint.z);
+    }
+    // CONE sides, u around the Y axis and repeating once per unit along the axis
+    return (real2)(sphericalMap(localPoint).x, -localPoint.y);
+}
+
+inline int round2(real number) {
    int sign = (int)((number > 0) - (number < 0));
    int odd = ((int)number % 2); // odd -> 1, even -> 0
    return ((int)(number-sign*(0.5-odd)));
 }
 
-inline double sunflowerRadius(double i, double n, double b) {
-  double r = 1.0; // put on boundary
-   if (i <= (n - b)) {
-      r = sqrt(i-0.5) / sqrt(n-(b+1.0)/2.0); // apply square root
-   }
-   return r;
+// sampleLens maps the random numbers u1 and u2 onto a point within the unit circle, or within the regular polygon with
+// one corner per aperture blade if there are at least 3 blades. The polygon is split into one triangle per blade,
+// u1 picks the triangle and then both pick a uniform point within it. See camera.SampleLens for the reference
+// implementation.
+inline real2 sampleLens(real u1, real u2, int blades, real bladeRotation) {
+    if (blades < 3) {
+        real r = sqrt(u1);
+        real theta = 2.0 * PI * u2;
+        return (real2)(r * cos(theta), r * sin(theta));
+    }
+    real blade = floor(u1 * blades);
+    u1 = u1 * blades - blade;
+    real angle = 2.0 * PI / blades;
+    real a0 = bladeRotation + blade * angle;
+    real a1 = a0 + angle;
+    real su = sqrt(u1);
+    real b0 = su * (1.0 - u2);
+    real b1 = su * u2;
+    return (real2)(b0 * cos(a0) + b1 * cos(a1), b0 * sin(a0) + b1 * sin(a1));
 }
 
-// Distributes n points evenly within a circle with sunflowerRadius 1
-// alpha controls point distribution on the edge. Typical values 1-2, higher values more points on the edge.
-// i is the index of a point. It is in the range [1,n] .
-// https://stackoverflow.com/questions/28567166/uniformly-distribute-x-points-inside-a-circle
-//
-// example: amountPoints=500, alpha=2, pointNumber=[1..amountPoints]
-inline double2 sunflower(int amountPoints, double alpha, int pointNumber, bool randomize, double rand) {
-   double pointIndex = (double) pointNumber;  //float64(pointNumber)
-   if (randomize) {
-      pointIndex += rand - 0.5;
-   }
-
-   double sqp = sqrt(convert_double(amountPoints));
-   double b = round(alpha * sqp); // number of boundary points
-   double phi = (sqrt(5.0) + 1.0) / 2.0;                                // golden ratio
-   double r = sunflowerRadius(pointIndex, amountPoints, b);
-   double theta = 2.0 * PI * pointIndex / (phi * phi);
-
-   return (double2)(r * cos(theta), r * sin(theta));
-}
-
-inline double2 checkAxis(double origin, double direction, double minBB, double maxBB) {
-    double2 out = (double2){0, 0};
-    double tminNumerator = minBB - origin; //-1.0 - origin;
-    double tmaxNumerator = maxBB - origin; // 1.0 - origin;
+inline real2 checkAxis(real origin, real direction, real minBB, real maxBB) {
+    real2 out = (real2){0, 0};
+    real tminNumerator = minBB - origin; //-1.0 - origin;
+    real tmaxNumerator = maxBB - origin; // 1.0 - origin;
     if (fabs(direction) >= EPSILON) {
         out.x = tminNumerator / direction;
         out.y = tmaxNumerator / direction;
@@ -260,74 +403,319 @@ inline double2 checkAxis(double origin, double direction, double minBB, double m
     }
     if (out.x > out.y) {
         // swap
-        double temp = out.x;
+        real temp = out.x;
         out.x = out.y;
         out.y = temp;
     }
     return out;
 }
 
-inline bool intersectRayWithBox(double4 tRayOrigin, double4 tRayDirection, double4 bbMin, double4 bbMax) {
+inline bool intersectRayWithBox(real4 tRayOrigin, real4 tRayDirection, real4 bbMin, real4 bbMax) {
     // There is supposed  to be a way to optimize this for fewer checks by looking at early values.
-    double2 xt = checkAxis(tRayOrigin.x, tRayDirection.x, bbMin.x, bbMax.x);
-    double2 yt = checkAxis(tRayOrigin.y, tRayDirection.y, bbMin.y, bbMax.y);
-    double2 zt = checkAxis(tRayOrigin.z, tRayDirection.z, bbMin.z, bbMax.z);
+    real2 xt = checkAxis(tRayOrigin.x, tRayDirection.x, bbMin.x, bbMax.x);
+    real2 yt = checkAxis(tRayOrigin.y, tRayDirection.y, bbMin.y, bbMax.y);
+    real2 zt = checkAxis(tRayOrigin.z, tRayDirection.z, bbMin.z, bbMax.z);
 
     // If the largest of the min values is greater smallest max value...
-    double tmin = maxX(xt.x, yt.x, zt.x); // x == min
-    double tmax = minX(xt.y, yt.y, zt.y); // y == max
+    real tmin = maxX(xt.x, yt.x, zt.x); // x == min
+    real tmax = minX(xt.y, yt.y, zt.y); // y == max
     return tmin < tmax;
 }
 
-inline bool checkCap(double4 origin, double4 direction, double t) {
-    double x = origin.x + t * direction.x;
-    double z = origin.z + t * direction.z;
+inline bool checkCap(real4 origin, real4 direction, real t) {
+    real x = origin.x + t * direction.x;
+    real z = origin.z + t * direction.z;
     return pow(x, 2) + pow(z, 2) <= 1.0;
 }
 
-inline double2 intersectCaps(double4 origin, double4 direction, double minY, double maxY) {
+inline real2 intersectCaps(real4 origin, real4 direction, real minY, real maxY) {
     // !c.closed removed
     if (fabs(direction.y) < EPSILON) {
-        return (double2)(0.0, 0.0);
+        return (real2)(0.0, 0.0);
     }
 
-    double2 retVal = (double2)(0.0, 0.0);
+    real2 retVal = (real2)(0.0, 0.0);
 
     // check for an intersection with the lower end cap by intersecting
     // the ray with the plane at y=cyl.minimum
-    double t1 = (minY - origin.y) / direction.y;
+    real t1 = (minY - origin.y) / direction.y;
     if (checkCap(origin, direction, t1)) {
         retVal.x = t1;
     }
 
     // check for an intersection with the upper end cap by intersecting
     // the ray with the plane at y=cyl.maximum
-    double t2 = (maxY - origin.y) / direction.y;
+    real t2 = (maxY - origin.y) / direction.y;
     if (checkCap(origin, direction, t2)) {
         retVal.y = t2;
     }
     return retVal;
 }
 
-// from https://stackoverflow.com/a/50665114. Perhaps try to find a better way to create random numbers? Maybe
-// it's possible to preload all random numbers and/or cycle a finite set?
-inline static float noise3D(float x, float y, float z) {
-    float ptr = 0.0f;
-    return fract(sin(x * 112.9898f + y * 179.233f + z * 237.212f) * 43758.5453f, &ptr);
+// pcg32 is the state of a PCG random number generator, see https://www.pcg-random.org. Every sample of every pixel
+// gets a generator of its own from the seed of the render, the index of the pixel and the number of the sample, so
+// the random numbers of a sample are the same however the image is split into batches and work groups.
+typedef struct tag_pcg32 {
+    ulong state;
+    ulong inc;
+} pcg32;
+
+// pcgNext returns the next 32 random bits of the generator, using the XSH RR output function of pcg32_random_r.
+inline uint pcgNext(pcg32 *rng) {
+    ulong old = rng->state;
+    rng->state = old * 6364136223846793005UL + rng->inc;
+    uint xorshifted = (uint)(((old >> 18u) ^ old) >> 27u);
+    uint rot = (uint)(old >> 59u);
+    return (xorshifted >> rot) | (xorshifted << ((-rot) & 31u));
+}
+
+// pcgHash is the PCG hash of Jarzynski and Olano, "Hash Functions for GPU Rendering".
+inline uint pcgHash(uint v) {
+    uint state = v * 747796405u + 2891336453u;
+    uint word = ((state >> ((state >> 28u) + 4u)) ^ state) * 277803737u;
+    return (word >> 22u) ^ word;
+}
+
+// pcgSeed returns a generator for the sample of the pixel, like pcg32_srandom_r, with the pixel selecting one of the
+// 2^63 streams of the generator and a hash of the seed, pixel and sample the position in it. Without the hash, nearby
+// positions in the streams of neighbouring pixels would give correlated numbers.
+inline pcg32 pcgSeed(uint seed, uint pixel, uint sample) {
+    uint h = pcgHash(seed ^ pcgHash(pixel ^ pcgHash(sample)));
+    pcg32 rng;
+    rng.state = 0;
+    rng.inc = ((ulong)pixel << 1u) | 1u;
+    pcgNext(&rng);
+    rng.state += ((ulong)h << 32u) | pcgHash(h);
+    pcgNext(&rng);
+    return rng;
+}
+
+// randomReal returns a uniformly distributed random number in [0, 1). Only 24 bits are used, which floats can hold
+// exactly, so that the USE_FLOAT build never rounds up to 1.
+inline real randomReal(pcg32 *rng) {
+    return (real)(pcgNext(rng) >> 8u) * (1.0 / 16777216.0);
+}
+
+// random2 returns two random numbers in [0, 1), drawn in order.
+inline real2 random2(pcg32 *rng) {
+    real u1 = randomReal(rng);
+    return (real2)(u1, randomReal(rng));
+}
+
+// Samplers, see sampler.go for the Go reference implementation these must stay in sync with. A sample of a pixel
+// draws its random numbers as 2D points of numbered dimensions, so that the Sobol samplers can stratify each of them
+// over the samples of the pixel. SAMPLER is set when building the kernel.
+#define INDEPENDENT_SAMPLER 0
+#define SOBOL_SAMPLER 1
+#define BLUE_NOISE_SAMPLER 2
+#ifndef SAMPLER
+#define SAMPLER INDEPENDENT_SAMPLER
+#endif
+#define BLUE_NOISE_SIZE 64
+
+// The dimensions of a path. Every two dimensions from an even one on are stratified together by the Sobol samplers,
+// so the pixel goes with the BSDF sample of the first bounce, which matter the most. The other dimensions of bounce b
+// are those from BOUNCE_DIMENSION + b * DIMENSIONS_PER_BOUNCE on, where the BSDF sample of later bounces goes with
+// their light sample. The choices between the lobes of materials draw one dimension each from LOBE_DIMENSION on.
+#define PIXEL_DIMENSION 0
+#define FIRST_BSDF_DIMENSION 1
+#define LENS_DIMENSION 2
+#define TIME_DIMENSION 3
+#define BOUNCE_DIMENSION 4
+#define DIMENSIONS_PER_BOUNCE 16
+#define BSDF_DIMENSION 0
+#define LIGHT_DIMENSION 1
+#define RR_DIMENSION 2
+#define LOBE_DIMENSION 3
+
+// SOBOL_DIRECTIONS are the direction numbers of the first four dimensions of the Sobol sequence, see sobolDirections.
+__constant uint SOBOL_DIRECTIONS[4][32] = {
+    {0x80000000u, 0x40000000u, 0x20000000u, 0x10000000u, 0x08000000u, 0x04000000u, 0x02000000u, 0x01000000u,
+     0x00800000u, 0x00400000u, 0x00200000u, 0x00100000u, 0x00080000u, 0x00040000u, 0x00020000u, 0x00010000u,
+     0x00008000u, 0x00004000u, 0x00002000u, 0x00001000u, 0x00000800u, 0x00000400u, 0x00000200u, 0x00000100u,
+     0x00000080u, 0x00000040u, 0x00000020u, 0x00000010u, 0x00000008u, 0x00000004u, 0x00000002u, 0x00000001u},
+    {0x80000000u, 0xc0000000u, 0xa0000000u, 0xf0000000u, 0x88000000u, 0xcc000000u, 0xaa000000u, 0xff000000u,
+     0x80800000u, 0xc0c00000u, 0xa0a00000u, 0xf0f00000u, 0x88880000u, 0xcccc0000u, 0xaaaa0000u, 0xffff0000u,
+     0x80008000u, 0xc000c000u, 0xa000a000u, 0xf000f000u, 0x88008800u, 0xcc00cc00u, 0xaa00aa00u, 0xff00ff00u,
+     0x80808080u, 0xc0c0c0c0u, 0xa0a0a0a0u, 0xf0f0f0f0u, 0x88888888u, 0xccccccccu, 0xaaaaaaaau, 0xffffffffu},
+    {0x80000000u, 0xc0000000u, 0x60000000u, 0x90000000u, 0xe8000000u, 0x5c000000u, 0x8e000000u, 0xc5000000u,
+     0x68800000u, 0x9cc00000u, 0xee600000u, 0x55900000u, 0x80680000u, 0xc09c0000u, 0x60ee0000u, 0x90550000u,
+     0xe8808000u, 0x5cc0c000u, 0x8e606000u, 0xc5909000u, 0x6868e800u, 0x9c9c5c00u, 0xeeee8e00u, 0x5555c500u,
+     0x8000e880u, 0xc0005cc0u, 0x60008e60u, 0x9000c590u, 0xe8006868u, 0x5c009c9cu, 0x8e00eeeeu, 0xc5005555u},
+    {0x80000000u, 0xc0000000u, 0x20000000u, 0x50000000u, 0xf8000000u, 0x74000000u, 0xa2000000u, 0x93000000u,
+     0xd8800000u, 0x25400000u, 0x59e00000u, 0xe6d00000u, 0x78080000u, 0xb40c0000u, 0x82020000u, 0xc3050000u,
+     0x208f8000u, 0x51474000u, 0xfbea2000u, 0x75d93000u, 0xa0858800u, 0x914e5400u, 0xdbe79e00u, 0x25db6d00u,
+     0x58800080u, 0xe54000c0u, 0x79e00020u, 0xb6d00050u, 0x800800f8u, 0xc00c0074u, 0x200200a2u, 0x50050093u}
+};
+
+typedef struct tag_pathSampler {
+    pcg32 rng;                         // the random numbers of the independent sampler
+    uint seed;                         // scrambles the Sobol points, per pixel for Sobol and per image for blue noise
+    uint index;                        // the number of the sample
+    uint dimension;                    // the next dimension
+    uint x, y;                         // the pixel
+    __global const ushort *blueNoise;  // the blue noise mask, see blueNoiseMask
+} pathSampler;
+
+inline uint reverseBits(uint x) {
+    x = ((x >> 1u) & 0x55555555u) | ((x & 0x55555555u) << 1u);
+    x = ((x >> 2u) & 0x33333333u) | ((x & 0x33333333u) << 2u);
+    x = ((x >> 4u) & 0x0f0f0f0fu) | ((x & 0x0f0f0f0fu) << 4u);
+    x = ((x >> 8u) & 0x00ff00ffu) | ((x & 0x00ff00ffu) << 8u);
+    return (x >> 16u) | (x << 16u);
+}
+
+inline uint hashCombine(uint seed, uint v) {
+    return seed ^ (v + (seed << 6u) + (seed >> 2u));
+}
+
+// nestedUniformScramble Owen scrambles x, using the hash of Laine and Karras as in Burley, "Practical Hash-based
+// Owen Scrambling".
+inline uint nestedUniformScramble(uint x, uint seed) {
+    x = reverseBits(x);
+    x += seed;
+    x ^= x * 0x6c50b47cu;
+    x ^= x * 0xb82f1e52u;
+    x ^= x * 0xc7afe638u;
+    x ^= x * 0x8d22f6e6u;
+    return reverseBits(x);
+}
+
+// sobol returns point index of dimension dim of the Sobol sequence.
+inline uint sobol(uint index, uint dim) {
+    uint x = 0;
+    for (uint k = 0; index != 0; index >>= 1u, k++) {
+        if (index & 1u) {
+            x ^= SOBOL_DIRECTIONS[dim][k];
+        }
+    }
+    return x;
+}
+
+// blueNoiseAt returns the value of the blue noise mask at the pixel of the sampler, with the mask shifted around by
+// the seed.
+inline real blueNoiseAt(pathSampler *s, uint seed) {
+    uint offset = pcgHash(seed);
+    uint x = (s->x + offset) % BLUE_NOISE_SIZE;
+    uint y = (s->y + (offset >> 16u)) % BLUE_NOISE_SIZE;
+    return ((real)s->blueNoise[y * BLUE_NOISE_SIZE + x] + 0.5) / (BLUE_NOISE_SIZE * BLUE_NOISE_SIZE);
+}
+
+// samplerStart returns the sampler of the sample of the pixel x, y of an image of the width.
+inline pathSampler samplerStart(uint seed, uint x, uint y, uint width, uint sample, __global const ushort *blueNoise) {
+    pathSampler s;
+    s.rng = pcgSeed(seed, y * width + x, sample);
+#if SAMPLER == BLUE_NOISE_SAMPLER
+    s.seed = pcgHash(seed);
+#else
+    s.seed = pcgHash(seed ^ pcgHash(y * width + x));
+#endif
+    s.index = sample;
+    s.dimension = 0;
+    s.x = x;
+    s.y = y;
+    s.blueNoise = blueNoise;
+    return s;
+}
+
+// sample2D returns the point of the next dimension of the sampler, in [0, 1)². The Sobol samplers return the first
+// or the last two of the first four dimensions of the Sobol sequence, shuffled and Owen scrambled per pair of
+// dimensions, and the blue noise sampler shifts them by the blue noise of the pixel.
+inline real2 sample2D(pathSampler *s) {
+    uint d = s->dimension++;
+#if SAMPLER == INDEPENDENT_SAMPLER
+    return random2(&s->rng);
+#else
+    uint seed = hashCombine(s->seed, pcgHash(d >> 1u));
+    uint dim = 2u * (d & 1u);
+    uint index = nestedUniformScramble(s->index, seed);
+    uint x = nestedUniformScramble(sobol(index, dim), hashCombine(seed, pcgHash(dim)));
+    uint y = nestedUniformScramble(sobol(index, dim + 1u), hashCombine(seed, pcgHash(dim + 1u)));
+    real2 u = (real2)((real)(x >> 8u), (real)(y >> 8u)) * (1.0 / 16777216.0);
+#if SAMPLER == BLUE_NOISE_SAMPLER
+    u += (real2)(blueNoiseAt(s, hashCombine(seed, pcgHash(dim))), blueNoiseAt(s, hashCombine(seed, pcgHash(dim + 1u))));
+    u -= floor(u);
+#endif
+    return u;
+#endif
+}
+
+// sample1D returns a number in [0, 1) from the next dimension of the sampler.
+inline real sample1D(pathSampler *s) {
+    return sample2D(s).x;
+}
+
+// Reconstruction filters, see filter.go for the Go reference implementation. Each sample is splatted into the pixels
+// within FILTER_RADIUS pixels of it, weighted by the filter at their centres, which reach at most FILTER_EXTENT pixels
+// from the pixel of the sample in each direction. FILTER, FILTER_RADIUS and FILTER_EXTENT are set when building the
+// kernel, by default to the box filter of the pixel itself.
+#define BOX_FILTER 0
+#define TENT_FILTER 1
+#define GAUSSIAN_FILTER 2
+#define MITCHELL_FILTER 3
+#define BLACKMAN_HARRIS_FILTER 4
+#ifndef FILTER
+#define FILTER BOX_FILTER
+#define FILTER_RADIUS 0.5
+#define FILTER_EXTENT 0
+#endif
+#define FILTER_WIDTH (2 * FILTER_EXTENT + 1)
+#define FILTER_PIXELS (FILTER_WIDTH * FILTER_WIDTH)
+
+// OUTPUT_STRIDE is the number of reals the kernel outputs per pixel: the mean color and squared luminance of its
+// samples, followed by the weighted sum of their colors and the sum of their weights for each of the FILTER_PIXELS
+// pixels around it, row by row.
+#define OUTPUT_STRIDE (4 + 4 * FILTER_PIXELS)
+
+// AOVS is set when building the kernel to also output the AOVs of each pixel into aovs, AOV_STRIDE reals per pixel. See
+// aov.go for their layout.
+#ifndef AOVS
+#define AOVS 0
+#endif
+#define AOV_STRIDE 24
+
+// filter1D returns the weight of the filter at the distance x from the centre of a pixel. The filters are separable,
+// so the weight of a sample for a pixel is filter1D(dx) * filter1D(dy).
+inline real filter1D(real x) {
+    real radius = (real)FILTER_RADIUS;
+    x = fabs(x);
+    if (x > radius) {
+        return 0.0;
+    }
+#if FILTER == TENT_FILTER
+    return radius - x;
+#elif FILTER == GAUSSIAN_FILTER
+    // shifted down to reach 0 at the radius, with the radius at three standard deviations
+    real alpha = 4.5 / (radius * radius);
+    return exp(-alpha * x * x) - exp(-alpha * radius * radius);
+#elif FILTER == MITCHELL_FILTER
+    // B = C = 1/3, stretched from [-2, 2] over the radius, with negative lobes that sharpen edges
+    real t = 2.0 * x / radius;
+    if (t < 1.0) {
+        return (7.0 * t * t * t - 12.0 * t * t + 16.0 / 3.0) / 6.0;
+    }
+    return (-7.0 / 3.0 * t * t * t + 12.0 * t * t - 20.0 * t + 32.0 / 3.0) / 6.0;
+#elif FILTER == BLACKMAN_HARRIS_FILTER
+    // the 4-term window over [-radius, radius], 1 at the centre
+    real t = 2.0 * PI * (0.5 + 0.5 * x / radius);
+    return 0.35875 - 0.48829 * cos(t) + 0.14128 * cos(2.0 * t) - 0.01168 * cos(3.0 * t);
+#else
+    return 1.0;
+#endif
 }
 
 // from https://math.stackexchange.com/questions/1585975/how-to-generate-random-points-on-a-sphere
 // note that we're exchanging y and z since y is up for us, while the formula above uses z as up.
-inline double4 randomPointOnSphere(double r, double u1, double u2) {
+inline real4 randomPointOnSphere(real r, real u1, real u2) {
     //latitude: 𝜆=arccos(2𝑢1−1)−𝜋2 OR arcsin(2𝑎−1)
     //longitude:𝜙=2𝜋𝑢2
-    double lat = acos(2*u1 - 1) - PI*2; // asin(2*u1-1);
-    double lon = 2*PI*u2;
+    real lat = acos(2*u1 - 1) - PI*2; // asin(2*u1-1);
+    real lon = 2*PI*u2;
 
     // 𝑥=cos𝜆cos𝜙
     // 𝑦=cos𝜆sin𝜙
     // 𝑧=sin𝜆
-    double4 out = (double4)(0.0, 0.0, 0.0, 1.0);
+    real4 out = (real4)(0.0, 0.0, 0.0, 1.0);
     out.x = cos(lat) * cos(lon) * r;
     out.y = (sin(lat) - PI*0.25)  * r;
     out.z = cos(lat) * sin(lon) * r;
@@ -338,53 +726,415 @@ inline double4 randomPointOnSphere(double r, double u1, double u2) {
 // randomVectorInHemisphere is based on
 // https://raytracey.blogspot.com/2016/11/opencl-path-tracing-tutorial-2-path.html
 //
-// but adapted to use another rand function and double4 instead of float4. The
+// but adapted to take its random numbers as u1 and u2 and to use double4 instead of float4. The
 // thing is that using this func for diffuse surfaces produces a good and
 // balanced result in the final image, while using the randomConeInHemisphere
 // func translated from Hunter Loftis PathTracer produces overexposed
 // highlights. I think randomConeInHemisphere distributes the rays more
 // "cone-like" while this one distributes them better across the entire
 // hemisphere, which is what we want for strictly diffuse surfaces.
-inline double4 randomVectorInHemisphere(double4 normalVec, double x, double y, double z) {
-    double rand1 = 2.0 * PI * noise3D(x, y, z);
-    double rand2 = noise3D(y, z, x);
-    double rand2s = sqrt(rand2);
+inline real4 randomVectorInHemisphere(real4 normalVec, real u1, real u2) {
+    real rand1 = 2.0 * PI * u1;
+    real rand2 = u2;
+    real rand2s = sqrt(rand2);
 
     /* create a local orthogonal coordinate frame centered at the hitpoint */
-    double4 axis;
+    real4 axis;
     if (fabs(normalVec.x) > 0.1) {
-        axis = (double4)(0.0, 1.0, 0.0, 0.0);
+        axis = (real4)(0.0, 1.0, 0.0, 0.0);
     } else {
-        axis = (double4)(1.0, 0.0, 0.0, 0.0);
+        axis = (real4)(1.0, 0.0, 0.0, 0.0);
     }
-    double4 u = normalize(cross(axis, normalVec));
-    double4 v = cross(normalVec, u);
+    real4 u = normalize(cross(axis, normalVec));
+    real4 v = cross(normalVec, u);
 
     /* use the coordinate frame and random numbers to compute the next ray
      * direction */
     return u * cos(rand1) * rand2s + v * sin(rand1) * rand2s + normalVec * sqrt(1.0 - rand2);
 }
 
+// The GGX functions below work in a local shading frame where the normal is +Z and both wo (towards the eye) and wi
+// (the new ray direction) point away from the surface. See internal/app/material/ggx.go for the Go reference
+// implementation, which is also used to test the math.
+
+// shadingBasis builds an orthonormal basis (u, v, normalVec) using the same axis choice as randomVectorInHemisphere.
+inline void shadingBasis(real4 normalVec, real4 *u, real4 *v) {
+    real4 axis;
+    if (fabs(normalVec.x) > 0.1) {
+        axis = (real4)(0.0, 1.0, 0.0, 0.0);
+    } else {
+        axis = (real4)(1.0, 0.0, 0.0, 0.0);
+    }
+    *u = normalize(cross(axis, normalVec));
+    *v = cross(normalVec, *u);
+}
+
+inline real4 toLocal(real4 w, real4 u, real4 v, real4 normalVec) {
+    return (real4)(dot(w, u), dot(w, v), dot(w, normalVec), 0.0);
+}
+
+inline real4 toWorld(real4 w, real4 u, real4 v, real4 normalVec) {
+    return u * w.x + v * w.y + normalVec * w.z;
+}
+
+// roughnessToAlpha maps perceptual roughness to the GGX alpha, clamped to avoid a degenerate distribution.
+inline real roughnessToAlpha(real roughness) {
+    return max(roughness * roughness, 0.001);
+}
+
+// smithG1 is the GGX Smith masking function for a local direction w.
+inline real smithG1(real4 w, real alpha) {
+    real cos2 = w.z * w.z;
+    if (cos2 == 0.0) {
+        return 0.0;
+    }
+    real tan2 = (1.0 - cos2) / cos2;
+    return 2.0 / (1.0 + sqrt(1.0 + alpha * alpha * tan2));
+}
+
+// sampleGGXVNDF samples a microfacet normal visible from wo, see "Sampling the GGX Distribution of Visible Normals"
+// by Eric Heitz (2018). Using the separable Smith term, f*cos/pdf of the resulting bounce becomes G1(wi) * Fresnel.
+inline real4 sampleGGXVNDF(real4 wo, real alpha, real u1, real u2) {
+    // stretch the view vector so we're sampling a hemisphere
+    real4 vh = normalize((real4)(alpha * wo.x, alpha * wo.y, wo.z, 0.0));
+
+    real4 t1 = (real4)(1.0, 0.0, 0.0, 0.0);
+    real lensq = vh.x * vh.x + vh.y * vh.y;
+    if (lensq > 0.0) {
+        t1 = (real4)(-vh.y, vh.x, 0.0, 0.0) / sqrt(lensq);
+    }
+    real4 t2 = cross(vh, t1);
+
+    // sample the projected area of the visible hemisphere
+    real r = sqrt(u1);
+    real phi = 2.0 * PI * u2;
+    real p1 = r * cos(phi);
+    real p2 = r * sin(phi);
+    real s = 0.5 * (1.0 + vh.z);
+    p2 = (1.0 - s) * sqrt(1.0 - p1 * p1) + s * p2;
+
+    // reproject onto the hemisphere and unstretch
+    real p3 = sqrt(max(0.0, 1.0 - p1 * p1 - p2 * p2));
+    real4 nh = t1 * p1 + t2 * p2 + vh * p3;
+    return normalize((real4)(alpha * nh.x, alpha * nh.y, max(0.0, nh.z), 0.0));
+}
+
+// reflectLocal mirrors wo around the (micro) normal m.
+inline real4 reflectLocal(real4 wo, real4 m) {
+    return m * (2.0 * dot(wo, m)) - wo;
+}
+
+// sampleGlossyReflection reflects the eye vector around a GGX microfacet normal, or around the surface normal if
+// roughness is 0. Returns false if the new direction ends up below the surface. weight is f*cos/pdf excluding Fresnel,
+// and cosM is the cosine between the eye vector and the normal reflected around, for computing Fresnel.
+inline bool sampleGlossyReflection(real4 eyeVector, real4 normalVec, real roughness, real u1, real u2, real4 *direction, real *weight, real *cosM) {
+    real4 u, v;
+    shadingBasis(normalVec, &u, &v);
+    real4 wo = toLocal(eyeVector, u, v, normalVec);
+    real4 m = (real4)(0.0, 0.0, 1.0, 0.0);
+    real alpha = roughnessToAlpha(roughness);
+    if (roughness > 0.0) {
+        m = sampleGGXVNDF(wo, alpha, u1, u2);
+    }
+    real4 wi = reflectLocal(wo, m);
+    if (wi.z <= 0.0) {
+        return false;
+    }
+    *weight = roughness > 0.0 ? smithG1(wi, alpha) : 1.0;
+    *cosM = dot(wo, m);
+    *direction = toWorld(wi, u, v, normalVec);
+    return true;
+}
+
+// schlickF0 is Schlick's Fresnel approximation given the reflectance at normal incidence. Used by the principled
+// specular and clearcoat layers.
+inline real schlickF0(real cosI, real f0) {
+    return f0 + (1.0 - f0) * pow(1.0 - clamp(cosI, 0.0, 1.0), 5);
+}
+
+// Dispersion. See internal/app/material/dispersion.go for the Go reference implementation and the derivation of the
+// constants. A path hitting a dispersive object picks a single wavelength, weights its throughput by wavelengthToRGB
+// and uses cauchyIOR for all dispersive refractions after that.
+#define MIN_WAVELENGTH 380.0
+#define MAX_WAVELENGTH 730.0
+
+// cauchyIOR returns the refractive index at wavelength (nm), given the index at the d line (587.6nm) and the Abbe
+// number, using n = A + B/lambda². The F and C lines are 486.1nm and 656.3nm.
+inline real cauchyIOR(real nd, real abbe, real wavelength) {
+    real b = (nd - 1.0) / (abbe * (1.0 / (486.1 * 486.1) - 1.0 / (656.3 * 656.3)));
+    real a = nd - b / (587.6 * 587.6);
+    return a + b / (wavelength * wavelength);
+}
+
+// wavelengthToRGB is a gaussian response per channel, scaled so that the average over all wavelengths is white.
+inline real4 wavelengthToRGB(real wavelength) {
+    real r = (wavelength - 610.0) / 45.0;
+    real g = (wavelength - 550.0) / 40.0;
+    real b = (wavelength - 465.0) / 35.0;
+    return (real4)(4.388497641005464 * exp(-r * r), 4.936658861096008 * exp(-g * g), 5.643570867683666 * exp(-b * b), 1.0);
+}
+
+// fresnelDielectric is the exact unpolarized Fresnel reflectance, where eta is the refractive index on the far side
+// divided by the one on the near side.
+inline real fresnelDielectric(real cosI, real eta) {
+    cosI = clamp(cosI, -1.0, 1.0);
+    if (cosI < 0.0) {
+        eta = 1.0 / eta;
+        cosI = -cosI;
+    }
+    real sin2T = (1.0 - cosI * cosI) / (eta * eta);
+    if (sin2T >= 1.0) {
+        // total internal reflection
+        return 1.0;
+    }
+    real cosT = sqrt(max(0.0, 1.0 - sin2T));
+    real rParl = (eta * cosI - cosT) / (eta * cosI + cosT);
+    real rPerp = (cosI - eta * cosT) / (cosI + eta * cosT);
+    return (rParl * rParl + rPerp * rPerp) / 2.0;
+}
+
+// fresnelConductor is the unpolarized Fresnel reflectance of a conductor with complex IOR eta + i*k, for one channel.
+inline real fresnelConductor(real cosI, real eta, real k) {
+    cosI = clamp(cosI, 0.0, 1.0);
+    real cos2 = cosI * cosI;
+    real sin2 = 1.0 - cos2;
+    real eta2 = eta * eta;
+    real k2 = k * k;
+
+    real t0 = eta2 - k2 - sin2;
+    real a2PlusB2 = sqrt(t0 * t0 + 4.0 * eta2 * k2);
+    real t1 = a2PlusB2 + cos2;
+    real a = sqrt(max(0.0, 0.5 * (a2PlusB2 + t0)));
+    real t2 = 2.0 * cosI * a;
+    real rs = (t1 - t2) / (t1 + t2);
+
+    real t3 = cos2 * a2PlusB2 + sin2 * sin2;
+    real t4 = t2 * sin2;
+    real rp = rs * (t3 - t4) / (t3 + t4);
+    return 0.5 * (rp + rs);
+}
+
 // mul multiplies the vec by the matrix, producing a new vector.
-inline double4 mul(double16 mat, double4 vec) {
-    double4 elem1 = mat.s0123 * vec;
-    double4 elem2 = mat.s4567 * vec;
-    double4 elem3 = mat.s89AB * vec;
-    double4 elem4 = mat.sCDEF * vec;
-    return (double4)(elem1.x + elem1.y + elem1.z + elem1.w, elem2.x + elem2.y + elem2.z + elem2.w, elem3.x + elem3.y + elem3.z + elem3.w,
+inline real4 mul(real16 mat, real4 vec) {
+    real4 elem1 = mat.s0123 * vec;
+    real4 elem2 = mat.s4567 * vec;
+    real4 elem3 = mat.s89AB * vec;
+    real4 elem4 = mat.sCDEF * vec;
+    return (real4)(elem1.x + elem1.y + elem1.z + elem1.w, elem2.x + elem2.y + elem2.z + elem2.w, elem3.x + elem3.y + elem3.z + elem3.w,
                      elem4.x + elem4.y + elem4.z + elem4.w);
 }
 
-inline double2 intersectCube(double4 tRayOrigin, double4 tRayDirection) {
-    double2 out = (0,0);
+// rotateAroundAxis rotates the vector v around the unit axis by the angle, using Rodrigues' rotation formula.
+inline real4 rotateAroundAxis(real4 v, real4 axis, real angle) {
+    real4 a = (real4)(axis.x, axis.y, axis.z, 0.0);
+    return v * cos(angle) + cross(a, v) * sin(angle) + a * dot(a, v) * (1.0 - cos(angle));
+}
+
+// undoMotion maps p, a point or a vector in world space, to where it was at shutter open relative to the object at
+// time s of the shutter interval, 0 being shutter open and 1 shutter close. The object turns around the axis through
+// pivot while moving along it, after scaling by a symmetric matrix, see objectMotion in motion.go.
+inline real4 undoMotion(real4 p, __local object *obj, real s) {
+    real w = p.w;
+    real4 v = p - (obj->motionPivot + obj->motionTranslation * s) * w;
+    v.w = 0.0;
+    v = rotateAroundAxis(v, obj->motionRotation, -obj->motionRotation.w * s) + obj->motionPivot * w;
+
+    // undo the scale at time s, a symmetric matrix going from the identity at shutter open to the scale at shutter
+    // close, by its cofactors
+    real a = 1.0 + (obj->motionScale.x - 1.0) * s;
+    real d = 1.0 + (obj->motionScale.y - 1.0) * s;
+    real f = 1.0 + (obj->motionScale.z - 1.0) * s;
+    real b = obj->motionScaleShear.x * s;
+    real c = obj->motionScaleShear.y * s;
+    real e = obj->motionScaleShear.z * s;
+    real c00 = d * f - e * e;
+    real c01 = c * e - b * f;
+    real c02 = b * e - c * d;
+    real c11 = a * f - c * c;
+    real c12 = b * c - a * e;
+    real c22 = a * d - b * b;
+    real det = a * c00 + b * c01 + c * c02;
+    return (real4)(c00 * v.x + c01 * v.y + c02 * v.z, c01 * v.x + c11 * v.y + c12 * v.z, c02 * v.x + c12 * v.y + c22 * v.z, 0.0) / det + (real4)(0.0, 0.0, 0.0, w);
+}
+
+// applyMotion moves the object to where it is at time s of the shutter interval, by replacing its inverse and inverse
+// transpose with those at time s, i.e. the inverse at shutter open times undoMotion. Each column of undoMotion as a
+// matrix is undoMotion of that column of the identity, since it is affine.
+inline void applyMotion(object *obj, __local object *moving, real s) {
+    real16 m = obj->inverse;
+    for (unsigned int col = 0; col < 4; col++) {
+        real4 unit = (real4)(0.0, 0.0, 0.0, 0.0);
+        unit[col] = 1.0;
+        real4 c = mul(m, undoMotion(unit, moving, s));
+        for (unsigned int row = 0; row < 4; row++) {
+            obj->inverse[row * 4 + col] = c[row];
+            obj->inverseTranspose[col * 4 + row] = c[row];
+        }
+    }
+}
+
+// Procedural patterns. Every pattern maps a point in pattern space to a value in [0, 1], see material/pattern.go for
+// the Go reference implementation these must stay in sync with.
+#define CHECKER_PATTERN 1
+#define STRIPE_PATTERN 2
+#define GRADIENT_PATTERN 3
+#define RING_PATTERN 4
+#define NOISE_PATTERN 5
+#define MARBLE_PATTERN 6
+#define WOOD_PATTERN 7
+#define VORONOI_PATTERN 8
+
+// permutation table from Ken Perlin's reference implementation of improved noise
+__constant int permutation[256] = {
+    151, 160, 137, 91, 90, 15, 131, 13, 201, 95, 96, 53, 194, 233, 7, 225, 140, 36, 103, 30, 69, 142, 8, 99, 37, 240,
+    21, 10, 23, 190, 6, 148, 247, 120, 234, 75, 0, 26, 197, 62, 94, 252, 219, 203, 117, 35, 11, 32, 57, 177, 33, 88,
+    237, 149, 56, 87, 174, 20, 125, 136, 171, 168, 68, 175, 74, 165, 71, 134, 139, 48, 27, 166, 77, 146, 158, 231, 83,
+    111, 229, 122, 60, 211, 133, 230, 220, 105, 92, 41, 55, 46, 245, 40, 244, 102, 143, 54, 65, 25, 63, 161, 1, 216,
+    80, 73, 209, 76, 132, 187, 208, 89, 18, 169, 200, 196, 135, 130, 116, 188, 159, 86, 164, 100, 109, 198, 173, 186,
+    3, 64, 52, 217, 226, 250, 124, 123, 5, 202, 38, 147, 118, 126, 255, 82, 85, 212, 207, 206, 59, 227, 47, 16, 58,
+    17, 182, 189, 28, 42, 223, 183, 170, 213, 119, 248, 152, 2, 44, 154, 163, 70, 221, 153, 101, 155, 167, 43, 172, 9,
+    129, 22, 39, 253, 19, 98, 108, 110, 79, 113, 224, 232, 178, 185, 112, 104, 218, 246, 97, 228, 251, 34, 242, 193,
+    238, 210, 144, 12, 191, 179, 162, 241, 81, 51, 145, 235, 249, 14, 239, 107, 49, 192, 214, 31, 181, 199, 106, 157,
+    184, 84, 204, 176, 115, 121, 50, 45, 127, 4, 150, 254, 138, 236, 205, 93, 222, 114, 67, 29, 24, 72, 243, 141, 128,
+    195, 78, 66, 215, 61, 156, 180
+};
+
+inline int perm(int i) { return permutation[i & 255]; }
+
+inline real fade(real t) { return t * t * t * (t * (t * 6.0 - 15.0) + 10.0); }
+
+inline real grad(int hash, real x, real y, real z) {
+    int h = hash & 15;
+    real u = h < 8 ? x : y;
+    real v = h < 4 ? y : (h == 12 || h == 14 ? x : z);
+    return ((h & 1) == 0 ? u : -u) + ((h & 2) == 0 ? v : -v);
+}
+
+// perlin returns Ken Perlin's improved noise at p, roughly in [-1, 1].
+inline real perlin(real4 p) {
+    real4 f = floor(p);
+    int X = ((int) f.x) & 255;
+    int Y = ((int) f.y) & 255;
+    int Z = ((int) f.z) & 255;
+    real x = p.x - f.x;
+    real y = p.y - f.y;
+    real z = p.z - f.z;
+    real u = fade(x);
+    real v = fade(y);
+    real w = fade(z);
+
+    int a = perm(X) + Y;
+    int aa = perm(a) + Z;
+    int ab = perm(a + 1) + Z;
+    int b = perm(X + 1) + Y;
+    int ba = perm(b) + Z;
+    int bb = perm(b + 1) + Z;
+
+    return mix(mix(mix(grad(perm(aa), x, y, z), grad(perm(ba), x - 1.0, y, z), u),
+                   mix(grad(perm(ab), x, y - 1.0, z), grad(perm(bb), x - 1.0, y - 1.0, z), u), v),
+               mix(mix(grad(perm(aa + 1), x, y, z - 1.0), grad(perm(ba + 1), x - 1.0, y, z - 1.0), u),
+                   mix(grad(perm(ab + 1), x, y - 1.0, z - 1.0), grad(perm(bb + 1), x - 1.0, y - 1.0, z - 1.0), u), v), w);
+}
+
+// fbm sums octaves of perlin noise, normalized to stay roughly in [-1, 1].
+inline real fbm(real4 p, int octaves) {
+    real sum = 0.0;
+    real amplitude = 1.0;
+    real total = 0.0;
+    for (int i = 0; i < octaves; i++) {
+        sum += amplitude * perlin(p);
+        total += amplitude;
+        amplitude *= 0.5;
+        p = p * 2.0;
+    }
+    return total == 0.0 ? 0.0 : sum / total;
+}
+
+// voronoi returns the distance to the closest feature point, where every unit cell holds one feature point.
+inline real voronoi(real4 p) {
+    int ci = (int) floor(p.x);
+    int cj = (int) floor(p.y);
+    int ck = (int) floor(p.z);
+    real closest = REAL_MAX;
+    for (int i = ci - 1; i <= ci + 1; i++) {
+        for (int j = cj - 1; j <= cj + 1; j++) {
+            for (int k = ck - 1; k <= ck + 1; k++) {
+                int h = perm(perm(perm(i) + j) + k);
+                real dx = i + perm(h) / 255.0 - p.x;
+                real dy = j + perm(h + 85) / 255.0 - p.y;
+                real dz = k + perm(h + 170) / 255.0 - p.z;
+                closest = min(closest, dx * dx + dy * dy + dz * dz);
+            }
+        }
+    }
+    return sqrt(closest);
+}
+
+inline real floorMod2(real v) { return v - 2.0 * floor(v / 2.0); }
+
+// patternValue returns the value in [0, 1] of the pattern at the given point in object space.
+inline real patternValue(__global pattern *pat, real4 objectPoint) {
+    real4 p = mul(pat->inverse, objectPoint);
+    switch (pat->type) {
+        case CHECKER_PATTERN:
+            return floorMod2(floor(p.x) + floor(p.y) + floor(p.z));
+        case STRIPE_PATTERN:
+            return floorMod2(floor(p.x));
+        case GRADIENT_PATTERN:
+            return p.x - floor(p.x);
+        case RING_PATTERN:
+            return floorMod2(floor(sqrt(p.x * p.x + p.z * p.z)));
+        case NOISE_PATTERN:
+            return clamp(0.5 + 0.5 * fbm(p, pat->octaves), 0.0, 1.0);
+        case MARBLE_PATTERN:
+            return 0.5 + 0.5 * sin((p.x + pat->turbulence * fbm(p, pat->octaves)) * PI);
+        case WOOD_PATTERN: {
+            real r = sqrt(p.x * p.x + p.z * p.z) + pat->turbulence * fbm(p, pat->octaves);
+            return r - floor(r);
+        }
+        case VORONOI_PATTERN:
+            return clamp(voronoi(p), 0.0, 1.0);
+    }
+    return 0.0;
+}
+
+inline real4 patternColor(__global pattern *pat, real4 objectPoint) {
+    real4 color = mix(pat->a, pat->b, patternValue(pat, objectPoint));
+    color.w = 1.0;
+    return color;
+}
+
+inline real patternScalar(__global pattern *pat, real4 objectPoint) {
+    return mix(pat->a.x, pat->b.x, patternValue(pat, objectPoint));
+}
+
+// bumpNormal tilts the object space normal n against the gradient of the scalar pattern at objectPoint, using
+// central differences.
+inline real4 bumpNormal(__global pattern *pat, real4 objectPoint, real4 n) {
+    const real delta = 0.001;
+    real4 dx = (real4)(delta, 0.0, 0.0, 0.0);
+    real4 dy = (real4)(0.0, delta, 0.0, 0.0);
+    real4 dz = (real4)(0.0, 0.0, delta, 0.0);
+    real4 gradient = (real4)(patternScalar(pat, objectPoint + dx) - patternScalar(pat, objectPoint - dx),
+                                 patternScalar(pat, objectPoint + dy) - patternScalar(pat, objectPoint - dy),
+                                 patternScalar(pat, objectPoint + dz) - patternScalar(pat, objectPoint - dz), 0.0) / (2.0 * delta);
+    n.w = 0.0;
+    n = normalize(n);
+    // only the part of the gradient along the surface tilts the normal
+    gradient = gradient - n * dot(gradient, n);
+    return normalize(n - gradient * pat->strength);
+}
+
+inline real2 intersectCube(real4 tRayOrigin, real4 tRayDirection) {
+    real2 out = (0,0);
     // There is supposed to be a way to optimize this for fewer checks by looking at early values.
-    double2 xt = checkAxis(tRayOrigin.x, tRayDirection.x, -1.0, 1.0);
-    double2 yt = checkAxis(tRayOrigin.y, tRayDirection.y, -1.0, 1.0);
-    double2 zt = checkAxis(tRayOrigin.z, tRayDirection.z, -1.0, 1.0);
+    real2 xt = checkAxis(tRayOrigin.x, tRayDirection.x, -1.0, 1.0);
+    real2 yt = checkAxis(tRayOrigin.y, tRayDirection.y, -1.0, 1.0);
+    real2 zt = checkAxis(tRayOrigin.z, tRayDirection.z, -1.0, 1.0);
 
     // Om det största av min-värdena är större än det minsta max-värdet.
-    double tmin = maxX(xt.x, yt.x, zt.x);
-    double tmax = minX(xt.y, yt.y, zt.y);
+    real tmin = maxX(xt.x, yt.x, zt.x);
+    real tmax = minX(xt.y, yt.y, zt.y);
     if (tmin > tmax) {
         return out;
     }
@@ -393,49 +1143,49 @@ inline double2 intersectCube(double4 tRayOrigin, double4 tRayDirection) {
     return out;
 }
 
-inline double4 intersectCylinder(double4 tRayOrigin, double4 tRayDirection, object obj) {
-    double4 out={0,0,0,0};
-    double rdx2 = tRayDirection.x * tRayDirection.x;
-    double rdz2 = tRayDirection.z * tRayDirection.z;
+inline real4 intersectCylinder(real4 tRayOrigin, real4 tRayDirection, object obj) {
+    real4 out={0,0,0,0};
+    real rdx2 = tRayDirection.x * tRayDirection.x;
+    real rdz2 = tRayDirection.z * tRayDirection.z;
 
-    double a = rdx2 + rdz2;
+    real a = rdx2 + rdz2;
     if (fabs(a) < EPSILON) {
         // c.intercectCaps(ray, xs)
         return out;
     }
 
-    double b = 2 * tRayOrigin.x * tRayDirection.x + 2 * tRayOrigin.z * tRayDirection.z;
+    real b = 2 * tRayOrigin.x * tRayDirection.x + 2 * tRayOrigin.z * tRayDirection.z;
 
-    double rox2 = tRayOrigin.x * tRayOrigin.x;
-    double roz2 = tRayOrigin.z * tRayOrigin.z;
+    real rox2 = tRayOrigin.x * tRayOrigin.x;
+    real roz2 = tRayOrigin.z * tRayOrigin.z;
 
-    double c1 = rox2 + roz2 - 1;
+    real c1 = rox2 + roz2 - 1;
 
-    double disc = b * b - 4 * a * c1;
+    real disc = b * b - 4 * a * c1;
 
     // ray does not intersect the cylinder
     if (disc < 0.0) {
         return out;
     }
 
-    double t0 = (-b - sqrt(disc)) / (2 * a);
-    double t1 = (-b + sqrt(disc)) / (2 * a);
+    real t0 = (-b - sqrt(disc)) / (2 * a);
+    real t1 = (-b + sqrt(disc)) / (2 * a);
 
-    double y0 = tRayOrigin.y + t0 * tRayDirection.y;
+    real y0 = tRayOrigin.y + t0 * tRayDirection.y;
 
     if (y0 > obj.minY && y0 < obj.maxY) {
         // add intersection
         out.x = t0;
     }
 
-    double y1 = tRayOrigin.y + t1 * tRayDirection.y;
+    real y1 = tRayOrigin.y + t1 * tRayDirection.y;
     if (y1 > obj.minY && y1 < obj.maxY) {
         // add intersection
         out.y = t1;
     }
 
     // TODO fix so caps can be enabled/disabled... for now, disable.
-//    double2 caps = intersectCaps(tRayOrigin, tRayDirection, obj.minY, obj.maxY);
+//    real2 caps = intersectCaps(tRayOrigin, tRayDirection, obj.minY, obj.maxY);
 //    if (caps.x > 0.0) {
 //        out.z = caps.x;
 //    }
@@ -445,86 +1195,223 @@ inline double4 intersectCylinder(double4 tRayOrigin, double4 tRayDirection, obje
     return out;
 }
 
-inline double2 intersectSphere(double4 tRayOrigin, double4 tRayDirection) {
+// intersectCone intersects the double-napped cone x² + z² = y² truncated at minY and maxY, with caps if capped.
+// See shapes/cone.go for the reference implementation.
+inline real4 intersectCone(real4 tRayOrigin, real4 tRayDirection, object obj, bool capped) {
+    real4 out = {0, 0, 0, 0};
+    real4 o = tRayOrigin;
+    real4 d = tRayDirection;
+    real a = d.x * d.x - d.y * d.y + d.z * d.z;
+    real b = 2.0 * o.x * d.x - 2.0 * o.y * d.y + 2.0 * o.z * d.z;
+    real c = o.x * o.x - o.y * o.y + o.z * o.z;
+
+    if (fabs(a) < EPSILON) {
+        // the ray is parallel to one of the halves, so there's a single intersection with the other half
+        if (fabs(b) >= EPSILON) {
+            real t = -c / (2.0 * b);
+            real y = o.y + t * d.y;
+            if (y > obj.minY && y < obj.maxY) {
+                out.x = t;
+            }
+        }
+    } else {
+        real disc = b * b - 4.0 * a * c;
+        if (disc >= 0.0) {
+            real t0 = (-b - sqrt(disc)) / (2.0 * a);
+            real t1 = (-b + sqrt(disc)) / (2.0 * a);
+            real y0 = o.y + t0 * d.y;
+            if (y0 > obj.minY && y0 < obj.maxY) {
+                out.x = t0;
+            }
+            real y1 = o.y + t1 * d.y;
+            if (y1 > obj.minY && y1 < obj.maxY) {
+                out.y = t1;
+            }
+        }
+    }
+
+    if (capped && fabs(d.y) >= EPSILON) {
+        // the caps have the radius of the cone at that y
+        real t = (obj.minY - o.y) / d.y;
+        real x = o.x + t * d.x;
+        real z = o.z + t * d.z;
+        if (x * x + z * z <= obj.minY * obj.minY) {
+            out.z = t;
+        }
+        t = (obj.maxY - o.y) / d.y;
+        x = o.x + t * d.x;
+        z = o.z + t * d.z;
+        if (x * x + z * z <= obj.maxY * obj.maxY) {
+            out.w = t;
+        }
+    }
+    return out;
+}
+
+// intersectDisk intersects the disk with radius 1 in the XZ plane.
+inline real intersectDisk(real4 tRayOrigin, real4 tRayDirection) {
+    if (fabs(tRayDirection.y) < EPSILON) {
+        return 0.0;
+    }
+    real t = -tRayOrigin.y / tRayDirection.y;
+    real x = tRayOrigin.x + t * tRayDirection.x;
+    real z = tRayOrigin.z + t * tRayDirection.z;
+    return x * x + z * z <= 1.0 ? t : 0.0;
+}
+
+// object types of CSG nodes, see intersectCSG
+#define CSG_UNION 9
+#define CSG_INTERSECTION 10
+#define CSG_DIFFERENCE 11
+
+#define TORUS_MAX_STEPS 512
+#define TORUS_BISECTIONS 40
+
+// intersectTorus intersects the torus (|p|² + 1 - r²)² - 4(x² + z²) = 0 with tube radius r, returning up to 4
+// intersections, including those behind the ray origin like for the other shapes. The quartic is not solved
+// analytically, instead the ray is clipped against the bounding sphere and stepped in steps of half the tube radius to
+// bracket the roots, which are then refined by bisection. See shapes/torus.go for the reference implementation.
+inline real4 intersectTorus(real4 tRayOrigin, real4 tRayDirection, real r) {
+    real4 out = {0, 0, 0, 0};
+    real4 d = (real4)(tRayDirection.x, tRayDirection.y, tRayDirection.z, 0.0);
+    real dirLen = length(d);
+    if (r <= 0.0 || dirLen == 0.0) {
+        return out;
+    }
+    d = d / dirLen;
+    real4 o = (real4)(tRayOrigin.x, tRayOrigin.y, tRayOrigin.z, 0.0);
+
+    // clip against the bounding sphere
+    real b = dot(o, d);
+    real disc = b * b - (dot(o, o) - (1.0 + r) * (1.0 + r));
+    if (disc < 0.0) {
+        return out;
+    }
+    real s0 = -b - sqrt(disc);
+    real s1 = -b + sqrt(disc);
+
+    // move the origin up to the bounding sphere for precision, then set up the quartic in s along the unit direction
+    o = o + d * s0;
+    real m = dot(o, d);
+    real k = dot(o, o) + 1.0 - r * r;
+    real c3 = 4.0 * m;
+    real c2 = 4.0 * m * m + 2.0 * k - 4.0 * (d.x * d.x + d.z * d.z);
+    real c1 = 4.0 * m * k - 8.0 * (o.x * d.x + o.z * d.z);
+    real c0 = k * k - 4.0 * (o.x * o.x + o.z * o.z);
+
+    real span = s1 - s0;
+    int steps = clamp((int) ceil(span / (0.5 * r)), 16, TORUS_MAX_STEPS);
+    real step = span / steps;
+
+    int found = 0;
+    real prevS = 0.0;
+    real prevF = c0;
+    for (int i = 1; i <= steps && found < 4; i++) {
+        real s = i * step;
+        real fs = (((s + c3) * s + c2) * s + c1) * s + c0;
+        if ((prevF < 0.0) != (fs < 0.0)) {
+            real lo = prevS;
+            real hi = s;
+            real flo = prevF;
+            for (int j = 0; j < TORUS_BISECTIONS; j++) {
+                real mid = (lo + hi) * 0.5;
+                real fm = (((mid + c3) * mid + c2) * mid + c1) * mid + c0;
+                if ((fm < 0.0) == (flo < 0.0)) {
+                    lo = mid;
+                    flo = fm;
+                } else {
+                    hi = mid;
+                }
+            }
+            out[found] = (s0 + (lo + hi) * 0.5) / dirLen;
+            found++;
+        }
+        prevS = s;
+        prevF = fs;
+    }
+    return out;
+}
+
+inline real2 intersectSphere(real4 tRayOrigin, real4 tRayDirection) {
     // this is a vector from the origin of the ray to the center of the
     // sphere at 0,0,0
-    double4 vecToCenter = tRayOrigin - ((double4)(0.0, 0.0, 0.0, 1.0));
+    real4 vecToCenter = tRayOrigin - ((real4)(0.0, 0.0, 0.0, 1.0));
 
     // This dot product is always 1.0 if tRayDirection is normalized. Which it isn't.
-    double a = dot(tRayDirection, tRayDirection);
+    real a = dot(tRayDirection, tRayDirection);
 
     // Take the dot of the direction and the vector from ray origin to
     // sphere center times 2
-    double b = 2.0 * dot(tRayDirection, vecToCenter);
+    real b = 2.0 * dot(tRayDirection, vecToCenter);
 
     // Take the dot of the two sphereToRay vectors and decrease by 1 (is
     // that because the sphere is unit length 1?
-    double c = dot(vecToCenter, vecToCenter) - 1.0;
+    real c = dot(vecToCenter, vecToCenter) - 1.0;
 
     // calculate the discriminant
-    double discriminant = (b * b) - 4 * a * c;
+    real discriminant = (b * b) - 4 * a * c;
     if (discriminant > 0.0) {
         // finally, find the intersection distances on our ray.
-        double t1 = (-b - sqrt(discriminant)) / (2 * a);
-        double t2 = (-b + sqrt(discriminant)) / (2*a);
-        double2 t;
+        real t1 = (-b - sqrt(discriminant)) / (2 * a);
+        real t2 = (-b + sqrt(discriminant)) / (2*a);
+        real2 t;
         t.x = t1;
         t.y = t2;
         return t;
     }
-    return (double2)(0.0, 0.0);
+    return (real2)(0.0, 0.0);
 }
 
-inline double intersectPlane(double4 tRayOrigin, double4 tRayDirection) {
+inline real intersectPlane(real4 tRayOrigin, real4 tRayDirection) {
     if (fabs(tRayDirection.y) > EPSILON) {
             return -tRayOrigin.y / tRayDirection.y;
     }
     return 0.0;
 }
 
-inline double schlick(double4 eyeVec, double4 normalVec, double n1, double n2) {
+inline real schlick(real4 eyeVec, real4 normalVec, real n1, real n2) {
 
     // find the cosine of the angle between the eye and normal vectors using Dot
-    double cos = dot(eyeVec, normalVec);
+    real cos = dot(eyeVec, normalVec);
     // total internal reflection can only occur if n1 > n2
     if (n1 > n2) {
-        double n = n1 / n2;
-        double sin2Theta = (n * n) * (1.0 - (cos * cos));
+        real n = n1 / n2;
+        real sin2Theta = (n * n) * (1.0 - (cos * cos));
         if (sin2Theta > 1.0) {
             return 1.0;
         }
         // compute cosine of theta_t using trig identity
-        double cosTheta = sqrt(1.0 - sin2Theta);
+        real cosTheta = sqrt(1.0 - sin2Theta);
 
         // when n1 > n2, use cos(theta_t) instead
         cos = cosTheta;
     }
-    double temp = (n1 - n2) / (n1 + n2);
-    double r0 = temp * temp;
+    real temp = (n1 - n2) / (n1 + n2);
+    real r0 = temp * temp;
     return r0 + (1-r0)*pow(1-cos, 5);
 }
 
-inline double4 computeRefractedRay(double4 eyeVector, double4 normalVec, double n1, double n2) {
+inline real4 computeRefractedRay(real4 eyeVector, real4 normalVec, real n1, real n2) {
     // Find the ratio of first index of refraction to the second.
-	double nRatio = n1 / n2;
+	real nRatio = n1 / n2;
 
 	// cos(theta_i) is the same as the dot product of the two vectors
-	double cosI = dot(eyeVector, normalVec);
+	real cosI = dot(eyeVector, normalVec);
 
 	// Find sin(theta_t)^2 via trigonometric identity
-	double sin2Theta = (nRatio * nRatio) * (1.0 - (cosI * cosI));
+	real sin2Theta = (nRatio * nRatio) * (1.0 - (cosI * cosI));
 	if (sin2Theta > 1.0) {
 	    // was black, how to handle?? This is probably that famous total reflectance?
 	    // In the original ray-tracer, this meant that the refraction did not contribute any "color" to
 	    // the final pixel color.
-		return (double4)(0,0,0,0);
+		return (real4)(0,0,0,0);
 	}
 
 	// Find cos(theta_t) via trigonometric identity
-	double cosTheta = sqrt(1.0 - sin2Theta);
+	real cosTheta = sqrt(1.0 - sin2Theta);
 
 	// Compute the direction of the refracted ray
-	double4 direction = (normalVec * ((nRatio*cosI)-cosTheta)) - eyeVector * nRatio;
+	real4 direction = (normalVec * ((nRatio*cosI)-cosTheta)) - eyeVector * nRatio;
 
     // Return the refracted ray direction vector (use underpoint at callsite)
     return direction;
@@ -532,200 +1419,334 @@ inline double4 computeRefractedRay(double4 eyeVector, double4 normalVec, double
 	//refractRay := mat.NewRay(comps.UnderPoint, direction)
 }
 
-// findClosestIntersection returns the closest intersection. NOTE! It possible we could optimize this for shadow rays,
-// if we pass some kind of maxT - if
-inline intersection findClosestIntersection(__local object *objects, unsigned int numObjects, __global group *groups, __global triangle *triangles, double4 rayOrigin, double4 rayDirection, context *ctx) {
-    // ----------------------------------------------------------
-    // Loop through scene objects in order to find intersections
-    // ----------------------------------------------------------
-    unsigned int numIntersections = 0;
-    for (unsigned int j = 0; j < numObjects; j++) {
+// intersectTriangles records the intersections with the triangles offset to offset+count-1 for object j. The context
+// holds at most MAX_INTERSECTIONS intersections, any further intersections are dropped.
+inline unsigned int intersectTriangles(__global triangle *triangles, int offset, int count, unsigned int j, real4 tRayOrigin, real4 tRayDirection, context *ctx, unsigned int numIntersections) {
+    for (int n = offset; n < offset + count && numIntersections < MAX_INTERSECTIONS; n++) {
+
+        real4 e1 = triangles[n].p2 - triangles[n].p1;
+        real4 e2 = triangles[n].p3 - triangles[n].p1;
+        real4 dirCrossE2 = cross(tRayDirection, e2);
+        real determinant = dot(e1, dirCrossE2);
+        if (fabs(determinant) < EPSILON) {
+            continue;
+        }
 
-        long objType = objects[j].type;
-        //  translate our ray into object space by multiplying ray pos and dir
-        //  with inverse object matrix
-        double4 tRayOrigin = mul(objects[j].inverse, rayOrigin);
-        double4 tRayDirection = mul(objects[j].inverse, rayDirection);
-
-        // Intersection code
-        if (objType == 0) { // PLANE - intersect transformed ray with plane
-            double t = intersectPlane(tRayOrigin, tRayDirection);
-            if (t != 0.0) {
-                ctx->intersections[numIntersections] = t;
-                ctx->xsObjects[numIntersections] = j;
-                numIntersections++;
-            }
-        } else if (objType == 1) { // SPHERE
+        // Triangle misses over P1-P3 edge
+        real f = 1.0 / determinant;
+        real4 p1ToOrigin = tRayOrigin - triangles[n].p1;
+        real u = f * dot(p1ToOrigin, dirCrossE2);
+        if (u < 0 || u > 1) {
+            continue;
+        }
 
-            // finally, find the intersection distances on our ray.
-            double2 t = intersectSphere(tRayOrigin, tRayDirection);
-             // required for refraction and possibly to detect when the camera starts inside a sphere
+        real4 originCrossE1 = cross(p1ToOrigin, e1);
+        real v = f * dot(tRayDirection, originCrossE1);
+        if (v < 0 || (u + v) > 1) {
+            continue;
+        }
+        real t = f * dot(e2, originCrossE1);
+        ctx->intersections[numIntersections] = t;
+        ctx->xsObjects[numIntersections] = j;
+
+        // assume we have vertex normals. If not, assume N in n1,n2,n3
+        // stored the computed normal in a list using the same indexing as xsObjects so
+        // if a ray intersects several triangles in the group, we'll get an intersection per triangle
+        // but can separate their normals and then only use the one for the nearest intersection
+        ctx->xsTriangle[numIntersections] = triangles[n].n2 * u + triangles[n].n3 * v + triangles[n].n1 * (1.0 - u - v);
+
+        ctx->xsMaterial[numIntersections] = triangles[n].material;
+        ctx->xsTriangleIndex[numIntersections] = n;
+        ctx->xsTriangleBary[numIntersections] = (real2)(u, v);
+        ctx->xsInstance[numIntersections] = -1;
+        numIntersections++;
+    }
+    return numIntersections;
+}
 
-            if (t.x != 0.0) {
-                ctx->intersections[numIntersections] = t.x;
-                ctx->xsObjects[numIntersections] = j;
-                numIntersections++;
+// intersectMesh records the intersections with the triangles of the BVH rooted at group root, which is the mesh of an
+// instance. The BVH is traversed depth-first using a local stack since OpenCL doesn't allow recursion.
+inline unsigned int intersectMesh(__global group *groups, __global triangle *triangles, int root, unsigned int j, real4 tRayOrigin, real4 tRayDirection, context *ctx, unsigned int numIntersections) {
+    int stack[64];
+    int stackSize = 0;
+    stack[stackSize++] = root;
+    while (stackSize > 0) {
+        group node = groups[stack[--stackSize]];
+        if (!intersectRayWithBox(tRayOrigin, tRayDirection, node.bbMin, node.bbMax)) {
+            continue;
+        }
+        numIntersections = intersectTriangles(triangles, node.triOffset, node.triCount, j, tRayOrigin, tRayDirection, ctx, numIntersections);
+        if (node.children[0] > 0) {
+            stack[stackSize++] = node.children[0];
+        }
+        if (node.children[1] > 0) {
+            stack[stackSize++] = node.children[1];
+        }
+    }
+    return numIntersections;
+}
+
+// intersectGroupTree is intersectMesh for the top-level BVH of object j, whose groups may also hold instances of
+// meshes. The ray is transformed into the space of each intersected instance to traverse its mesh, after which the
+// normals are transformed back and the material of the instance, if any, is applied. This two-level structure is what
+// allows thousands of instances to share the triangles and BVH of a single mesh.
+inline unsigned int intersectGroupTree(__global group *groups, __global triangle *triangles, __global instance *instances, int root, unsigned int j, real4 tRayOrigin, real4 tRayDirection, context *ctx, unsigned int numIntersections) {
+    int stack[64];
+    int stackSize = 0;
+    stack[stackSize++] = root;
+    while (stackSize > 0) {
+        group node = groups[stack[--stackSize]];
+        if (!intersectRayWithBox(tRayOrigin, tRayDirection, node.bbMin, node.bbMax)) {
+            continue;
+        }
+        numIntersections = intersectTriangles(triangles, node.triOffset, node.triCount, j, tRayOrigin, tRayDirection, ctx, numIntersections);
+
+        for (int k = node.instOffset; k < node.instOffset + node.instCount; k++) {
+            unsigned int first = numIntersections;
+            real4 iRayOrigin = mul(instances[k].inverse, tRayOrigin);
+            real4 iRayDirection = mul(instances[k].inverse, tRayDirection);
+            numIntersections = intersectMesh(groups, triangles, instances[k].root, j, iRayOrigin, iRayDirection, ctx, numIntersections);
+            for (unsigned int x = first; x < numIntersections; x++) {
+                real4 n = mul(instances[k].inverseTranspose, ctx->xsTriangle[x]);
+                n.w = 0.0;
+                ctx->xsTriangle[x] = n;
+                ctx->xsInstance[x] = k;
+                if (instances[k].material >= 0) {
+                    ctx->xsMaterial[x] = instances[k].material;
+                }
             }
-            if (t.y != 0.0) {
-                ctx->intersections[numIntersections] = t.y;
+        }
+
+        if (node.children[0] > 0) {
+            stack[stackSize++] = node.children[0];
+        }
+        if (node.children[1] > 0) {
+            stack[stackSize++] = node.children[1];
+        }
+    }
+    return numIntersections;
+}
+
+// intersectObject records all intersections between the ray and object j in ctx, starting at numIntersections, and
+// returns the new number of intersections. time is the time of the ray within the shutter interval, see undoMotion.
+inline unsigned int intersectObject(__local object *objects, unsigned int j, __global group *groups, __global triangle *triangles, __global instance *instances, real4 rayOrigin, real4 rayDirection, real time, context *ctx, unsigned int numIntersections) {
+    int objType = objects[j].type;
+    //  translate our ray into object space by multiplying ray pos and dir
+    //  with inverse object matrix, after moving it relative to where the object is at the time of the ray
+    if (objects[j].hasMotion) {
+        rayOrigin = undoMotion(rayOrigin, &objects[j], time);
+        rayDirection = undoMotion(rayDirection, &objects[j], time);
+    }
+    real4 tRayOrigin = mul(objects[j].inverse, rayOrigin);
+    real4 tRayDirection = mul(objects[j].inverse, rayDirection);
+
+    // Intersection code
+    if (objType == 0) { // PLANE - intersect transformed ray with plane
+        real t = intersectPlane(tRayOrigin, tRayDirection);
+        if (t != 0.0) {
+            ctx->intersections[numIntersections] = t;
+            ctx->xsObjects[numIntersections] = j;
+            numIntersections++;
+        }
+    } else if (objType == 1) { // SPHERE
+
+        // finally, find the intersection distances on our ray.
+        real2 t = intersectSphere(tRayOrigin, tRayDirection);
+         // required for refraction and possibly to detect when the camera starts inside a sphere
+
+        if (t.x != 0.0) {
+            ctx->intersections[numIntersections] = t.x;
+            ctx->xsObjects[numIntersections] = j;
+            numIntersections++;
+        }
+        if (t.y != 0.0) {
+            ctx->intersections[numIntersections] = t.y;
+            ctx->xsObjects[numIntersections] = j;
+            numIntersections++;
+        }
+    } else if (objType == 2) { // CYLINDER
+        real4 out = intersectCylinder(tRayOrigin, tRayDirection, objects[j]);
+        for (unsigned int a = 0; a < 4; a++) {
+            if (out[a] != 0) {
+                ctx->intersections[numIntersections] = out[a];
                 ctx->xsObjects[numIntersections] = j;
                 numIntersections++;
             }
-        } else if (objType == 2) { // CYLINDER
-            double4 out = intersectCylinder(tRayOrigin, tRayDirection, objects[j]);
-            for (unsigned int a = 0; a < 4; a++) {
-                if (out[a] != 0) {
-                    ctx->intersections[numIntersections] = out[a];
-                    ctx->xsObjects[numIntersections] = j;
-                    numIntersections++;
-                }
-            }
-        } else if (objType == 3) { // BOX
-            double2 out = intersectCube(tRayOrigin, tRayDirection);
+        }
+    } else if (objType == 3) { // BOX
+        real2 out = intersectCube(tRayOrigin, tRayDirection);
+
+        // assign intersections
+        if (out.x != 0.0) {
+            ctx->intersections[numIntersections] = out.x;
+            ctx->xsObjects[numIntersections] = j;
+            numIntersections++;
+        }
+        if (out.y != 0.0) {
+            ctx->intersections[numIntersections] = out.y;
+            ctx->xsObjects[numIntersections] = j;
+            numIntersections++;
+        }
 
-            // assign intersections
-            if (out.x != 0.0) {
-                ctx->intersections[numIntersections] = out.x;
+    } else if (objType == 5 || objType == 6) { // CONE and CAPPED CONE
+        real4 out = intersectCone(tRayOrigin, tRayDirection, objects[j], objType == 6);
+        for (unsigned int a = 0; a < 4; a++) {
+            if (out[a] != 0) {
+                ctx->intersections[numIntersections] = out[a];
                 ctx->xsObjects[numIntersections] = j;
                 numIntersections++;
             }
-            if (out.y != 0.0) {
-                ctx->intersections[numIntersections] = out.y;
+        }
+    } else if (objType == 7) { // DISK
+        real t = intersectDisk(tRayOrigin, tRayDirection);
+        if (t != 0.0) {
+            ctx->intersections[numIntersections] = t;
+            ctx->xsObjects[numIntersections] = j;
+            numIntersections++;
+        }
+    } else if (objType == 8) { // TORUS
+        real4 out = intersectTorus(tRayOrigin, tRayDirection, objects[j].minorRadius);
+        for (unsigned int a = 0; a < 4; a++) {
+            if (out[a] != 0) {
+                ctx->intersections[numIntersections] = out[a];
                 ctx->xsObjects[numIntersections] = j;
                 numIntersections++;
             }
+        }
+    } else if (objType == 4) { // GROUPS
+
+        // Group with triangles experiment
+        // Groups MUST have their bounds computed. Start by checking if ray intersects bounds.
+        // Remember: At this point in the code, the group's transform has already modified the ray.
+        // However, the cube intersection is based on transform/rotate/scale to unit cube. Our BB does not
+        // really work that way...
+        // Note!! BB must have extent in all 3-axises. I.e two triangles forming a wall facing the Z axis will have 0
+        // depth which breaks the intersect code. (typically, use this for models that's rarely flat, or fake something if 0.)
+        // Using this BB only reduces teapot with 8 samples from 3m29.753546781s to 31.606680099s.
+        // Further, adding the BB check for each node in the tree further reduces the time taken to 4.037422895s
+        if (!intersectRayWithBox(tRayOrigin, tRayDirection, objects[j].bbMin, objects[j].bbMax)) {
+            // skipped++;
+            return numIntersections;
+        }
+        // hit++;
+
+        // If the "object" BB was intersected, traverse the BVH of each of its children (references to indexes in
+        // "groups").
+        for (int childIndex = 0; childIndex < objects[j].childCount; childIndex++) {
+            numIntersections = intersectGroupTree(groups, triangles, instances, objects[j].children[childIndex], j, tRayOrigin, tRayDirection, ctx, numIntersections);
+        }
+    }
+    return numIntersections;
+}
+
+// isCSG returns true if the object type is one of the CSG operations.
+inline bool isCSG(int objType) {
+    return objType == CSG_UNION || objType == CSG_INTERSECTION || objType == CSG_DIFFERENCE;
+}
+
+// csgIntersectionAllowed is the same as shapes.IntersectionAllowed, i.e. whether an intersection with the left (lhit)
+// or right operand lies on the surface of the combined shape, given whether the ray is currently inside the left and
+// right operands.
+inline bool csgIntersectionAllowed(int op, bool lhit, bool inl, bool inr) {
+    if (op == CSG_UNION) {
+        return (lhit && !inr) || (!lhit && !inl);
+    }
+    if (op == CSG_INTERSECTION) {
+        return (lhit && inr) || (!lhit && inl);
+    }
+    return (lhit && !inr) || (!lhit && inl);
+}
+
+// intersectCSG records the intersections with the CSG node j that lie on the surface of the combined shape. The CSG
+// tree is flattened in pre-order, i.e. objects j+1 to children[1]-1 make up the left operand and children[1] to
+// children[2]-1 the right operand. All intersections with the leaves of the tree are collected first, including
+// those behind the ray origin, which tell whether the ray starts inside an operand. Then each CSG node, starting with
+// the innermost, discards the intersections with its operands that aren't allowed by its operation, see
+// shapes.FilterIntersections. The remaining intersections keep the index of the leaf that was hit, so normals and
+// materials come from the operands.
+inline unsigned int intersectCSG(__local object *objects, unsigned int j, __global group *groups, __global triangle *triangles, __global instance *instances, real4 rayOrigin, real4 rayDirection, real time, context *ctx, unsigned int numIntersections) {
+    unsigned int start = numIntersections;
+    unsigned int end = objects[j].children[2];
+    for (unsigned int k = j + 1; k < end; k++) {
+        if (!isCSG(objects[k].type)) {
+            numIntersections = intersectObject(objects, k, groups, triangles, instances, rayOrigin, rayDirection, time, ctx, numIntersections);
+        }
+    }
+    unsigned int count = numIntersections - start;
+
+    // sort the intersections by t, using insertion sort on indexes since the context holds several arrays per hit
+    unsigned int order[64];
+    bool removed[64];
+    for (unsigned int a = 0; a < count; a++) {
+        unsigned int x = start + a;
+        unsigned int b = a;
+        for (; b > 0 && ctx->intersections[order[b - 1]] > ctx->intersections[x]; b--) {
+            order[b] = order[b - 1];
+        }
+        order[b] = x;
+        removed[a] = false;
+    }
 
-        } else if (objType == 4) { // GROUPS
-
-            // Group with triangles experiment
-            // Groups MUST have their bounds computed. Start by checking if ray intersects bounds.
-            // Remember: At this point in the code, the group's transform has already modified the ray.
-            // However, the cube intersection is based on transform/rotate/scale to unit cube. Our BB does not
-            // really work that way...
-            // Note!! BB must have extent in all 3-axises. I.e two triangles forming a wall facing the Z axis will have 0
-            // depth which breaks the intersect code. (typically, use this for models that's rarely flat, or fake something if 0.)
-            // Using this BB only reduces teapot with 8 samples from 3m29.753546781s to 31.606680099s.
-            // Further, adding the BB check for each node in the tree further reduces the time taken to 4.037422895s
-            if (!intersectRayWithBox(tRayOrigin, tRayDirection, objects[j].bbMin, objects[j].bbMax)) {
-                // skipped++;
+    // in reverse pre-order, the operands of a CSG node are always filtered before the node itself
+    for (int n = end - 1; n >= (int) j; n--) {
+        int op = objects[n].type;
+        if (!isCSG(op)) {
+            continue;
+        }
+        unsigned int right = objects[n].children[1];
+        unsigned int nEnd = objects[n].children[2];
+        bool inl = false;
+        bool inr = false;
+        for (unsigned int a = 0; a < count; a++) {
+            unsigned int leaf = ctx->xsObjects[order[a]];
+            if (removed[a] || leaf <= (unsigned int) n || leaf >= nEnd) {
                 continue;
             }
-            // hit++;
-
-            // If the "object" BB was intersected, we take a look at the "object's" groupOffset. If > -1, we
-            // need to set up a local stack to traverse the group hierarchy
-            if (objects[j].childCount > 0) {
-
-                // this is somewhat ugly, but since a "parent" obj (from objects) may have up to 64 children
-                // (references to indexes in "groups"), we must use a for-statement here.
-                for (int childIndex = 0; childIndex < objects[j].childCount; childIndex++) {
-                    // START PSUEDO-RECURSIVE CODE
-                    // 1) Create an empty stack. (move to top later)
-                    int stack[64] = {0};
-
-                    // Stack index, i.e. current "depth" of stack
-                    int currentSIndex = 0;
-
-                    // Tree index, i.e. which "node index" we're currently processing
-                    int currentNodeIndex = objects[j].children[childIndex];
-
-                    // Initialize current node as root. Note the ugly code to get a pointer to the current node...
-                    group root = groups[currentNodeIndex];
-                    group *current = &root;
-
-                    for (; current != 0 || currentSIndex > -1;) {
-                        for (; current != 0 && intersectRayWithBox(tRayOrigin, tRayDirection, current->bbMin, current->bbMax);) {
-
-                            // Iterate over all triangles and record triangle/ray intersections...
-                            for (int n = current->triOffset; n < current->triOffset + current->triCount; n++) {
-
-                                double4 dirCrossE2 = cross(tRayDirection, triangles[n].e2);
-                                double determinant = dot(triangles[n].e1, dirCrossE2);
-                                if (fabs(determinant) < EPSILON) {
-                                    continue;
-                                }
-
-                                // Triangle misses over P1-P3 edge
-                                double f = 1.0 / determinant;
-                                double4 p1ToOrigin = tRayOrigin - triangles[n].p1;
-                                double u = f * dot(p1ToOrigin, dirCrossE2);
-                                if (u < 0 || u > 1) {
-                                    continue;
-                                }
-
-                                double4 originCrossE1 = cross(p1ToOrigin, triangles[n].e1);
-                                double v = f * dot(tRayDirection, originCrossE1);
-                                if (v < 0 || (u + v) > 1) {
-                                    continue;
-                                }
-                                double t = f * dot(triangles[n].e2, originCrossE1);
-                                ctx->intersections[numIntersections] = t;
-                                ctx->xsObjects[numIntersections] = j;
-
-                                // assume we have vertex normals. If not, assume N in n1,n2,n3
-                                // stored the computed normal in a list using the same indexing as xsObjects so
-                                // if a ray intersects several triangles in the group, we'll get an intersection per triangle
-                                // but can separate their normals and then only use the one for the nearest intersection
-                                ctx->xsTriangle[numIntersections] = triangles[n].n2 * u + triangles[n].n3 * v + triangles[n].n1 * (1.0 - u - v);
-
-                                // experiment: record the color and emission of the intersection
-                                ctx->xsTriangleColor[numIntersections] = triangles[n].color;
-                                ctx->xsTriangleEmission[numIntersections] = (double4){0,0,0,0}; //triangles[n].emission;
-                                numIntersections++;
-                            }
-
-                            // Push the current node index to the Stack, i.e. add at current index and then increment the stack depth.
-                            stack[currentSIndex] = currentNodeIndex;
-                            currentSIndex++;
-
-                            // if the left child is populated (i.e. > -1), update currentNodeIndex with left child and
-                            // update the pointer to the current node
-                            if (current->children[0] > 0) {
-                                currentNodeIndex = current->children[0];
-                                root = groups[current->children[0]];
-                                current = &root;
-                            } else {
-                                // If no left child, mark current as nil, so we can exit the inner for.
-                                current = 0;
-                            }
-                        } // exit of inner for loop, i.e. carry on to the right side
-
-                        // We pop our stack by decrementing (remember, the last iteration above resulting an increment, but no push. (Fix?)
-                        currentSIndex--;
-                        if (currentSIndex == -1) {
-                            goto done;
-                        }
+            bool lhit = leaf < right;
+            if (!csgIntersectionAllowed(op, lhit, inl, inr)) {
+                removed[a] = true;
+            }
+            if (lhit) {
+                inl = !inl;
+            } else {
+                inr = !inr;
+            }
+        }
+    }
 
-                        // get the popped item by fetching the node index from the current stack index.
-                        root = groups[stack[currentSIndex]];
-                        current = &root;
+    // discarded intersections are set to 0, which findClosestIntersection ignores
+    for (unsigned int a = 0; a < count; a++) {
+        if (removed[a]) {
+            ctx->intersections[order[a]] = 0.0;
+        }
+    }
+    return numIntersections;
+}
 
-                        // we're done with the left subtree, check if there's a right-hand node.
-                        if (current->children[1] > 0) {
-                            // if there's a right-hand node, update the node index and the current node.
-                            currentNodeIndex = current->children[1];
-                            root = groups[current->children[1]];
-                            current = &root;
-                        } else {
-                            // if no right-hand side, set current to nil. In a binary tree, we should
-                            // always get a right side if we got a left side...
-                            current = 0;
-                        }
-                    }
-                    // END PSUEDO-RECURSIVE CODE
-                done:
-                    current = 0;
-                }
-            }
+// findClosestIntersection returns the closest intersection. NOTE! It possible we could optimize this for shadow rays,
+// if we pass some kind of maxT - if
+inline intersection findClosestIntersection(__local object *objects, unsigned int numObjects, __global group *groups, __global triangle *triangles, __global instance *instances, real4 rayOrigin, real4 rayDirection, real time, context *ctx) {
+    // ----------------------------------------------------------
+    // Loop through scene objects in order to find intersections
+    // ----------------------------------------------------------
+    unsigned int numIntersections = 0;
+    for (unsigned int j = 0; j < numObjects; j++) {
+        if (isCSG(objects[j].type)) {
+            numIntersections = intersectCSG(objects, j, groups, triangles, instances, rayOrigin, rayDirection, time, ctx, numIntersections);
+            // skip the operands, they're only intersected as part of the CSG node
+            j = objects[j].children[2] - 1;
+        } else {
+            numIntersections = intersectObject(objects, j, groups, triangles, instances, rayOrigin, rayDirection, time, ctx, numIntersections);
         }
     }
 
     if  (numIntersections == 0) {
+
         return (intersection){0.0, -1, -1};
     }
 
     // find lowest positive intersection index
-    double lowestIntersectionT = 1024.0;
+    real lowestIntersectionT = 1024.0;
     int lowestIntersectionIndex = -1;
     int normalIndex = -1;
     for (unsigned int x = 0; x < numIntersections; x++) {
@@ -742,79 +1763,100 @@ inline intersection findClosestIntersection(__local object *objects, unsigned in
 }
 
 
-inline ray rayForPixel(unsigned int x, unsigned int y, camera cam, float rndX, float rndY, int sample, int totalSamples) {
-    double4 pointInView = {0.0, 0.0, -1.0, 1.0};
-    double4 originPoint = {0.0, 0.0, 0.0, 1.0};
-    double xOffset = cam.pixelSize * ((double)x + rndX);
-    double yOffset = cam.pixelSize * ((double)y + rndY);
-
-    // this feels a little hacky but actually works.
-    pointInView.x = cam.halfWidth - xOffset;
-    pointInView.y = cam.halfHeight - yOffset;
-
-    double4 pixel = mul(cam.inverse, pointInView);
-    double4 origin = mul(cam.inverse, originPoint);
-
-    double4 subVec = pixel - origin;
-    double4 direction = normalize(subVec);
-
-    // if DoF...
-    if (cam.aperture != 0) {
-
-        double4 pos = origin + direction * cam.focalLength;
-        double4 newOrigin = {};
-        double2 xy = sunflower(totalSamples, 2, sample, false, rndX);
-        //printf("X: %f Y: %f ", xy.x, xy.y);
-        newOrigin.x = origin.x + (xy.y * cam.aperture);
-        newOrigin.y = origin.y + (xy.x * cam.aperture);
-//        newOrigin.x = origin.x + (-cam.aperture + xy.y * cam.aperture * 2);
-//        newOrigin.y = origin.y + (-cam.aperture + xy.x * cam.aperture * 2);
-        newOrigin.z = origin.z;
-        newOrigin.w = 1.0;
-        direction = pos - newOrigin;
-        origin = newOrigin;
+// rayForPixel computes the ray through the pixel x, y offset by rndX, rndY within the pixel for the projection of the
+// camera, see camera.Projection. Returns false if the point is outside the image of the projection, i.e. the corners
+// of fisheye images. With an aperture, perspective rays start at a point of the lens picked by lensU, lensV instead
+// of the centre of the lens, and pass through the point on the plane in focus that the ray from the centre of the
+// lens would have hit. All of this happens in camera space, so it works no matter how the camera is rotated.
+inline bool rayForPixel(unsigned int x, unsigned int y, camera cam, float rndX, float rndY, float lensU, float lensV, ray *r) {
+    real px = (real)x + rndX;
+    real py = (real)y + rndY;
+    real4 origin = (real4)(0.0, 0.0, 0.0, 1.0);
+    real4 direction;
+
+    if (cam.projection == 1) {
+        // orthographic, parallel rays from the scaled image plane
+        origin.x = (cam.halfWidth - px * cam.pixelSize) * cam.orthoScale;
+        origin.y = (cam.halfHeight - py * cam.pixelSize) * cam.orthoScale;
+        direction = (real4)(0.0, 0.0, -1.0, 0.0);
+    } else if (cam.projection == 2) {
+        // equidistant fisheye, the angle to the view direction grows linearly up to 90° at the edge of the circle
+        real radius = (real)min(cam.width, cam.height) * 0.5;
+        real nx = ((real)cam.width * 0.5 - px) / radius;
+        real ny = ((real)cam.height * 0.5 - py) / radius;
+        real dist = sqrt(nx * nx + ny * ny);
+        if (dist > 1.0) {
+            return false;
+        }
+        real theta = dist * PI * 0.5;
+        real phi = atan2(ny, nx);
+        direction = (real4)(sin(theta) * cos(phi), sin(theta) * sin(phi), -cos(theta), 0.0);
+    } else if (cam.projection == 3) {
+        // equirectangular, longitude along x and latitude along y
+        real phi = (px / (real)cam.width - 0.5) * 2.0 * PI;
+        real theta = (0.5 - py / (real)cam.height) * PI;
+        // for stereo, the eye is on the left (or the right) of the horizontal direction of the ray
+        origin.x = cos(phi) * cam.eyeOffset;
+        origin.z = -sin(phi) * cam.eyeOffset;
+        direction = (real4)(-sin(phi) * cos(theta), sin(theta), -cos(phi) * cos(theta), 0.0);
+    } else {
+        // the image plane is at z = -1
+        direction = (real4)(cam.halfWidth - px * cam.pixelSize, cam.halfHeight - py * cam.pixelSize, -1.0, 0.0);
+
+        // if DoF...
+        if (cam.aperture > 0.0) {
+            real4 focus = origin + direction * cam.focusDistance;
+            real2 lens = sampleLens(lensU, lensV, cam.blades, cam.bladeRotation) * cam.aperture;
+            origin.x = lens.x;
+            origin.y = lens.y;
+            direction = focus - origin;
+        }
     }
-    ray r = {origin, direction};
-    return r;
+
+    r->origin = mul(cam.inverse, origin);
+    r->direction = normalize(mul(cam.inverse, direction));
+    return true;
 }
 
 // nextEventEstimation is a very efficient method of reducing noise by directly sampling all light sources for each bounce,
 // checking for line of sight to a random point on every lightsource. However, NEE only works reasonably well with diffuse
 // materials.
 //
-// This function operates on a
-inline void nextEventEstimation(__local object *objects, unsigned int numObjects, __global group *groups, __global triangle *triangles, bounce *b, double fgi, double fgi2, double n, double4 mask, unsigned int x, double4 *accumColor) {
+// This function operates on the hit point, normal and surface color of the current bounce, and draws the points on the
+// light sources from the current dimension of the sampler on.
+inline void nextEventEstimation(__local object *objects, unsigned int numObjects, __global group *groups, __global triangle *triangles, __global instance *instances, real4 point, real4 normal, real4 color, real4 mask, real time, pathSampler *smp, real4 *accumColor) {
     for (unsigned int l = 0; l < numObjects;l++) {
         if (objects[l].emission.x > 0.0) { // Note: handle if we have a light source without red emission...
 
-            double4 lightOriginPosition = (double4)(objects[l].transform[3], objects[l].transform[7], objects[l].transform[11], 0.0); // note .w will be == 1 after next line
-            double scaleBy = max(max(objects[l].transform[0], objects[l].transform[5]), objects[l].transform[10]);
-            double4 lightScale = (double4)(scaleBy, scaleBy, scaleBy, 1.0);
-            double4 rpos = randomPointOnSphere(1.0, noise3D(fgi, n+x*l, fgi2), noise3D(fgi, fgi2, n+x*x*l));
-            double4 lightPosition = lightOriginPosition + (rpos * lightScale);
+            real4 lightOriginPosition = (real4)(objects[l].transform[3], objects[l].transform[7], objects[l].transform[11], 0.0); // note .w will be == 1 after next line
+            real scaleBy = max(max(objects[l].transform[0], objects[l].transform[5]), objects[l].transform[10]);
+            real4 lightScale = (real4)(scaleBy, scaleBy, scaleBy, 1.0);
+            real2 u = sample2D(smp);
+            real4 rpos = randomPointOnSphere(1.0, u.x, u.y);
+            real4 lightPosition = lightOriginPosition + (rpos * lightScale);
 
-            double4 shadowRayDirection = normalize(lightPosition - b->point);
-            double4 shadowRayOrigin = b->point + (shadowRayDirection*EPSILON); // take a slight overpos
+            real4 shadowRayDirection = normalize(lightPosition - point);
+            real4 shadowRayOrigin = point + (shadowRayDirection*EPSILON); // take a slight overpos
 
-            double lightDotNormal = dot(shadowRayDirection, b->normal);
+            real lightDotNormal = dot(shadowRayDirection, normal);
             if (lightDotNormal > 0.0) {
 
                 // now, we need to check if the shadowRay intersects any scene object EXCEPT our light source...
                 context ctx = {{0},{0},{0},{0},{0}};
-                intersection ixs = findClosestIntersection(objects, numObjects, groups, triangles, shadowRayOrigin, shadowRayDirection, &ctx);
+                intersection ixs = findClosestIntersection(objects, numObjects, groups, triangles, instances, shadowRayOrigin, shadowRayDirection, time, &ctx);
                 if (ixs.lowestIntersectionIndex == l && ixs.t > EPSILON) {
-                    double4 effectiveColor = b->color * objects[l].emission;
+                    real4 effectiveColor = color * objects[l].emission;
 
                     // I've seen this as well:
                     // l += light.getPower() * cos * cosp * rectangle.getArea() / lengthSquared;
                     // perhaps use the surface area of the light's hemisphere and divide by t*t?
                     // 2*Pi*r2
-                    //double attenuation = 2*PI*objects[0].transform[0]*objects[0].transform[0] / ((0.25+ixs.t)*(0.25+ixs.t));
+                    //real attenuation = 2*PI*objects[0].transform[0]*objects[0].transform[0] / ((0.25+ixs.t)*(0.25+ixs.t));
 
                     // Christian's attenuation based on % of hemisphere which is covered by light source.
                     // Note 8 months later: I can't figure out why I'm using that value from the object's transform...
                     // ..it may be a trick to not accidently divide by zero? But what if x is == 0 and t is 0????
-                    double attenuation = 1 - ixs.t / sqrt(ixs.t*ixs.t + objects[l].transform[0]*objects[l].transform[0]);
+                    real attenuation = 1 - ixs.t / sqrt(ixs.t*ixs.t + objects[l].transform[0]*objects[l].transform[0]);
 
                     // Compute and update the accumulate color pointer passed to the function
                     *accumColor += effectiveColor * lightDotNormal * mask * attenuation;
@@ -828,32 +1870,108 @@ inline void nextEventEstimation(__local object *objects, unsigned int numObjects
 // CLK_ADDRESS_REPEAT makes sure that we don't get "mirrored" textures when crossing the 1.0 or 0.0 boundaries.
 __constant sampler_t sampler = CLK_NORMALIZED_COORDS_TRUE | CLK_ADDRESS_REPEAT | CLK_FILTER_LINEAR;
 
-__kernel void trace(__constant object *global_objects, unsigned int numObjects, __global triangle *triangles, __global group *groups, __global double *output,
-                    __constant double *seedX, unsigned int samples, __global camera *cam, unsigned int yOffset,
+// perturbNormal applies a tangent-space normal map to the object space normal n. st is the texture coordinate, where
+// t increases downwards in the image. tangent points towards increasing s and bitangent roughly towards decreasing t,
+// i.e. "up" in the image. Only the direction of the bitangent is used, both are made orthonormal to n here.
+inline real4 perturbNormal(image2d_array_t image, real2 st, real4 n, real4 tangent, real4 bitangent, unsigned char textureIndex, float strength) {
+    n.w = 0.0;
+    tangent.w = 0.0;
+    bitangent.w = 0.0;
+    n = normalize(n);
+    tangent = tangent - n * dot(n, tangent);
+    if (length(tangent) < EPSILON) {
+        // no usable tangent, e.g. a triangle without texture coordinates
+        return n;
+    }
+    tangent = normalize(tangent);
+    real4 b = cross(n, tangent);
+    if (dot(b, bitangent) < 0.0) {
+        b = -b;
+    }
+
+    // remap the color from [0, 1] to [-1, 1] and scale the bumps by strength
+    float4 rgba = read_imagef(image, sampler, (float4)(st.x, st.y, textureIndex, 0));
+    real mx = (rgba.x * 2.0 - 1.0) * strength;
+    real my = (rgba.y * 2.0 - 1.0) * strength;
+    real mz = rgba.z * 2.0 - 1.0;
+    return normalize(tangent * mx + b * my + n * max(mz, EPSILON));
+}
+
+// applyMaterial replaces the material properties of obj with those of the material m.
+inline void applyMaterial(object *obj, __global material *m) {
+    obj->color = m->color;
+    obj->emission = m->emission;
+    obj->eta = m->eta;
+    obj->k = m->k;
+    obj->absorption = m->absorption;
+    obj->refractiveIndex = m->refractiveIndex;
+    obj->reflectivity = m->reflectivity;
+    obj->roughness = m->roughness;
+    obj->metalness = m->metalness;
+    obj->specular = m->specular;
+    obj->clearcoat = m->clearcoat;
+    obj->clearcoatRoughness = m->clearcoatRoughness;
+    obj->sheen = m->sheen;
+    obj->transmission = m->transmission;
+    obj->abbeNumber = m->abbeNumber;
+    obj->textureScaleX = m->textureScaleX;
+    obj->textureScaleY = m->textureScaleY;
+    obj->textureScaleXNM = m->textureScaleXNM;
+    obj->textureScaleYNM = m->textureScaleYNM;
+    obj->strengthNM = m->strengthNM;
+    obj->isTextured = m->isTextured;
+    obj->textureIndex = m->textureIndex;
+    obj->isTexturedNM = m->isTexturedNM;
+    obj->textureIndexNM = m->textureIndexNM;
+    obj->colorPattern = m->colorPattern;
+    obj->roughnessPattern = m->roughnessPattern;
+    obj->bumpPattern = m->bumpPattern;
+    obj->materialID = m->materialID;
+}
+
+__kernel void trace(__constant object *global_objects, unsigned int numObjects, __global triangle *triangles, __global group *groups, __global instance *instances, __global material *materials, __global pattern *patterns, __global real *output, __global real *aovs,
+                    unsigned int seed, __global const ushort *blueNoise, __global const uint *pixelSamples, unsigned int firstSample,
+                    __global camera *cam, unsigned int yOffset,
                     image2d_array_t image, image2d_array_t sphereTextures, image2d_array_t cubeMapTextures) {
 
     // int skipped = 0;
     // int hit = 0;
-    double colorWeight = 1.0 / samples;
     int i = get_global_id(0);
-    __local float fgi, fgi2;
-    fgi = seedX[i] / numObjects;
-    fgi2 = seedX[i] / samples;
-    double4 originPoint = (double4)(0.0f, 0.0f, 0.0f, 1.0f);
-    double4 colors = (double4)(0, 0, 0, 0);
+    real4 originPoint = (real4)(0.0f, 0.0f, 0.0f, 1.0f);
+    real4 colors = (real4)(0, 0, 0, 0);
+    real sumOfSquares = 0.0;
+    real4 splats[FILTER_PIXELS];
+
+    // the AOVs of the first hits of the samples, and the light of the samples split into direct and indirect light
+    real4 albedoSum = (real4)(0.0, 0.0, 0.0, 0.0);
+    real4 normalSum = (real4)(0.0, 0.0, 0.0, 0.0);
+    real4 positionSum = (real4)(0.0, 0.0, 0.0, 0.0);
+    real4 directSum = (real4)(0.0, 0.0, 0.0, 0.0);
+    real4 indirectSum = (real4)(0.0, 0.0, 0.0, 0.0);
+    real depthSum = 0.0;
+    unsigned int hits = 0;
+    int objectID = -1;
+    int materialID = -1;
+    for (int k = 0; k < FILTER_PIXELS; k++) {
+        splats[k] = (real4)(0.0, 0.0, 0.0, 0.0);
+    }
 
     // experiment: copy objects to local memory. May actually be faster, at least on CPU?
-    __local object objects[16];
+    __local object objects[MAX_OBJECTS];
+    numObjects = min(numObjects, (unsigned int) MAX_OBJECTS);
     for (unsigned int a = 0;a < numObjects;a++) {
         objects[a] = global_objects[a];
     }
 
-    __local intersection ixs;
-
     // get current x,y coordinate from i given image width
     unsigned int x = i % cam->width;
     unsigned int y = yOffset + i / cam->width;
 
+    // pixels may take any number of samples, including none, continuing the samples of earlier passes from firstSample.
+    // The last batch of rows may reach past the bottom of the image.
+    unsigned int samples = y < (unsigned int)cam->height ? pixelSamples[y * cam->width + x] : 0;
+    real colorWeight = samples > 0 ? 1.0 / samples : 0.0;
+
 // Comment in to debug a single pixel
 //    if (x != 428 || y != 558) {
 //        output[i * 4] = 1.0;
@@ -863,94 +1981,225 @@ __kernel void trace(__constant object *global_objects, unsigned int numObjects,
 //        return;
 //    }
 
-    __local double4 rayOrigin, rayDirection;
     for (unsigned int n = 0; n < samples; n++) {
         // For each sample, compute a new ray cast through the target (x,y) pixel with random offset within the pixel.
-        ray r = rayForPixel(x, y, *cam, noise3D(fgi, n, fgi2), noise3D(fgi, fgi2, n), n, samples);
-        rayOrigin = r.origin;
-        rayDirection = r.direction;
-
-        unsigned int actualBounces = 0;
-        unsigned int effectiveBounces = 0;
-        // Each ray may bounce up to 16 times
-        __local bounce bounces[16]; // = {};
+        pathSampler smp = samplerStart(seed, x, y, cam->width, firstSample + n, blueNoise);
+        smp.dimension = PIXEL_DIMENSION;
+        real2 pixelSample = sample2D(&smp);
+        smp.dimension = LENS_DIMENSION;
+        real2 lensSample = sample2D(&smp);
+
+        // the weights of the sample for the pixels around it count even if the sample is outside the image of the
+        // projection, so that it stays anti-aliased at its edges
+        real filterWeights[FILTER_PIXELS];
+        for (int dy = -FILTER_EXTENT; dy <= FILTER_EXTENT; dy++) {
+            for (int dx = -FILTER_EXTENT; dx <= FILTER_EXTENT; dx++) {
+                int k = (dy + FILTER_EXTENT) * FILTER_WIDTH + dx + FILTER_EXTENT;
+                filterWeights[k] = filter1D((real)dx + 0.5 - pixelSample.x) * filter1D((real)dy + 0.5 - pixelSample.y);
+                splats[k].w += filterWeights[k];
+            }
+        }
+
+        ray r;
+        if (!rayForPixel(x, y, *cam, pixelSample.x, pixelSample.y, lensSample.x, lensSample.y, &r)) {
+            // nothing to see outside the image of the projection
+            continue;
+        }
+        // the ray and its intersections are private to the work-item, sharing them with the work group would mix up
+        // the paths of its pixels
+        real4 rayOrigin = r.origin;
+        real4 rayDirection = r.direction;
+        // the whole path is traced at the same random time while the shutter is open, see undoMotion
+        smp.dimension = TIME_DIMENSION;
+        real shutterTime = sample1D(&smp);
+
+        // accumColor is the light gathered by this path so far, while throughput is the fraction of any light found
+        // further down the path that still reaches the camera, i.e. the product of all colors and cosines so far.
+        real4 accumColor = (real4)(0.0, 0.0, 0.0, 0.0);
+        // direct is the part of accumColor that comes straight from a light source, or off the first surface the path
+        // scatters off, i.e. the first one that it doesn't just refract through. surfaceHits counts those surfaces.
+        real4 direct = (real4)(0.0, 0.0, 0.0, 0.0);
+        unsigned int surfaceHits = 0;
+        real4 throughput = (real4)(1.0, 1.0, 1.0, 1.0);
         bool entering = false;
         bool inside = false;
         bool exiting = false;
         bool reflecting = false;
+        // absorption coefficients of the medium we're currently inside, if any. Set when entering a refractive object.
+        real4 mediumAbsorption = (real4)(0.0, 0.0, 0.0, 0.0);
+        // wavelength in nm once the path has hit a dispersive object, 0 while the path still carries all of RGB.
+        real wavelength = 0.0;
 
-        // For each ray, allow up to MAX_BOUNCES bounces, with a cap of MAX_EFFECTIVE_BOUNCES since refraction
-        // does not "consume" a color-contributing "effective" bounce.
-        for (unsigned int b = 0; b < MAX_BOUNCES && effectiveBounces < MAX_EFFECTIVE_BOUNCES ; b++) {
+        // For each ray, allow up to MAX_DEPTH bounces. Once past RR_DEPTH, russian roulette decides if the path
+        // should continue or not.
+        for (unsigned int b = 0; b < MAX_DEPTH; b++) {
 
             context ctx = {{0},{0},{0},{0},{0}};
-            ixs = findClosestIntersection(objects, numObjects, groups, triangles, rayOrigin, rayDirection, &ctx);
+            intersection ixs = findClosestIntersection(objects, numObjects, groups, triangles, instances, rayOrigin, rayDirection, shutterTime, &ctx);
 
             if (ixs.lowestIntersectionIndex > -1) {
                 object obj = objects[ixs.lowestIntersectionIndex];
+                if (obj.hasMotion) {
+                    applyMotion(&obj, &objects[ixs.lowestIntersectionIndex], shutterTime);
+                }
+                // a model may have many different materials, so hits on triangles use the material of the triangle
+                if (obj.type == 4) {
+                    applyMaterial(&obj, &materials[ctx.xsMaterial[ixs.normalIndex]]);
+                }
+
+                // Beer-Lambert: the segment we just travelled was inside a medium, attenuate by its absorption.
+                if (inside) {
+                    throughput *= exp(-mediumAbsorption * (ixs.t * length(rayDirection)));
+                }
 
                 // Remember that we use the untransformed ray here!
 
                 // Position gives us the intersection position along RAY at T
-                double4 position = rayOrigin + rayDirection * ixs.t;
+                real4 position = rayOrigin + rayDirection * ixs.t;
 
                 // The vector to the eye (or last bounce origin) is exactly the opposite
                 // of the ray direction
-                double4 eyeVector = -rayDirection;
+                real4 eyeVector = -rayDirection;
 
                 // object normal at intersection: Transform point from world to object
                 // space
-                double4 objectNormal;
+                real4 objectNormal;
 
-                // PLANE always have its normal UP in local space (unless we have a normal map)
+                // PLANE always have its normal UP in local space
                 if (obj.type == 0) {
-                    if (obj.isTexturedNM) {
-                        double4 localPoint = mul(obj.inverse, position);
-                        float4 rgba = read_imagef(image, sampler, (float4)(fabs(localPoint.x) * obj.textureScaleXNM, fabs(localPoint.z) * obj.textureScaleYNM, obj.textureIndexNM, 0));
-                        objectNormal = (double4)(rgba.x, rgba.y, rgba.z, 0.0);
-                        objectNormal = normalize(objectNormal);
-                    } else {
-                        objectNormal = (double4)(0.0, 1.0, 0.0, 0.0);
-                    }
+                    objectNormal = (real4)(0.0, 1.0, 0.0, 0.0);
                 } else if (obj.type == 1) {
 
                     // SPHERE always has its normal from sphere center outwards to the
                     // world position.
-                    double4 localPoint = mul(obj.inverse, position);
+                    real4 localPoint = mul(obj.inverse, position);
                     objectNormal = localPoint - originPoint;
                 } else if (obj.type == 2) {
                     // CYLINDER
                     // compute the square of the distance from the y axis
-                    double4 localPoint = mul(obj.inverse, position);
-                    double dist = pow(localPoint.x, 2) + pow(localPoint.z, 2);
+                    real4 localPoint = mul(obj.inverse, position);
+                    real dist = pow(localPoint.x, 2) + pow(localPoint.z, 2);
                     if (dist < 1 && localPoint.y >= obj.maxY - EPSILON) {
-                        objectNormal = (double4)(0.0, 1.0, 0.0, 0.0);
+                        objectNormal = (real4)(0.0, 1.0, 0.0, 0.0);
                     } else if (dist < 1 && localPoint.y <= obj.minY + EPSILON) {
-                        objectNormal = (double4)(0.0, -1.0, 0.0, 0.0);
+                        objectNormal = (real4)(0.0, -1.0, 0.0, 0.0);
                     } else {
-                        objectNormal = (double4)(localPoint.x, 0.0, localPoint.z, 0.0);
+                        objectNormal = (real4)(localPoint.x, 0.0, localPoint.z, 0.0);
                     }
                 } else if (obj.type == 3) {
                     // CUBE
                     // NormalAtLocal for a cube uses the fact that given a unit cube, the point of the surface axis X,Y or Z is
                     // always either 1.0 for positive XYZ and -1.0 for negative XYZ. I.e - if the point is 0.4, 1, -0.5,
                     // we know that the point is on the top Y surface and we can return a 0,1,0 normal.
-                    double4 localPoint = mul(obj.inverse, position);
-                    double maxc = maxX(fabs(localPoint.x), fabs(localPoint.y), fabs(localPoint.z));
+                    real4 localPoint = mul(obj.inverse, position);
+                    real maxc = maxX(fabs(localPoint.x), fabs(localPoint.y), fabs(localPoint.z));
                     if (maxc == fabs(localPoint.x)) {
-                        objectNormal = (double4)(localPoint.x, 0.0, 0.0, 0.0);
+                        objectNormal = (real4)(localPoint.x, 0.0, 0.0, 0.0);
                     } else if (maxc == fabs(localPoint.y)) {
-                        objectNormal = (double4)(0.0, localPoint.y, 0.0, 0.0);
+                        objectNormal = (real4)(0.0, localPoint.y, 0.0, 0.0);
                     } else {
-                        objectNormal = (double4)(0.0, 0.0, localPoint.z, 0.0);
+                        objectNormal = (real4)(0.0, 0.0, localPoint.z, 0.0);
                     }
                 } else if (obj.type == 4) {
                     // GROUP, which in practice means a triangle, whose normal is typically pre-populated in N and stored in xsTriangles
                     objectNormal = ctx.xsTriangle[ixs.normalIndex];
+                } else if (obj.type == 5 || obj.type == 6) {
+                    // CONE, caps only for capped cones
+                    real4 localPoint = mul(obj.inverse, position);
+                    real dist = localPoint.x * localPoint.x + localPoint.z * localPoint.z;
+                    if (obj.type == 6 && dist < obj.maxY * obj.maxY && localPoint.y >= obj.maxY - EPSILON) {
+                        objectNormal = (real4)(0.0, 1.0, 0.0, 0.0);
+                    } else if (obj.type == 6 && dist < obj.minY * obj.minY && localPoint.y <= obj.minY + EPSILON) {
+                        objectNormal = (real4)(0.0, -1.0, 0.0, 0.0);
+                    } else {
+                        real y = sqrt(dist);
+                        objectNormal = (real4)(localPoint.x, localPoint.y > 0.0 ? -y : y, localPoint.z, 0.0);
+                    }
+                } else if (obj.type == 7) {
+                    // DISK always has its normal UP in local space, just like planes
+                    objectNormal = (real4)(0.0, 1.0, 0.0, 0.0);
+                } else if (obj.type == 8) {
+                    // TORUS, the gradient of the torus function
+                    real4 localPoint = mul(obj.inverse, position);
+                    real q = localPoint.x * localPoint.x + localPoint.y * localPoint.y + localPoint.z * localPoint.z + 1.0 - obj.minorRadius * obj.minorRadius;
+                    objectNormal = (real4)(localPoint.x * (q - 2.0), localPoint.y * q, localPoint.z * (q - 2.0), 0.0);
+                }
+
+                // Tangent-space normal mapping. Each primitive provides texture coordinates plus a tangent and
+                // bitangent in object space, see perturbNormal.
+                if (obj.isTexturedNM) {
+                    real4 localPoint = mul(obj.inverse, position);
+                    localPoint.w = 0.0;
+                    real2 st = (real2)(0.0, 0.0);
+                    real4 tangent = (real4)(1.0, 0.0, 0.0, 0.0);
+                    real4 bitangent = (real4)(0.0, 0.0, -1.0, 0.0);
+                    if (obj.type == 0) {
+                        // PLANE, repeats once per unit, same orientation as color textures
+                        st = (real2)(localPoint.x, localPoint.z);
+                    } else if (obj.type == 1 || (obj.type == 2 && fabs(objectNormal.y) < 0.5)) {
+                        // SPHERE and CYLINDER sides, u goes around the Y axis just like sphericalMap
+                        real2 uv = sphericalMap(localPoint);
+                        st = (real2)(uv.x, obj.type == 1 ? 1.0 - uv.y : -localPoint.y);
+                        tangent = (real4)(-localPoint.z, 0.0, localPoint.x, 0.0);
+                        bitangent = (real4)(0.0, 1.0, 0.0, 0.0);
+                    } else if (obj.type == 2) {
+                        // CYLINDER caps are mapped like planes
+                        st = (real2)(localPoint.x, localPoint.z);
+                    } else if (obj.type == 3) {
+                        // CUBE, each face is mapped onto the full texture with the tangent pointing right and the
+                        // bitangent up as seen from outside the face.
+                        if (objectNormal.x != 0.0) {
+                            tangent = (real4)(0.0, 0.0, objectNormal.x > 0.0 ? -1.0 : 1.0, 0.0);
+                            bitangent = (real4)(0.0, 1.0, 0.0, 0.0);
+                        } else if (objectNormal.y != 0.0) {
+                            tangent = (real4)(1.0, 0.0, 0.0, 0.0);
+                            bitangent = (real4)(0.0, 0.0, objectNormal.y > 0.0 ? -1.0 : 1.0, 0.0);
+                        } else {
+                            tangent = (real4)(objectNormal.z > 0.0 ? 1.0 : -1.0, 0.0, 0.0, 0.0);
+                            bitangent = (real4)(0.0, 1.0, 0.0, 0.0);
+                        }
+                        st = (real2)((dot(localPoint, tangent) + 1.0) * 0.5, (1.0 - dot(localPoint, bitangent)) * 0.5);
+                    } else if (obj.type >= 5 && obj.type <= 8) {
+                        // CONE, DISK and TORUS. Cone caps use the plane defaults.
+                        st = shapeST(obj, localPoint);
+                        if (obj.type == 7) {
+                            bitangent = (real4)(0.0, 0.0, 1.0, 0.0);
+                        } else if (obj.type == 8) {
+                            real rho = sqrt(localPoint.x * localPoint.x + localPoint.z * localPoint.z);
+                            tangent = (real4)(-localPoint.z, 0.0, localPoint.x, 0.0);
+                            bitangent = (real4)(-localPoint.y * localPoint.x / rho, rho - 1.0, -localPoint.y * localPoint.z / rho, 0.0);
+                        } else if (!isConeCap(obj, localPoint)) {
+                            tangent = (real4)(-localPoint.z, 0.0, localPoint.x, 0.0);
+                            bitangent = (real4)(0.0, 1.0, 0.0, 0.0);
+                        }
+                    } else if (obj.type == 4) {
+                        // GROUP, interpolate texture coordinates and tangents of the intersected triangle
+                        triangle tri = triangles[ctx.xsTriangleIndex[ixs.normalIndex]];
+                        real u = ctx.xsTriangleBary[ixs.normalIndex].x;
+                        real v = ctx.xsTriangleBary[ixs.normalIndex].y;
+                        real2 uv = tri.uv2 * u + tri.uv3 * v + tri.uv1 * (1.0 - u - v);
+                        st = (real2)(uv.x, 1.0 - uv.y);
+                        tangent = tri.tan2 * u + tri.tan3 * v + tri.tan1 * (1.0 - u - v);
+                        tangent.w = 0.0;
+                        bitangent = cross(normalize(cross(tri.p2 - tri.p1, tri.p3 - tri.p1)), tangent) * tri.tan1.w;
+                        // the tangents of instanced meshes are in the space of the mesh, while the normal is not
+                        int inst = ctx.xsInstance[ixs.normalIndex];
+                        if (inst >= 0) {
+                            tangent = mul(instances[inst].transform, tangent);
+                            bitangent = mul(instances[inst].transform, bitangent);
+                        }
+                    }
+                    st = (real2)(st.x * obj.textureScaleXNM, st.y * obj.textureScaleYNM);
+                    objectNormal = perturbNormal(image, st, objectNormal, tangent, bitangent, obj.textureIndexNM, obj.strengthNM);
+                }
+
+                // Procedural bump pattern, evaluated in object space just like the normal.
+                if (obj.bumpPattern > 0) {
+                    objectNormal = bumpNormal(&patterns[obj.bumpPattern - 1], mul(obj.inverse, position), objectNormal);
                 }
                 // Finish the normal vector by multiplying it back into world coord
                 // using the inverse transpose matrix and then normalize it
-                double4 normalVec = mul(obj.inverseTranspose, objectNormal);
+                real4 normalVec = mul(obj.inverseTranspose, objectNormal);
                 normalVec.w = 0.0; // set w to 0
                 normalVec = normalize(normalVec);
 
@@ -963,101 +2212,252 @@ __kernel void trace(__constant object *global_objects, unsigned int numObjects,
                     normalVec = normalVec * -1.0;
                 }
 
+                // Resolve the color and emission of the hit. They're applied once the next bounce has been sampled, but
+                // the AOVs of the first hit need the color before sampling may end the path.
+                real4 color = obj.color;
+                real4 emission = obj.emission;
+                if (obj.isTextured) {
+                    // texture experiment for PLANE, CUBE and SPHERE, plus CONE, DISK and TORUS using the plane textures
+                    if (obj.type == 0) { // PLANE
+                        real4 localPoint = mul(obj.inverse, position);
+                        float4 rgba = read_imagef(image, sampler, (float4)(localPoint.x * obj.textureScaleX, localPoint.z * obj.textureScaleY, obj.textureIndex, 0));
+                        color = (real4)(rgba.x, rgba.y, rgba.z, 1.0);
+                    } else if (obj.type == 1) { // SPHERE
+                        real4 localPoint = mul(obj.inverse, position);
+                        real2 uv = sphericalMap(localPoint);
+                        float4 rgba = read_imagef(sphereTextures, sampler, (float4)(uv.x, 1.0-uv.y, obj.textureIndex, 0));
+                        color = (real4)(rgba.x, rgba.y, rgba.z, 1.0);
+                    } else if (obj.type == 3) { // CUBE
+                        real4 localPoint = mul(obj.inverse, position);
+                        real2 uv = cubeUV(localPoint);
+                        float4 rgba = read_imagef(cubeMapTextures, sampler, (float4)(uv.x, uv.y, obj.textureIndex, 0));
+                        color = (real4)(rgba.x, rgba.y, rgba.z, 1.0);
+                    } else if (obj.type >= 5 && obj.type <= 8) { // CONE, DISK and TORUS
+                        real2 st = shapeST(obj, mul(obj.inverse, position));
+                        float4 rgba = read_imagef(image, sampler, (float4)(st.x * obj.textureScaleX, st.y * obj.textureScaleY, obj.textureIndex, 0));
+                        color = (real4)(rgba.x, rgba.y, rgba.z, 1.0);
+                    } else if (obj.type == 4) { // GROUP, using the texture coordinates of the triangle
+                        triangle tri = triangles[ctx.xsTriangleIndex[ixs.normalIndex]];
+                        real u = ctx.xsTriangleBary[ixs.normalIndex].x;
+                        real v = ctx.xsTriangleBary[ixs.normalIndex].y;
+                        real2 uv = tri.uv2 * u + tri.uv3 * v + tri.uv1 * (1.0 - u - v);
+                        float4 rgba = read_imagef(image, sampler, (float4)(uv.x * obj.textureScaleX, (1.0 - uv.y) * obj.textureScaleY, obj.textureIndex, 0));
+                        color = (real4)(rgba.x, rgba.y, rgba.z, 1.0);
+                    }
+                }
+                if (obj.colorPattern > 0) {
+                    color = patternColor(&patterns[obj.colorPattern - 1], mul(obj.inverse, position));
+                }
+
+                if (b == 0) {
+                    hits++;
+                    albedoSum += color;
+                    normalSum += normalVec;
+                    positionSum += position;
+                    depthSum += ixs.t * length(rayDirection);
+                    if (objectID == -1) {
+                        objectID = ixs.lowestIntersectionIndex;
+                        materialID = obj.materialID;
+                    }
+                }
+
                 // Compute the over point, with a slight offset along the normal, in
                 // order to avoid self-intersection on the next bounce.
-                double4 overPoint = position + normalVec * EPSILON;
+                real4 overPoint = position + normalVec * EPSILON;
 
                 // Prepare the outgoing ray (next bounce) by reusing the original ray, just
                 // update its origin and direction.
 
                 // Impl here supports either diffuse or reflected, but for obj.reflectivity > 0 a proportionate portion of samples
                 // will diffuse instead of reflect. Poor-man's BRDF
-                double cosine = 1.0; // experiment: for reflected, always use 1.0
+                real cosine = 1.0; // experiment: for reflected, always use 1.0
                 entering = false;
                 exiting = false;
                 reflecting = false;
-                double sch = 0.0;
+                real sch = 0.0;
+
+                // Principled layers: untinted is set for bounces off white specular layers, which should not be tinted
+                // by the base color, and sheenWeight adds white to diffuse bounces at grazing angles.
+                bool untinted = false;
+                real sheenWeight = 0.0;
+                real cosO = dot(eyeVector, normalVec);
+                real glossyWeight = 1.0;
+                real cosM = 1.0;
+                // random numbers for sampling the direction of the next ray, whichever way the path goes, followed by
+                // those for choosing the way, see the dimensions of the samplers
+                uint bounceDimension = BOUNCE_DIMENSION + b * DIMENSIONS_PER_BOUNCE;
+                smp.dimension = b == 0 ? FIRST_BSDF_DIMENSION : bounceDimension + BSDF_DIMENSION;
+                real2 directionSample = sample2D(&smp);
+                smp.dimension = bounceDimension + LOBE_DIMENSION;
+
+                // Roughness may vary over the surface by a procedural pattern.
+                real roughness = obj.roughness;
+                if (obj.roughnessPattern > 0) {
+                    roughness = clamp(patternScalar(&patterns[obj.roughnessPattern - 1], mul(obj.inverse, position)), 0.0, 1.0);
+                }
+
+                // Once inside a (partially) transmissive object, keep treating it as glass until the path exits.
+                bool transmits = inside || obj.transmission == 0.0 || sample1D(&smp) < obj.transmission;
+
+                // Dispersive objects refract each wavelength differently. Non-dispersive ones stay on the RGB path.
+                real ior = obj.refractiveIndex;
+                if (obj.abbeNumber > 0.0 && ior != 1.0 && ior != -1.0) {
+                    if (wavelength == 0.0) {
+                        wavelength = MIN_WAVELENGTH + sample1D(&smp) * (MAX_WAVELENGTH - MIN_WAVELENGTH);
+                        throughput *= wavelengthToRGB(wavelength);
+                    }
+                    ior = cauchyIOR(obj.refractiveIndex, obj.abbeNumber, wavelength);
+                }
 
                 // First, decide to refract or reflect depending on material properties.
-                if (obj.reflectivity != 0.0 && noise3D(fgi, n, b) < obj.reflectivity) {
-                    // reflect, even if transparent.
-                    // Reflected, calculate reflection vector and set as rayDirection
-                    double dotScalar = dot(rayDirection, normalVec);
-                    double4 norm = (normalVec * 2.0) * dotScalar;
-                    rayDirection = rayDirection - norm;
+                if (obj.clearcoat != 0.0 && sample1D(&smp) < obj.clearcoat * schlickF0(cosO, 0.04)) {
+                    // Clearcoat, a white glossy layer on top of everything else.
+                    if (!sampleGlossyReflection(eyeVector, normalVec, obj.clearcoatRoughness, directionSample.x, directionSample.y, &rayDirection, &glossyWeight, &cosM)) {
+                        break;
+                    }
+                    throughput *= glossyWeight;
+                    untinted = true;
+                    reflecting = true;
+                } else if (obj.metalness != 0.0 && sample1D(&smp) < obj.metalness) {
+                    // Conductor (metal). Unless a complex IOR is given, the color is used as the reflectance.
+                    if (!sampleGlossyReflection(eyeVector, normalVec, roughness, directionSample.x, directionSample.y, &rayDirection, &glossyWeight, &cosM)) {
+                        // reflected into the surface, the path is absorbed
+                        break;
+                    }
+                    throughput *= glossyWeight;
+                    if (obj.k.x > 0.0 || obj.k.y > 0.0 || obj.k.z > 0.0) {
+                        throughput *= (real4)(fresnelConductor(cosM, obj.eta.x, obj.k.x),
+                                                fresnelConductor(cosM, obj.eta.y, obj.k.y),
+                                                fresnelConductor(cosM, obj.eta.z, obj.k.z), 1.0);
+                    }
+                    reflecting = true;
+                } else if (obj.reflectivity != 0.0 && sample1D(&smp) < obj.reflectivity) {
+                    // reflect, even if transparent. Glossy if the material has a roughness.
+                    if (!sampleGlossyReflection(eyeVector, normalVec, roughness, directionSample.x, directionSample.y, &rayDirection, &glossyWeight, &cosM)) {
+                        break;
+                    }
+                    throughput *= glossyWeight;
                     reflecting = true;
-                } else if (obj.refractiveIndex == -1.0) {
+                } else if (obj.refractiveIndex == -1.0 && transmits) {
                     // Slightly hacky - a refractive index of -1.0 means we have a super-thin material that should be handled
                     // as a "refraction without refraction", e.g. transparent but won't affect the ray direction.
 
-                      if (schlick(eyeVector, normalVec,  1.0, 1.5) < noise3D(fgi, n*n, b)) {
+                      if (schlick(eyeVector, normalVec,  1.0, 1.5) < sample1D(&smp)) {
                           // passing through, set underpoint
                           overPoint = position - normalVec * EPSILON;
                           // do not touch rayDirection
                       } else {
                           // reflected
-                          double dotScalar = dot(rayDirection, normalVec);
-                          double4 norm = (normalVec * 2.0) * dotScalar;
+                          real dotScalar = dot(rayDirection, normalVec);
+                          real4 norm = (normalVec * 2.0) * dotScalar;
                           rayDirection = rayDirection - norm;
                           reflecting = true;
                       }
                 }
                 // Consider removing this HACK for handling glass models without thickness.
-                else if (obj.refractiveIndex != 1.0) {
+                else if (obj.refractiveIndex != 1.0 && roughness > 0.0 && transmits) {
+                    // Rough (frosted) glass. Sample a GGX microfacet normal, then let its Fresnel reflectance decide
+                    // between reflecting and refracting. Since normalVec always faces the eye, eta is flipped when
+                    // we're inside the medium.
+                    real eta = inside ? 1.0 / ior : ior;
+                    real4 u, v;
+                    shadingBasis(normalVec, &u, &v);
+                    real4 wo = toLocal(eyeVector, u, v, normalVec);
+                    real alpha = roughnessToAlpha(roughness);
+                    real4 m = sampleGGXVNDF(wo, alpha, directionSample.x, directionSample.y);
+                    real cosI = dot(wo, m);
+                    sch = fresnelDielectric(cosI, eta);
+                    real4 wi;
+                    if (sch >= sample1D(&smp)) {
+                        wi = reflectLocal(wo, m);
+                        if (wi.z <= 0.0) {
+                            break;
+                        }
+                        reflecting = true;
+                    } else {
+                        real cosT = sqrt(max(0.0, 1.0 - (1.0 - cosI * cosI) / (eta * eta)));
+                        wi = -wo / eta + m * (cosI / eta - cosT);
+                        if (wi.z >= 0.0) {
+                            break;
+                        }
+                        overPoint = position - normalVec * EPSILON;
+                        inside = !inside;
+                        entering = inside;
+                        exiting = !inside;
+                    }
+                    // the weight is applied here since refracted bounces skip the throughput update below
+                    throughput *= smithG1(wi, alpha);
+                    rayDirection = toWorld(wi, u, v, normalVec);
+                } else if (obj.refractiveIndex != 1.0 && transmits) {
                     // Handle "normal" refraction for solid objects
 
                     if (!inside) {
                         // if we have hit a refractive object and we're not inside one...
 
                         // compute schlick to determine chance of reflection
-                        sch = schlick(eyeVector, normalVec,  1.0, obj.refractiveIndex);
-                        double rnd = noise3D(fgi, n*n, b);
+                        sch = schlick(eyeVector, normalVec,  1.0, ior);
+                        real rnd = sample1D(&smp);
                          if (x == 428 && y == 591) {
                             printf("NOT INSIDE: schlick was %f, chance was %f\n", sch, rnd);
                          }
                         if (sch < rnd) {
                             // refraction
-                            rayDirection = computeRefractedRay(eyeVector, normalVec,  1.0, obj.refractiveIndex);
+                            rayDirection = computeRefractedRay(eyeVector, normalVec,  1.0, ior);
                             overPoint = position - normalVec * EPSILON;
                             inside = true;
                             entering = true;
                             exiting = false;
                         } else {
                             // reflection
-                            double dotScalar = dot(rayDirection, normalVec);
-                            double4 norm = (normalVec * 2.0) * dotScalar;
+                            real dotScalar = dot(rayDirection, normalVec);
+                            real4 norm = (normalVec * 2.0) * dotScalar;
                             rayDirection = rayDirection - norm;
                             reflecting = true;
                         }
                     } else {
                         // If already inside, we are passing back into air but we may still reflect internally in the medium??
-                         double sch = schlick(eyeVector, normalVec,  obj.refractiveIndex, 1.0);
+                         real sch = schlick(eyeVector, normalVec,  ior, 1.0);
                          if (x == 378 && y == 558) {
                              printf("IS INSIDE: schlick was %f\n", sch);
                           }
-                         if (sch < noise3D(fgi, n*n, b)) {
+                         if (sch < sample1D(&smp)) {
                             // refract back into air
-                            rayDirection = computeRefractedRay(eyeVector, normalVec,  obj.refractiveIndex, 1.0);
+                            rayDirection = computeRefractedRay(eyeVector, normalVec,  ior, 1.0);
                             overPoint = position - normalVec * EPSILON;
                             inside = false;
                             entering = false;
                             exiting = true;
                          } else {
                             // internal reflection??
-                            double dotScalar = dot(rayDirection, normalVec);
-                            double4 norm = (normalVec * 2.0) * dotScalar;
+                            real dotScalar = dot(rayDirection, normalVec);
+                            real4 norm = (normalVec * 2.0) * dotScalar;
                             rayDirection = rayDirection - norm;
                             entering = false;
                             exiting = false;
                             reflecting = true;
                          }
                     }
+                } else if (obj.specular != 0.0 && sample1D(&smp) < schlickF0(cosO, 0.08 * obj.specular)) {
+                    // Principled dielectric specular layer, white reflection on top of the diffuse base.
+                    if (!sampleGlossyReflection(eyeVector, normalVec, roughness, directionSample.x, directionSample.y, &rayDirection, &glossyWeight, &cosM)) {
+                        break;
+                    }
+                    throughput *= glossyWeight;
+                    untinted = true;
+                    reflecting = true;
                 } else {
                     // Diffuse
-                    rayDirection = randomVectorInHemisphere(normalVec, fgi, b, n);
+                    rayDirection = randomVectorInHemisphere(normalVec, directionSample.x, directionSample.y);
                     // Calculate the cosine of the OUTGOING ray in relation to the surface
                     // normal.
                     cosine = dot(rayDirection, normalVec);
+                    if (obj.sheen != 0.0) {
+                        real4 h = normalize(rayDirection + eyeVector);
+                        sheenWeight = obj.sheen * pow(1.0 - clamp(dot(rayDirection, h), 0.0, 1.0), 5);
+                    }
+                }
+                if (entering) {
+                    mediumAbsorption = obj.absorption;
                 }
                 rayOrigin = overPoint;
 
@@ -1066,123 +2466,112 @@ __kernel void trace(__constant object *global_objects, unsigned int numObjects,
                     printf("iteration: %d === intersected: %s === schlick: %f ===new origin: %f, %f, %f ==== direction: %f %f %f\n", b, obj.label,sch, rayOrigin.x, rayOrigin.y, rayOrigin.z, rayDirection.x, rayDirection.y, rayDirection.z);
                 }
 
-                // Finish this iteration by storing the bounce. Objects (with triangles) gets special treatment
-                // since a model may have many different materials. See xsTriangleColor
-                if (obj.type == 4) {
-                    bounce bnce = {position, cosine, ctx.xsTriangleColor[ixs.normalIndex], ctx.xsTriangleEmission[ixs.normalIndex], normalVec, 1.0, entering || exiting};
-                    bounces[b] = bnce;
-                } else {
-                    // texture experiment for PLANE, CUBE and SPHERE
-                    double4 color = obj.color;
-                    if (obj.isTextured) {
-                          if (obj.type == 0) { // PLANE
-                              double4 localPoint = mul(obj.inverse, position);
-                              float4 rgba = read_imagef(image, sampler, (float4)(localPoint.x * obj.textureScaleX, localPoint.z * obj.textureScaleY, obj.textureIndex, 0));
-                              color = (double4)(rgba.x, rgba.y, rgba.z, 1.0);
-                          } else if (obj.type == 1) { // SPHERE
-                              double4 localPoint = mul(obj.inverse, position);
-                              double2 uv = sphericalMap(localPoint);
-                              float4 rgba = read_imagef(sphereTextures, sampler, (float4)(uv.x, 1.0-uv.y, obj.textureIndex, 0));
-                              color = (double4)(rgba.x, rgba.y, rgba.z, 1.0);
-                          } else if (obj.type == 3) { // CUBE
-                              double4 localPoint = mul(obj.inverse, position);
-                              double2 uv = cubeUV(localPoint);
-                              float4 rgba = read_imagef(cubeMapTextures, sampler, (float4)(uv.x, uv.y, obj.textureIndex, 0));
-                              color = (double4)(rgba.x, rgba.y, rgba.z, 1.0);
-                          }
-                    }
-                    bounce bnce = {position, cosine, color, obj.emission, normalVec, 1.0, entering || exiting};
-                    bounces[b] = bnce;
+                if (untinted) {
+                    color = (real4)(1.0, 1.0, 1.0, 1.0);
                 }
+                color += sheenWeight;
 
-                // Only increment effective bounces for non-refractive/reflective materials
-                if (!entering && !exiting && !reflecting) {
-                    effectiveBounces++;
+                // when refracting, simply pass through without updating color, throughput etc for this bounce.
+                if (entering || exiting) {
+                    continue;
                 }
 
-                // increment total bounces.
-                actualBounces++;
+                // add "strength" multiplied by remaining throughput to accumColor.
+                accumColor += throughput * emission;
+                if (surfaceHits < 2) {
+                    direct += throughput * emission;
+                }
+
+                // direct sampling of a light source just uses its color (original just used emission here).
+                if (b == 0 && emission.x > 0.0) {
+                    accumColor = color;
+                    direct = color;
+                }
 
-                // experiment - stop bouncing if intersecting a light source
+                // stop bouncing if intersecting a light source
                 if (obj.emission.x > 0.0) {
                     break;
                 }
-            }
-        }
-
-        // ------------------------------------ //
-        // Calculate final color using bounces! //
-        // ------------------------------------ //
-        double4 accumColor = (double4)(0.0, 0.0, 0.0, 0.0);
-        double4 mask = (double4)(1.0, 1.0, 1.0, 1.0);
-        unsigned int imageX = x;
-        for (unsigned int x = 0; x < actualBounces; x++) {
-
-            // first run - just use the material color of the first bounce
-            //accumColor = bounces[x].color;
-            //break;
-
-            // second run - as above, but use cos for the OUTGOING ray from the object's normal.
-            // this gives a slightly noisy result since the outgoing ray is random.
-            //accumColor = bounces[x].color * bounces[x].cos;
-            //break;
-
-            // third run - we actually use the cos of the _incoming_ ray instead
-            //accumColor = bounces[x].color * bounces[x].inCosine;
-            //break;
-
-            // fourth run - just add all FLAT colors together and average them
-            //accumColor += bounces[x].color / actualBounces;
-
-            // fifth run - just add colors together multiplied by OUT ray and average them
-            //accumColor += bounces[x].color*bounces[x].inCosine / actualBounces;
-
-            // sixth run - sample the (point) light source on each bounce
-
-            bounce bnce = bounces[x];
-            if (imageX == 428 && y == 558) {
-                printf("bounce: %d === refraction: %d \n", x, bnce.isRefraction);
-            }
-
-            // when refracting, simply pass updating color, mask etc for this bounce.
-            if (bnce.isRefraction) {
-                continue;
-            }
-
-            // add "strength" multiplied by remaining mask to accumColor.
-            accumColor = accumColor + mask * bnce.emission;
-
-            // If sampling a light source, ignore further bounces
-            if (bnce.emission.x > 0.0) {
-
-                // direct sampling of a light source
-                if (x == 0) {
-                    accumColor = bnce.color;// original just used emission here.
+                surfaceHits++;
+
+                // Here is the next event estimation experiment:  iterate over all light sources in the scene, accumulate light
+                // from all, updating accumColor. Works well for diffuse materials, but not for reflections/refraction.
+                // smp.dimension = bounceDimension + LIGHT_DIMENSION;
+                // nextEventEstimation(objects, numObjects, groups, triangles, instances, position, normalVec, color, throughput, shutterTime, &smp, &accumColor);
+
+                // Update the throughput by multiplying it with the hit object's color and perform cosine-weighted
+                // importance sampling by multiplying with the cosine. Note to self: For refracting/reflection, we set cos to 1.0.
+                throughput *= color * cosine;
+
+                // Russian roulette: past RR_DEPTH, paths survive with a probability given by their brightest throughput
+                // component. Survivors are reweighted by 1/p so that the estimate stays unbiased.
+                if (b >= RR_DEPTH) {
+                    real p = min(max(throughput.x, max(throughput.y, throughput.z)), 0.95);
+                    smp.dimension = bounceDimension + RR_DIMENSION;
+                    if (sample1D(&smp) >= p) {
+                        break;
+                    }
+                    throughput /= p;
                 }
+            } else {
+                // nothing more to hit, the path escapes the scene.
                 break;
             }
-
-
-            // Here is the next event estimation experiment:  iterate over all light sources in the scene, accumulate light
-            // from all, updating accumColor. Works well for diffuse materials, but not for reflections/refraction.
-            // nextEventEstimation(objects, numObjects, groups, triangles, &bnce, fgi, fgi2, n, mask, x, &accumColor);
-
-            // Update the mask by multiplying it with the hit object's color
-            mask *= bnce.color;
-
-            // perform cosine-weighted importance sampling by multiplying the mask
-            // with the cosine. Note to self: For refracting/reflection, we set cos to 1.0.
-            mask *= bnce.cos;
         }
 
         // Finish this "sample" by adding the accumulated color to the total
         colors += accumColor;
+        directSum += direct;
+        indirectSum += accumColor - direct;
+        real luminance = dot(accumColor, (real4)(0.2126, 0.7152, 0.0722, 0.0));
+        sumOfSquares += luminance * luminance;
+        for (int k = 0; k < FILTER_PIXELS; k++) {
+            splats[k] += (real4)(accumColor.x, accumColor.y, accumColor.z, 0.0) * filterWeights[k];
+        }
     }
 
     // Finish the pixel by multiplying each RGB component by its total fraction and
-    // store in the output buffer.
-    output[i * 4] = colors.x * colorWeight;
-    output[i * 4 + 1] = colors.y * colorWeight;
-    output[i * 4 + 2] = colors.z * colorWeight;
-    output[i * 4 + 3] = 1.0;
+    // store in the output buffer. Alpha holds the mean of the squared luminance of the samples, from which adaptive
+    // sampling estimates the variance of the pixel.
+    output[i * OUTPUT_STRIDE] = colors.x * colorWeight;
+    output[i * OUTPUT_STRIDE + 1] = colors.y * colorWeight;
+    output[i * OUTPUT_STRIDE + 2] = colors.z * colorWeight;
+    output[i * OUTPUT_STRIDE + 3] = sumOfSquares * colorWeight;
+    for (int k = 0; k < FILTER_PIXELS; k++) {
+        output[i * OUTPUT_STRIDE + 4 + k * 4] = splats[k].x;
+        output[i * OUTPUT_STRIDE + 4 + k * 4 + 1] = splats[k].y;
+        output[i * OUTPUT_STRIDE + 4 + k * 4 + 2] = splats[k].z;
+        output[i * OUTPUT_STRIDE + 4 + k * 4 + 3] = splats[k].w;
+    }
+
+#if AOVS
+    // albedo, direct and indirect light are averaged over all samples, the others over those that hit something. The
+    // IDs are those of the first sample that hit something.
+    __global real *aov = aovs + i * AOV_STRIDE;
+    real hitWeight = hits > 0 ? 1.0 / hits : 0.0;
+    aov[0] = albedoSum.x * colorWeight;
+    aov[1] = albedoSum.y * colorWeight;
+    aov[2] = albedoSum.z * colorWeight;
+    aov[3] = hits * colorWeight;
+    aov[4] = normalSum.x * hitWeight;
+    aov[5] = normalSum.y * hitWeight;
+    aov[6] = normalSum.z * hitWeight;
+    aov[7] = depthSum * hitWeight;
+    aov[8] = positionSum.x * hitWeight;
+    aov[9] = positionSum.y * hitWeight;
+    aov[10] = positionSum.z * hitWeight;
+    aov[11] = 0.0;
+    aov[12] = objectID;
+    aov[13] = materialID;
+    aov[14] = 0.0;
+    aov[15] = 0.0;
+    aov[16] = directSum.x * colorWeight;
+    aov[17] = directSum.y * colorWeight;
+    aov[18] = directSum.z * colorWeight;
+    aov[19] = 0.0;
+    aov[20] = indirectSum.x * colorWeight;
+    aov[21] = indirectSum.y * colorWeight;
+    aov[22] = indirectSum.z * colorWeight;
+    aov[23] = 0.0;
+#endif
 }
\ No newline at end of file